@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -13,7 +14,6 @@ import (
 	"github.com/hacdias/webdav/v4/lib"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 )
 
 func init() {
@@ -27,6 +27,7 @@ func init() {
 	flags.IntP("port", "p", lib.DefaultPort, "port to listen on")
 	flags.StringP("prefix", "P", lib.DefaultPrefix, "URL path prefix")
 	flags.String("log_format", lib.DefaultLogFormat, "logging format")
+	flags.String("log_level", lib.DefaultLogLevel, "logging level")
 }
 
 var rootCmd = &cobra.Command{
@@ -58,23 +59,31 @@ set WD_CERT.`,
 			return err
 		}
 
+		// Setup the logger based on the configuration
+		logger, err := cfg.NewLogger()
+		if err != nil {
+			return err
+		}
+		zap.ReplaceGlobals(logger)
+		cfg.Logger = logger
+
+		defer func() {
+			// Flush the logger at the end
+			_ = logger.Sync()
+		}()
+
 		// Create HTTP handler from the config
 		handler, err := lib.NewHandler(cfg)
 		if err != nil {
 			return err
 		}
 
-		// Setup the logger based on the configuration
-		err = setupLogger(cfg)
+		server := &http.Server{Handler: handler}
+		server.TLSConfig, err = cfg.TLSConfig()
 		if err != nil {
 			return err
 		}
 
-		defer func() {
-			// Flush the logger at the end
-			_ = zap.L().Sync()
-		}()
-
 		// Build listener
 		listener, err := getListener(cfg)
 		if err != nil {
@@ -89,9 +98,9 @@ set WD_CERT.`,
 
 			var err error
 			if cfg.TLS {
-				err = http.ServeTLS(listener, handler, cfg.Cert, cfg.Key)
+				err = server.ServeTLS(listener, cfg.Cert, cfg.Key)
 			} else {
-				err = http.Serve(listener, handler)
+				err = server.Serve(listener)
 			}
 
 			if err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -101,10 +110,39 @@ set WD_CERT.`,
 			quit <- os.Interrupt
 		}()
 
+		// Reload the configuration on SIGHUP. Settings that affect the listener,
+		// such as the address or TLS, require a restart.
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+
+		go func() {
+			for range reload {
+				cfg, err := lib.ParseConfig(cfgFilename, flags)
+				if err == nil {
+					cfg.Logger = logger
+					err = handler.Reload(cfg)
+				}
+
+				if err != nil {
+					zap.L().Error("failed to reload configuration", zap.Error(err))
+				} else {
+					zap.L().Info("reloaded configuration")
+				}
+			}
+		}()
+
 		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 		signal := <-quit
 
 		zap.L().Info("caught signal, shutting down", zap.Stringer("signal", signal))
+
+		// Let the requests being served finish, while rejecting the new ones.
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := handler.Shutdown(ctx); err != nil {
+			zap.L().Warn("requests still in flight at shutdown", zap.Error(err))
+		}
+		_ = server.Shutdown(ctx)
 		_ = listener.Close()
 
 		return nil
@@ -127,19 +165,3 @@ func getListener(cfg *lib.Config) (net.Listener, error) {
 
 	return net.Listen(network, address)
 }
-
-func setupLogger(cfg *lib.Config) error {
-	loggerConfig := zap.NewProductionConfig()
-	loggerConfig.DisableCaller = true
-	if cfg.Debug {
-		loggerConfig.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
-	}
-	loggerConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	loggerConfig.Encoding = cfg.LogFormat
-	logger, err := loggerConfig.Build()
-	if err != nil {
-		return err
-	}
-	zap.ReplaceGlobals(logger)
-	return nil
-}
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	AccessLogStructured = "structured"
+	AccessLogCombined   = "combined"
+)
+
+// accessLogger emits a log entry for every request after it was served.
+type accessLogger struct {
+	format   string
+	out      io.Writer
+	logger   *zap.Logger
+	redactor *redactor
+}
+
+// accessLogEntry contains the information logged about a request. The method
+// is recorded before serving the request, as it can be rewritten in the meanwhile.
+type accessLogEntry struct {
+	start    time.Time
+	method   string
+	username string
+}
+
+func (l *accessLogger) log(r *http.Request, w *recordingResponseWriter, entry accessLogEntry) {
+	duration := time.Since(entry.start)
+
+	switch l.format {
+	case AccessLogCombined:
+		bytes := "-"
+		if w.bytes > 0 {
+			bytes = strconv.FormatInt(w.bytes, 10)
+		}
+
+		_, _ = fmt.Fprintf(l.out, "%s - %s [%s] %q %d %s %q %q\n",
+			remoteHost(r),
+			orDash(entry.username),
+			entry.start.Format("02/Jan/2006:15:04:05 -0700"),
+			entry.method+" "+l.redactor.uri(r.RequestURI)+" "+r.Proto,
+			w.Status(),
+			bytes,
+			orDash(l.redactor.uri(r.Referer())),
+			orDash(r.UserAgent()),
+		)
+	default:
+		requestLogger(r, l.logger).Info("request",
+			zap.String("method", entry.method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", w.Status()),
+			zap.Int64("bytes", w.bytes),
+			zap.Duration("duration", duration),
+			zap.String("username", entry.username),
+			zap.String("remote_address", r.RemoteAddr),
+		)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/net/webdav"
+)
+
+func TestAccessLogStructured(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	h := newTestHandler(t, &Config{
+		Logger:    zap.New(core),
+		Auth:      true,
+		AccessLog: AccessLog{Enabled: true, Format: AccessLogStructured},
+		Users:     []User{{Username: "admin", Password: "admin"}},
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	r.SetBasicAuth("admin", "admin")
+	doRequest(h, r)
+
+	r = httptest.NewRequest(http.MethodHead, "/file.txt", nil)
+	r.SetBasicAuth("admin", "admin")
+	doRequest(h, r)
+
+	doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+
+	entries := logs.FilterMessage("request").AllUntimed()
+	require.Len(t, entries, 3)
+
+	fields := entries[0].ContextMap()
+	require.Equal(t, http.MethodGet, fields["method"])
+	require.Equal(t, "/file.txt", fields["path"])
+	require.EqualValues(t, http.StatusOK, fields["status"])
+	require.EqualValues(t, len("content"), fields["bytes"])
+	require.Equal(t, "admin", fields["username"])
+	require.Equal(t, "192.0.2.1:1234", fields["remote_address"])
+	require.Contains(t, fields, "duration")
+
+	// HEAD requests record the status, but write no body.
+	fields = entries[1].ContextMap()
+	require.Equal(t, http.MethodHead, fields["method"])
+	require.EqualValues(t, http.StatusOK, fields["status"])
+	require.EqualValues(t, 0, fields["bytes"])
+
+	fields = entries[2].ContextMap()
+	require.EqualValues(t, http.StatusUnauthorized, fields["status"])
+	require.Equal(t, "", fields["username"])
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Auth:      true,
+		AccessLog: AccessLog{Enabled: true, Format: AccessLogCombined},
+		Users:     []User{{Username: "admin", Password: "admin"}},
+	})
+
+	var out bytes.Buffer
+	h.(*Handler).handler.accessLog.out = &out
+
+	r := httptest.NewRequest(http.MethodGet, "/dir/", nil)
+	r.SetBasicAuth("admin", "admin")
+	r.Header.Set("User-Agent", "test-agent")
+	doRequest(h, r)
+
+	// The request was rewritten to a PROPFIND, but the original method is logged.
+	require.Regexp(t, `^192\.0\.2\.1 - admin \[.+\] "GET /dir/ HTTP/1\.1" 207 \d+ "-" "test-agent"\n$`, out.String())
+}
+
+// slowFileSystem is a file system that takes a while to stat the files, like a
+// stalled storage would.
+type slowFileSystem struct {
+	webdav.FileSystem
+	delay time.Duration
+}
+
+func (fs slowFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	time.Sleep(fs.delay)
+	return fs.FileSystem.Stat(ctx, name)
+}
+
+func TestSlowRequests(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	h := newTestHandler(t, &Config{
+		Logger:        zap.New(core),
+		Auth:          true,
+		SlowThreshold: 20 * time.Millisecond,
+		Users:         []User{{Username: "admin", Password: "admin"}, {Username: "fast", Password: "fast"}},
+	})
+
+	admin := h.(*Handler).handler.users["admin"]
+	admin.FileSystem = slowFileSystem{FileSystem: admin.FileSystem, delay: 50 * time.Millisecond}
+
+	for _, username := range []string{"admin", "fast"} {
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.SetBasicAuth(username, username)
+		require.Equal(t, http.StatusOK, doRequest(h, r).Code)
+	}
+
+	entries := logs.FilterMessage("slow request").AllUntimed()
+	require.Len(t, entries, 1)
+	require.Equal(t, zapcore.WarnLevel, entries[0].Level)
+
+	fields := entries[0].ContextMap()
+	require.Equal(t, http.MethodGet, fields["method"])
+	require.Equal(t, "/file.txt", fields["path"])
+	require.Equal(t, "admin", fields["username"])
+	require.EqualValues(t, http.StatusOK, fields["status"])
+	require.GreaterOrEqual(t, fields["duration"], 50*time.Millisecond)
+}
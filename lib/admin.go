@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"sort"
+)
+
+// admin serves the endpoint that reports the effective configuration to the
+// admin users, so that it can be checked without access to the server. The
+// secrets, such as the passwords, are left out.
+type admin struct {
+	path string
+	body []byte
+}
+
+type adminConfig struct {
+	Version     string           `json:"version"`
+	Backend     string           `json:"backend"`
+	Prefix      string           `json:"prefix"`
+	Auth        bool             `json:"auth"`
+	AuthMethod  string           `json:"auth_method,omitempty"`
+	AuthMethods []string         `json:"auth_methods,omitempty"`
+	ReadOnly    bool             `json:"read_only"`
+	Permissions adminPermissions `json:"permissions"`
+	Users       []adminUser      `json:"users"`
+	Features    []string         `json:"features"`
+}
+
+type adminPermissions struct {
+	Scope             string      `json:"scope"`
+	Modify            bool        `json:"modify"`
+	Rules             []adminRule `json:"rules,omitempty"`
+	Grants            *Grants     `json:"allowed,omitempty"`
+	BlockedExtensions []string    `json:"blocked_extensions,omitempty"`
+}
+
+type adminRule struct {
+	Path    string `json:"path"`
+	Regex   bool   `json:"regex,omitempty"`
+	Glob    bool   `json:"glob,omitempty"`
+	Allow   bool   `json:"allow"`
+	Modify  bool   `json:"modify"`
+	Inherit *bool  `json:"inherit,omitempty"`
+}
+
+type adminUser struct {
+	Username    string           `json:"username"`
+	Admin       bool             `json:"admin,omitempty"`
+	Permissions adminPermissions `json:"permissions"`
+	Quota       int64            `json:"quota,omitempty"`
+	MaxFiles    int64            `json:"max_files,omitempty"`
+	Owner       string           `json:"owner,omitempty"`
+}
+
+func newAdmin(c *Config) (*admin, error) {
+	res := adminConfig{
+		Version:     buildVersion(),
+		Backend:     c.Backend,
+		Prefix:      c.Prefix,
+		Auth:        c.Auth,
+		ReadOnly:    c.ReadOnly,
+		Permissions: newAdminPermissions(c.Permissions),
+		Users:       []adminUser{},
+		Features:    enabledFeatures(c),
+	}
+	if c.Auth {
+		res.AuthMethod = c.AuthMethod
+		res.AuthMethods = c.AuthMethods
+	}
+
+	for _, u := range c.Users {
+		res.Users = append(res.Users, adminUser{
+			Username:    u.Username,
+			Admin:       u.Admin,
+			Permissions: newAdminPermissions(u.Permissions),
+			Quota:       u.Quota,
+			MaxFiles:    u.MaxFiles,
+			Owner:       u.Owner,
+		})
+	}
+	sort.Slice(res.Users, func(i, j int) bool {
+		return res.Users[i].Username < res.Users[j].Username
+	})
+
+	body, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	return &admin{path: c.Admin.Path, body: append(body, '\n')}, nil
+}
+
+func newAdminPermissions(p Permissions) adminPermissions {
+	res := adminPermissions{Scope: p.Scope, Modify: p.Modify, Grants: p.Grants, BlockedExtensions: p.BlockedExtensions}
+	for _, rule := range p.Rules {
+		res.Rules = append(res.Rules, adminRule{
+			Path:    rule.Path,
+			Regex:   rule.Regex,
+			Glob:    rule.Glob,
+			Allow:   rule.Allow,
+			Modify:  rule.Modify,
+			Inherit: rule.Inherit,
+		})
+	}
+	return res
+}
+
+// buildVersion returns the version of the module the server was built from, if
+// known.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "unknown"
+	}
+	return info.Main.Version
+}
+
+// enabledFeatures returns the names of the optional features that are enabled,
+// as they are configured.
+func enabledFeatures(c *Config) []string {
+	features := []struct {
+		name    string
+		enabled bool
+	}{
+		{"access_log", c.AccessLog.Enabled},
+		{"anonymous", c.Anonymous.Scope != ""},
+		{"anonymous_read", c.AnonymousRead},
+		{"archives", c.Archives},
+		{"audit", c.Audit.Enabled},
+		{"caldav", c.CalDAV},
+		{"case_insensitive", c.CaseInsensitive},
+		{"checksums", c.Checksums},
+		{"chown", c.Chown},
+		{"compression", c.Compression.Enabled},
+		{"content_disposition", c.Disposition != ""},
+		{"cors", c.CORS.Enabled},
+		{"create_scope", c.CreateScope},
+		{"dead_properties", c.DeadProperties},
+		{"expect_continue", c.ExpectContinue},
+		{"health", c.Health.Enabled},
+		{"hide_dotfiles", c.HideDotfiles},
+		{"hide_empty_dirs", c.HideEmptyDirs},
+		{"idempotent_delete", c.IdempotentDelete},
+		{"lockout", c.Lockout.Enabled},
+		{"lower_scope", c.LowerScope != ""},
+		{"metrics", c.Metrics.Enabled},
+		{"mounts", len(c.Mounts) > 0},
+		{"partial_updates", c.PartialUpdates},
+		{"precompressed", c.Precompressed},
+		{"propfind_cache", c.PropfindCache.Enabled},
+		{"proxy", c.Proxy.Enabled},
+		{"redaction", len(c.Redaction.Paths) > 0 || len(c.Redaction.Headers) > 0},
+		{"request_id", c.RequestID.Enabled},
+		{"strict_scope", c.StrictScope},
+		{"trailing_slash", c.TrailingSlash},
+		{"user_store", c.UserStore != nil},
+		{"versioning", c.Versioning.Enabled},
+		{"virtual_file", c.VirtualFile.Name != ""},
+		{"write_once", len(c.WriteOnce) > 0},
+	}
+
+	enabled := []string{}
+	for _, feature := range features {
+		if feature.enabled {
+			enabled = append(enabled, feature.name)
+		}
+	}
+	return enabled
+}
+
+// serve serves the configuration to the admin users, and forbids it to the
+// others.
+func (a *admin) serve(w http.ResponseWriter, r *http.Request, user *handlerUser) {
+	if !user.Admin {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(a.body)
+	}
+}
@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerAdmin(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Auth:      true,
+		Admin:     Admin{Enabled: true, Path: "/admin/config"},
+		Checksums: true,
+		Users: []User{
+			{Username: "root", Password: "root-secret", Admin: true},
+			{Username: "basic", Password: "basic-secret", Permissions: Permissions{
+				Modify: true,
+				Rules:  []*Rule{{Path: "/private", Allow: false}},
+			}},
+		},
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		t.Parallel()
+
+		r := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+		r.SetBasicAuth("basic", "basic-secret")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.NotContains(t, w.Body.String(), "root")
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		t.Parallel()
+
+		r := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+		r.SetBasicAuth("root", "root-secret")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		require.NotContains(t, w.Body.String(), "secret")
+		require.NotContains(t, w.Body.String(), "password")
+
+		var res adminConfig
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		require.True(t, res.Auth)
+		require.Equal(t, AuthMethodBasic, res.AuthMethod)
+		require.Contains(t, res.Features, "checksums")
+		require.NotEmpty(t, res.Version)
+
+		require.Len(t, res.Users, 2)
+		require.Equal(t, "basic", res.Users[0].Username)
+		require.False(t, res.Users[0].Admin)
+		require.True(t, res.Users[0].Permissions.Modify)
+		require.Equal(t, []adminRule{{Path: "/private"}}, res.Users[0].Permissions.Rules)
+		require.Equal(t, "root", res.Users[1].Username)
+		require.True(t, res.Users[1].Admin)
+	})
+
+	t.Run("Method", func(t *testing.T) {
+		t.Parallel()
+
+		r := httptest.NewRequest(http.MethodPut, "/admin/config", nil)
+		r.SetBasicAuth("root", "root-secret")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
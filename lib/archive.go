@@ -0,0 +1,182 @@
+package lib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// The formats of the archives of the collections.
+const (
+	archiveZip   = "zip"
+	archiveTarGz = "tar.gz"
+)
+
+// archiveWriter writes the entries of an archive, in the order they are added.
+type archiveWriter interface {
+	// add adds a file, or a directory if the reader is nil, to the archive.
+	add(name string, info os.FileInfo, r io.Reader) error
+	Close() error
+}
+
+// zipWriter writes the entries of a ZIP archive.
+type zipWriter struct {
+	*zip.Writer
+}
+
+func (z zipWriter) add(name string, info os.FileInfo, r io.Reader) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: info.ModTime()}
+	header.SetMode(info.Mode())
+	if r == nil {
+		header.Name += "/"
+		header.Method = zip.Store
+	}
+
+	w, err := z.CreateHeader(header)
+	if err != nil || r == nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// tarGzWriter writes the entries of a tar archive compressed with gzip.
+type tarGzWriter struct {
+	tar  *tar.Writer
+	gzip *gzip.Writer
+}
+
+func newTarGzWriter(w io.Writer) *tarGzWriter {
+	gw := gzip.NewWriter(w)
+	return &tarGzWriter{tar: tar.NewWriter(gw), gzip: gw}
+}
+
+func (t *tarGzWriter) add(name string, info os.FileInfo, r io.Reader) error {
+	// The owners of the files are left out, as they are not the users'.
+	header := &tar.Header{Name: name, Mode: int64(info.Mode().Perm()), ModTime: info.ModTime(), Typeflag: tar.TypeReg, Size: info.Size()}
+	if r == nil {
+		header.Name += "/"
+		header.Typeflag = tar.TypeDir
+		header.Size = 0
+	}
+
+	if err := t.tar.WriteHeader(header); err != nil || r == nil {
+		return err
+	}
+	_, err := io.CopyN(t.tar, r, header.Size)
+	return err
+}
+
+func (t *tarGzWriter) Close() error {
+	if err := t.tar.Close(); err != nil {
+		return err
+	}
+	return t.gzip.Close()
+}
+
+// serveArchive streams an archive of the collection, in the format given by the
+// "archive" query parameter, built as it is walked. Only the files and the
+// directories the user can read are included. Once the archive has begun, the
+// errors can only be logged, and the archive is left incomplete.
+func (u *handlerUser) serveArchive(w http.ResponseWriter, r *http.Request, name string) {
+	filename := path.Base(strings.TrimSuffix(r.URL.Path, "/"))
+	if filename == "/" || filename == "." {
+		filename = "archive"
+	}
+
+	var aw archiveWriter
+	format := r.URL.Query().Get("archive")
+	switch format {
+	case archiveZip:
+		w.Header().Set("Content-Type", "application/zip")
+		aw = zipWriter{zip.NewWriter(w)}
+	case archiveTarGz:
+		w.Header().Set("Content-Type", "application/gzip")
+		aw = newTarGzWriter(w)
+	default:
+		http.Error(w, "Invalid archive query", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(DispositionAttachment, filename+"."+format))
+	w.WriteHeader(http.StatusOK)
+
+	dir := r.URL.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+
+	err := u.archiveDir(r.Context(), aw, name, dir, "")
+	if err == nil {
+		err = aw.Close()
+	}
+	if err != nil {
+		requestLogger(r, u.logger).Error("failed to write archive", zap.String("path", name), zap.Error(err))
+	}
+}
+
+// archiveDir adds the members of the directory to the archive, under the given
+// prefix. The directory is at the given path of the requests, which the
+// permissions of the user apply to.
+func (u *handlerUser) archiveDir(ctx context.Context, aw archiveWriter, name, dir, prefix string) error {
+	f, err := u.FileSystem.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	infos, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		member := path.Join(name, info.Name())
+		entry := prefix + info.Name()
+
+		// The symbolic links to files are archived as what they point to. The
+		// ones to directories are left out, as they could point back to one of
+		// the directories being archived, which would never end.
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = u.FileSystem.Stat(ctx, member); err != nil || info.IsDir() {
+				continue
+			}
+		}
+
+		switch {
+		case info.IsDir():
+			if !u.allowedMethod(http.MethodGet, dir+info.Name()+"/") {
+				continue
+			}
+			if err := aw.add(entry, info, nil); err != nil {
+				return err
+			}
+			if err := u.archiveDir(ctx, aw, member, dir+info.Name()+"/", entry+"/"); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if !u.allowedMethod(http.MethodGet, dir+info.Name()) {
+				continue
+			}
+			if err := u.archiveFile(ctx, aw, member, entry, info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (u *handlerUser) archiveFile(ctx context.Context, aw archiveWriter, name, entry string, info os.FileInfo) error {
+	f, err := u.FileSystem.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return aw.add(entry, info, f)
+}
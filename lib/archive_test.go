@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchives(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Archives: true,
+		Permissions: Permissions{Rules: []*Rule{
+			{Path: "/dir/secret.txt", Allow: false},
+			{Path: "/private/", Allow: false},
+		}},
+	}
+	h := newTestHandler(t, cfg)
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "dir", "nested.txt"), []byte("nested"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "dir", "secret.txt"), []byte("secret"), 0666))
+	require.NoError(t, os.MkdirAll(filepath.Join(cfg.Scope, "private"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "private", "data.txt"), []byte("data"), 0666))
+
+	expected := map[string]string{
+		"file.txt":       "content",
+		"dir/":           "",
+		"dir/nested.txt": "nested",
+	}
+
+	t.Run("Zip", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/?archive=zip", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+		require.Equal(t, `attachment; filename="archive.zip"`, w.Header().Get("Content-Disposition"))
+
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		require.NoError(t, err)
+		entries := map[string]string{}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			require.NoError(t, err)
+			data, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			rc.Close()
+			entries[f.Name] = string(data)
+		}
+		require.Equal(t, expected, entries)
+	})
+
+	t.Run("Tar", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/dir/?archive=tar.gz", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+		require.Equal(t, `attachment; filename="dir.tar.gz"`, w.Header().Get("Content-Disposition"))
+
+		gr, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		tr := tar.NewReader(gr)
+		entries := map[string]string{}
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			entries[header.Name] = string(data)
+		}
+		require.Equal(t, map[string]string{"nested.txt": "nested"}, entries)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		require.Equal(t, http.StatusBadRequest, doRequest(h, httptest.NewRequest(http.MethodGet, "/?archive=rar", nil)).Code)
+
+		// The files are served as usual.
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt?archive=zip", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "content", w.Body.String())
+	})
+}
+
+func TestArchivesSymlinks(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{Archives: true}
+	h := newTestHandler(t, cfg)
+	require.NoError(t, os.Symlink(".", filepath.Join(cfg.Scope, "dir", "loop")))
+	require.NoError(t, os.Symlink("../file.txt", filepath.Join(cfg.Scope, "dir", "link.txt")))
+
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/?archive=zip", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The links to files are archived, but not the ones to directories.
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	require.ElementsMatch(t, []string{"file.txt", "dir/", "dir/link.txt"}, names)
+}
+
+func TestArchivesDisabled(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{})
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/?archive=zip", nil))
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	require.NotEqual(t, "application/zip", w.Header().Get("Content-Type"))
+}
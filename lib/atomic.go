@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// uploadPrefix is the prefix of the temporary files the uploads are written to.
+const uploadPrefix = ".webdav-upload-"
+
+var (
+	errIsDirectory   = errors.New("is a directory")
+	errUploadAborted = errors.New("upload aborted")
+)
+
+// isUpload reports whether the flags open a file for replacing its contents,
+// as is the case for PUT and COPY.
+func isUpload(flag int) bool {
+	return flag&os.O_CREATE != 0 && flag&os.O_TRUNC != 0 && flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+// atomicUploader is implemented by the file systems whose uploads are atomic
+// on their own, which do not need a temporary file.
+type atomicUploader interface {
+	atomicUploads()
+}
+
+// uploadDiscarder is implemented by the files of the atomic uploads, which can
+// be discarded rather than stored on close.
+type uploadDiscarder interface {
+	discard()
+}
+
+// isUploadName reports whether the name is that of a temporary upload file.
+func isUploadName(name string) bool {
+	return strings.HasPrefix(path.Base(name), uploadPrefix)
+}
+
+// atomicUploads reports whether the uploads to the file system are atomic on
+// their own.
+func atomicUploads(fs webdav.FileSystem) bool {
+	_, ok := fs.(atomicUploader)
+	return ok
+}
+
+// openUpload opens a temporary file next to the file with the given name, so
+// that both are on the same file system. Its name does not depend on the name
+// of the file, so that it is never too long when the latter is not. Once the
+// upload is complete, the temporary file is renamed into place, so that nobody
+// ever observes a partially written file. If given, the check runs on the
+// temporary file before it is renamed, and the previous version of the file is
+// kept right before.
+func openUpload(ctx context.Context, fs webdav.FileSystem, name string, flag int, perm os.FileMode, check *uploadCheck, versioning Versioning) (webdav.File, error) {
+	if info, err := fs.Stat(ctx, name); err == nil {
+		if info.IsDir() {
+			return nil, &os.PathError{Op: "open", Path: name, Err: errIsDirectory}
+		}
+		// Keep the permissions of the file being replaced.
+		perm = info.Mode().Perm()
+	}
+
+	suffix := make([]byte, 16)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	tmp := path.Join(path.Dir(name), uploadPrefix+hex.EncodeToString(suffix))
+
+	file, err := fs.OpenFile(ctx, tmp, flag|os.O_EXCL, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &uploadFile{File: file, ctx: ctx, fs: fs, name: name, tmp: tmp, check: check, versioning: versioning}, nil
+}
+
+// uploadFile is the temporary file an upload is written to. If anything goes
+// wrong while writing it, it is discarded on close, and the original file is
+// left untouched.
+type uploadFile struct {
+	webdav.File
+	ctx        context.Context
+	fs         webdav.FileSystem
+	name       string
+	tmp        string
+	check      *uploadCheck
+	versioning Versioning
+	failed     bool
+}
+
+func (f *uploadFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	if err != nil {
+		f.failed = true
+	}
+	return n, err
+}
+
+// ReadFrom is used by [io.Copy], which allows to notice when reading the
+// request body fails, for example because the client disconnected.
+func (f *uploadFile) ReadFrom(r io.Reader) (int64, error) {
+	n, err := copyUpload(f.ctx, f.File, r)
+	if err != nil {
+		f.failed = true
+	}
+	return n, err
+}
+
+func (f *uploadFile) Close() error {
+	err := f.File.Close()
+	if err == nil && f.failed {
+		err = errUploadAborted
+	}
+	if err == nil && f.check != nil {
+		err = f.check.run(f.ctx, f.fs, f.tmp)
+	}
+	if err == nil && f.versioning.Enabled {
+		err = keepVersion(f.ctx, f.fs, f.versioning, f.name, time.Now())
+	}
+	if err == nil {
+		err = f.fs.Rename(context.Background(), f.tmp, f.name)
+		if err == nil {
+			return nil
+		}
+	}
+
+	// The error is more relevant than the one of removing the temporary file.
+	_ = f.fs.RemoveAll(context.Background(), f.tmp)
+	return err
+}
@@ -0,0 +1,161 @@
+package lib
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// abortedReader returns the data of the reader, and then fails as if the
+// client disconnected in the middle of the upload.
+type abortedReader struct {
+	io.Reader
+}
+
+func (r abortedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset by peer")
+	}
+	return n, err
+}
+
+func TestAtomicUploads(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T, backend string) (http.Handler, string) {
+		cfg := &Config{
+			Backend:     backend,
+			Permissions: Permissions{Modify: true},
+		}
+		h := newTestHandler(t, cfg)
+		return h, cfg.Scope
+	}
+
+	put := func(h http.Handler, name string, body io.Reader) int {
+		r := httptest.NewRequest(http.MethodPut, name, body)
+		return doRequest(h, r).Code
+	}
+
+	get := func(h http.Handler, name string) string {
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, name, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	requireNoUploads := func(t *testing.T, dir string) {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			require.False(t, strings.HasPrefix(entry.Name(), uploadPrefix), entry.Name())
+		}
+	}
+
+	t.Run("Aborted Upload", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, BackendDisk)
+		require.NotEqual(t, http.StatusCreated, put(h, "/file.txt", abortedReader{strings.NewReader("partial")}))
+
+		data, err := os.ReadFile(filepath.Join(scope, "file.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "content", string(data))
+		requireNoUploads(t, scope)
+	})
+
+	t.Run("Aborted New File", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, BackendDisk)
+		require.NotEqual(t, http.StatusCreated, put(h, "/dir/new.txt", abortedReader{strings.NewReader("partial")}))
+
+		require.NoFileExists(t, filepath.Join(scope, "dir", "new.txt"))
+		requireNoUploads(t, filepath.Join(scope, "dir"))
+	})
+
+	t.Run("Aborted Upload With Wrapped Files", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{
+			NoSniff:     true,
+			Permissions: Permissions{Modify: true},
+		}
+		h := newTestHandler(t, cfg)
+		require.NotEqual(t, http.StatusCreated, put(h, "/file.txt", abortedReader{strings.NewReader("partial")}))
+
+		data, err := os.ReadFile(filepath.Join(cfg.Scope, "file.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "content", string(data))
+		requireNoUploads(t, cfg.Scope)
+	})
+
+	t.Run("Aborted Upload In Memory", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t, BackendMemory)
+		require.Equal(t, http.StatusCreated, put(h, "/file.txt", strings.NewReader("content")))
+		require.NotEqual(t, http.StatusCreated, put(h, "/file.txt", abortedReader{strings.NewReader("partial")}))
+		require.Equal(t, "content", get(h, "/file.txt"))
+	})
+
+	t.Run("Replaces File", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, BackendDisk)
+		require.NoError(t, os.Chmod(filepath.Join(scope, "file.txt"), 0600))
+		require.Equal(t, http.StatusCreated, put(h, "/file.txt", strings.NewReader("new content")))
+		require.Equal(t, "new content", get(h, "/file.txt"))
+
+		// The permissions of the replaced file are kept.
+		info, err := os.Stat(filepath.Join(scope, "file.txt"))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		requireNoUploads(t, scope)
+	})
+
+	t.Run("Long Name", func(t *testing.T) {
+		t.Parallel()
+
+		// The temporary file is no longer than the names the file system allows.
+		h, scope := newHandler(t, BackendDisk)
+		name := "/" + strings.Repeat("a", 240) + ".txt"
+		require.Equal(t, http.StatusCreated, put(h, name, strings.NewReader("content")))
+		require.Equal(t, "content", get(h, name))
+		requireNoUploads(t, scope)
+	})
+
+	t.Run("Hidden", func(t *testing.T) {
+		t.Parallel()
+
+		// The temporary files left behind, say by a crash, are not served.
+		h, scope := newHandler(t, BackendDisk)
+		tmp := uploadPrefix + "0123456789abcdef0123456789abcdef"
+		require.NoError(t, os.WriteFile(filepath.Join(scope, tmp), []byte("partial"), 0666))
+
+		require.Equal(t, http.StatusNotFound, doRequest(h, httptest.NewRequest(http.MethodGet, "/"+tmp, nil)).Code)
+		require.Equal(t, http.StatusConflict, put(h, "/"+tmp, strings.NewReader("content")))
+
+		r := httptest.NewRequest("PROPFIND", "/", nil)
+		r.Header.Set("Depth", "1")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.Contains(t, w.Body.String(), "file.txt")
+		require.NotContains(t, w.Body.String(), uploadPrefix)
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, BackendDisk)
+		require.NotEqual(t, http.StatusCreated, put(h, "/dir", strings.NewReader("content")))
+		require.DirExists(t, filepath.Join(scope, "dir"))
+		requireNoUploads(t, scope)
+	})
+}
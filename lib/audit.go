@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"net/http"
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// auditLogger records the requests that may modify the contents, whether they
+// succeeded or not, apart from the rest of the logs.
+type auditLogger struct {
+	file   string
+	core   zapcore.Core
+	logger *zap.Logger
+}
+
+// newAuditLogger creates the audit logger. With a file, the records are
+// appended to it as JSON lines. Otherwise, they are emitted with the rest of
+// the logs, by the "audit" logger. Either way, the paths are redacted.
+func newAuditLogger(c Audit, logger *zap.Logger, redactor *redactor) (*auditLogger, error) {
+	if c.File == "" {
+		return &auditLogger{logger: logger.Named("audit")}, nil
+	}
+
+	f, err := os.OpenFile(c.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "time"
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(f), zapcore.InfoLevel)
+	return &auditLogger{file: c.File, core: core, logger: redactor.wrap(zap.New(core))}, nil
+}
+
+// auditEntry contains the information recorded about a request. It is taken
+// before serving the request, as the request can be rewritten in the meanwhile.
+type auditEntry struct {
+	method      string
+	path        string
+	destination string
+	username    string
+}
+
+func newAuditEntry(r *http.Request, user *handlerUser) auditEntry {
+	dst, _ := destinationPath(r)
+	return auditEntry{method: r.Method, path: r.URL.Path, destination: dst, username: user.Username}
+}
+
+func (l *auditLogger) log(r *http.Request, entry auditEntry, status int) {
+	fields := []zap.Field{
+		zap.String("method", entry.method),
+		zap.String("path", entry.path),
+		zap.Int("status", status),
+		zap.String("username", entry.username),
+		zap.String("remote_address", r.RemoteAddr),
+	}
+	if entry.destination != "" {
+		fields = append(fields, zap.String("destination", entry.destination))
+	}
+	if id, ok := requestID(r); ok {
+		fields = append(fields, zap.String("request_id", id))
+	}
+
+	l.logger.Info("audit", fields...)
+}
@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	h := newTestHandler(t, &Config{
+		Logger: zap.New(core),
+		Auth:   true,
+		Audit:  Audit{Enabled: true},
+		Users: []User{{Username: "admin", Password: "admin", Permissions: Permissions{
+			Modify: true,
+			Rules:  []*Rule{{Path: "/dir/", Allow: true, Modify: false}},
+		}}},
+	})
+
+	request := func(method, path string) int {
+		r := httptest.NewRequest(method, path, nil)
+		if method == http.MethodPut {
+			r = httptest.NewRequest(method, path, strings.NewReader("new"))
+		}
+		r.SetBasicAuth("admin", "admin")
+		return doRequest(h, r).Code
+	}
+
+	require.Equal(t, http.StatusCreated, request(http.MethodPut, "/new.txt"))
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/new.txt"))
+	require.Equal(t, http.StatusMultiStatus, request("PROPFIND", "/"))
+	require.Equal(t, http.StatusNoContent, request(http.MethodDelete, "/new.txt"))
+	require.Equal(t, http.StatusForbidden, request(http.MethodDelete, "/dir/"))
+
+	entries := logs.FilterMessage("audit").AllUntimed()
+	require.Len(t, entries, 3)
+	require.Equal(t, "audit", entries[0].LoggerName)
+
+	for i, expected := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodPut, "/new.txt", http.StatusCreated},
+		{http.MethodDelete, "/new.txt", http.StatusNoContent},
+		{http.MethodDelete, "/dir/", http.StatusForbidden},
+	} {
+		fields := entries[i].ContextMap()
+		require.Equal(t, expected.method, fields["method"])
+		require.Equal(t, expected.path, fields["path"])
+		require.EqualValues(t, expected.status, fields["status"])
+		require.Equal(t, "admin", fields["username"])
+		require.NotContains(t, fields, "destination")
+	}
+}
+
+func TestAuditLogFile(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "audit.log")
+	h := newTestHandler(t, &Config{
+		Permissions: Permissions{Modify: true},
+		Audit:       Audit{Enabled: true, File: file},
+	})
+
+	r := httptest.NewRequest("MOVE", "/file.txt", nil)
+	r.Header.Set("Destination", "/moved.txt")
+	require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+	require.Equal(t, http.StatusCreated, doRequest(h, httptest.NewRequest("MKCOL", "/new", nil)).Code)
+
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, records, 2)
+
+	require.Equal(t, "MOVE", records[0]["method"])
+	require.Equal(t, "/file.txt", records[0]["path"])
+	require.Equal(t, "/moved.txt", records[0]["destination"])
+	require.EqualValues(t, http.StatusCreated, records[0]["status"])
+	require.Contains(t, records[0], "time")
+
+	require.Equal(t, "MKCOL", records[1]["method"])
+	require.Equal(t, "/new", records[1]["path"])
+}
@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// Authenticator authenticates the requests with HTTP Basic authentication, or
+// with the certificate authentication when the client presents no certificate.
+// It returns the user making the request, or false if the credentials are
+// missing or invalid.
+//
+// The returned user is matched by username with the configured ones, which
+// define the scope and the permissions, so that an authenticator only needs to
+// verify the credentials, for example against LDAP or a database.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*User, bool)
+}
+
+// usersAuthenticator is the default authenticator, which checks the password
+// of the configured users.
+type usersAuthenticator struct {
+	lookup func(username string) (*handlerUser, bool)
+	logger *zap.Logger
+}
+
+func (a usersAuthenticator) Authenticate(r *http.Request) (*User, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+
+	user, ok := a.lookup(username)
+	if !ok {
+		return nil, false
+	}
+
+	if !user.checkPassword(password) {
+		requestLogger(r, a.logger).Info("invalid password", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
+		return nil, false
+	}
+
+	return &user.User, true
+}
@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// mapAuthenticator authenticates the users of a credential store, along with
+// the usernames they map to.
+type mapAuthenticator map[string]string
+
+func (a mapAuthenticator) Authenticate(r *http.Request) (*User, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok || a[username+":"+password] == "" {
+		return nil, false
+	}
+	return &User{Username: a[username+":"+password]}, true
+}
+
+func TestAuthenticator(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Auth: true,
+		Authenticator: mapAuthenticator{
+			"alice:secret":   "alice",
+			"bob:secret":     "bob",
+			"carol:external": "unknown",
+		},
+		Users: []User{
+			{Username: "alice"},
+			{Username: "bob", Password: "configured"},
+		},
+	})
+
+	request := func(username, password string) int {
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		if username != "" {
+			r.SetBasicAuth(username, password)
+		}
+		return doRequest(h, r).Code
+	}
+
+	// Accepted by the authenticator, even without a configured password.
+	require.Equal(t, http.StatusOK, request("alice", "secret"))
+	require.Equal(t, http.StatusOK, request("bob", "secret"))
+
+	// Rejected by the authenticator, even with the configured password.
+	require.Equal(t, http.StatusUnauthorized, request("alice", "wrong"))
+	require.Equal(t, http.StatusUnauthorized, request("bob", "configured"))
+	require.Equal(t, http.StatusUnauthorized, request("", ""))
+
+	// Accepted, but not one of the configured users.
+	require.Equal(t, http.StatusUnauthorized, request("carol", "external"))
+}
+
+func TestConfigAuthenticator(t *testing.T) {
+	t.Parallel()
+
+	users := []User{{Username: "alice"}}
+	cfg := &Config{Auth: true, Authenticator: mapAuthenticator{}, Users: users}
+	require.NoError(t, cfg.Validate())
+
+	cfg = &Config{Auth: true, AuthMethod: AuthMethodDigest, Digest: Digest{NonceTimeout: time.Minute}, Authenticator: mapAuthenticator{}, Users: users}
+	require.ErrorContains(t, cfg.Validate(), "authenticator")
+
+	// Without an authenticator, the passwords are required.
+	cfg = &Config{Auth: true, Users: []User{{Username: "alice"}}}
+	require.ErrorContains(t, cfg.Validate(), "password must be set")
+}
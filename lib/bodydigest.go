@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var errDigestMismatch = errors.New("digest does not match the body")
+
+// digestAlgorithms are the algorithms of the Digest header, as registered for
+// RFC 3230, that the uploads can be verified with.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"md5":     md5.New,
+	"sha-256": sha256.New,
+}
+
+// bodyDigest computes the digests of a request body, as it is read, so that
+// they can be compared with the ones sent by the client, once it is written.
+type bodyDigest struct {
+	algorithms []string
+	expected   [][]byte
+	hashes     []hash.Hash
+}
+
+// newBodyDigest returns the digests to verify the body of the request with, as
+// given by the Content-MD5 and the Digest headers. The algorithms that are not
+// supported are ignored. If there is nothing to verify, nil is returned.
+func newBodyDigest(header http.Header) (*bodyDigest, error) {
+	d := &bodyDigest{}
+
+	if value := header.Get("Content-MD5"); value != "" {
+		if err := d.add("md5", value); err != nil {
+			return nil, fmt.Errorf("invalid Content-MD5 header: %w", err)
+		}
+	}
+
+	for _, value := range header.Values("Digest") {
+		for _, part := range strings.Split(value, ",") {
+			algorithm, sum, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok {
+				return nil, errors.New("invalid Digest header")
+			}
+
+			algorithm = strings.ToLower(algorithm)
+			if _, ok := digestAlgorithms[algorithm]; !ok {
+				continue
+			}
+			if err := d.add(algorithm, sum); err != nil {
+				return nil, fmt.Errorf("invalid Digest header: %w", err)
+			}
+		}
+	}
+
+	if len(d.hashes) == 0 {
+		return nil, nil
+	}
+	return d, nil
+}
+
+func (d *bodyDigest) add(algorithm, value string) error {
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return err
+	}
+
+	h := digestAlgorithms[algorithm]()
+	if len(sum) != h.Size() {
+		return fmt.Errorf("%s digest has the wrong length", algorithm)
+	}
+
+	d.algorithms = append(d.algorithms, algorithm)
+	d.expected = append(d.expected, sum)
+	d.hashes = append(d.hashes, h)
+	return nil
+}
+
+// reader returns the body, which computes the digests as it is read.
+func (d *bodyDigest) reader(body io.ReadCloser) io.ReadCloser {
+	writers := make([]io.Writer, 0, len(d.hashes))
+	for _, h := range d.hashes {
+		writers = append(writers, h)
+	}
+	return digestReader{Reader: io.TeeReader(body, io.MultiWriter(writers...)), Closer: body}
+}
+
+// verify compares the digests of the body read so far to the expected ones.
+func (d *bodyDigest) verify() error {
+	for i, h := range d.hashes {
+		if !bytes.Equal(h.Sum(nil), d.expected[i]) {
+			return fmt.Errorf("%w: %s", errDigestMismatch, d.algorithms[i])
+		}
+	}
+	return nil
+}
+
+type digestReader struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyDigest(t *testing.T) {
+	t.Parallel()
+
+	const content = "new content"
+	md5Sum := md5.Sum([]byte(content))
+	sha256Sum := sha256.Sum256([]byte(content))
+	corrupted := sha256.Sum256([]byte("corrupted"))
+
+	put := func(h http.Handler, name string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, name, strings.NewReader(content))
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		return doRequest(h, r)
+	}
+
+	newHandler := func(t *testing.T, verify bool) (http.Handler, string) {
+		h := newTestHandler(t, &Config{Permissions: Permissions{Modify: true}, VerifyDigest: verify})
+		return h, h.(*Handler).handler.user.Scope
+	}
+
+	t.Run("Matching", func(t *testing.T) {
+		t.Parallel()
+
+		h, dir := newHandler(t, true)
+		for name, header := range map[string]http.Header{
+			"/md5.txt":     {"Content-Md5": {base64.StdEncoding.EncodeToString(md5Sum[:])}},
+			"/sha256.txt":  {"Digest": {"SHA-256=" + base64.StdEncoding.EncodeToString(sha256Sum[:])}},
+			"/unknown.txt": {"Digest": {"unixsum=30637"}},
+		} {
+			require.Equal(t, http.StatusCreated, put(h, name, header).Code, name)
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			require.NoError(t, err)
+			require.Equal(t, content, string(data))
+		}
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		t.Parallel()
+
+		h, dir := newHandler(t, true)
+		w := put(h, "/new.txt", http.Header{"Digest": {
+			"md5=" + base64.StdEncoding.EncodeToString(md5Sum[:]) + ", sha-256=" + base64.StdEncoding.EncodeToString(corrupted[:]),
+		}})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.NoFileExists(t, filepath.Join(dir, "new.txt"))
+
+		// The existing file is left untouched.
+		w = put(h, "/file.txt", http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(corrupted[:md5.Size])}})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		data, err := os.ReadFile(filepath.Join(dir, "file.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "content", string(data))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		h, dir := newHandler(t, true)
+		w := put(h, "/new.txt", http.Header{"Content-Md5": {"not base64"}})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.NoFileExists(t, filepath.Join(dir, "new.txt"))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t, false)
+		w := put(h, "/new.txt", http.Header{"Digest": {"sha-256=" + base64.StdEncoding.EncodeToString(corrupted[:])}})
+		require.Equal(t, http.StatusCreated, w.Code)
+	})
+}
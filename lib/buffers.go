@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// bufferPool keeps the buffers that the uploads are copied with, so that the
+// concurrent uploads do not allocate a buffer each.
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	if size <= 0 {
+		return nil
+	}
+
+	p := &bufferPool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, p.size)
+		return &buf
+	}
+	return p
+}
+
+type bufferPoolKey struct{}
+
+// withBufferPool makes the upload of the request copied to the file system
+// with the buffers of the pool.
+func withBufferPool(r *http.Request, p *bufferPool) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), bufferPoolKey{}, p))
+}
+
+// copyUpload copies the body of an upload to the file, with a buffer of the
+// pool of the request, if any. The file is always written to, rather than made
+// to read from the body, as the files call it from their ReadFrom method.
+func copyUpload(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	dst = struct{ io.Writer }{dst}
+	p, _ := ctx.Value(bufferPoolKey{}).(*bufferPool)
+	if p == nil {
+		return io.Copy(dst, src)
+	}
+
+	buf := p.pool.Get().(*[]byte)
+	defer p.pool.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
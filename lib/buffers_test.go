@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerUploadBuffer(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Permissions:  Permissions{Modify: true},
+		UploadBuffer: 4096,
+	}
+	h := newTestHandler(t, cfg)
+
+	for _, size := range []int{0, 1, 4095, 4096, 4097, 3*4096 + 17, 1 << 20} {
+		data := bytes.Repeat([]byte("0123456789abcdef"), size/16+1)[:size]
+
+		// The body is only read, so that it is copied with the buffer.
+		r := httptest.NewRequest(http.MethodPut, "/upload.bin", struct{ io.Reader }{bytes.NewReader(data)})
+		require.Equal(t, http.StatusCreated, doRequest(h, r).Code, size)
+
+		written, err := os.ReadFile(filepath.Join(cfg.Scope, "upload.bin"))
+		require.NoError(t, err)
+		require.Equal(t, data, written, size)
+	}
+}
+
+func TestConfigUploadBuffer(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, ``, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, DefaultUploadBuffer, cfg.UploadBuffer)
+
+	cfg = &Config{UploadBuffer: -1}
+	require.ErrorContains(t, cfg.Validate(), "upload buffer cannot be negative")
+}
+
+func BenchmarkCopyUpload(b *testing.B) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 16*1024)
+
+	for _, bench := range []struct {
+		name string
+		ctx  context.Context
+	}{
+		{"Allocated", context.Background()},
+		{"Pooled", context.WithValue(context.Background(), bufferPoolKey{}, newBufferPool(DefaultUploadBuffer))},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, err := copyUpload(bench.ctx, io.Discard, struct{ io.Reader }{bytes.NewReader(data)})
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package lib
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+)
+
+// calendarAccessClass is the compliance class of the servers of calendars.
+//
+// See https://www.rfc-editor.org/rfc/rfc4791#section-5.1
+const calendarAccessClass = "calendar-access"
+
+var resourceTypeProperty = xml.Name{Space: "DAV:", Local: "resourcetype"}
+
+// calendarResourceType is the resource type of the calendar collections, which
+// are collections too.
+//
+// See https://www.rfc-editor.org/rfc/rfc4791#section-4.2
+const calendarResourceType = `<D:collection xmlns:D="DAV:"/><C:calendar xmlns:C="urn:ietf:params:xml:ns:caldav"/>`
+
+// mkcalendarRequest is the optional body of a MKCALENDAR request, with the
+// properties to set on the calendar.
+type mkcalendarRequest struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav mkcalendar"`
+	Set     []struct {
+		Prop struct {
+			Props []webdav.Property `xml:",any"`
+		} `xml:"DAV: prop"`
+	} `xml:"DAV: set"`
+}
+
+// calendarProperties returns the properties of the calendar, beginning with its
+// resource type, and followed by the ones set by the body of the request. The
+// DAV: properties are computed by the server, except for the display name, so
+// they cannot be set.
+func calendarProperties(r *http.Request) ([]webdav.Property, error) {
+	props := []webdav.Property{{XMLName: resourceTypeProperty, InnerXML: []byte(calendarResourceType)}}
+
+	var req mkcalendarRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); errors.Is(err, io.EOF) {
+		return props, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, set := range req.Set {
+		for _, p := range set.Prop.Props {
+			if p.XMLName.Space == "DAV:" && p.XMLName.Local != "displayname" {
+				return nil, errProtectedProperty
+			}
+			props = append(props, p)
+		}
+	}
+	return props, nil
+}
+
+var errProtectedProperty = errors.New("protected property")
+
+// serveMkcalendar creates a calendar collection, that is, a collection whose
+// resource type includes the calendar one. The resource type is stored as a
+// dead property, which takes precedence over the one of the WebDAV handler.
+//
+// See https://www.rfc-editor.org/rfc/rfc4791#section-5.3.1
+func (u *handlerUser) serveMkcalendar(w http.ResponseWriter, r *http.Request, name string) {
+	props, err := calendarProperties(r)
+	if errors.Is(err, errProtectedProperty) {
+		serveError(w, r, http.StatusForbidden, "Forbidden", "<D:cannot-modify-protected-property/>")
+		return
+	} else if err != nil {
+		http.Error(w, "Invalid MKCALENDAR body", http.StatusBadRequest)
+		return
+	}
+
+	release, err := u.confirmLocks(r, name)
+	if err != nil {
+		if errors.Is(err, webdav.ErrLocked) {
+			if u.Logger != nil {
+				u.Logger(r, err)
+			}
+			http.Error(w, "Locked", http.StatusLocked)
+		} else {
+			http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+		}
+		return
+	}
+	defer release()
+
+	ctx := r.Context()
+	if err := u.FileSystem.Mkdir(ctx, name, 0777); err != nil {
+		switch {
+		case os.IsNotExist(err):
+			http.Error(w, "Conflict", http.StatusConflict)
+		case os.IsPermission(err):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		default:
+			// Something already exists at the path.
+			serveError(w, r, http.StatusMethodNotAllowed, "Method not allowed", `<C:resource-must-be-null xmlns:C="urn:ietf:params:xml:ns:caldav"/>`)
+		}
+		return
+	}
+
+	// The calendar is removed if its properties cannot be set, as it must not
+	// be created then.
+	if err := u.setCalendarProperties(r, name, props); err != nil {
+		requestLogger(r, u.logger).Error("failed to set calendar properties", zap.String("path", name), zap.Error(err))
+		_ = u.FileSystem.RemoveAll(ctx, name)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (u *handlerUser) setCalendarProperties(r *http.Request, name string, props []webdav.Property) error {
+	f, err := u.FileSystem.OpenFile(r.Context(), name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	holder, ok := f.(webdav.DeadPropsHolder)
+	if !ok {
+		return errors.New("dead properties not supported")
+	}
+	stats, err := holder.Patch([]webdav.Proppatch{{Props: props}})
+	if err != nil {
+		return err
+	}
+	for _, stat := range stats {
+		if stat.Status != http.StatusOK {
+			return errors.New("dead properties not set")
+		}
+	}
+	return nil
+}
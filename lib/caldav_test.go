@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMkcalendar(t *testing.T) {
+	t.Parallel()
+
+	const mkcalendarBody = `<?xml version="1.0" encoding="utf-8"?>
+<C:mkcalendar xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:set><D:prop><D:displayname>Work</D:displayname><C:calendar-description>Meetings</C:calendar-description></D:prop></D:set>
+</C:mkcalendar>`
+
+	const findBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:resourcetype/><D:displayname/><C:calendar-description/></D:prop>
+</D:propfind>`
+
+	newHandler := func(t *testing.T, cfg *Config) (http.Handler, string) {
+		cfg.Permissions = Permissions{Modify: true}
+		cfg.CalDAV = true
+		h := newTestHandler(t, cfg)
+		return h, h.(*Handler).handler.user.Scope
+	}
+
+	request := func(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Depth", "0")
+		return doRequest(h, r)
+	}
+
+	for name, cfg := range map[string]*Config{
+		"Disk":   {DeadProperties: true},
+		"Memory": {Backend: BackendMemory},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h, scope := newHandler(t, cfg)
+			require.Equal(t, http.StatusCreated, request(h, "MKCALENDAR", "/work/", mkcalendarBody).Code)
+			if cfg.Backend != BackendMemory {
+				require.DirExists(t, filepath.Join(scope, "work"))
+			}
+
+			w := request(h, "PROPFIND", "/work/", findBody)
+			require.Equal(t, http.StatusMultiStatus, w.Code)
+			require.Contains(t, w.Body.String(), "<D:resourcetype>"+calendarResourceType+"</D:resourcetype>")
+			require.Contains(t, w.Body.String(), "Work</D:displayname>")
+			require.Contains(t, w.Body.String(), "Meetings</")
+
+			// The calendars are collections, which can have members.
+			require.Equal(t, http.StatusCreated, request(h, http.MethodPut, "/work/event.ics", "BEGIN:VCALENDAR").Code)
+
+			// The other collections are not calendars.
+			require.Equal(t, http.StatusCreated, request(h, "MKCOL", "/plain/", "").Code)
+			w = request(h, "PROPFIND", "/plain/", findBody)
+			require.Equal(t, http.StatusMultiStatus, w.Code)
+			require.Contains(t, w.Body.String(), `<D:resourcetype><D:collection xmlns:D="DAV:"/></D:resourcetype>`)
+		})
+	}
+
+	t.Run("Without Body", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t, &Config{DeadProperties: true})
+		require.Equal(t, http.StatusCreated, request(h, "MKCALENDAR", "/home/", "").Code)
+
+		w := request(h, "PROPFIND", "/home/", findBody)
+		require.Contains(t, w.Body.String(), "<D:resourcetype>"+calendarResourceType+"</D:resourcetype>")
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, &Config{DeadProperties: true})
+		require.Equal(t, http.StatusMethodNotAllowed, request(h, "MKCALENDAR", "/dir/", "").Code)
+		require.Equal(t, http.StatusMethodNotAllowed, request(h, "MKCALENDAR", "/file.txt", "").Code)
+		require.Equal(t, http.StatusConflict, request(h, "MKCALENDAR", "/missing/work/", "").Code)
+		require.Equal(t, http.StatusBadRequest, request(h, "MKCALENDAR", "/work/", "<mkcalendar").Code)
+
+		// The resource type cannot be set by the clients.
+		body := `<C:mkcalendar xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:set><D:prop><D:resourcetype/></D:prop></D:set></C:mkcalendar>`
+		require.Equal(t, http.StatusForbidden, request(h, "MKCALENDAR", "/work/", body).Code)
+		require.NoDirExists(t, filepath.Join(scope, "work"))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		// The method is not implemented, as any other the server does not support.
+		h := newTestHandler(t, &Config{Permissions: Permissions{Modify: true}})
+		require.Equal(t, http.StatusNotImplemented, request(h, "MKCALENDAR", "/work/", "").Code)
+
+		w := request(h, http.MethodOptions, "/missing", "")
+		require.NotContains(t, w.Header().Get("Allow"), "MKCALENDAR")
+		require.NotContains(t, w.Header().Get("DAV"), calendarAccessClass)
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t, &Config{DeadProperties: true})
+		w := request(h, http.MethodOptions, "/missing", "")
+		require.Contains(t, w.Header().Get("Allow"), "MKCALENDAR")
+		require.Contains(t, w.Header().Get("DAV"), calendarAccessClass)
+	})
+}
+
+func TestConfigCalDAV(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{CalDAV: true}
+	require.ErrorContains(t, cfg.Validate(), "caldav requires dead properties")
+
+	cfg = &Config{CalDAV: true, DeadProperties: true}
+	require.NoError(t, cfg.Validate())
+}
@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+const (
+	// caseListingTTL is how long the listings of the directories are cached,
+	// so that resolving many paths in the same directory scans it only once.
+	caseListingTTL = 2 * time.Second
+
+	// caseListingCacheSize is the maximum number of listings in the cache.
+	caseListingCacheSize = 1000
+)
+
+// caseListings caches the names of the entries of the directories, which are
+// searched for the case-insensitive matches.
+type caseListings struct {
+	mu      sync.Mutex
+	entries map[string]caseListing
+}
+
+type caseListing struct {
+	names   []string
+	expires time.Time
+}
+
+func newCaseListings() *caseListings {
+	return &caseListings{entries: map[string]caseListing{}}
+}
+
+// names returns the names of the entries of the directory.
+func (c *caseListings) names(ctx context.Context, fs webdav.FileSystem, dir string) ([]string, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	listing, ok := c.entries[dir]
+	c.mu.Unlock()
+	if ok && now.Before(listing.expires) {
+		return listing.names, nil
+	}
+
+	f, err := fs.OpenFile(ctx, dir, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The listings expire quickly, so starting over is enough to bound the
+	// memory they use.
+	if len(c.entries) >= caseListingCacheSize {
+		c.entries = map[string]caseListing{}
+	}
+	c.entries[dir] = caseListing{names: names, expires: now.Add(caseListingTTL)}
+	return names, nil
+}
+
+// invalidate forgets the listing of a directory, after its entries changed.
+func (c *caseListings) invalidate(dir string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, path.Clean("/"+dir))
+}
+
+// match returns the name of the entry of the directory matching the given name,
+// case-insensitively. An exact match is preferred. Otherwise, the match must be
+// unambiguous, as several entries could only differ by case.
+func (c *caseListings) match(ctx context.Context, fs webdav.FileSystem, dir, name string) (string, bool) {
+	names, err := c.names(ctx, fs, dir)
+	if err != nil {
+		return "", false
+	}
+
+	match := ""
+	for _, candidate := range names {
+		if candidate == name {
+			return name, true
+		}
+		if strings.EqualFold(candidate, name) {
+			if match != "" {
+				return "", false
+			}
+			match = candidate
+		}
+	}
+	return match, match != ""
+}
+
+type literalNameKey struct{}
+
+// withCaseChange prepares a move or a copy whose destination only differs from
+// its source by case. Otherwise, the destination would resolve to the source,
+// which the WebDAV handler would remove to overwrite it. The base name of the
+// destination is thus kept as is. If only the case of the parent directories
+// differs, both are the same file, and false is returned.
+func withCaseChange(r *http.Request) (*http.Request, bool) {
+	dst, ok := destinationPath(r)
+	src := path.Clean("/" + r.URL.Path)
+	dst = path.Clean(dst)
+	if !ok || src == dst || !strings.EqualFold(src, dst) {
+		return r, true
+	}
+	if path.Base(src) == path.Base(dst) {
+		return r, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), literalNameKey{}, path.Base(dst))), true
+}
+
+// resolve returns the name of the file matching the given name, with the case
+// of each segment matched against the existing entries, if the name does not
+// exist as is. The segments that match no entry are kept as they are.
+func (d Dir) resolve(ctx context.Context, name string) string {
+	if d.caseListings == nil {
+		return name
+	}
+	if literal, _ := ctx.Value(literalNameKey{}).(string); literal != "" && path.Base(name) == literal {
+		return name
+	}
+	if _, err := d.FileSystem.Stat(ctx, name); !errors.Is(err, os.ErrNotExist) {
+		return name
+	}
+
+	segments := strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/")
+	resolved := "/"
+	for i, segment := range segments {
+		match, ok := d.caseListings.match(ctx, d.FileSystem, resolved, segment)
+		if !ok {
+			return path.Join(append([]string{resolved}, segments[i:]...)...)
+		}
+		resolved = path.Join(resolved, match)
+	}
+	return resolved
+}
+
+// resolveParent resolves the parent directory of the name, but not the name
+// itself, as is needed for the names that are created, such as when renaming a
+// file to change its case.
+func (d Dir) resolveParent(ctx context.Context, name string) string {
+	if d.caseListings == nil {
+		return name
+	}
+
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return name
+	}
+	return path.Join(d.resolve(ctx, path.Dir(name)), path.Base(name))
+}
+
+// invalidateListing forgets the listing of the directory of a name, as its
+// entries changed, and whether the directories holding it are empty.
+func (d Dir) invalidateListing(name string) {
+	if d.caseListings != nil {
+		d.caseListings.invalidate(path.Dir(path.Clean("/" + name)))
+	}
+	if d.emptyDirs != nil {
+		d.emptyDirs.invalidate(name)
+	}
+}
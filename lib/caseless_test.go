@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaseInsensitive(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T, caseInsensitive bool) (http.Handler, string) {
+		dir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "photos"), 0777))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "photos", "img.jpg"), []byte("image"), 0666))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "photos", "Both.txt"), []byte("upper"), 0666))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "photos", "both.txt"), []byte("lower"), 0666))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "photos", "BOTH.TXT"), []byte("caps"), 0666))
+
+		return newTestHandler(t, &Config{
+			Permissions:     Permissions{Scope: dir, Modify: true},
+			CaseInsensitive: caseInsensitive,
+		}), dir
+	}
+
+	t.Run("Mismatch", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t, true)
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/Photos/Img.JPG", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "image", w.Body.String())
+	})
+
+	t.Run("Exact", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t, true)
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/PHOTOS/both.txt", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "lower", w.Body.String())
+	})
+
+	t.Run("Ambiguous", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t, true)
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/photos/both.TXT", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Upload", func(t *testing.T) {
+		t.Parallel()
+
+		h, dir := newHandler(t, true)
+		w := doRequest(h, httptest.NewRequest(http.MethodPut, "/PHOTOS/new.jpg", nil))
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.FileExists(t, filepath.Join(dir, "photos", "new.jpg"))
+
+		// The new file is found right away, despite the cached listing.
+		w = doRequest(h, httptest.NewRequest(http.MethodGet, "/photos/NEW.JPG", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		t.Parallel()
+
+		h, dir := newHandler(t, true)
+		r := httptest.NewRequest("MOVE", "/photos/img.jpg", nil)
+		r.Header.Set("Destination", "/Photos/IMG.jpg")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.FileExists(t, filepath.Join(dir, "photos", "IMG.jpg"))
+		require.NoFileExists(t, filepath.Join(dir, "photos", "img.jpg"))
+	})
+
+	t.Run("SameFile", func(t *testing.T) {
+		t.Parallel()
+
+		h, dir := newHandler(t, true)
+		r := httptest.NewRequest("MOVE", "/photos/img.jpg", nil)
+		r.Header.Set("Destination", "/Photos/img.jpg")
+		r.Header.Set("Overwrite", "T")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.FileExists(t, filepath.Join(dir, "photos", "img.jpg"))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t, false)
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/Photos/Img.JPG", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+const (
+	CertificateFieldCN    = "cn"
+	CertificateFieldEmail = "email"
+	CertificateFieldDNS   = "dns"
+	CertificateFieldURI   = "uri"
+)
+
+// CertificateMapping maps the subject of a client certificate to a username.
+type CertificateMapping struct {
+	Subject  string
+	Username string
+}
+
+// certificateAuth authenticates users by the TLS client certificate they
+// present. The username is extracted from the configured certificate field
+// and, optionally, mapped to a different username.
+type certificateAuth struct {
+	field    string
+	mappings map[string]string
+}
+
+func newCertificateAuth(c Certificate) *certificateAuth {
+	a := &certificateAuth{
+		field:    c.Field,
+		mappings: map[string]string{},
+	}
+
+	for _, m := range c.Mappings {
+		a.mappings[m.Subject] = m.Username
+	}
+
+	return a
+}
+
+// subjects returns the values of the configured field in the certificate.
+func (a *certificateAuth) subjects(cert *x509.Certificate) []string {
+	switch a.field {
+	case CertificateFieldEmail:
+		return cert.EmailAddresses
+	case CertificateFieldDNS:
+		return cert.DNSNames
+	case CertificateFieldURI:
+		uris := make([]string, len(cert.URIs))
+		for i, uri := range cert.URIs {
+			uris[i] = uri.String()
+		}
+		return uris
+	default:
+		if cert.Subject.CommonName == "" {
+			return nil
+		}
+		return []string{cert.Subject.CommonName}
+	}
+}
+
+// usernames returns the candidate usernames for the given certificate, in
+// order of preference.
+func (a *certificateAuth) usernames(cert *x509.Certificate) []string {
+	subjects := a.subjects(cert)
+	usernames := make([]string, len(subjects))
+	for i, subject := range subjects {
+		if username, ok := a.mappings[subject]; ok {
+			usernames[i] = username
+		} else {
+			usernames[i] = subject
+		}
+	}
+	return usernames
+}
+
+// clientCertificate returns the verified client certificate of the request, if any.
+func clientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// TLSConfig returns the TLS configuration to use for the server. It is nil if
+// no specific configuration is needed.
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	if !c.TLS || c.AuthMethod != AuthMethodCertificate {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(c.Certificate.ClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("failed to parse client CA: no certificates found")
+	}
+
+	// Clients without certificates can still connect, so that they can fall back
+	// to Basic authentication.
+	return &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  pool,
+	}, nil
+}
@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requestWithCertificate(cert *x509.Certificate) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	return r
+}
+
+func TestCertificateAuth(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T, certificate Certificate) http.Handler {
+		return newTestHandler(t, &Config{
+			Auth:        true,
+			AuthMethod:  AuthMethodCertificate,
+			TLS:         true,
+			Cert:        "cert.pem",
+			Key:         "key.pem",
+			Certificate: certificate,
+			Users: []User{
+				{Username: "alice", Password: "alice"},
+				{Username: "bob", Password: "bob"},
+			},
+		})
+	}
+
+	t.Run("Common Name", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHandler(t, Certificate{ClientCA: "ca.pem", Field: CertificateFieldCN})
+
+		w := doRequest(h, requestWithCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = doRequest(h, requestWithCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "mallory"}}))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Mappings", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHandler(t, Certificate{
+			ClientCA: "ca.pem",
+			Field:    CertificateFieldEmail,
+			Mappings: []CertificateMapping{{Subject: "robert@example.com", Username: "bob"}},
+		})
+
+		w := doRequest(h, requestWithCertificate(&x509.Certificate{EmailAddresses: []string{"robert@example.com"}}))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		// The common name is ignored when using a different field.
+		w = doRequest(h, requestWithCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Fallback Without TLS", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHandler(t, Certificate{ClientCA: "ca.pem", Field: CertificateFieldCN})
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Contains(t, w.Header().Get("WWW-Authenticate"), "Basic")
+
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.SetBasicAuth("bob", "bob")
+		w = doRequest(h, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestCertificateConfig(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0666))
+
+	cfg := &Config{
+		TLS:         true,
+		AuthMethod:  AuthMethodCertificate,
+		Certificate: Certificate{ClientCA: caFile, Field: CertificateFieldCN},
+	}
+
+	tlsConfig, err := cfg.TLSConfig()
+	require.NoError(t, err)
+	require.Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+	require.NotNil(t, tlsConfig.ClientCAs)
+
+	cfg.Certificate.ClientCA = filepath.Join(t.TempDir(), "missing.pem")
+	_, err = cfg.TLSConfig()
+	require.Error(t, err)
+
+	cfg.AuthMethod = AuthMethodBasic
+	tlsConfig, err = cfg.TLSConfig()
+	require.NoError(t, err)
+	require.Nil(t, tlsConfig)
+}
@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"net/http"
+	"strings"
+)
+
+// authSchemes are the schemes of the Authorization header that the credentials
+// are sent with, by auth method.
+var authSchemes = map[string]string{
+	AuthMethodBasic:         "basic",
+	AuthMethodDigest:        "digest",
+	AuthMethodJWT:           "bearer",
+	AuthMethodIntrospection: "bearer",
+	AuthMethodLDAP:          "basic",
+}
+
+// multiAuthenticate authenticates the request with the auth method matching the
+// scheme of its credentials. Without credentials, or with credentials of some
+// other scheme, the first auth method is used, which challenges the client with
+// all of them. If it fails, the response is written and false is returned.
+func (h *handler) multiAuthenticate(w http.ResponseWriter, r *http.Request) (*handlerUser, bool) {
+	scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+
+	method := h.authMethods[0]
+	for _, m := range h.authMethods {
+		if strings.EqualFold(scheme, authSchemes[m]) {
+			method = m
+			break
+		}
+	}
+
+	switch method {
+	case AuthMethodDigest:
+		return h.digestAuthenticate(w, r)
+	case AuthMethodJWT:
+		return h.jwtAuthenticate(w, r)
+	case AuthMethodIntrospection:
+		return h.introspectionAuthenticate(w, r)
+	case AuthMethodLDAP:
+		return h.ldapAuthenticate(w, r)
+	default:
+		return h.basicAuthenticate(w, r)
+	}
+}
+
+// setChallenge sets the WWW-Authenticate header of the response to the given
+// challenge of the auth method. With several auth methods, the challenges of
+// the others are sent as well, in the order they are configured in.
+func (h *handler) setChallenge(w http.ResponseWriter, method, challenge string) {
+	if len(h.authMethods) == 0 {
+		w.Header().Set("WWW-Authenticate", challenge)
+		return
+	}
+
+	w.Header().Del("WWW-Authenticate")
+	for _, m := range h.authMethods {
+		if m == method {
+			w.Header().Add("WWW-Authenticate", challenge)
+			continue
+		}
+
+		switch m {
+		case AuthMethodBasic, AuthMethodLDAP:
+			w.Header().Add("WWW-Authenticate", `Basic realm="`+h.realm+`"`)
+		case AuthMethodDigest:
+			w.Header().Add("WWW-Authenticate", h.digest.challenge(false))
+		default:
+			w.Header().Add("WWW-Authenticate", `Bearer realm="`+h.realm+`"`)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerAuthMethods(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("secret")
+	h := newTestHandler(t, &Config{
+		Auth:        true,
+		AuthMethods: []string{AuthMethodJWT, AuthMethodDigest, AuthMethodBasic},
+		Digest:      Digest{NonceTimeout: time.Minute},
+		JWT: JWT{
+			Secret:        string(secret),
+			Algorithm:     "HS256",
+			UsernameClaim: "preferred_username",
+		},
+		Users: []User{{Username: "alice", Password: "alice"}},
+	})
+
+	requireChallenges := func(t *testing.T, w *httptest.ResponseRecorder) []string {
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		challenges := w.Header().Values("WWW-Authenticate")
+		require.Len(t, challenges, 3)
+		require.Equal(t, `Bearer realm="`+DefaultRealm+`"`, challenges[0])
+		require.True(t, strings.HasPrefix(challenges[1], "Digest "), challenges[1])
+		require.Equal(t, `Basic realm="`+DefaultRealm+`"`, challenges[2])
+		return challenges
+	}
+
+	t.Run("Challenges", func(t *testing.T) {
+		t.Parallel()
+
+		requireChallenges(t, doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil)))
+
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.Header.Set("Authorization", "Negotiate token")
+		requireChallenges(t, doRequest(h, r))
+
+		// The failed scheme gets its own challenge, in its place.
+		w := doRequest(h, bearerRequest("not-a-token"))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		challenges := w.Header().Values("WWW-Authenticate")
+		require.Len(t, challenges, 3)
+		require.Equal(t, `Bearer realm="`+DefaultRealm+`", error="invalid_token"`, challenges[0])
+		require.Equal(t, `Basic realm="`+DefaultRealm+`"`, challenges[2])
+	})
+
+	t.Run("Bearer", func(t *testing.T) {
+		t.Parallel()
+
+		token := signJWT(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"preferred_username": "alice", "exp": time.Now().Add(time.Hour).Unix()})
+		require.Equal(t, http.StatusOK, doRequest(h, bearerRequest(token)).Code)
+	})
+
+	t.Run("Digest", func(t *testing.T) {
+		t.Parallel()
+
+		challenges := requireChallenges(t, doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil)))
+
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.Header.Set("Authorization", digestAuthorization(t, challenges[1], "alice", "alice", http.MethodGet, "/file.txt"))
+		require.Equal(t, http.StatusOK, doRequest(h, r).Code)
+	})
+
+	t.Run("Basic", func(t *testing.T) {
+		t.Parallel()
+
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.SetBasicAuth("alice", "alice")
+		require.Equal(t, http.StatusOK, doRequest(h, r).Code)
+
+		r = httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.SetBasicAuth("alice", "wrong")
+		requireChallenges(t, doRequest(h, r))
+	})
+}
+
+func TestConfigAuthMethods(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+auth: true
+auth_methods:
+  - digest
+  - basic
+users:
+  - username: alice
+    password: alice
+`, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, []string{AuthMethodDigest, AuthMethodBasic}, cfg.AuthMethods)
+
+	for methods, err := range map[string]string{
+		AuthMethodCertificate + "," + AuthMethodBasic: "cannot be one of several auth methods",
+		AuthMethodJWT + "," + AuthMethodIntrospection: "use the same scheme",
+		AuthMethodBasic + "," + AuthMethodBasic:       "use the same scheme",
+		AuthMethodDigest + "," + "unknown":            "cannot be one of several auth methods",
+	} {
+		cfg := &Config{Auth: true, AuthMethods: strings.Split(methods, ","), Users: []User{{Username: "alice", Password: "alice"}}}
+		require.ErrorContains(t, cfg.Validate(), err, methods)
+	}
+}
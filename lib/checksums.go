@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// checksumsProperty is the property holding the checksums of the files, as
+// understood by the ownCloud and Nextcloud clients.
+var checksumsProperty = xml.Name{Space: "http://owncloud.org/ns", Local: "checksums"}
+
+// getETagProperty is the property holding the ETags, which is also reported
+// for the collections if enabled.
+var getETagProperty = xml.Name{Space: "DAV:", Local: "getetag"}
+
+// checksumCacheSize is the maximum number of checksums kept in the cache.
+const checksumCacheSize = 10000
+
+// checksumCache keeps the checksums of the files, by name, along with the
+// modification time and the size they were computed for, so that they are
+// computed again when the file changes.
+type checksumCache struct {
+	mu      sync.Mutex
+	entries map[string]checksumEntry
+}
+
+type checksumEntry struct {
+	modTime time.Time
+	size    int64
+	sum     string
+}
+
+func newChecksumCache() *checksumCache {
+	return &checksumCache{entries: map[string]checksumEntry{}}
+}
+
+// checksum returns the hex encoded SHA-256 of the contents of the file.
+func (c *checksumCache) checksum(ctx context.Context, fs webdav.FileSystem, name string, info os.FileInfo) (string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[name]
+	c.mu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.sum, nil
+	}
+
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Make room by evicting any entry, which is enough to bound the memory
+	// used by the files that were removed or renamed.
+	if _, ok := c.entries[name]; !ok && len(c.entries) >= checksumCacheSize {
+		for key := range c.entries {
+			delete(c.entries, key)
+			break
+		}
+	}
+	c.entries[name] = checksumEntry{modTime: info.ModTime(), size: info.Size(), sum: sum}
+	return sum, nil
+}
+
+// DeadProps returns the dead properties of the file, if they are stored or the
+// file system holds them, along with the checksums property, if enabled.
+func (f dirFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	props := map[xml.Name]webdav.Property{}
+	if f.dir.deadProps != nil {
+		stored, err := f.dir.deadProps.get(context.Background(), f.dir.FileSystem, f.name)
+		if err != nil {
+			return nil, err
+		}
+		props = stored
+	} else if holder, ok := f.File.(webdav.DeadPropsHolder); ok {
+		inner, err := holder.DeadProps()
+		if err != nil {
+			return nil, err
+		}
+		for name, prop := range inner {
+			props[name] = prop
+		}
+	}
+
+	if f.dir.checksums == nil && !f.dir.dirETags {
+		return props, nil
+	}
+
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// The WebDAV handler only reports the ETags of the files.
+	if info.IsDir() && f.dir.dirETags {
+		etag, err := collectionETag(context.Background(), f.dir, f.name, info)
+		if err != nil {
+			return nil, err
+		}
+		props[getETagProperty] = webdav.Property{XMLName: getETagProperty, InnerXML: []byte(html.EscapeString(etag))}
+	}
+	if info.IsDir() || f.dir.checksums == nil {
+		return props, nil
+	}
+
+	sum, err := f.dir.checksums.checksum(context.Background(), f.dir.FileSystem, f.name, info)
+	if err != nil {
+		return nil, err
+	}
+
+	props[checksumsProperty] = webdav.Property{
+		XMLName:  checksumsProperty,
+		InnerXML: []byte(`<checksum xmlns="http://owncloud.org/ns">SHA256:` + sum + `</checksum>`),
+	}
+	return props, nil
+}
+
+// computes reports whether the property is computed, rather than stored.
+func (d Dir) computes(name xml.Name) bool {
+	return (d.checksums != nil && name == checksumsProperty) || (d.dirETags && name == getETagProperty)
+}
+
+// Patch patches the dead properties of the file, if they are stored or the file
+// system holds them. Like the live properties, the computed ones, such as the
+// checksums, cannot be modified.
+func (f dirFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	protected := false
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			if f.dir.computes(p.XMLName) {
+				protected = true
+			}
+		}
+	}
+
+	if f.dir.deadProps != nil && !protected {
+		return f.dir.deadProps.patch(context.Background(), f.dir.FileSystem, f.name, patches)
+	}
+
+	holder, ok := f.File.(webdav.DeadPropsHolder)
+	if ok && !protected {
+		return holder.Patch(patches)
+	}
+
+	// Either all patches succeed, or none does.
+	forbidden := webdav.Propstat{Status: http.StatusForbidden}
+	failed := webdav.Propstat{Status: webdav.StatusFailedDependency}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			switch {
+			case !protected:
+				forbidden.Props = append(forbidden.Props, webdav.Property{XMLName: p.XMLName})
+			case f.dir.computes(p.XMLName):
+				forbidden.XMLError = `<D:cannot-modify-protected-property xmlns:D="DAV:"/>`
+				forbidden.Props = append(forbidden.Props, webdav.Property{XMLName: p.XMLName})
+			default:
+				failed.Props = append(failed.Props, webdav.Property{XMLName: p.XMLName})
+			}
+		}
+	}
+
+	if len(failed.Props) == 0 {
+		return []webdav.Propstat{forbidden}, nil
+	}
+	return []webdav.Propstat{forbidden, failed}, nil
+}
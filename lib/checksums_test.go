@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const checksumsPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:" xmlns:oc="http://owncloud.org/ns"><D:prop><oc:checksums/></D:prop></D:propfind>`
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestHandlerChecksums(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0666))
+	h := newTestHandler(t, &Config{Permissions: Permissions{Scope: dir, Modify: true}, Checksums: true})
+
+	propfind := func(body string) string {
+		r := httptest.NewRequest("PROPFIND", "/file.txt", strings.NewReader(body))
+		r.Header.Set("Depth", "0")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		return w.Body.String()
+	}
+
+	require.Contains(t, propfind(checksumsPropfind), "SHA256:"+sha256Hex("content"))
+
+	// The checksum is also part of all the properties.
+	require.Contains(t, propfind(""), "SHA256:"+sha256Hex("content"))
+
+	r := httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("changed"))
+	require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+	require.Contains(t, propfind(checksumsPropfind), "SHA256:"+sha256Hex("changed"))
+
+	// The checksum cannot be modified.
+	r = httptest.NewRequest("PROPPATCH", "/file.txt", strings.NewReader(`<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:oc="http://owncloud.org/ns"><D:set><D:prop><oc:checksums>SHA256:0</oc:checksums></D:prop></D:set></D:propertyupdate>`))
+	w := doRequest(h, r)
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	require.Contains(t, w.Body.String(), "403 Forbidden")
+	require.Contains(t, propfind(checksumsPropfind), "SHA256:"+sha256Hex("changed"))
+
+	// Directories have no checksum.
+	r = httptest.NewRequest("PROPFIND", "/", strings.NewReader(checksumsPropfind))
+	r.Header.Set("Depth", "0")
+	w = doRequest(h, r)
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	require.NotContains(t, w.Body.String(), "SHA256:")
+}
+
+func TestHandlerChecksumsMemory(t *testing.T) {
+	t.Parallel()
+
+	// The dead properties held by the file system are kept.
+	h := newTestHandler(t, &Config{Permissions: Permissions{Modify: true}, Backend: BackendMemory, Checksums: true})
+	require.Equal(t, http.StatusCreated, doRequest(h, httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("content"))).Code)
+
+	r := httptest.NewRequest("PROPPATCH", "/file.txt", strings.NewReader(`<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:x="urn:x"><D:set><D:prop><x:color>blue</x:color></D:prop></D:set></D:propertyupdate>`))
+	w := doRequest(h, r)
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	require.Contains(t, w.Body.String(), "200 OK")
+
+	r = httptest.NewRequest("PROPFIND", "/file.txt", nil)
+	r.Header.Set("Depth", "0")
+	w = doRequest(h, r)
+	require.Contains(t, w.Body.String(), "blue")
+	require.Contains(t, w.Body.String(), "SHA256:"+sha256Hex("content"))
+}
+
+func TestChecksumCache(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file.txt")
+	require.NoError(t, os.WriteFile(name, []byte("content"), 0666))
+
+	fs := Dir{FileSystem: newContainedDir(dir)}
+	c := newChecksumCache()
+	checksum := func() string {
+		info, err := fs.Stat(context.Background(), "/file.txt")
+		require.NoError(t, err)
+		sum, err := c.checksum(context.Background(), fs, "/file.txt", info)
+		require.NoError(t, err)
+		return sum
+	}
+
+	require.Equal(t, sha256Hex("content"), checksum())
+
+	// The cached checksum is used as long as the file is unchanged.
+	entry := c.entries["/file.txt"]
+	entry.sum = "cached"
+	c.entries["/file.txt"] = entry
+	require.Equal(t, "cached", checksum())
+
+	require.NoError(t, os.WriteFile(name, []byte("changed"), 0666))
+	require.NoError(t, os.Chtimes(name, time.Now(), time.Now().Add(time.Minute)))
+	require.Equal(t, sha256Hex("changed"), checksum())
+}
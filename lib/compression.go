@@ -0,0 +1,234 @@
+package lib
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// compressor compresses the responses with a compressible content type that
+// are larger than a minimum size.
+type compressor struct {
+	minSize      int64
+	level        int
+	contentTypes []string
+}
+
+func newCompressor(c Compression) *compressor {
+	if !c.Enabled {
+		return nil
+	}
+
+	return &compressor{
+		minSize:      c.MinSize,
+		level:        c.Level,
+		contentTypes: c.ContentTypes,
+	}
+}
+
+// compressible reports whether the content type matches any of the configured
+// content types, which can end with a wildcard, such as "text/*".
+func (c *compressor) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range c.contentTypes {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(mediaType, prefix) {
+				return true
+			}
+		} else if mediaType == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap returns a response writer that compresses the response, if the client
+// accepts a supported encoding, or nil otherwise. The returned writer must be
+// closed after the request has been served.
+func (c *compressor) wrap(w http.ResponseWriter, r *http.Request) *compressResponseWriter {
+	encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return nil
+	}
+
+	return &compressResponseWriter{ResponseWriter: w, compressor: c, encoding: encoding}
+}
+
+// acceptedEncoding returns the preferred supported encoding in the value of an
+// Accept-Encoding header, or an empty string if none is accepted.
+func acceptedEncoding(header string) string {
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "*" {
+			name = encodingGzip
+		}
+		if name != encodingGzip && name != encodingDeflate {
+			continue
+		}
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			quality, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		if quality > bestQuality || (quality > 0 && quality == bestQuality && name == encodingGzip) {
+			best, bestQuality = name, quality
+		}
+	}
+	return best
+}
+
+// compressResponseWriter decides whether to compress the response once the
+// header is written. If the size of the response is not known in advance, it
+// is buffered until the minimum size is reached.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	compressor *compressor
+	encoding   string
+
+	status  int
+	decided bool
+	writer  io.WriteCloser
+	buffer  []byte
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+
+	if !w.eligible() {
+		w.passthrough()
+		return
+	}
+
+	if length, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil {
+		if length < w.compressor.minSize {
+			w.passthrough()
+		} else {
+			w.compress()
+		}
+	}
+}
+
+func (w *compressResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.decided {
+		if w.writer != nil {
+			return w.writer.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+
+	w.buffer = append(w.buffer, data...)
+	if int64(len(w.buffer)) < w.compressor.minSize {
+		return len(data), nil
+	}
+
+	w.compress()
+	if err := w.flushBuffer(); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// close writes the rest of the response.
+func (w *compressResponseWriter) close() error {
+	if w.status == 0 {
+		return nil
+	}
+
+	if !w.decided {
+		w.passthrough()
+		if err := w.flushBuffer(); err != nil {
+			return err
+		}
+	}
+
+	if w.writer != nil {
+		return w.writer.Close()
+	}
+	return nil
+}
+
+// eligible reports whether the response can be compressed, regardless of its
+// size. Already encoded responses and partial contents are never compressed.
+func (w *compressResponseWriter) eligible() bool {
+	if w.status != http.StatusOK && w.status != http.StatusMultiStatus {
+		return false
+	}
+
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+
+	if !w.compressor.compressible(header.Get("Content-Type")) {
+		return false
+	}
+
+	header.Add("Vary", "Accept-Encoding")
+	return true
+}
+
+func (w *compressResponseWriter) passthrough() {
+	w.decided = true
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *compressResponseWriter) compress() {
+	w.decided = true
+
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", w.encoding)
+
+	// The compressed representation is not byte-for-byte identical, so the
+	// ETag can only be weak.
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+
+	// The level is validated beforehand, so creating the writers cannot fail.
+	if w.encoding == encodingDeflate {
+		w.writer, _ = zlib.NewWriterLevel(w.ResponseWriter, w.compressor.level)
+	} else {
+		w.writer, _ = gzip.NewWriterLevel(w.ResponseWriter, w.compressor.level)
+	}
+}
+
+func (w *compressResponseWriter) flushBuffer() error {
+	var err error
+	if w.writer != nil {
+		_, err = w.writer.Write(w.buffer)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buffer)
+	}
+	w.buffer = nil
+	return err
+}
@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	t.Parallel()
+
+	for header, expected := range map[string]string{
+		"":                         "",
+		"br":                       "",
+		"gzip":                     "gzip",
+		"deflate":                  "deflate",
+		"deflate, gzip":            "gzip",
+		"gzip;q=0.5, deflate":      "deflate",
+		"gzip;q=0":                 "",
+		"*":                        "gzip",
+		"identity, GZIP;q=1.0, br": "gzip",
+		"gzip;q=invalid, deflate":  "deflate",
+	} {
+		require.Equal(t, expected, acceptedEncoding(header), header)
+	}
+}
+
+func TestCompressorCompressible(t *testing.T) {
+	t.Parallel()
+
+	c := newCompressor(Compression{Enabled: true, ContentTypes: DefaultCompressionContentTypes})
+	require.True(t, c.compressible("text/plain; charset=utf-8"))
+	require.True(t, c.compressible("text/xml"))
+	require.True(t, c.compressible("application/json"))
+	require.False(t, c.compressible("image/png"))
+	require.False(t, c.compressible("application/zip"))
+	require.False(t, c.compressible(""))
+}
+
+func TestHandlerCompression(t *testing.T) {
+	t.Parallel()
+
+	content := strings.Repeat("compressible text ", 200)
+	cfg := &Config{
+		Compression: Compression{Enabled: true, MinSize: 1024},
+	}
+	h := newTestHandler(t, cfg)
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "large.txt"), []byte(content), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "image.png"), []byte(content), 0666))
+	for i := 0; i < 30; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "dir", strings.Repeat("x", i+1)+".txt"), nil, 0666))
+	}
+
+	get := func(method, path, encoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Accept-Encoding", encoding)
+		return doRequest(h, r)
+	}
+
+	t.Run("Gzip", func(t *testing.T) {
+		t.Parallel()
+
+		w := get(http.MethodGet, "/large.txt", "gzip")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		require.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		require.Empty(t, w.Header().Get("Content-Length"))
+		require.True(t, strings.HasPrefix(w.Header().Get("ETag"), "W/"))
+		require.Less(t, w.Body.Len(), len(content))
+
+		reader, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, content, string(data))
+	})
+
+	t.Run("Deflate", func(t *testing.T) {
+		t.Parallel()
+
+		w := get(http.MethodGet, "/large.txt", "deflate")
+		require.Equal(t, "deflate", w.Header().Get("Content-Encoding"))
+
+		reader, err := zlib.NewReader(w.Body)
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, content, string(data))
+	})
+
+	t.Run("PROPFIND", func(t *testing.T) {
+		t.Parallel()
+
+		w := get("PROPFIND", "/dir/", "gzip")
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+		reader, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Contains(t, string(data), "/dir/xxx.txt")
+	})
+
+	t.Run("Not Accepted", func(t *testing.T) {
+		t.Parallel()
+
+		w := get(http.MethodGet, "/large.txt", "")
+		require.Empty(t, w.Header().Get("Content-Encoding"))
+		require.Equal(t, content, w.Body.String())
+	})
+
+	t.Run("Small", func(t *testing.T) {
+		t.Parallel()
+
+		w := get(http.MethodGet, "/file.txt", "gzip")
+		require.Empty(t, w.Header().Get("Content-Encoding"))
+		require.Equal(t, "content", w.Body.String())
+	})
+
+	t.Run("Not Compressible", func(t *testing.T) {
+		t.Parallel()
+
+		w := get(http.MethodGet, "/image.png", "gzip")
+		require.Empty(t, w.Header().Get("Content-Encoding"))
+		require.Equal(t, content, w.Body.String())
+	})
+
+	t.Run("HEAD", func(t *testing.T) {
+		t.Parallel()
+
+		w := get(http.MethodHead, "/large.txt", "gzip")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Empty(t, w.Header().Get("Content-Encoding"))
+		require.Equal(t, "3600", w.Header().Get("Content-Length"))
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		t.Parallel()
+
+		r := httptest.NewRequest(http.MethodGet, "/large.txt", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		r.Header.Set("Range", "bytes=0-1999")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		require.Empty(t, w.Header().Get("Content-Encoding"))
+		require.Equal(t, content[:2000], w.Body.String())
+	})
+}
+
+func TestConfigCompression(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{Compression: Compression{Enabled: true}}
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, DefaultCompressionLevel, cfg.Compression.Level)
+	require.Equal(t, DefaultCompressionContentTypes, cfg.Compression.ContentTypes)
+
+	cfg = &Config{Compression: Compression{Enabled: true, Level: 10}}
+	require.Error(t, cfg.Validate())
+
+	cfg = &Config{Compression: Compression{Enabled: true, MinSize: -1}}
+	require.Error(t, cfg.Validate())
+}
@@ -0,0 +1,170 @@
+package lib
+
+import (
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// concurrencyRetryAfter is the delay, in seconds, the clients are told to wait
+// before retrying when too many requests are being served.
+const concurrencyRetryAfter = "1"
+
+// concurrencyLimit limits the number of requests served at the same time. The
+// requests that may modify the contents, which are heavier, can be limited to
+// fewer, on top of the limit of all requests. So can the requests of each
+// client, and of each user, so that none of them starves the others.
+type concurrencyLimit struct {
+	config   Concurrency
+	requests chan struct{}
+	writes   chan struct{}
+	clients  *keyedLimit
+	users    *keyedLimit
+}
+
+func newConcurrencyLimit(c Concurrency) *concurrencyLimit {
+	if c.MaxRequests <= 0 && c.MaxWrites <= 0 && c.MaxPerClient <= 0 && c.MaxPerUser <= 0 {
+		return nil
+	}
+
+	l := &concurrencyLimit{config: c, clients: newKeyedLimit(c.MaxPerClient), users: newKeyedLimit(c.MaxPerUser)}
+	if c.MaxRequests > 0 {
+		l.requests = make(chan struct{}, c.MaxRequests)
+	}
+	if c.MaxWrites > 0 {
+		l.writes = make(chan struct{}, c.MaxWrites)
+	}
+	return l
+}
+
+// acquire takes a slot for a request, without waiting for one. The returned
+// function releases it, and false is returned if the limit is reached.
+func (l *concurrencyLimit) acquire(write bool) (func(), bool) {
+	if !takeSlot(l.requests) {
+		return nil, false
+	}
+	if write && !takeSlot(l.writes) {
+		releaseSlot(l.requests)
+		return nil, false
+	}
+
+	return func() {
+		if write {
+			releaseSlot(l.writes)
+		}
+		releaseSlot(l.requests)
+	}, true
+}
+
+func takeSlot(slots chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func releaseSlot(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
+
+// keyedLimit limits the number of requests served at the same time for each
+// key, such as the address of a client. A nil limit allows any number.
+type keyedLimit struct {
+	max int
+
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+func newKeyedLimit(max int) *keyedLimit {
+	if max <= 0 {
+		return nil
+	}
+	return &keyedLimit{max: max, inFlight: map[string]int{}}
+}
+
+// take counts a request for the key, unless the limit is reached, in which case
+// false is returned. Otherwise, release must be called once it is served.
+func (l *keyedLimit) take(key string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[key] >= l.max {
+		return false
+	}
+	l.inFlight[key]++
+	return true
+}
+
+func (l *keyedLimit) release(key string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The keys are removed once idle, so that the map does not grow with
+	// every client ever seen.
+	if l.inFlight[key]--; l.inFlight[key] <= 0 {
+		delete(l.inFlight, key)
+	}
+}
+
+// limitConcurrency takes a slot for the request, and one for its client. If a
+// limit is reached, the response is written and false is returned. Otherwise,
+// the returned function must be called once the request is served.
+func (h *handler) limitConcurrency(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	// The client is known by its address, which is the one behind the trusted
+	// proxies, if any.
+	client := remoteHost(r)
+	if !h.concurrency.clients.take(client) {
+		requestLogger(r, h.logger).Info("too many concurrent requests from client", zap.String("method", r.Method), zap.String("remote_address", r.RemoteAddr))
+		serveTooManyRequests(w)
+		return nil, false
+	}
+
+	done, ok := h.concurrency.acquire(!isReadMethod(r.Method))
+	if !ok {
+		h.concurrency.clients.release(client)
+		requestLogger(r, h.logger).Info("too many concurrent requests", zap.String("method", r.Method), zap.String("remote_address", r.RemoteAddr))
+		w.Header().Set("Retry-After", concurrencyRetryAfter)
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return nil, false
+	}
+
+	return func() {
+		done()
+		h.concurrency.clients.release(client)
+	}, true
+}
+
+// limitUserConcurrency takes a slot for the request of the user. If the limit is
+// reached, the response is written and false is returned. Otherwise, the
+// returned function must be called once the request is served.
+func (h *handler) limitUserConcurrency(w http.ResponseWriter, r *http.Request, username string) (func(), bool) {
+	if !h.concurrency.users.take(username) {
+		requestLogger(r, h.logger).Info("too many concurrent requests from user", zap.String("method", r.Method), zap.String("username", username))
+		serveTooManyRequests(w)
+		return nil, false
+	}
+	return func() { h.concurrency.users.release(username) }, true
+}
+
+func serveTooManyRequests(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", concurrencyRetryAfter)
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
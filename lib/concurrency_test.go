@@ -0,0 +1,199 @@
+package lib
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// requestsInProgress returns the amount of requests holding a slot.
+func requestsInProgress(h http.Handler) int {
+	return len(h.(*Handler).handler.concurrency.requests)
+}
+
+func TestHandlerConcurrency(t *testing.T) {
+	t.Parallel()
+
+	// stall starts an upload that is served until the returned function is
+	// called, which returns its status. The request can be prepared, such as
+	// to come from another client.
+	stall := func(t *testing.T, h http.Handler, name string, prepare ...func(*http.Request)) func() int {
+		body, pw := io.Pipe()
+		r := httptest.NewRequest(http.MethodPut, name, body)
+		for _, p := range prepare {
+			p(r)
+		}
+
+		status := make(chan int)
+		go func() {
+			status <- doRequest(h, r).Code
+		}()
+
+		return func() int {
+			require.NoError(t, pw.Close())
+			return <-status
+		}
+	}
+
+	t.Run("Requests", func(t *testing.T) {
+		t.Parallel()
+
+		h := newTestHandler(t, &Config{
+			Permissions: Permissions{Modify: true},
+			Concurrency: Concurrency{MaxRequests: 2},
+		})
+
+		first := stall(t, h, "/first.txt")
+		second := stall(t, h, "/second.txt")
+		require.Eventually(t, func() bool { return requestsInProgress(h) == 2 }, time.Second, time.Millisecond)
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Equal(t, "1", w.Header().Get("Retry-After"))
+
+		// Once a request is served, its slot is released.
+		require.Equal(t, http.StatusCreated, first())
+		require.Equal(t, http.StatusOK, doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil)).Code)
+		require.Equal(t, http.StatusCreated, second())
+		require.Zero(t, requestsInProgress(h))
+	})
+
+	t.Run("Writes", func(t *testing.T) {
+		t.Parallel()
+
+		h := newTestHandler(t, &Config{
+			Permissions: Permissions{Modify: true},
+			Concurrency: Concurrency{MaxRequests: 10, MaxWrites: 1},
+		})
+
+		first := stall(t, h, "/first.txt")
+		require.Eventually(t, func() bool { return requestsInProgress(h) == 1 }, time.Second, time.Millisecond)
+
+		// Reads are still served, but not other writes.
+		require.Equal(t, http.StatusOK, doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil)).Code)
+		w := doRequest(h, httptest.NewRequest(http.MethodPut, "/second.txt", strings.NewReader("second")))
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		// The rejected write does not hold a slot of the requests either.
+		require.Equal(t, 1, requestsInProgress(h))
+		require.Equal(t, http.StatusCreated, first())
+		require.Equal(t, http.StatusCreated, doRequest(h, httptest.NewRequest(http.MethodPut, "/second.txt", strings.NewReader("second"))).Code)
+	})
+
+	t.Run("Clients", func(t *testing.T) {
+		t.Parallel()
+
+		h := newTestHandler(t, &Config{
+			Permissions: Permissions{Modify: true},
+			Proxy:       Proxy{Enabled: true, Trusted: []string{"203.0.113.1"}},
+			Concurrency: Concurrency{MaxPerClient: 2},
+		})
+		from := func(addr string) func(*http.Request) {
+			return func(r *http.Request) { r.RemoteAddr = addr + ":1234" }
+		}
+		get := func(prepare func(*http.Request)) int {
+			r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+			prepare(r)
+			return doRequest(h, r).Code
+		}
+		inFlight := func() int {
+			clients := h.(*Handler).handler.concurrency.clients
+			clients.mu.Lock()
+			defer clients.mu.Unlock()
+			return clients.inFlight["192.0.2.1"]
+		}
+
+		first := stall(t, h, "/first.txt", from("192.0.2.1"))
+		second := stall(t, h, "/second.txt", from("192.0.2.1"))
+		require.Eventually(t, func() bool { return inFlight() == 2 }, time.Second, time.Millisecond)
+
+		// The third request of the client is rejected, not the ones of others.
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		require.Equal(t, "1", w.Header().Get("Retry-After"))
+		require.Equal(t, http.StatusOK, get(from("198.51.100.7")))
+
+		// Behind a trusted proxy, the client is the forwarded one.
+		require.Equal(t, http.StatusTooManyRequests, get(func(r *http.Request) {
+			r.RemoteAddr = "203.0.113.1:1234"
+			r.Header.Set("X-Forwarded-For", "192.0.2.1")
+		}))
+		require.Equal(t, http.StatusOK, get(func(r *http.Request) {
+			r.RemoteAddr = "203.0.113.1:1234"
+			r.Header.Set("X-Forwarded-For", "198.51.100.7")
+		}))
+
+		// The slots are released however the requests end.
+		require.Equal(t, http.StatusCreated, first())
+		require.Equal(t, http.StatusOK, get(from("192.0.2.1")))
+		require.Equal(t, http.StatusCreated, second())
+		require.Zero(t, inFlight())
+		require.Empty(t, h.(*Handler).handler.concurrency.clients.inFlight)
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		t.Parallel()
+
+		h := newTestHandler(t, &Config{
+			Auth: true,
+			Users: []User{
+				{Username: "alice", Password: "alice", Permissions: Permissions{Modify: true}},
+				{Username: "bob", Password: "bob"},
+			},
+			Concurrency: Concurrency{MaxPerUser: 1},
+		})
+		as := func(username string) func(*http.Request) {
+			return func(r *http.Request) { r.SetBasicAuth(username, username) }
+		}
+		get := func(username, addr string) int {
+			r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+			r.RemoteAddr = addr
+			as(username)(r)
+			return doRequest(h, r).Code
+		}
+
+		upload := stall(t, h, "/upload.txt", as("alice"))
+		require.Eventually(t, func() bool { return get("alice", "198.51.100.7:1234") == http.StatusTooManyRequests }, time.Second, time.Millisecond)
+		require.Equal(t, http.StatusOK, get("bob", "192.0.2.1:1234"))
+
+		require.Equal(t, http.StatusCreated, upload())
+		require.Equal(t, http.StatusOK, get("alice", "198.51.100.7:1234"))
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		t.Parallel()
+
+		h := newTestHandler(t, &Config{
+			Auth:          true,
+			Authenticator: panicAuthenticator{},
+			Users:         []User{{Username: "admin"}},
+			Concurrency:   Concurrency{MaxRequests: 1},
+		})
+
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.SetBasicAuth("admin", "admin")
+		require.Panics(t, func() { doRequest(h, r) })
+		require.Zero(t, requestsInProgress(h))
+	})
+}
+
+type panicAuthenticator struct{}
+
+func (panicAuthenticator) Authenticate(r *http.Request) (*User, bool) {
+	panic("authenticator failed")
+}
+
+func TestConfigConcurrency(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, "concurrency:\n  max_requests: 100\n  max_writes: 10\n", ".yml")
+	require.Equal(t, Concurrency{MaxRequests: 100, MaxWrites: 10}, cfg.Concurrency)
+
+	require.ErrorContains(t, (&Config{Concurrency: Concurrency{MaxWrites: -1}}).Validate(), "concurrency")
+	require.ErrorContains(t, (&Config{Concurrency: Concurrency{MaxPerClient: -1}}).Validate(), "concurrency")
+}
@@ -3,11 +3,22 @@ package lib
 import (
 	"errors"
 	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/http/httpguts"
 )
 
 const (
@@ -23,22 +34,203 @@ const (
 	DefaultPort      = 0
 	DefaultPrefix    = "/"
 	DefaultLogFormat = "console"
+	DefaultLogLevel  = "info"
+	DefaultBackend   = BackendDisk
+	DefaultETag      = ETagModTime
+	DefaultS3Region  = "us-east-1"
+
+	DefaultS3DialTimeout           = 10 * time.Second
+	DefaultS3ResponseHeaderTimeout = time.Minute
+	DefaultS3IdleTimeout           = 90 * time.Second
+
+	DefaultAccessLogFormat    = AccessLogStructured
+	DefaultRedactionMode      = RedactionMask
+	DefaultUnknownStatus      = http.StatusNotImplemented
+	DefaultLocksReapInterval  = time.Minute
+	DefaultRequestIDHeader    = "X-Request-ID"
+	DefaultMetricsPath        = "/metrics"
+	DefaultMetricsPrefix      = "webdav"
+	DefaultHealthPath         = "/healthz"
+	DefaultAdminPath          = "/admin/config"
+	DefaultLandingPath        = "/"
+	DefaultConcurrentWrites   = ConcurrentWritesAllow
+	DefaultListingSort        = ListingSortName
+	DefaultListingOrder       = ListingOrderAsc
+	DefaultShutdownTimeout    = 30 * time.Second
+	DefaultAuthMethod         = AuthMethodBasic
+	DefaultRealm              = "Restricted"
+	DefaultDigestNonceTimeout = 5 * time.Minute
+	DefaultCertificateField   = CertificateFieldCN
+	DefaultJWTAlgorithm       = "HS256"
+	DefaultJWTUsernameClaim   = "sub"
+	DefaultLockoutAttempts    = 5
+	DefaultCompressionMinSize = 1024
+	DefaultCompressionLevel   = 6
+	DefaultLockoutWindow      = 5 * time.Minute
+	DefaultLockoutCooldown    = 15 * time.Minute
+
+	DefaultIntrospectionUsernameField = "username"
+	DefaultIntrospectionCacheTTL      = time.Minute
+	DefaultScopeMode                  = "0700"
+	DefaultPropfindCacheSize          = 1000
+	DefaultPropfindCacheTTL           = time.Minute
+	DefaultUserCacheSize              = 1000
+	DefaultUserCacheTTL               = time.Minute
+	DefaultUploadBuffer               = 32 * 1024
+	DefaultVersioningMaxVersions      = 10
+	DefaultLDAPUserAttribute          = "uid"
+	DefaultLDAPMemberAttribute        = "member"
+	DefaultLDAPPoolSize               = 4
+	DefaultLDAPTimeout                = 10 * time.Second
+)
+
+// DefaultCompressionContentTypes are the content types that are compressed by
+// default. Media that is already compressed, such as images, is left out.
+var DefaultCompressionContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// reservedHeaders are the headers that cannot be configured, as they describe
+// the response body and how it is transferred.
+var reservedHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Encoding":  true,
+	"Content-Length":    true,
+	"Content-Range":     true,
+	"Content-Type":      true,
+	"Transfer-Encoding": true,
+}
+
+const (
+	AuthMethodBasic         = "basic"
+	AuthMethodDigest        = "digest"
+	AuthMethodCertificate   = "certificate"
+	AuthMethodJWT           = "jwt"
+	AuthMethodIntrospection = "introspection"
+	AuthMethodLDAP          = "ldap"
 )
 
 type Config struct {
-	Permissions `mapstructure:",squash"`
-	Debug       bool
-	Address     string
-	Port        int
-	TLS         bool
-	Cert        string
-	Key         string
-	Prefix      string
-	NoSniff     bool
-	LogFormat   string `mapstructure:"log_format"`
-	Auth        bool
-	CORS        CORS
-	Users       []User
+	Permissions      `mapstructure:",squash"`
+	Backend          string
+	S3               S3
+	Debug            bool
+	Address          string
+	Port             int
+	TLS              bool
+	Cert             string
+	Key              string
+	Prefix           string
+	NoSniff          bool
+	ETag             string            `mapstructure:"etag"`
+	CollectionETags  bool              `mapstructure:"collection_etags"`
+	Checksums        bool              `mapstructure:"checksums"`
+	DeadProperties   bool              `mapstructure:"dead_properties"`
+	CaseInsensitive  bool              `mapstructure:"case_insensitive"`
+	Versioning       Versioning        `mapstructure:"versioning"`
+	StrictScope      bool              `mapstructure:"strict_scope"`
+	LowerScope       string            `mapstructure:"lower_scope"`
+	CreateScope      bool              `mapstructure:"create_scope"`
+	ScopeMode        string            `mapstructure:"scope_mode"`
+	Chown            bool              `mapstructure:"chown"`
+	HideDotfiles     bool              `mapstructure:"hide_dotfiles"`
+	HideEmptyDirs    bool              `mapstructure:"hide_empty_dirs"`
+	HiddenFiles      []string          `mapstructure:"hidden_files"`
+	ContentTypes     map[string]string `mapstructure:"content_types"`
+	Precompressed    bool              `mapstructure:"precompressed"`
+	Disposition      string            `mapstructure:"content_disposition"`
+	Headers          map[string]string `mapstructure:"headers"`
+	Server           string            `mapstructure:"server"`
+	HideServer       bool              `mapstructure:"hide_server"`
+	PoweredBy        string            `mapstructure:"powered_by"`
+	ReadOnly         bool              `mapstructure:"read_only"`
+	WriteOnce        []string          `mapstructure:"write_once"`
+	DisabledMethods  []string          `mapstructure:"disabled_methods"`
+	UnknownStatus    int               `mapstructure:"unknown_method_status"`
+	AnonymousRead    bool              `mapstructure:"anonymous_read"`
+	Anonymous        Permissions       `mapstructure:"anonymous"`
+	DirectoryListing bool              `mapstructure:"directory_listing"`
+	IndexFiles       []string          `mapstructure:"index_files"`
+	ListingSort      string            `mapstructure:"listing_sort"`
+	ListingOrder     string            `mapstructure:"listing_order"`
+	ListingLimit     int               `mapstructure:"listing_limit"`
+	Archives         bool              `mapstructure:"archives"`
+	TrailingSlash    bool              `mapstructure:"trailing_slash"`
+	PartialUpdates   bool              `mapstructure:"partial_updates"`
+	CalDAV           bool              `mapstructure:"caldav"`
+	IdempotentDelete bool              `mapstructure:"idempotent_delete"`
+	CreateParentDirs bool              `mapstructure:"create_parent_dirs"`
+	VerifyDigest     bool              `mapstructure:"verify_digest"`
+	ConcurrentWrites string            `mapstructure:"concurrent_writes"`
+	RateLimit        int64             `mapstructure:"rate_limit"`
+	MaxUploadSize    int64             `mapstructure:"max_upload_size"`
+	UploadBuffer     int               `mapstructure:"upload_buffer"`
+	ExpectContinue   bool              `mapstructure:"expect_continue"`
+	RequestTimeout   time.Duration     `mapstructure:"request_timeout"`
+	ShutdownTimeout  time.Duration     `mapstructure:"shutdown_timeout"`
+	SlowThreshold    time.Duration     `mapstructure:"slow_request_threshold"`
+	MaxPropfindDepth int               `mapstructure:"max_propfind_depth"`
+	LogFormat        string            `mapstructure:"log_format"`
+	LogLevel         string            `mapstructure:"log_level"`
+	Explain          bool
+	DebugIfHeader    bool `mapstructure:"debug_if_header"`
+	Auth             bool
+	AuthMethod       string   `mapstructure:"auth_method"`
+	AuthMethods      []string `mapstructure:"auth_methods"`
+	Realm            string
+	Digest           Digest
+	Certificate      Certificate
+	JWT              JWT
+	Introspection    Introspection
+	LDAP             LDAP
+	Lockout          Lockout
+	Concurrency      Concurrency
+	Proxy            Proxy
+	Networks         Networks
+	RequestID        RequestID `mapstructure:"request_id"`
+	AccessLog        AccessLog `mapstructure:"access_log"`
+	Audit            Audit
+	Redaction        Redaction
+	Compression      Compression
+	PropfindCache    PropfindCache `mapstructure:"propfind_cache"`
+	Metrics          Metrics
+	Health           Health
+	Admin            Admin
+	Landing          Landing
+	Locks            Locks
+	CORS             CORS
+	Mounts           []Mount
+	VirtualFile      VirtualFile `mapstructure:"virtual_file"`
+	Users            []User
+	UsersFile        string    `mapstructure:"users_file"`
+	UserCache        UserCache `mapstructure:"user_cache"`
+
+	// Logger is the logger used by the handler. If not set, a logger is
+	// created based on the log level and format.
+	Logger *zap.Logger `mapstructure:"-"`
+
+	// Authenticator replaces the check of the users' passwords with the basic
+	// and the certificate authentication methods. If not set, the passwords of
+	// the users are checked.
+	Authenticator Authenticator `mapstructure:"-"`
+
+	// UploadHook checks the files uploaded by PUT requests before they are
+	// made available. If not set, the uploads are not checked.
+	UploadHook UploadHook `mapstructure:"-"`
+
+	// ETagFunc computes the ETags of the files and of the collections, taking
+	// precedence over the ETag strategy. If not set, the ETags are computed
+	// according to the strategy.
+	ETagFunc ETagFunc `mapstructure:"-"`
+
+	// UserStore looks up the users that are not configured, which are cached
+	// according to the user cache. If not set, only the configured users
+	// exist.
+	UserStore UserStore `mapstructure:"-"`
 }
 
 func ParseConfig(filename string, flags *pflag.FlagSet) (*Config, error) {
@@ -86,11 +278,58 @@ func ParseConfig(filename string, flags *pflag.FlagSet) (*Config, error) {
 	v.SetDefault("Auth", DefaultAuth)
 	v.SetDefault("Prefix", DefaultPrefix)
 	v.SetDefault("Log_Format", DefaultLogFormat)
+	v.SetDefault("Log_Level", DefaultLogLevel)
+	v.SetDefault("Backend", DefaultBackend)
+	v.SetDefault("ETag", DefaultETag)
 
 	// Other defaults
+	v.SetDefault("Auth_Method", DefaultAuthMethod)
+	v.SetDefault("Realm", DefaultRealm)
+	v.SetDefault("Digest.Nonce_Timeout", DefaultDigestNonceTimeout)
+	v.SetDefault("Certificate.Field", DefaultCertificateField)
+	v.SetDefault("JWT.Algorithm", DefaultJWTAlgorithm)
+	v.SetDefault("JWT.Username_Claim", DefaultJWTUsernameClaim)
+	v.SetDefault("Introspection.Username_Field", DefaultIntrospectionUsernameField)
+	v.SetDefault("Introspection.Cache_TTL", DefaultIntrospectionCacheTTL)
+	v.SetDefault("LDAP.User_Attribute", DefaultLDAPUserAttribute)
+	v.SetDefault("LDAP.Member_Attribute", DefaultLDAPMemberAttribute)
+	v.SetDefault("LDAP.Pool_Size", DefaultLDAPPoolSize)
+	v.SetDefault("LDAP.Timeout", DefaultLDAPTimeout)
+	v.SetDefault("Versioning.Max_Versions", DefaultVersioningMaxVersions)
+	v.SetDefault("Lockout.Attempts", DefaultLockoutAttempts)
+	v.SetDefault("Lockout.Window", DefaultLockoutWindow)
+	v.SetDefault("Lockout.Cooldown", DefaultLockoutCooldown)
+	v.SetDefault("Compression.Min_Size", DefaultCompressionMinSize)
+	v.SetDefault("Compression.Level", DefaultCompressionLevel)
+	v.SetDefault("Compression.Content_Types", DefaultCompressionContentTypes)
+	v.SetDefault("Propfind_Cache.Size", DefaultPropfindCacheSize)
+	v.SetDefault("Propfind_Cache.TTL", DefaultPropfindCacheTTL)
+	v.SetDefault("User_Cache.Size", DefaultUserCacheSize)
+	v.SetDefault("User_Cache.TTL", DefaultUserCacheTTL)
+	v.SetDefault("Upload_Buffer", DefaultUploadBuffer)
+	v.SetDefault("Unknown_Method_Status", DefaultUnknownStatus)
+	v.SetDefault("Locks.Reap_Interval", DefaultLocksReapInterval)
+	v.SetDefault("Access_Log.Format", DefaultAccessLogFormat)
+	v.SetDefault("Request_ID.Header", DefaultRequestIDHeader)
+	v.SetDefault("Redaction.Mode", DefaultRedactionMode)
+	v.SetDefault("Metrics.Path", DefaultMetricsPath)
+	v.SetDefault("Metrics.Prefix", DefaultMetricsPrefix)
+	v.SetDefault("Health.Path", DefaultHealthPath)
+	v.SetDefault("Admin.Path", DefaultAdminPath)
+	v.SetDefault("Landing.Path", DefaultLandingPath)
+	v.SetDefault("Concurrent_Writes", DefaultConcurrentWrites)
+	v.SetDefault("Scope_Mode", DefaultScopeMode)
+	v.SetDefault("Listing_Sort", DefaultListingSort)
+	v.SetDefault("Listing_Order", DefaultListingOrder)
+	v.SetDefault("Shutdown_Timeout", DefaultShutdownTimeout)
+	v.SetDefault("S3.Region", DefaultS3Region)
+	v.SetDefault("S3.Dial_Timeout", DefaultS3DialTimeout)
+	v.SetDefault("S3.Response_Header_Timeout", DefaultS3ResponseHeaderTimeout)
+	v.SetDefault("S3.Idle_Timeout", DefaultS3IdleTimeout)
 	v.SetDefault("CORS.Allowed_Headers", []string{"*"})
 	v.SetDefault("CORS.Allowed_Hosts", []string{"*"})
 	v.SetDefault("CORS.Allowed_Methods", []string{"*"})
+	v.SetDefault("CORS.Exposed_Headers", DefaultCORSExposedHeaders)
 
 	// Read and unmarshal configuration
 	err := v.ReadInConfig()
@@ -119,6 +358,45 @@ func ParseConfig(filename string, flags *pflag.FlagSet) (*Config, error) {
 		if !v.IsSet(fmt.Sprintf("Users.%d.Rules", i)) {
 			cfg.Users[i].Rules = cfg.Rules
 		}
+
+		if !v.IsSet(fmt.Sprintf("Users.%d.Allowed", i)) {
+			cfg.Users[i].Grants = cfg.Grants
+		}
+
+		if !v.IsSet(fmt.Sprintf("Users.%d.Blocked_Extensions", i)) {
+			cfg.Users[i].BlockedExtensions = cfg.BlockedExtensions
+		}
+
+		if !v.IsSet(fmt.Sprintf("Users.%d.Rate_Limit", i)) {
+			cfg.Users[i].RateLimit = cfg.RateLimit
+		}
+
+		if !v.IsSet(fmt.Sprintf("Users.%d.Max_Upload_Size", i)) {
+			cfg.Users[i].MaxUploadSize = cfg.MaxUploadSize
+		}
+	}
+
+	// Cascade LDAP group settings
+	for i := range cfg.LDAP.Groups {
+		if !v.IsSet(fmt.Sprintf("LDAP.Groups.%d.Scope", i)) {
+			cfg.LDAP.Groups[i].Scope = cfg.Scope
+		}
+
+		if !v.IsSet(fmt.Sprintf("LDAP.Groups.%d.Modify", i)) {
+			cfg.LDAP.Groups[i].Modify = cfg.Modify
+		}
+
+		if !v.IsSet(fmt.Sprintf("LDAP.Groups.%d.Rules", i)) {
+			cfg.LDAP.Groups[i].Rules = cfg.Rules
+		}
+
+		if !v.IsSet(fmt.Sprintf("LDAP.Groups.%d.Allowed", i)) {
+			cfg.LDAP.Groups[i].Grants = cfg.Grants
+		}
+
+		if !v.IsSet(fmt.Sprintf("LDAP.Groups.%d.Blocked_Extensions", i)) {
+			cfg.LDAP.Groups[i].BlockedExtensions = cfg.BlockedExtensions
+		}
 	}
 
 	err = cfg.Validate()
@@ -132,19 +410,604 @@ func ParseConfig(filename string, flags *pflag.FlagSet) (*Config, error) {
 func (c *Config) Validate() error {
 	var err error
 
-	if c.Auth && len(c.Users) == 0 {
+	if c.Auth && len(c.Users) == 0 && c.UsersFile == "" && !c.usesAuthMethod(AuthMethodLDAP) {
 		return errors.New("invalid config: auth cannot be enabled without users")
 	}
 
-	if !c.Auth && len(c.Users) != 0 {
+	if !c.Auth && (len(c.Users) != 0 || c.UsersFile != "") {
 		return errors.New("invalid config: auth cannot be disabled with users defined")
 	}
 
-	c.Scope, err = filepath.Abs(c.Scope)
+	if c.UsersFile != "" {
+		c.UsersFile, err = filepath.Abs(c.UsersFile)
+		if err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+	}
+
+	if c.LogFormat == "" {
+		c.LogFormat = DefaultLogFormat
+	}
+
+	if c.LogLevel == "" {
+		c.LogLevel = DefaultLogLevel
+	}
+
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	if c.Backend == "" {
+		c.Backend = DefaultBackend
+	}
+
+	if c.ScopeMode == "" {
+		c.ScopeMode = DefaultScopeMode
+	}
+
+	if _, err := parseScopeMode(c.ScopeMode); err != nil {
+		return fmt.Errorf("invalid config: invalid scope mode %q", c.ScopeMode)
+	}
+
+	if c.Chown && c.Backend != BackendDisk {
+		return errors.New("invalid config: chown requires the disk backend")
+	}
+
+	// The resource types of the calendars are stored as dead properties, which
+	// the memory backend holds on its own.
+	if c.CalDAV && !c.DeadProperties && c.Backend != BackendMemory {
+		return errors.New("invalid config: caldav requires dead properties")
+	}
+
+	switch c.Backend {
+	case BackendDisk, BackendMemory:
+	case BackendS3:
+		if err := c.S3.Validate(); err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+	default:
+		return fmt.Errorf("invalid config: unknown backend %q", c.Backend)
+	}
+
+	if c.ETag == "" {
+		c.ETag = DefaultETag
+	}
+
+	switch c.ETag {
+	case ETagModTime, ETagContent:
+	default:
+		return fmt.Errorf("invalid config: unknown etag strategy %q", c.ETag)
+	}
+
+	for _, pattern := range c.HiddenFiles {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid config: hidden files pattern %q: %w", pattern, err)
+		}
+	}
+
+	for _, pattern := range c.WriteOnce {
+		if _, err := path.Match(pattern, ""); err != nil || !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("invalid config: write-once pattern %q must be an absolute path pattern", pattern)
+		}
+	}
+
+	if name := c.VirtualFile.Name; name != "" && (name == "." || name == ".." || strings.Contains(name, "/")) {
+		return fmt.Errorf("invalid config: virtual file %q must be a file name", name)
+	}
+
+	for _, index := range c.IndexFiles {
+		if index == "" || index == "." || index == ".." || strings.Contains(index, "/") {
+			return fmt.Errorf("invalid config: index file %q must be a file name", index)
+		}
+	}
+
+	if c.ListingSort == "" {
+		c.ListingSort = DefaultListingSort
+	}
+	if c.ListingOrder == "" {
+		c.ListingOrder = DefaultListingOrder
+	}
+	if !listingSorts[c.ListingSort] {
+		return fmt.Errorf("invalid config: unknown listing sort %q", c.ListingSort)
+	}
+	if c.ListingOrder != ListingOrderAsc && c.ListingOrder != ListingOrderDesc {
+		return fmt.Errorf("invalid config: unknown listing order %q", c.ListingOrder)
+	}
+
+	switch c.Disposition {
+	case "", DispositionInline, DispositionAttachment:
+	default:
+		return fmt.Errorf("invalid config: unknown content disposition %q", c.Disposition)
+	}
+	if c.ListingLimit < 0 {
+		return errors.New("invalid config: listing limit cannot be negative")
+	}
+
+	// The extensions are matched case-insensitively, with or without the dot.
+	contentTypes := make(map[string]string, len(c.ContentTypes))
+	for ext, contentType := range c.ContentTypes {
+		ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+		if ext == "" {
+			return errors.New("invalid config: content types require an extension")
+		}
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return fmt.Errorf("invalid config: content type %q for extension %q: %w", contentType, ext, err)
+		}
+		contentTypes[ext] = contentType
+	}
+	c.ContentTypes = contentTypes
+
+	// The header names are canonicalized, as they may have been lowercased.
+	headers := make(map[string]string, len(c.Headers))
+	for name, value := range c.Headers {
+		if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			return fmt.Errorf("invalid config: invalid header %q", name)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if reservedHeaders[name] {
+			return fmt.Errorf("invalid config: header %q cannot be configured", name)
+		}
+		headers[name] = value
+	}
+	c.Headers = headers
+
+	if c.HideServer && c.Server != "" {
+		return errors.New("invalid config: the server cannot be both set and hidden")
+	}
+	if !httpguts.ValidHeaderFieldValue(c.Server) || !httpguts.ValidHeaderFieldValue(c.PoweredBy) {
+		return errors.New("invalid config: invalid server or powered by header")
+	}
+
+	// Not sniffing the content types only makes sense if browsers do not
+	// sniff them either.
+	if _, ok := c.Headers["X-Content-Type-Options"]; c.NoSniff && !ok {
+		c.Headers["X-Content-Type-Options"] = "nosniff"
+	}
+
+	if c.AuthMethod == "" {
+		c.AuthMethod = DefaultAuthMethod
+	}
+
+	switch c.AuthMethod {
+	case AuthMethodBasic, AuthMethodDigest, AuthMethodCertificate, AuthMethodJWT, AuthMethodIntrospection, AuthMethodLDAP:
+	default:
+		return fmt.Errorf("invalid config: unknown auth method %q", c.AuthMethod)
+	}
+
+	// With several auth methods, the client picks the one it answers the
+	// challenges with, so they must be told apart by the scheme of its
+	// credentials.
+	for i, method := range c.AuthMethods {
+		switch method {
+		case AuthMethodBasic, AuthMethodDigest, AuthMethodJWT, AuthMethodIntrospection, AuthMethodLDAP:
+		default:
+			return fmt.Errorf("invalid config: auth method %q cannot be one of several auth methods", method)
+		}
+
+		for _, other := range c.AuthMethods[:i] {
+			if authSchemes[other] == authSchemes[method] {
+				return fmt.Errorf("invalid config: auth methods %q and %q use the same scheme", other, method)
+			}
+		}
+	}
+
+	// The realm is sent as a quoted string in the authentication challenges.
+	if c.Realm == "" {
+		c.Realm = DefaultRealm
+	}
+	if strings.ContainsAny(c.Realm, `"\`) || strings.ContainsFunc(c.Realm, unicode.IsControl) {
+		return fmt.Errorf("invalid config: realm %q cannot contain quotes, backslashes or control characters", c.Realm)
+	}
+
+	if c.usesAuthMethod(AuthMethodCertificate) {
+		if !c.TLS {
+			return errors.New("invalid config: certificate authentication requires TLS")
+		}
+
+		if c.Certificate.ClientCA == "" {
+			return errors.New("invalid config: client CA must be defined for certificate authentication")
+		}
+
+		switch c.Certificate.Field {
+		case CertificateFieldCN, CertificateFieldEmail, CertificateFieldDNS, CertificateFieldURI:
+		default:
+			return fmt.Errorf("invalid config: unknown certificate field %q", c.Certificate.Field)
+		}
+
+		c.Certificate.ClientCA, err = filepath.Abs(c.Certificate.ClientCA)
+		if err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+	}
+
+	if c.usesAuthMethod(AuthMethodJWT) {
+		if env, ok := strings.CutPrefix(c.JWT.Secret, "{env}"); ok {
+			c.JWT.Secret = os.Getenv(env)
+			if c.JWT.Secret == "" {
+				return errors.New("invalid config: JWT secret environment variable is empty")
+			}
+		}
+
+		if (c.JWT.Secret == "") == (c.JWT.JWKSURL == "") {
+			return errors.New("invalid config: either the JWT secret or the JWKS URL must be defined")
+		}
+
+		if !jwtAlgorithms[c.JWT.Algorithm] {
+			return fmt.Errorf("invalid config: unsupported JWT algorithm %q", c.JWT.Algorithm)
+		}
+
+		if strings.HasPrefix(c.JWT.Algorithm, "HS") {
+			if c.JWT.Secret == "" {
+				return fmt.Errorf("invalid config: JWT algorithm %q requires a secret", c.JWT.Algorithm)
+			}
+		} else if c.JWT.JWKSURL == "" {
+			return fmt.Errorf("invalid config: JWT algorithm %q requires a JWKS URL", c.JWT.Algorithm)
+		}
+
+		if c.JWT.UsernameClaim == "" {
+			return errors.New("invalid config: JWT username claim must be defined")
+		}
+	}
+
+	if c.usesAuthMethod(AuthMethodIntrospection) {
+		if env, ok := strings.CutPrefix(c.Introspection.ClientSecret, "{env}"); ok {
+			c.Introspection.ClientSecret = os.Getenv(env)
+			if c.Introspection.ClientSecret == "" {
+				return errors.New("invalid config: introspection client secret environment variable is empty")
+			}
+		}
+
+		u, err := url.Parse(c.Introspection.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid config: invalid introspection URL %q", c.Introspection.URL)
+		}
+
+		if c.Introspection.ClientID == "" {
+			return errors.New("invalid config: introspection client ID must be defined")
+		}
+
+		if c.Introspection.UsernameField == "" {
+			c.Introspection.UsernameField = DefaultIntrospectionUsernameField
+		}
+
+		if c.Introspection.CacheTTL < 0 {
+			return errors.New("invalid config: introspection cache TTL cannot be negative")
+		}
+	}
+
+	if c.usesAuthMethod(AuthMethodLDAP) {
+		u, err := url.Parse(c.LDAP.URL)
+		if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+			return fmt.Errorf("invalid config: invalid LDAP URL %q", c.LDAP.URL)
+		}
+
+		if c.LDAP.StartTLS && u.Scheme == "ldaps" {
+			return errors.New("invalid config: LDAP StartTLS cannot be used with an ldaps URL")
+		}
+
+		if c.LDAP.BaseDN == "" {
+			return errors.New("invalid config: LDAP base DN must be defined")
+		}
+
+		if c.LDAP.UserAttribute == "" {
+			c.LDAP.UserAttribute = DefaultLDAPUserAttribute
+		}
+		if c.LDAP.MemberAttribute == "" {
+			c.LDAP.MemberAttribute = DefaultLDAPMemberAttribute
+		}
+		if c.LDAP.GroupBaseDN == "" {
+			c.LDAP.GroupBaseDN = c.LDAP.BaseDN
+		}
+
+		if c.LDAP.PoolSize < 0 || c.LDAP.Timeout < 0 {
+			return errors.New("invalid config: LDAP pool size and timeout cannot be negative")
+		}
+		if c.LDAP.PoolSize == 0 {
+			c.LDAP.PoolSize = DefaultLDAPPoolSize
+		}
+		if c.LDAP.Timeout == 0 {
+			c.LDAP.Timeout = DefaultLDAPTimeout
+		}
+
+		if c.LDAP.CA != "" {
+			c.LDAP.CA, err = filepath.Abs(c.LDAP.CA)
+			if err != nil {
+				return fmt.Errorf("invalid config: %w", err)
+			}
+		}
+	}
+
+	if c.usesAuthMethod(AuthMethodDigest) && c.UsersFile != "" {
+		return errors.New("invalid config: digest authentication cannot be used with a users file")
+	}
+
+	if c.usesAuthMethod(AuthMethodDigest) && c.Digest.NonceTimeout <= 0 {
+		return errors.New("invalid config: digest nonce timeout must be positive")
+	}
+
+	if c.Concurrency.MaxRequests < 0 || c.Concurrency.MaxWrites < 0 || c.Concurrency.MaxPerClient < 0 || c.Concurrency.MaxPerUser < 0 {
+		return errors.New("invalid config: concurrency limits cannot be negative")
+	}
+
+	if c.Versioning.Enabled && (c.Versioning.MaxVersions < 0 || c.Versioning.MaxAge < 0) {
+		return errors.New("invalid config: versioning max versions and max age cannot be negative")
+	}
+
+	if c.Lockout.Enabled {
+		if c.Lockout.Attempts <= 0 {
+			return errors.New("invalid config: lockout attempts must be positive")
+		}
+
+		if c.Lockout.Window <= 0 || c.Lockout.Cooldown <= 0 {
+			return errors.New("invalid config: lockout window and cooldown must be positive")
+		}
+	}
+
+	if c.Proxy.Enabled {
+		if len(c.Proxy.Trusted) == 0 {
+			return errors.New("invalid config: proxy requires at least one trusted proxy")
+		}
+
+		for _, trusted := range c.Proxy.Trusted {
+			if _, err := parsePrefix(trusted); err != nil {
+				return fmt.Errorf("invalid config: invalid trusted proxy %q: %w", trusted, err)
+			}
+		}
+	}
+
+	for _, networks := range [][]string{c.Networks.Allowed, c.Networks.Denied} {
+		for _, network := range networks {
+			if _, err := parsePrefix(network); err != nil {
+				return fmt.Errorf("invalid config: invalid network %q: %w", network, err)
+			}
+		}
+	}
+
+	if c.AccessLog.Enabled {
+		switch c.AccessLog.Format {
+		case AccessLogStructured, AccessLogCombined:
+		default:
+			return fmt.Errorf("invalid config: unknown access log format %q", c.AccessLog.Format)
+		}
+	}
+
+	if c.Audit.Enabled && c.Audit.File != "" {
+		c.Audit.File, err = filepath.Abs(c.Audit.File)
+		if err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+	}
+
+	if c.RequestID.Header == "" {
+		c.RequestID.Header = DefaultRequestIDHeader
+	}
+	if strings.ContainsAny(c.RequestID.Header, " \t:") {
+		return fmt.Errorf("invalid config: invalid request id header %q", c.RequestID.Header)
+	}
+
+	if c.Redaction.Mode == "" {
+		c.Redaction.Mode = DefaultRedactionMode
+	}
+	switch c.Redaction.Mode {
+	case RedactionMask, RedactionHash:
+	default:
+		return fmt.Errorf("invalid config: unknown redaction mode %q", c.Redaction.Mode)
+	}
+	for _, pattern := range c.Redaction.Paths {
+		if _, err := path.Match(pattern, ""); err != nil || !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("invalid config: redaction pattern %q must be an absolute path pattern", pattern)
+		}
+	}
+	for _, name := range c.Redaction.Headers {
+		if name == "" {
+			return errors.New("invalid config: redacted headers cannot be empty")
+		}
+	}
+
+	if c.Compression.Enabled {
+		if c.Compression.Level == 0 {
+			c.Compression.Level = DefaultCompressionLevel
+		}
+
+		if c.Compression.ContentTypes == nil {
+			c.Compression.ContentTypes = DefaultCompressionContentTypes
+		}
+
+		if c.Compression.MinSize < 0 {
+			return errors.New("invalid config: compression min size cannot be negative")
+		}
+
+		if c.Compression.Level < 1 || c.Compression.Level > 9 {
+			return errors.New("invalid config: compression level must be between 1 and 9")
+		}
+	}
+
+	if c.PropfindCache.Enabled {
+		if c.PropfindCache.Size == 0 {
+			c.PropfindCache.Size = DefaultPropfindCacheSize
+		}
+
+		if c.PropfindCache.TTL == 0 {
+			c.PropfindCache.TTL = DefaultPropfindCacheTTL
+		}
+
+		if c.PropfindCache.Size < 0 || c.PropfindCache.TTL < 0 {
+			return errors.New("invalid config: PROPFIND cache size and TTL cannot be negative")
+		}
+	}
+
+	if c.UserCache.Size == 0 {
+		c.UserCache.Size = DefaultUserCacheSize
+	}
+
+	if c.UserCache.TTL == 0 {
+		c.UserCache.TTL = DefaultUserCacheTTL
+	}
+
+	if c.UserCache.Size < 0 || c.UserCache.TTL < 0 {
+		return errors.New("invalid config: user cache size and TTL cannot be negative")
+	}
+
+	if c.Metrics.Enabled && !strings.HasPrefix(c.Metrics.Path, "/") {
+		return errors.New("invalid config: metrics path must start with a slash")
+	}
+
+	if c.RequestTimeout < 0 {
+		return errors.New("invalid config: request timeout cannot be negative")
+	}
+
+	if c.ShutdownTimeout < 0 {
+		return errors.New("invalid config: shutdown timeout cannot be negative")
+	}
+
+	for i, method := range c.DisabledMethods {
+		method = strings.ToUpper(method)
+		if !isServerMethod(method) {
+			return fmt.Errorf("invalid config: unknown method %q", method)
+		}
+		c.DisabledMethods[i] = method
+	}
+
+	if c.UnknownStatus == 0 {
+		c.UnknownStatus = DefaultUnknownStatus
+	}
+	switch c.UnknownStatus {
+	case http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+	default:
+		return fmt.Errorf("invalid config: unknown method status %d must be 400, 405 or 501", c.UnknownStatus)
+	}
+
+	if c.SlowThreshold < 0 {
+		return errors.New("invalid config: slow request threshold cannot be negative")
+	}
+
+	if c.CORS.MaxAge < 0 {
+		return errors.New("invalid config: CORS max age cannot be negative")
+	}
+
+	if c.Health.Enabled {
+		if c.Health.Path == "" {
+			c.Health.Path = DefaultHealthPath
+		}
+
+		if !strings.HasPrefix(c.Health.Path, "/") {
+			return errors.New("invalid config: health path must start with a slash")
+		}
+	}
+
+	if c.Admin.Enabled {
+		if !c.Auth {
+			return errors.New("invalid config: admin endpoint requires authentication")
+		}
+
+		if c.Admin.Path == "" {
+			c.Admin.Path = DefaultAdminPath
+		}
+
+		if !strings.HasPrefix(c.Admin.Path, "/") {
+			return errors.New("invalid config: admin path must start with a slash")
+		}
+	}
+
+	if c.Landing.Redirect != "" || c.Landing.Page != "" {
+		if c.Landing.Redirect != "" && c.Landing.Page != "" {
+			return errors.New("invalid config: landing cannot have both a redirect and a page")
+		}
+
+		if c.Landing.Path == "" {
+			c.Landing.Path = DefaultLandingPath
+		}
+
+		if !strings.HasPrefix(c.Landing.Path, "/") {
+			return errors.New("invalid config: landing path must start with a slash")
+		}
+
+		if c.Landing.Redirect != "" {
+			if _, err := url.Parse(c.Landing.Redirect); err != nil {
+				return fmt.Errorf("invalid config: landing redirect: %w", err)
+			}
+		}
+
+		if c.Landing.Page != "" {
+			c.Landing.Page, err = filepath.Abs(c.Landing.Page)
+			if err != nil {
+				return fmt.Errorf("invalid config: %w", err)
+			}
+		}
+	}
+
+	if c.MaxPropfindDepth < 0 {
+		return errors.New("invalid config: max propfind depth cannot be negative")
+	}
+
+	if c.Locks.MaxTimeout < 0 {
+		return errors.New("invalid config: lock max timeout cannot be negative")
+	}
+
+	if c.Locks.DefaultTimeout < 0 {
+		return errors.New("invalid config: lock default timeout cannot be negative")
+	}
+
+	if c.Locks.ReapInterval < 0 {
+		return errors.New("invalid config: lock reap interval cannot be negative")
+	}
+
+	if c.ConcurrentWrites == "" {
+		c.ConcurrentWrites = DefaultConcurrentWrites
+	}
+
+	switch c.ConcurrentWrites {
+	case ConcurrentWritesAllow, ConcurrentWritesWait, ConcurrentWritesReject:
+	default:
+		return fmt.Errorf("invalid config: unknown concurrent writes policy %q", c.ConcurrentWrites)
+	}
+
+	c.Scope, err = c.absScope(c.Scope)
 	if err != nil {
 		return fmt.Errorf("invalid config: %w", err)
 	}
 
+	// The default user has no username to expand the scope with.
+	if strings.Contains(c.Scope, userPlaceholder) && (!c.Auth || c.AnonymousRead) {
+		return fmt.Errorf("invalid config: scope with %s requires authentication, without anonymous read", userPlaceholder)
+	}
+
+	if c.LowerScope != "" {
+		if strings.Contains(c.LowerScope, userPlaceholder) {
+			return fmt.Errorf("invalid config: lower scope cannot contain %s", userPlaceholder)
+		}
+
+		c.LowerScope, err = c.absScope(c.LowerScope)
+		if err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+	}
+
+	mountPaths := map[string]bool{}
+	for i := range c.Mounts {
+		mount := &c.Mounts[i]
+		if !strings.HasPrefix(mount.Path, "/") {
+			return fmt.Errorf("invalid config: mount path %q must start with a slash", mount.Path)
+		}
+
+		mount.Path = path.Clean(mount.Path)
+		if mount.Path == "/" {
+			return errors.New("invalid config: cannot mount at the root, use the scope instead")
+		}
+		if mountPaths[mount.Path] {
+			return fmt.Errorf("invalid config: duplicate mount path %q", mount.Path)
+		}
+		mountPaths[mount.Path] = true
+
+		if mount.Scope == "" {
+			return fmt.Errorf("invalid config: mount %q requires a scope", mount.Path)
+		}
+		mount.Scope, err = c.absScope(mount.Scope)
+		if err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+	}
+
 	if c.TLS {
 		if c.Cert == "" {
 			return errors.New("invalid config: Cert must be defined if TLS is activated")
@@ -165,26 +1028,447 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.RateLimit < 0 {
+		return errors.New("invalid config: rate limit cannot be negative")
+	}
+
+	if c.MaxUploadSize < 0 {
+		return errors.New("invalid config: max upload size cannot be negative")
+	}
+
+	if c.UploadBuffer == 0 {
+		c.UploadBuffer = DefaultUploadBuffer
+	}
+
+	if c.UploadBuffer < 0 {
+		return errors.New("invalid config: upload buffer cannot be negative")
+	}
+
+	c.Permissions.expandUser("")
 	err = c.Permissions.Validate()
 	if err != nil {
 		return fmt.Errorf("invalid config: %w", err)
 	}
 
-	for _, u := range c.Users {
-		err := u.Validate()
+	// The anonymous requests are served with their own permissions, if given.
+	if c.Anonymous.Scope != "" {
+		if !c.Auth || !c.AnonymousRead {
+			return errors.New("invalid config: anonymous scope requires authentication, with anonymous read")
+		}
+
+		if c.Anonymous.Modify {
+			return errors.New("invalid config: anonymous permissions cannot allow modifications")
+		}
+
+		if strings.Contains(c.Anonymous.Scope, userPlaceholder) {
+			return fmt.Errorf("invalid config: anonymous scope cannot contain %s", userPlaceholder)
+		}
+
+		c.Anonymous.Scope, err = c.absScope(c.Anonymous.Scope)
+		if err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+
+		c.Anonymous.expandUser("")
+		if err := c.Anonymous.Validate(); err != nil {
+			return fmt.Errorf("invalid config: anonymous: %w", err)
+		}
+	}
+
+	// The users authenticated with LDAP get the permissions of the first group
+	// they are a member of, whose scope defaults to the one of the default user.
+	for i := range c.LDAP.Groups {
+		group := &c.LDAP.Groups[i]
+		if group.Name == "" {
+			return errors.New("invalid config: LDAP group name must be defined")
+		}
+
+		if group.Scope == "" {
+			group.Scope = c.Scope
+		}
+		group.Scope, err = c.absScope(group.Scope)
 		if err != nil {
 			return fmt.Errorf("invalid config: %w", err)
 		}
+
+		if err := group.Permissions.Validate(); err != nil {
+			return fmt.Errorf("invalid config: LDAP group %q: %w", group.Name, err)
+		}
+	}
+
+	if c.Authenticator != nil && !c.usesAuthMethod(AuthMethodBasic) && !c.usesAuthMethod(AuthMethodCertificate) {
+		if len(c.AuthMethods) > 0 {
+			return fmt.Errorf("invalid config: an authenticator cannot be used with the %q auth methods", c.AuthMethods)
+		}
+		return fmt.Errorf("invalid config: an authenticator cannot be used with the %q auth method", c.AuthMethod)
+	}
+
+	for i := range c.Users {
+		// With token authentication, or with an authenticator checking the
+		// credentials, the users do not need a password.
+		err := c.Users[i].validate(c.requiresPasswords())
+		if err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+
+		if c.usesAuthMethod(AuthMethodDigest) && c.Users[i].hasHashedPassword() {
+			return fmt.Errorf("invalid config: user %q: digest authentication requires a plaintext password", c.Users[i].Username)
+		}
+	}
+
+	return nil
+}
+
+// requiresPasswords reports whether the users must have a password, which is
+// the case if their passwords are checked by the handler.
+func (c *Config) requiresPasswords() bool {
+	return c.Authenticator == nil &&
+		(c.usesAuthMethod(AuthMethodBasic) || c.usesAuthMethod(AuthMethodDigest) || c.usesAuthMethod(AuthMethodCertificate))
+}
+
+// usesAuthMethod reports whether the requests can be authenticated with the
+// given auth method. The auth methods, if any, take precedence over the auth
+// method.
+func (c *Config) usesAuthMethod(method string) bool {
+	if len(c.AuthMethods) == 0 {
+		return c.AuthMethod == method
+	}
+
+	for _, m := range c.AuthMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+type Digest struct {
+	NonceTimeout time.Duration `mapstructure:"nonce_timeout"`
+}
+
+type Certificate struct {
+	ClientCA string `mapstructure:"client_ca"`
+	Field    string
+	Mappings []CertificateMapping
+}
+
+type Concurrency struct {
+	MaxRequests  int `mapstructure:"max_requests"`
+	MaxWrites    int `mapstructure:"max_writes"`
+	MaxPerClient int `mapstructure:"max_per_client"`
+	MaxPerUser   int `mapstructure:"max_per_user"`
+}
+
+type Versioning struct {
+	Enabled     bool
+	MaxVersions int           `mapstructure:"max_versions"`
+	MaxAge      time.Duration `mapstructure:"max_age"`
+}
+
+type Lockout struct {
+	Enabled  bool
+	Attempts int
+	Window   time.Duration
+	Cooldown time.Duration
+}
+
+type Proxy struct {
+	Enabled bool
+	Trusted []string
+}
+
+// Networks are the networks the requests can come from, as addresses or CIDRs.
+type Networks struct {
+	Allowed []string
+	Denied  []string
+}
+
+type JWT struct {
+	Secret        string
+	JWKSURL       string `mapstructure:"jwks_url"`
+	Algorithm     string
+	UsernameClaim string `mapstructure:"username_claim"`
+	Anonymous     bool
+}
+
+// LDAP is the configuration of the authentication of the users against an LDAP
+// directory, whose groups may grant them their permissions.
+type LDAP struct {
+	URL             string
+	StartTLS        bool   `mapstructure:"start_tls"`
+	CA              string `mapstructure:"ca"`
+	BaseDN          string `mapstructure:"base_dn"`
+	UserAttribute   string `mapstructure:"user_attribute"`
+	GroupBaseDN     string `mapstructure:"group_base_dn"`
+	MemberAttribute string `mapstructure:"member_attribute"`
+	Groups          []LDAPGroup
+	PoolSize        int           `mapstructure:"pool_size"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+}
+
+// LDAPGroup maps the members of an LDAP group to their permissions.
+type LDAPGroup struct {
+	Permissions `mapstructure:",squash"`
+	Name        string
+}
+
+// Introspection is the configuration of the authentication by bearer tokens
+// checked against an OAuth 2.0 token introspection endpoint.
+type Introspection struct {
+	URL           string
+	ClientID      string        `mapstructure:"client_id"`
+	ClientSecret  string        `mapstructure:"client_secret"`
+	UsernameField string        `mapstructure:"username_field"`
+	CacheTTL      time.Duration `mapstructure:"cache_ttl"`
+}
+
+type Compression struct {
+	Enabled      bool
+	MinSize      int64 `mapstructure:"min_size"`
+	Level        int
+	ContentTypes []string `mapstructure:"content_types"`
+}
+
+// PropfindCache is the configuration of the cache of the responses to the
+// PROPFIND requests, for the trees that are mostly read.
+type PropfindCache struct {
+	Enabled bool
+	Size    int
+	TTL     time.Duration `mapstructure:"ttl"`
+}
+
+// UserCache is the configuration of the cache of the users looked up in the
+// user store, which bounds the number of them kept in memory.
+type UserCache struct {
+	Size int
+	TTL  time.Duration `mapstructure:"ttl"`
+}
+
+// VirtualFile is the configuration of the read-only file served in every
+// collection, such as a README with usage notes, which does not exist in the
+// file systems.
+type VirtualFile struct {
+	Name    string
+	Content string
+}
+
+// RequestID is the configuration of the IDs of the requests, which are included
+// in their logs, and in the headers of their responses.
+type RequestID struct {
+	Enabled bool
+	Header  string
+}
+
+type AccessLog struct {
+	Enabled bool
+	Format  string
+}
+
+// Audit is the configuration of the audit log, which records the requests that
+// may modify the contents.
+type Audit struct {
+	Enabled bool
+	File    string
+}
+
+// Redaction is the configuration of the redaction of the logs. The paths that
+// match the patterns, or whose parents do, are masked or hashed, and the values
+// of the headers are never logged.
+type Redaction struct {
+	Paths   []string
+	Mode    string
+	Headers []string
+}
+
+// S3 is the configuration of the S3 backend. The scopes are the key prefixes
+// the files are stored under, within the bucket.
+type S3 struct {
+	Bucket          string
+	Endpoint        string
+	Region          string
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	PathStyle       bool   `mapstructure:"path_style"`
+	// DialTimeout bounds the connections to the endpoint, including the TLS
+	// handshakes, ResponseHeaderTimeout the wait for the responses once the
+	// requests are sent, and IdleTimeout how long the unused connections are
+	// kept.
+	DialTimeout           time.Duration `mapstructure:"dial_timeout"`
+	ResponseHeaderTimeout time.Duration `mapstructure:"response_header_timeout"`
+	IdleTimeout           time.Duration `mapstructure:"idle_timeout"`
+	// TempDir is the directory the uploads are buffered in. If empty, the
+	// default temporary directory of the system is used.
+	TempDir string `mapstructure:"temp_dir"`
+}
+
+func (s *S3) Validate() error {
+	if s.Bucket == "" {
+		return errors.New("s3 bucket is required")
+	}
+
+	if s.Region == "" {
+		s.Region = DefaultS3Region
+	}
+
+	if s.Endpoint == "" {
+		s.Endpoint = "https://s3." + s.Region + ".amazonaws.com"
+	}
+
+	if s.DialTimeout < 0 || s.ResponseHeaderTimeout < 0 || s.IdleTimeout < 0 {
+		return errors.New("s3 timeouts cannot be negative")
+	}
+	if s.DialTimeout == 0 {
+		s.DialTimeout = DefaultS3DialTimeout
+	}
+	if s.ResponseHeaderTimeout == 0 {
+		s.ResponseHeaderTimeout = DefaultS3ResponseHeaderTimeout
+	}
+	if s.IdleTimeout == 0 {
+		s.IdleTimeout = DefaultS3IdleTimeout
+	}
+
+	if s.TempDir != "" {
+		info, err := os.Stat(s.TempDir)
+		if err != nil {
+			return fmt.Errorf("invalid s3 temp dir: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("invalid s3 temp dir %q: not a directory", s.TempDir)
+		}
+	}
+
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("invalid s3 endpoint %q", s.Endpoint)
+	}
+
+	// Like the AWS tools, fall back to the credentials of the environment.
+	if s.AccessKeyID == "" && s.SecretAccessKey == "" {
+		s.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		s.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	if (s.AccessKeyID == "") != (s.SecretAccessKey == "") {
+		return errors.New("s3 access key id and secret access key must be set together")
 	}
 
 	return nil
 }
 
+// absScope returns the absolute form of a scope. With the S3 backend, the
+// scopes are key prefixes, which are not relative to the working directory.
+func (c *Config) absScope(scope string) (string, error) {
+	if c.Backend == BackendS3 {
+		return path.Clean("/" + scope), nil
+	}
+	return filepath.Abs(scope)
+}
+
+// parseScopeMode parses the permissions of the created scopes, in octal.
+func parseScopeMode(mode string) (os.FileMode, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > uint64(os.ModePerm) {
+		return 0, fmt.Errorf("invalid mode %q", mode)
+	}
+	return os.FileMode(perm), nil
+}
+
+// checkScopes checks that the scopes on disk are directories. The missing ones
+// are created if configured, or are errors. The scopes expanded from templates
+// are created with the users, when they are first seen.
+func (c *Config) checkScopes() error {
+	if c.Backend != BackendDisk {
+		return nil
+	}
+
+	scopes := []string{c.Scope, c.Anonymous.Scope, c.LowerScope}
+	for _, u := range c.Users {
+		if !u.home {
+			scopes = append(scopes, u.Scope)
+		}
+	}
+	for _, mount := range c.Mounts {
+		scopes = append(scopes, mount.Scope)
+	}
+	for _, group := range c.LDAP.Groups {
+		scopes = append(scopes, group.Scope)
+	}
+
+	for _, scope := range scopes {
+		if scope == "" || strings.Contains(scope, userPlaceholder) {
+			continue
+		}
+
+		info, err := os.Stat(scope)
+		if errors.Is(err, os.ErrNotExist) && c.CreateScope {
+			mode, err := parseScopeMode(c.ScopeMode)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(scope, mode); err != nil {
+				return fmt.Errorf("failed to create scope %q: %w", scope, err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("invalid scope %q: %w", scope, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("invalid scope %q: not a directory", scope)
+		}
+	}
+	return nil
+}
+
+// Mount serves a directory at a path, in addition to the scope.
+type Mount struct {
+	Path  string
+	Scope string
+}
+
+type Locks struct {
+	DefaultTimeout time.Duration `mapstructure:"default_timeout"`
+	MaxTimeout     time.Duration `mapstructure:"max_timeout"`
+	ReapInterval   time.Duration `mapstructure:"reap_interval"`
+	Shared         bool
+	File           string
+}
+
+type Metrics struct {
+	Enabled bool
+	Path    string
+	Prefix  string
+}
+
+type Health struct {
+	Enabled    bool
+	Path       string
+	CheckScope bool `mapstructure:"check_scope"`
+}
+
+// Admin is the endpoint that reports the effective configuration, without the
+// secrets, to the users flagged as admins.
+type Admin struct {
+	Enabled bool
+	Path    string
+}
+
+// Landing answers the GET requests to a path with a redirect, or a static page.
+type Landing struct {
+	Path     string
+	Redirect string
+	Page     string
+}
+
 type CORS struct {
 	Enabled        bool
 	Credentials    bool
-	AllowedHeaders []string `mapstructure:"allowed_headers"`
-	AllowedHosts   []string `mapstructure:"allowed_hosts"`
-	AllowedMethods []string `mapstructure:"allowed_methods"`
-	ExposedHeaders []string `mapstructure:"exposed_headers"`
+	AllowedHeaders []string      `mapstructure:"allowed_headers"`
+	AllowedHosts   []string      `mapstructure:"allowed_hosts"`
+	AllowedMethods []string      `mapstructure:"allowed_methods"`
+	ExposedHeaders []string      `mapstructure:"exposed_headers"`
+	MaxAge         time.Duration `mapstructure:"max_age"`
 }
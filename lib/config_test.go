@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -34,11 +35,19 @@ func TestConfigDefaults(t *testing.T) {
 	require.EqualValues(t, DefaultPort, cfg.Port)
 	require.EqualValues(t, DefaultPrefix, cfg.Prefix)
 	require.EqualValues(t, DefaultLogFormat, cfg.LogFormat)
+	require.EqualValues(t, DefaultLogLevel, cfg.LogLevel)
+	require.EqualValues(t, DefaultAuthMethod, cfg.AuthMethod)
+	require.EqualValues(t, DefaultETag, cfg.ETag)
+	require.EqualValues(t, DefaultDigestNonceTimeout, cfg.Digest.NonceTimeout)
+	require.EqualValues(t, DefaultLockoutAttempts, cfg.Lockout.Attempts)
+	require.EqualValues(t, DefaultLockoutWindow, cfg.Lockout.Window)
+	require.EqualValues(t, DefaultLockoutCooldown, cfg.Lockout.Cooldown)
 	require.NotEmpty(t, cfg.Scope)
 
 	require.EqualValues(t, []string{"*"}, cfg.CORS.AllowedHeaders)
 	require.EqualValues(t, []string{"*"}, cfg.CORS.AllowedHosts)
 	require.EqualValues(t, []string{"*"}, cfg.CORS.AllowedMethods)
+	require.EqualValues(t, DefaultCORSExposedHeaders, cfg.CORS.ExposedHeaders)
 }
 
 func TestConfigCascade(t *testing.T) {
@@ -60,6 +69,26 @@ func TestConfigCascade(t *testing.T) {
 		require.Len(t, cfg.Users[1].Rules, 0)
 	}
 
+	t.Run("Limits", func(t *testing.T) {
+		content := `
+auth: true
+rate_limit: 1024
+max_upload_size: 100
+users:
+  - username: admin
+    password: admin
+  - username: basic
+    password: basic
+    rate_limit: 2048
+    max_upload_size: 200`
+
+		cfg := writeAndParseConfig(t, content, ".yml")
+		require.EqualValues(t, 1024, cfg.Users[0].RateLimit)
+		require.EqualValues(t, 2048, cfg.Users[1].RateLimit)
+		require.EqualValues(t, 100, cfg.Users[0].MaxUploadSize)
+		require.EqualValues(t, 200, cfg.Users[1].MaxUploadSize)
+	})
+
 	t.Run("YAML", func(t *testing.T) {
 		content := `
 auth: true
@@ -159,7 +188,8 @@ cors:
     - GET
   exposed_headers:
     - Content-Length
-    - Content-Range`, ".yml")
+    - Content-Range
+  max_age: 10m`, ".yml")
 	require.NoError(t, cfg.Validate())
 
 	require.True(t, cfg.CORS.Enabled)
@@ -168,6 +198,7 @@ cors:
 	require.EqualValues(t, []string{"Depth"}, cfg.CORS.AllowedHeaders)
 	require.EqualValues(t, []string{"http://localhost:8080"}, cfg.CORS.AllowedHosts)
 	require.EqualValues(t, []string{"GET"}, cfg.CORS.AllowedMethods)
+	require.Equal(t, 10*time.Minute, cfg.CORS.MaxAge)
 }
 
 func TestConfigRules(t *testing.T) {
@@ -217,3 +248,130 @@ func TestConfigEnv(t *testing.T) {
 	require.NoError(t, os.Setenv("WD_MODIFY", ""))
 	require.NoError(t, os.Setenv("WD_SCOPE", ""))
 }
+
+func TestConfigHeaders(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+nosniff: true
+headers:
+  cache-control: no-cache
+  x-content-type-options: ""`, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, map[string]string{"Cache-Control": "no-cache", "X-Content-Type-Options": ""}, cfg.Headers)
+
+	cfg = &Config{NoSniff: true}
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, map[string]string{"X-Content-Type-Options": "nosniff"}, cfg.Headers)
+
+	for _, headers := range []map[string]string{
+		{"Content-Length": "10"},
+		{"content-type": "text/plain"},
+		{"X Bad": "value"},
+		{"X-Good": "bad\r\nvalue"},
+	} {
+		cfg := &Config{Headers: headers}
+		require.Error(t, cfg.Validate())
+	}
+}
+
+func TestConfigRealm(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `realm: Team Files`, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, "Team Files", cfg.Realm)
+
+	for _, realm := range []string{`Team "Files"`, `Team\Files`, "Team\nFiles"} {
+		cfg := &Config{Realm: realm}
+		require.Error(t, cfg.Validate(), realm)
+	}
+}
+
+func TestConfigDisabledMethods(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, "disabled_methods: [lock, Unlock]", ".yml")
+	require.Equal(t, []string{"LOCK", "UNLOCK"}, cfg.DisabledMethods)
+
+	cfg = &Config{DisabledMethods: []string{"TRACE"}}
+	require.ErrorContains(t, cfg.Validate(), "unknown method")
+}
+
+func TestConfigAdmin(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+auth: true
+admin:
+  enabled: true
+users:
+  - username: root
+    password: root
+    admin: true
+`, ".yml")
+	require.Equal(t, DefaultAdminPath, cfg.Admin.Path)
+	require.True(t, cfg.Users[0].Admin)
+
+	cfg = &Config{Admin: Admin{Enabled: true, Path: DefaultAdminPath}}
+	require.ErrorContains(t, cfg.Validate(), "admin endpoint requires authentication")
+}
+
+func TestConfigAnonymous(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+auth: true
+anonymous_read: true
+anonymous:
+  scope: /public
+users:
+  - username: root
+    password: root
+`, ".yml")
+	require.Equal(t, "/public", cfg.Anonymous.Scope)
+	require.False(t, cfg.Anonymous.Modify)
+
+	cfg = &Config{Anonymous: Permissions{Scope: "/public"}}
+	require.ErrorContains(t, cfg.Validate(), "anonymous scope requires authentication")
+
+	cfg = &Config{Auth: true, AnonymousRead: true, Users: []User{{Username: "root", Password: "root"}}, Anonymous: Permissions{Scope: "/public", Modify: true}}
+	require.ErrorContains(t, cfg.Validate(), "anonymous permissions cannot allow modifications")
+}
+
+func TestConfigBlockedExtensions(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+auth: true
+blocked_extensions: [EXE, .sh]
+users:
+  - username: guest
+    password: guest
+  - username: admin
+    password: admin
+    blocked_extensions: []
+`, ".yml")
+	require.Equal(t, []string{".exe", ".sh"}, cfg.BlockedExtensions)
+	require.Equal(t, []string{".exe", ".sh"}, cfg.Users[0].BlockedExtensions)
+	require.Empty(t, cfg.Users[1].BlockedExtensions)
+
+	for _, ext := range []string{"", ".", ".tar.gz", "dir/exe"} {
+		cfg := &Config{Permissions: Permissions{BlockedExtensions: []string{ext}}}
+		require.ErrorContains(t, cfg.Validate(), "invalid blocked extension", ext)
+	}
+}
+
+func TestConfigScopeMode(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+create_scope: true`, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, DefaultScopeMode, cfg.ScopeMode)
+
+	for _, mode := range []string{"rwx", "0800", "17777"} {
+		cfg := &Config{ScopeMode: mode}
+		require.ErrorContains(t, cfg.Validate(), "invalid scope mode", mode)
+	}
+}
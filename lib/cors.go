@@ -0,0 +1,27 @@
+package lib
+
+import (
+	"github.com/rs/cors"
+)
+
+// DefaultCORSExposedHeaders are the response headers exposed to the browsers by
+// default. They are the WebDAV response headers that are not safelisted.
+var DefaultCORSExposedHeaders = []string{
+	"DAV",
+	"ETag",
+	"Lock-Token",
+	"Content-Range",
+	"MS-Author-Via",
+}
+
+func corsOptions(c CORS) cors.Options {
+	return cors.Options{
+		AllowCredentials:   c.Credentials,
+		AllowedOrigins:     c.AllowedHosts,
+		AllowedMethods:     c.AllowedMethods,
+		AllowedHeaders:     c.AllowedHeaders,
+		ExposedHeaders:     c.ExposedHeaders,
+		MaxAge:             int(c.MaxAge.Seconds()),
+		OptionsPassthrough: false,
+	}
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORSOptions(t *testing.T) {
+	t.Parallel()
+
+	options := corsOptions(CORS{
+		Credentials:    true,
+		AllowedHosts:   []string{"https://example.com"},
+		AllowedMethods: []string{"GET", "PROPFIND"},
+		AllowedHeaders: []string{"Depth"},
+		ExposedHeaders: []string{"ETag", "DAV"},
+		MaxAge:         10 * time.Minute,
+	})
+
+	require.True(t, options.AllowCredentials)
+	require.Equal(t, []string{"https://example.com"}, options.AllowedOrigins)
+	require.Equal(t, []string{"GET", "PROPFIND"}, options.AllowedMethods)
+	require.Equal(t, []string{"Depth"}, options.AllowedHeaders)
+	require.Equal(t, []string{"ETag", "DAV"}, options.ExposedHeaders)
+	require.Equal(t, 600, options.MaxAge)
+}
+
+func TestHandlerCORS(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		CORS: CORS{
+			Enabled:        true,
+			AllowedHosts:   []string{"*"},
+			AllowedMethods: []string{"GET", "PROPFIND"},
+			AllowedHeaders: []string{"*"},
+			ExposedHeaders: DefaultCORSExposedHeaders,
+			MaxAge:         time.Hour,
+		},
+	})
+
+	r := httptest.NewRequest(http.MethodOptions, "/file.txt", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", "PROPFIND")
+	w := doRequest(h, r)
+	require.Equal(t, "3600", w.Header().Get("Access-Control-Max-Age"))
+
+	r = httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	r.Header.Set("Origin", "https://example.com")
+	w = doRequest(h, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "Dav, Etag, Lock-Token, Content-Range, Ms-Author-Via", w.Header().Get("Access-Control-Expose-Headers"))
+}
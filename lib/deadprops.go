@@ -0,0 +1,250 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/webdav"
+)
+
+// deadPropsFile is the file the dead properties of the entries of a directory
+// are stored in, within that directory. It is hidden from the clients.
+const deadPropsFile = ".davprops.json"
+
+// storedProperty is the representation of a dead property in the file.
+type storedProperty struct {
+	Space    string `json:"space"`
+	Local    string `json:"local"`
+	Lang     string `json:"lang,omitempty"`
+	InnerXML string `json:"inner_xml"`
+}
+
+// deadPropsStore persists the dead properties, set by PROPPATCH, next to the
+// files, so that they are kept across restarts. The properties of a resource
+// are stored by base name in the file of its parent directory, and the root
+// stores its own under "/".
+type deadPropsStore struct {
+	// mu serializes the updates of the files, which are read, modified, and
+	// written back.
+	mu sync.Mutex
+}
+
+func deadPropsLocation(name string) (string, string) {
+	name = path.Clean("/" + name)
+	return path.Join(path.Dir(name), deadPropsFile), path.Base(name)
+}
+
+// read returns the properties stored in a file, by base name.
+func (s *deadPropsStore) read(ctx context.Context, fs webdav.FileSystem, file string) (map[string][]storedProperty, error) {
+	entries := map[string][]storedProperty{}
+
+	f, err := fs.OpenFile(ctx, file, os.O_RDONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// write replaces the file, atomically if possible, or removes it if there are
+// no properties left.
+func (s *deadPropsStore) write(ctx context.Context, fs webdav.FileSystem, file string, entries map[string][]storedProperty) error {
+	if len(entries) == 0 {
+		if err := fs.RemoveAll(ctx, file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	var f webdav.File
+	if atomicUploads(fs) {
+		f, err = fs.OpenFile(ctx, file, flag, 0666)
+	} else {
+		f, err = openUpload(ctx, fs, file, flag, 0666, nil, Versioning{})
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// get returns the dead properties of a resource.
+func (s *deadPropsStore) get(ctx context.Context, fs webdav.FileSystem, name string) (map[xml.Name]webdav.Property, error) {
+	file, key := deadPropsLocation(name)
+
+	s.mu.Lock()
+	entries, err := s.read(ctx, fs, file)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	props := map[xml.Name]webdav.Property{}
+	for _, p := range entries[key] {
+		xmlName := xml.Name{Space: p.Space, Local: p.Local}
+		props[xmlName] = webdav.Property{XMLName: xmlName, Lang: p.Lang, InnerXML: []byte(p.InnerXML)}
+	}
+	return props, nil
+}
+
+// patch sets and removes the dead properties of a resource, in order. Either
+// all of them succeed, or none does.
+func (s *deadPropsStore) patch(ctx context.Context, fs webdav.FileSystem, name string, patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	file, key := deadPropsLocation(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.read(ctx, fs, file)
+	if err != nil {
+		return nil, err
+	}
+
+	props := map[xml.Name]storedProperty{}
+	for _, p := range entries[key] {
+		props[xml.Name{Space: p.Space, Local: p.Local}] = p
+	}
+
+	stat := webdav.Propstat{Status: http.StatusOK}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			stat.Props = append(stat.Props, webdav.Property{XMLName: p.XMLName})
+			if patch.Remove {
+				delete(props, p.XMLName)
+				continue
+			}
+			props[p.XMLName] = storedProperty{Space: p.XMLName.Space, Local: p.XMLName.Local, Lang: p.Lang, InnerXML: string(p.InnerXML)}
+		}
+	}
+
+	// Keep the file stable, regardless of the order of the map.
+	stored := make([]storedProperty, 0, len(props))
+	for _, p := range props {
+		stored = append(stored, p)
+	}
+	sort.Slice(stored, func(i, j int) bool {
+		if stored[i].Space != stored[j].Space {
+			return stored[i].Space < stored[j].Space
+		}
+		return stored[i].Local < stored[j].Local
+	})
+
+	if len(stored) == 0 {
+		delete(entries, key)
+	} else {
+		entries[key] = stored
+	}
+
+	if err := s.write(ctx, fs, file, entries); err != nil {
+		return nil, err
+	}
+	return []webdav.Propstat{stat}, nil
+}
+
+// move moves the dead properties of a resource to its new name, after it was
+// renamed. Those of the resources within a renamed directory move along with
+// the directory itself.
+func (s *deadPropsStore) move(ctx context.Context, fs webdav.FileSystem, oldName, newName string) error {
+	oldFile, oldKey := deadPropsLocation(oldName)
+	newFile, newKey := deadPropsLocation(newName)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.read(ctx, fs, oldFile)
+	if err != nil {
+		return err
+	}
+	props, ok := entries[oldKey]
+	if ok {
+		delete(entries, oldKey)
+		if err := s.write(ctx, fs, oldFile, entries); err != nil {
+			return err
+		}
+	}
+
+	// The destination may have been overwritten, along with its properties.
+	entries, err = s.read(ctx, fs, newFile)
+	if err != nil {
+		return err
+	}
+	if _, overwritten := entries[newKey]; !ok && !overwritten {
+		return nil
+	}
+
+	if ok {
+		entries[newKey] = props
+	} else {
+		delete(entries, newKey)
+	}
+	return s.write(ctx, fs, newFile, entries)
+}
+
+// remove removes the dead properties of a resource, after it was removed.
+func (s *deadPropsStore) remove(ctx context.Context, fs webdav.FileSystem, name string) error {
+	file, key := deadPropsLocation(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.read(ctx, fs, file)
+	if err != nil {
+		return err
+	}
+	if _, ok := entries[key]; !ok {
+		return nil
+	}
+
+	delete(entries, key)
+	return s.write(ctx, fs, file, entries)
+}
+
+// wrappedDeadProps returns the dead properties of a file that another one
+// wraps, so that the wrappers outside of the [Dir] keep its properties.
+func wrappedDeadProps(f webdav.File) (map[xml.Name]webdav.Property, error) {
+	if holder, ok := f.(webdav.DeadPropsHolder); ok {
+		return holder.DeadProps()
+	}
+	return nil, nil
+}
+
+// wrappedPatch patches the dead properties of a file that another one wraps.
+// If the file holds none, the patches are forbidden, as with the WebDAV
+// handler.
+func wrappedPatch(f webdav.File, patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	if holder, ok := f.(webdav.DeadPropsHolder); ok {
+		return holder.Patch(patches)
+	}
+
+	forbidden := webdav.Propstat{Status: http.StatusForbidden}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			forbidden.Props = append(forbidden.Props, webdav.Property{XMLName: p.XMLName})
+		}
+	}
+	return []webdav.Propstat{forbidden}, nil
+}
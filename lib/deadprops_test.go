@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadProperties(t *testing.T) {
+	t.Parallel()
+
+	const setBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="http://example.com/ns">
+  <D:set><D:prop><Z:color>blue</Z:color><Z:rating>5</Z:rating></D:prop></D:set>
+</D:propertyupdate>`
+
+	const removeBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="http://example.com/ns">
+  <D:remove><D:prop><Z:color/></D:prop></D:remove>
+</D:propertyupdate>`
+
+	const findBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:" xmlns:Z="http://example.com/ns">
+  <D:prop><Z:color/><Z:rating/></D:prop>
+</D:propfind>`
+
+	newHandler := func(t *testing.T, dir string) http.Handler {
+		return newTestHandler(t, &Config{
+			Permissions:    Permissions{Scope: dir, Modify: true},
+			DeadProperties: true,
+		})
+	}
+
+	request := func(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Depth", "0")
+		return doRequest(h, r)
+	}
+
+	t.Run("Persistence", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0666))
+
+		h := newHandler(t, dir)
+		w := request(h, "PROPPATCH", "/file.txt", setBody)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.Contains(t, w.Body.String(), "HTTP/1.1 200 OK")
+		require.FileExists(t, filepath.Join(dir, deadPropsFile))
+
+		// The properties are still there with a new handler, as after a restart.
+		h = newHandler(t, dir)
+		w = request(h, "PROPFIND", "/file.txt", findBody)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.Contains(t, w.Body.String(), `<color xmlns="http://example.com/ns">blue</color>`)
+		require.Contains(t, w.Body.String(), `<rating xmlns="http://example.com/ns">5</rating>`)
+
+		w = request(h, "PROPPATCH", "/file.txt", removeBody)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+
+		w = request(h, "PROPFIND", "/file.txt", findBody)
+		require.NotContains(t, w.Body.String(), "blue")
+		require.Contains(t, w.Body.String(), `<rating xmlns="http://example.com/ns">5</rating>`)
+	})
+
+	t.Run("Hidden", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0666))
+
+		h := newHandler(t, dir)
+		require.Equal(t, http.StatusMultiStatus, request(h, "PROPPATCH", "/file.txt", setBody).Code)
+
+		r := httptest.NewRequest("PROPFIND", "/", nil)
+		r.Header.Set("Depth", "1")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.NotContains(t, w.Body.String(), deadPropsFile)
+		require.Equal(t, http.StatusNotFound, request(h, http.MethodGet, "/"+deadPropsFile, "").Code)
+		require.Equal(t, http.StatusConflict, request(h, http.MethodPut, "/"+deadPropsFile, "{}").Code)
+
+		data, err := os.ReadFile(filepath.Join(dir, deadPropsFile))
+		require.NoError(t, err)
+		require.Contains(t, string(data), "blue")
+	})
+
+	t.Run("Move and Delete", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0666))
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "dir"), 0777))
+
+		h := newHandler(t, dir)
+		require.Equal(t, http.StatusMultiStatus, request(h, "PROPPATCH", "/file.txt", setBody).Code)
+
+		r := httptest.NewRequest("MOVE", "/file.txt", nil)
+		r.Header.Set("Destination", "/dir/moved.txt")
+		require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+		require.NoFileExists(t, filepath.Join(dir, deadPropsFile))
+
+		w := request(h, "PROPFIND", "/dir/moved.txt", findBody)
+		require.Contains(t, w.Body.String(), "blue")
+
+		require.Equal(t, http.StatusNoContent, request(h, http.MethodDelete, "/dir/moved.txt", "").Code)
+		require.NoFileExists(t, filepath.Join(dir, "dir", deadPropsFile))
+	})
+}
@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"net/http"
+	"strings"
+)
+
+// infiniteDepth is the depth of the requests with "Depth: infinity", or with
+// no Depth header, which means the same for PROPFIND.
+const infiniteDepth = -1
+
+// parseDepth parses the Depth header. Invalid values are reported as such, so
+// that the WebDAV handler rejects them.
+func parseDepth(header string) (int, bool) {
+	switch strings.ToLower(strings.TrimSpace(header)) {
+	case "0":
+		return 0, true
+	case "1":
+		return 1, true
+	case "", "infinity":
+		return infiniteDepth, true
+	default:
+		return 0, false
+	}
+}
+
+// depthExceeds reports whether the Depth header is deeper than the maximum.
+func depthExceeds(header string, max int) bool {
+	depth, ok := parseDepth(header)
+	if !ok || max <= 0 {
+		return false
+	}
+	return depth == infiniteDepth || depth > max
+}
+
+// servePropfindDepthError replies a PROPFIND request that is too deep with the
+// precondition of RFC 4918, section 9.1.
+func servePropfindDepthError(w http.ResponseWriter) {
+	writeXMLError(w, http.StatusForbidden, "<D:propfind-finite-depth/>")
+}
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerMaxPropfindDepth(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "dir", "sub"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dir", "sub", "deep.txt"), []byte("deep"), 0666))
+
+	h := newTestHandler(t, &Config{Permissions: Permissions{Scope: dir}, MaxPropfindDepth: 1})
+
+	propfind := func(depth string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PROPFIND", "/", nil)
+		if depth != "" {
+			r.Header.Set("Depth", depth)
+		}
+		return doRequest(h, r)
+	}
+
+	for _, depth := range []string{"infinity", ""} {
+		w := propfind(depth)
+		require.Equal(t, http.StatusForbidden, w.Code, depth)
+		require.Contains(t, w.Body.String(), "<D:propfind-finite-depth/>", depth)
+	}
+
+	for _, depth := range []string{"0", "1"} {
+		require.Equal(t, http.StatusMultiStatus, propfind(depth).Code, depth)
+	}
+
+	// Directories requested with GET are listed one level deep.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Depth", "infinity")
+	w := doRequest(h, r)
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	require.Contains(t, w.Body.String(), "/dir/")
+	require.NotContains(t, w.Body.String(), "deep.txt")
+
+	// Without a maximum, any depth is fine.
+	h = newTestHandler(t, &Config{})
+	r = httptest.NewRequest("PROPFIND", "/", nil)
+	r.Header.Set("Depth", "infinity")
+	require.Equal(t, http.StatusMultiStatus, doRequest(h, r).Code)
+}
+
+func TestDepthExceeds(t *testing.T) {
+	t.Parallel()
+
+	require.False(t, depthExceeds("infinity", 0))
+	require.True(t, depthExceeds("Infinity", 1))
+	require.True(t, depthExceeds("", 1))
+	require.False(t, depthExceeds("1", 1))
+	// Invalid depths are left for the WebDAV handler to reject.
+	require.False(t, depthExceeds("2", 1))
+}
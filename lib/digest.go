@@ -0,0 +1,249 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// digestNonceCounts is the maximum number of nonces whose counts are tracked.
+const digestNonceCounts = 10000
+
+// digestAuth implements the server side of HTTP Digest authentication, as
+// described in RFC 2617, with qop=auth. Nonces encode their creation time and
+// are signed with a per-process key, which is enough for verifying that they
+// were issued by us and are not expired. The highest nonce count of each nonce
+// is tracked until it expires, so that the requests cannot be replayed.
+type digestAuth struct {
+	realm   string
+	timeout time.Duration
+	key     []byte
+	opaque  string
+
+	mu     sync.Mutex
+	counts map[string]nonceCount
+	// forgotten is when the most recent of the nonces forgotten before they
+	// expired was issued. The nonces issued before are no longer accepted.
+	forgotten time.Time
+}
+
+type nonceCount struct {
+	count  uint64
+	issued time.Time
+}
+
+func newDigestAuth(realm string, timeout time.Duration) (*digestAuth, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	opaque := make([]byte, 16)
+	if _, err := rand.Read(opaque); err != nil {
+		return nil, err
+	}
+
+	return &digestAuth{
+		realm:   realm,
+		timeout: timeout,
+		key:     key,
+		opaque:  hex.EncodeToString(opaque),
+		counts:  map[string]nonceCount{},
+	}, nil
+}
+
+// challenge returns the value of the WWW-Authenticate header for a new nonce.
+// If stale is true, the client is told that its credentials were correct, but
+// the nonce has expired, so it can retry without prompting the user.
+func (d *digestAuth) challenge(stale bool) string {
+	challenge := fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=MD5, nonce="%s", opaque="%s"`, d.realm, d.newNonce(time.Now()), d.opaque)
+	if stale {
+		challenge += ", stale=true"
+	}
+	return challenge
+}
+
+func (d *digestAuth) newNonce(t time.Time) string {
+	data := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(data, uint64(t.UnixNano()))
+	return base64.RawURLEncoding.EncodeToString(d.sign(data))
+}
+
+func (d *digestAuth) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write(data)
+	return mac.Sum(data)
+}
+
+// checkNonce reports whether the nonce was issued by us and whether it is
+// still within the configured timeout.
+func (d *digestAuth) checkNonce(nonce string) (valid, expired bool) {
+	issued, ok := d.nonceIssued(nonce)
+	if !ok {
+		return false, false
+	}
+	return true, time.Since(issued) > d.timeout
+}
+
+// nonceIssued returns when the nonce was issued, if it was issued by us.
+func (d *digestAuth) nonceIssued(nonce string) (time.Time, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(data) != 8+sha256.Size {
+		return time.Time{}, false
+	}
+
+	if !hmac.Equal(d.sign(data[:8:8]), data) {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data[:8]))), true
+}
+
+// useNonce reports whether the nonce count is higher than the ones already
+// used with the valid nonce, which it becomes the highest of. If too many
+// nonces are tracked, the expired ones are forgotten first, and then the
+// oldest ones, which are no longer accepted either.
+func (d *digestAuth) useNonce(nonce, nc string) bool {
+	count, err := strconv.ParseUint(nc, 16, 64)
+	if err != nil {
+		return false
+	}
+	issued, ok := d.nonceIssued(nonce)
+	if !ok {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	used, ok := d.counts[nonce]
+	if !ok && !issued.After(d.forgotten) {
+		return false
+	}
+	if ok && count <= used.count {
+		return false
+	}
+
+	if !ok && len(d.counts) >= digestNonceCounts {
+		d.forget(time.Now())
+	}
+	d.counts[nonce] = nonceCount{count: count, issued: issued}
+	return true
+}
+
+// forget forgets the expired nonces or, if there are none, the oldest half of
+// the nonces.
+func (d *digestAuth) forget(now time.Time) {
+	for nonce, used := range d.counts {
+		if now.Sub(used.issued) > d.timeout {
+			delete(d.counts, nonce)
+		}
+	}
+	if len(d.counts) < digestNonceCounts {
+		return
+	}
+
+	issued := make([]time.Time, 0, len(d.counts))
+	for _, used := range d.counts {
+		issued = append(issued, used.issued)
+	}
+	slices.SortFunc(issued, time.Time.Compare)
+	d.forgotten = issued[len(issued)/2]
+	for nonce, used := range d.counts {
+		if !used.issued.After(d.forgotten) {
+			delete(d.counts, nonce)
+		}
+	}
+}
+
+// digestCredentials are the parameters sent by the client in the
+// Authorization header.
+type digestCredentials map[string]string
+
+// parseDigestCredentials parses the value of an "Authorization: Digest ..."
+// header. Values can either be quoted strings or tokens.
+func parseDigestCredentials(header string) (digestCredentials, bool) {
+	scheme, params, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Digest") {
+		return nil, false
+	}
+
+	creds := digestCredentials{}
+	for {
+		params = strings.TrimLeft(params, " \t,")
+		if params == "" {
+			break
+		}
+
+		var key string
+		key, params, ok = strings.Cut(params, "=")
+		if !ok {
+			return nil, false
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		var value string
+		if strings.HasPrefix(params, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(params) && params[i] != '"'; i++ {
+				if params[i] == '\\' && i+1 < len(params) {
+					i++
+				}
+				b.WriteByte(params[i])
+			}
+			if i == len(params) {
+				return nil, false
+			}
+			value = b.String()
+			params = params[i+1:]
+		} else {
+			value, params, _ = strings.Cut(params, ",")
+			value = strings.TrimSpace(value)
+		}
+
+		creds[key] = value
+	}
+
+	return creds, true
+}
+
+// verify checks the credentials against the given password. The method and
+// URI of the request are needed to compute the expected response.
+func (d *digestAuth) verify(creds digestCredentials, method, uri, password string) bool {
+	if creds["uri"] != uri || creds["realm"] != d.realm {
+		return false
+	}
+
+	if algorithm, ok := creds["algorithm"]; ok && !strings.EqualFold(algorithm, "MD5") {
+		return false
+	}
+
+	ha1 := md5Hex(creds["username"] + ":" + d.realm + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+
+	// Only qop=auth is offered, the responses of RFC 2069 without the nonce
+	// count are not accepted.
+	if creds["qop"] != "auth" || creds["nc"] == "" || creds["cnonce"] == "" {
+		return false
+	}
+	expected := md5Hex(ha1 + ":" + creds["nonce"] + ":" + creds["nc"] + ":" + creds["cnonce"] + ":auth:" + ha2)
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(creds["response"])) == 1
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,192 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func digestAuthorization(t *testing.T, challenge, username, password, method, uri string) string {
+	return digestAuthorizationCount(t, challenge, username, password, method, uri, "00000001")
+}
+
+func digestAuthorizationCount(t *testing.T, challenge, username, password, method, uri, nc string) string {
+	creds, ok := parseDigestCredentials(challenge)
+	require.True(t, ok)
+
+	ha1 := md5Hex(username + ":" + creds["realm"] + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+	response := md5Hex(ha1 + ":" + creds["nonce"] + ":" + nc + ":cnonce:auth:" + ha2)
+
+	return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", qop=auth, nc=%s, cnonce="cnonce", response="%s", opaque="%s"`,
+		username, creds["realm"], creds["nonce"], uri, nc, response, creds["opaque"])
+}
+
+func TestDigestAuth(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Auth:       true,
+		AuthMethod: AuthMethodDigest,
+		Digest:     Digest{NonceTimeout: time.Minute},
+		Users:      []User{{Username: "admin", Password: "admin"}},
+	}
+	h := newTestHandler(t, cfg)
+
+	t.Run("Round Trip", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		challenge := w.Header().Get("WWW-Authenticate")
+		require.Contains(t, challenge, `qop="auth"`)
+		require.NotContains(t, challenge, "stale")
+
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.Header.Set("Authorization", digestAuthorization(t, challenge, "admin", "admin", http.MethodGet, "/file.txt"))
+		w = doRequest(h, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "content", w.Body.String())
+	})
+
+	t.Run("Wrong Password", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+		challenge := w.Header().Get("WWW-Authenticate")
+
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.Header.Set("Authorization", digestAuthorization(t, challenge, "admin", "wrong", http.MethodGet, "/file.txt"))
+		w = doRequest(h, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.NotContains(t, w.Header().Get("WWW-Authenticate"), "stale")
+	})
+
+	t.Run("Wrong URI", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+		challenge := w.Header().Get("WWW-Authenticate")
+
+		r := httptest.NewRequest(http.MethodGet, "/dir/", nil)
+		r.Header.Set("Authorization", digestAuthorization(t, challenge, "admin", "admin", http.MethodGet, "/file.txt"))
+		w = doRequest(h, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+		challenge := w.Header().Get("WWW-Authenticate")
+
+		request := func(nc string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+			r.Header.Set("Authorization", digestAuthorizationCount(t, challenge, "admin", "admin", http.MethodGet, "/file.txt", nc))
+			return doRequest(h, r)
+		}
+		require.Equal(t, http.StatusOK, request("00000001").Code)
+		require.Equal(t, http.StatusOK, request("00000003").Code)
+
+		// The same or lower nonce counts are replays, and the client is told to
+		// use a new nonce.
+		for _, nc := range []string{"00000003", "00000002", "invalid"} {
+			w = request(nc)
+			require.Equal(t, http.StatusUnauthorized, w.Code, nc)
+			require.Contains(t, w.Header().Get("WWW-Authenticate"), "stale=true", nc)
+		}
+	})
+
+	t.Run("Without qop", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+		creds, ok := parseDigestCredentials(w.Header().Get("WWW-Authenticate"))
+		require.True(t, ok)
+
+		// The responses of RFC 2069 do not include a nonce count.
+		ha1 := md5Hex("admin:" + creds["realm"] + ":admin")
+		response := md5Hex(ha1 + ":" + creds["nonce"] + ":" + md5Hex("GET:/file.txt"))
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.Header.Set("Authorization", fmt.Sprintf(`Digest username="admin", realm="%s", nonce="%s", uri="/file.txt", response="%s", opaque="%s"`,
+			creds["realm"], creds["nonce"], response, creds["opaque"]))
+		require.Equal(t, http.StatusUnauthorized, doRequest(h, r).Code)
+	})
+
+	t.Run("Stale Nonce", func(t *testing.T) {
+		t.Parallel()
+
+		digest := h.(*Handler).handler.digest
+		challenge := fmt.Sprintf(`Digest realm="%s", nonce="%s", opaque="%s"`, digest.realm, digest.newNonce(time.Now().Add(-time.Hour)), digest.opaque)
+
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.Header.Set("Authorization", digestAuthorization(t, challenge, "admin", "admin", http.MethodGet, "/file.txt"))
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		challenge = w.Header().Get("WWW-Authenticate")
+		require.Contains(t, challenge, "stale=true")
+
+		// Retry with the fresh nonce, without changing the credentials.
+		r = httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.Header.Set("Authorization", digestAuthorization(t, challenge, "admin", "admin", http.MethodGet, "/file.txt"))
+		w = doRequest(h, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestDigestNonceCounts(t *testing.T) {
+	t.Parallel()
+
+	d, err := newDigestAuth("Restricted", time.Minute)
+	require.NoError(t, err)
+
+	now := time.Now()
+	nonces := make([]string, digestNonceCounts)
+	for i := range nonces {
+		nonces[i] = d.newNonce(now.Add(time.Duration(i-len(nonces)) * time.Millisecond))
+		require.True(t, d.useNonce(nonces[i], "1"))
+	}
+	require.Len(t, d.counts, digestNonceCounts)
+
+	// Once too many nonces are tracked, the oldest ones are forgotten, and
+	// no longer accepted, so that they cannot be replayed.
+	require.True(t, d.useNonce(d.newNonce(now), "1"))
+	require.Less(t, len(d.counts), digestNonceCounts)
+	require.False(t, d.useNonce(nonces[0], "2"))
+	require.True(t, d.useNonce(nonces[len(nonces)-1], "2"))
+	require.False(t, d.useNonce(nonces[len(nonces)-1], "2"))
+}
+
+func TestDigestConfigRejectsHashedPasswords(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Auth:        true,
+		AuthMethod:  AuthMethodDigest,
+		Digest:      Digest{NonceTimeout: time.Minute},
+		Permissions: Permissions{Scope: "/"},
+		Users:       []User{{Username: "admin", Password: "{bcrypt}$2y$10$zEP6oofmXFeHaeMfBNLnP"}},
+	}
+	require.ErrorContains(t, cfg.Validate(), "plaintext password")
+}
+
+func TestParseDigestCredentials(t *testing.T) {
+	t.Parallel()
+
+	creds, ok := parseDigestCredentials(`Digest username="Mufasa", realm="a \"quoted\", realm", nc=00000001, qop=auth`)
+	require.True(t, ok)
+	require.Equal(t, "Mufasa", creds["username"])
+	require.Equal(t, `a "quoted", realm`, creds["realm"])
+	require.Equal(t, "00000001", creds["nc"])
+	require.Equal(t, "auth", creds["qop"])
+
+	_, ok = parseDigestCredentials(`Basic YWRtaW46YWRtaW4=`)
+	require.False(t, ok)
+
+	_, ok = parseDigestCredentials(`Digest username="unterminated`)
+	require.False(t, ok)
+}
@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	DispositionInline     = "inline"
+	DispositionAttachment = "attachment"
+)
+
+// setDisposition sets the Content-Disposition header of the response to a GET
+// request for a file. The "download" query parameter makes it an attachment, or
+// inline if false, which takes precedence over the configured disposition. If
+// neither is given, the header is not set. If the query is invalid, the error is
+// written and false is returned.
+func setDisposition(w http.ResponseWriter, r *http.Request, filename, disposition string) bool {
+	if values, ok := r.URL.Query()["download"]; ok {
+		download := true
+		if len(values) > 0 && values[0] != "" {
+			var err error
+			if download, err = strconv.ParseBool(values[0]); err != nil {
+				http.Error(w, "Invalid download query", http.StatusBadRequest)
+				return false
+			}
+		}
+
+		disposition = DispositionInline
+		if download {
+			disposition = DispositionAttachment
+		}
+	}
+
+	if disposition != "" {
+		w.Header().Set("Content-Disposition", contentDisposition(disposition, filename))
+	}
+	return true
+}
+
+// contentDisposition returns the value of a Content-Disposition header with
+// the filename. The names that are not plain ASCII are given as an extended
+// parameter, as of RFC 6266, encoded as of RFC 5987, after an ASCII fallback
+// for the clients that do not support it.
+func contentDisposition(disposition, filename string) string {
+	fallback, plain := asciiFilename(filename)
+	value := disposition + `; filename="` + fallback + `"`
+	if !plain {
+		value += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+	return value
+}
+
+// asciiFilename returns the filename as a quoted string, with the characters
+// that cannot be in one replaced by underscores, and whether there were none.
+func asciiFilename(filename string) (string, bool) {
+	var b strings.Builder
+	plain := true
+	for _, c := range filename {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c < 0x20 || c >= 0x7f:
+			b.WriteByte('_')
+			plain = false
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String(), plain
+}
+
+// encodeRFC5987 percent-encodes the bytes of the value that are not attribute
+// characters, as of RFC 5987, section 3.2.1.
+func encodeRFC5987(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func isAttrChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
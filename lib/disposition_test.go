@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerDisposition(t *testing.T) {
+	t.Parallel()
+
+	get := func(h http.Handler, target string) *httptest.ResponseRecorder {
+		return doRequest(h, httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	t.Run("Query", func(t *testing.T) {
+		t.Parallel()
+
+		h := newTestHandler(t, &Config{})
+
+		w := get(h, "/file.txt")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Empty(t, w.Header().Get("Content-Disposition"))
+
+		w = get(h, "/file.txt?download")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, `attachment; filename="file.txt"`, w.Header().Get("Content-Disposition"))
+		require.Equal(t, "content", w.Body.String())
+
+		w = get(h, "/file.txt?download=false")
+		require.Equal(t, `inline; filename="file.txt"`, w.Header().Get("Content-Disposition"))
+
+		require.Equal(t, http.StatusBadRequest, get(h, "/file.txt?download=maybe").Code)
+
+		// The collections have no disposition.
+		w = get(h, "/dir/?download")
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.Empty(t, w.Header().Get("Content-Disposition"))
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{Disposition: DispositionAttachment}
+		h := newTestHandler(t, cfg)
+		require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "résumé \"1\".pdf"), []byte("pdf"), 0666))
+
+		w := get(h, "/r%C3%A9sum%C3%A9%20%221%22.pdf")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, `attachment; filename="r_sum_ \"1\".pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9%20%221%22.pdf`, w.Header().Get("Content-Disposition"))
+
+		w = get(h, "/file.txt?download=0")
+		require.Equal(t, `inline; filename="file.txt"`, w.Header().Get("Content-Disposition"))
+	})
+}
+
+func TestConfigDisposition(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{Disposition: "download"}
+	require.ErrorContains(t, cfg.Validate(), "unknown content disposition")
+}
@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	// emptyDirTTL is how long it is cached whether the directories are empty,
+	// so that the listings do not walk the same trees again and again. The
+	// changes made through the server are seen right away.
+	emptyDirTTL = 10 * time.Second
+
+	// emptyDirCacheSize is the maximum number of directories in the cache.
+	emptyDirCacheSize = 10000
+)
+
+// emptyDirs caches whether the directories are empty, for hiding them from the
+// listings.
+type emptyDirs struct {
+	mu      sync.Mutex
+	entries map[string]emptyDir
+}
+
+type emptyDir struct {
+	empty   bool
+	expires time.Time
+}
+
+func newEmptyDirs() *emptyDirs {
+	return &emptyDirs{entries: map[string]emptyDir{}}
+}
+
+func (c *emptyDirs) get(name string, now time.Time) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[path.Clean("/"+name)]
+	if !ok || !now.Before(entry.expires) {
+		return false, false
+	}
+	return entry.empty, true
+}
+
+func (c *emptyDirs) set(name string, empty bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The entries expire quickly, so starting over is enough to bound the
+	// memory they use.
+	if len(c.entries) >= emptyDirCacheSize {
+		c.entries = map[string]emptyDir{}
+	}
+	c.entries[path.Clean("/"+name)] = emptyDir{empty: empty, expires: now.Add(emptyDirTTL)}
+}
+
+// invalidate forgets the directories holding the given path, whose emptiness
+// may have changed along with it.
+func (c *emptyDirs) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for dir := path.Clean("/" + name); ; dir = path.Dir(dir) {
+		delete(c.entries, dir)
+		if dir == "/" {
+			return
+		}
+	}
+}
+
+// isEmpty reports whether the directory has no members that the clients can
+// see, other than empty directories. The files are looked for first, so that
+// the directories are only walked into when there are none, and the walk stops
+// at the first one found.
+func (d Dir) isEmpty(ctx context.Context, name string) bool {
+	now := time.Now()
+	if empty, ok := d.emptyDirs.get(name, now); ok {
+		return empty
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+
+	f, err := d.FileSystem.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return false
+	}
+	infos, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return false
+	}
+
+	empty := true
+	var dirs []string
+	for _, info := range infos {
+		member := path.Join(name, info.Name())
+		switch {
+		case d.hides(member):
+		case !info.IsDir():
+			empty = false
+		default:
+			dirs = append(dirs, member)
+		}
+		if !empty {
+			break
+		}
+	}
+	for _, dir := range dirs {
+		if !empty {
+			break
+		}
+		empty = d.isEmpty(ctx, dir)
+	}
+
+	// The walk may have been cut short, in which case nothing is known.
+	if ctx.Err() != nil {
+		return false
+	}
+	d.emptyDirs.set(name, empty, now)
+	return empty
+}
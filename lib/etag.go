@@ -0,0 +1,206 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	ETagModTime = "mtime"
+	ETagContent = "content"
+)
+
+// ETagFunc computes the ETag of a file or a collection, which must be quoted,
+// such as `"abc"` or `W/"abc"`, to match an existing deployment for example.
+type ETagFunc func(info os.FileInfo) (string, error)
+
+// contentETag returns a strong ETag computed from the hash of the contents of
+// the file. This requires reading the whole file.
+func contentETag(ctx context.Context, fs webdav.FileSystem, name string) (string, error) {
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return `"` + hex.EncodeToString(hash.Sum(nil)) + `"`, nil
+}
+
+// collectionETag returns a weak ETag of a collection, derived from the latest
+// modification time of the collection and its members, and from the number of
+// members, so that it changes when a member is added, removed or modified.
+func collectionETag(ctx context.Context, fs webdav.FileSystem, name string, info os.FileInfo) (string, error) {
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	members, err := f.Readdir(-1)
+	if err != nil {
+		return "", err
+	}
+
+	latest := info.ModTime()
+	for _, member := range members {
+		if member.ModTime().After(latest) {
+			latest = member.ModTime()
+		}
+	}
+
+	return fmt.Sprintf(`W/"%x-%x"`, latest.UnixNano(), len(members)), nil
+}
+
+// fileETag returns the ETag of the file, the same way [webdav.Handler] does.
+func fileETag(ctx context.Context, info os.FileInfo) (string, error) {
+	if etager, ok := info.(webdav.ETager); ok {
+		etag, err := etager.ETag(ctx)
+		if err != webdav.ErrNotImplemented {
+			return etag, err
+		}
+	}
+
+	return fmt.Sprintf(`"%x%x"`, info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// matchETag reports whether the ETag matches any of the ETags in the value of
+// an If-Match or If-None-Match header. With weak comparison, weak ETags match
+// the strong ETags with the same value.
+func matchETag(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if candidate == etag && !strings.HasPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkConditions evaluates the conditional headers of the request. File
+// downloads are left to [webdav.Handler], which already honors them. If the
+// request must not be served, the response is written and false is returned.
+func (u *handlerUser) checkConditions(w http.ResponseWriter, r *http.Request, name string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return u.checkCollectionConditions(w, r, name)
+	case http.MethodPut, http.MethodPatch:
+		return u.checkPutConditions(w, r, name)
+	default:
+		return true
+	}
+}
+
+func (u *handlerUser) checkCollectionConditions(w http.ResponseWriter, r *http.Request, name string) bool {
+	info, err := u.FileSystem.Stat(r.Context(), name)
+	if err != nil || !info.IsDir() {
+		return true
+	}
+
+	etag, err := fileETag(r.Context(), info)
+	if err != nil {
+		return true
+	}
+
+	w.Header().Set("ETag", etag)
+	if !info.ModTime().IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	}
+
+	notModified := false
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		notModified = matchETag(header, etag, true)
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !info.ModTime().IsZero() {
+		notModified = !info.ModTime().Truncate(time.Second).After(since)
+	}
+
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	}
+
+	return true
+}
+
+// checkPropfindConditions answers the PROPFIND requests on the collections
+// with 304 Not Modified, if their ETag matches the If-None-Match header, so that
+// the clients can cheaply find out whether a listing changed. As the ETag only
+// covers the members of the collection, the requests of infinite depth are
+// always served.
+func (u *handlerUser) checkPropfindConditions(w http.ResponseWriter, r *http.Request, name string) bool {
+	header := r.Header.Get("If-None-Match")
+	if depth := r.Header.Get("Depth"); header == "" || (depth != "0" && depth != "1") {
+		return true
+	}
+
+	info, err := u.FileSystem.Stat(r.Context(), name)
+	if err != nil || !info.IsDir() {
+		return true
+	}
+
+	etag, err := fileETag(r.Context(), info)
+	if err != nil || !matchETag(header, etag, true) {
+		return true
+	}
+
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNotModified)
+	return false
+}
+
+func (u *handlerUser) checkPutConditions(w http.ResponseWriter, r *http.Request, name string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return true
+	}
+
+	etag := ""
+	if info, err := u.FileSystem.Stat(r.Context(), name); err == nil {
+		etag, err = fileETag(r.Context(), info)
+		if err != nil {
+			requestLogger(r, u.logger).Error("failed to compute etag", zap.String("path", name), zap.Error(err))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return false
+		}
+	}
+
+	// If the resource does not exist, If-Match never matches, and If-None-Match
+	// always does.
+	failed := false
+	if ifMatch != "" {
+		failed = etag == "" || !matchETag(ifMatch, etag, false)
+	}
+	if ifNoneMatch != "" && etag != "" && matchETag(ifNoneMatch, etag, true) {
+		failed = true
+	}
+
+	if failed {
+		http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+		return false
+	}
+
+	return true
+}
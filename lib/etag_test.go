@@ -0,0 +1,235 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchETag(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, matchETag(`"a"`, `"a"`, false))
+	require.True(t, matchETag(`"b", "a"`, `"a"`, false))
+	require.True(t, matchETag(`*`, `"a"`, false))
+	require.False(t, matchETag(`"b"`, `"a"`, false))
+	require.False(t, matchETag(`W/"a"`, `"a"`, false))
+	require.True(t, matchETag(`W/"a"`, `"a"`, true))
+	require.True(t, matchETag(`"a"`, `W/"a"`, true))
+}
+
+func TestConfigETag(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{ETag: "random"}
+	require.ErrorContains(t, cfg.Validate(), "unknown etag strategy")
+}
+
+func TestHandlerConditionalCollection(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Permissions: Permissions{Modify: true},
+	})
+
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/dir/", nil))
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	lastModified := w.Header().Get("Last-Modified")
+	require.NotEmpty(t, lastModified)
+
+	r := httptest.NewRequest(http.MethodGet, "/dir/", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = doRequest(h, r)
+	require.Equal(t, http.StatusNotModified, w.Code)
+	require.Equal(t, etag, w.Header().Get("ETag"))
+	require.Empty(t, w.Body.String())
+
+	r = httptest.NewRequest(http.MethodGet, "/dir/", nil)
+	r.Header.Set("If-Modified-Since", lastModified)
+	require.Equal(t, http.StatusNotModified, doRequest(h, r).Code)
+
+	r = httptest.NewRequest(http.MethodGet, "/dir/", nil)
+	r.Header.Set("If-Modified-Since", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	require.Equal(t, http.StatusMultiStatus, doRequest(h, r).Code)
+
+	// Adding an entry modifies the collection.
+	time.Sleep(10 * time.Millisecond)
+	w = doRequest(h, httptest.NewRequest(http.MethodPut, "/dir/new.txt", strings.NewReader("new")))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	r = httptest.NewRequest(http.MethodGet, "/dir/", nil)
+	r.Header.Set("If-None-Match", etag)
+	require.Equal(t, http.StatusMultiStatus, doRequest(h, r).Code)
+}
+
+func TestHandlerCollectionETags(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Permissions:     Permissions{Modify: true},
+		CollectionETags: true,
+	})
+	scope := h.(*Handler).handler.user.Scope
+	require.NoError(t, os.WriteFile(filepath.Join(scope, "dir", "child.txt"), []byte("child"), 0666))
+
+	propfind := func(etag, depth string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PROPFIND", "/dir/", nil)
+		r.Header.Set("Depth", depth)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		return doRequest(h, r)
+	}
+
+	w := doRequest(h, httptest.NewRequest(http.MethodHead, "/dir/", nil))
+	etag := w.Header().Get("ETag")
+	require.True(t, strings.HasPrefix(etag, `W/"`), etag)
+
+	// The ETag is reported as a property of the collection.
+	w = propfind("", "0")
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	require.Contains(t, w.Body.String(), "<D:getetag>"+html.EscapeString(etag)+"</D:getetag>")
+
+	w = propfind(etag, "1")
+	require.Equal(t, http.StatusNotModified, w.Code)
+	require.Equal(t, etag, w.Header().Get("ETag"))
+	require.Empty(t, w.Body.String())
+
+	// The ETag does not cover the whole tree.
+	require.Equal(t, http.StatusMultiStatus, propfind(etag, "infinity").Code)
+
+	// Modifying a member, which does not modify the collection itself, changes
+	// the ETag.
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(scope, "dir", "child.txt"), later, later))
+	require.Equal(t, http.StatusMultiStatus, propfind(etag, "1").Code)
+	etag = doRequest(h, httptest.NewRequest(http.MethodHead, "/dir/", nil)).Header().Get("ETag")
+	require.Equal(t, http.StatusNotModified, propfind(etag, "1").Code)
+
+	// So does adding a member.
+	w = doRequest(h, httptest.NewRequest(http.MethodPut, "/dir/new.txt", strings.NewReader("new")))
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, http.StatusMultiStatus, propfind(etag, "1").Code)
+}
+
+func TestHandlerConditionalFile(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{})
+
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+
+	r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	r.Header.Set("If-None-Match", etag)
+	require.Equal(t, http.StatusNotModified, doRequest(h, r).Code)
+
+	r = httptest.NewRequest(http.MethodHead, "/file.txt", nil)
+	r.Header.Set("If-Modified-Since", w.Header().Get("Last-Modified"))
+	require.Equal(t, http.StatusNotModified, doRequest(h, r).Code)
+}
+
+func TestHandlerConditionalPut(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Permissions: Permissions{Modify: true},
+	})
+
+	put := func(name, header, value string) int {
+		r := httptest.NewRequest(http.MethodPut, name, strings.NewReader("new content"))
+		r.Header.Set(header, value)
+		return doRequest(h, r).Code
+	}
+
+	etag := doRequest(h, httptest.NewRequest(http.MethodHead, "/file.txt", nil)).Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	require.Equal(t, http.StatusPreconditionFailed, put("/file.txt", "If-Match", `"outdated"`))
+	require.Equal(t, http.StatusPreconditionFailed, put("/file.txt", "If-Match", "W/"+etag))
+	require.Equal(t, http.StatusPreconditionFailed, put("/file.txt", "If-None-Match", "*"))
+	require.Equal(t, http.StatusPreconditionFailed, put("/missing.txt", "If-Match", "*"))
+
+	// The file was not modified by the rejected requests.
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+	require.Equal(t, "content", w.Body.String())
+
+	require.Equal(t, http.StatusCreated, put("/file.txt", "If-Match", etag))
+	require.Equal(t, http.StatusCreated, put("/new.txt", "If-None-Match", "*"))
+
+	// The ETag changed, so the previous one no longer matches.
+	require.Equal(t, http.StatusPreconditionFailed, put("/file.txt", "If-Match", etag))
+}
+
+func TestHandlerContentETag(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Permissions: Permissions{Modify: true},
+		ETag:        ETagContent,
+	})
+
+	sum := sha256.Sum256([]byte("content"))
+	expected := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, expected, w.Header().Get("ETag"))
+
+	r := httptest.NewRequest("PROPFIND", "/file.txt", nil)
+	r.Header.Set("Depth", "0")
+	require.Contains(t, doRequest(h, r).Body.String(), "<D:getetag>"+expected+"</D:getetag>")
+
+	// Writing the same contents again keeps the same ETag.
+	r = httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("content"))
+	r.Header.Set("If-Match", expected)
+	w = doRequest(h, r)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, expected, w.Header().Get("ETag"))
+}
+
+func TestHandlerETagFunc(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Permissions: Permissions{Modify: true},
+		ETagFunc: func(info os.FileInfo) (string, error) {
+			return fmt.Sprintf(`W/"legacy-%s-%d"`, info.Name(), info.Size()), nil
+		},
+	}
+	h := newTestHandler(t, cfg)
+
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `W/"legacy-file.txt-7"`, w.Header().Get("ETag"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "dir", "a.txt"), []byte("abc"), 0666))
+	r := httptest.NewRequest("PROPFIND", "/dir/", nil)
+	r.Header.Set("Depth", "1")
+	require.Contains(t, doRequest(h, r).Body.String(), `<D:getetag>W/"legacy-a.txt-3"</D:getetag>`)
+
+	// The collections get them too.
+	w = doRequest(h, httptest.NewRequest(http.MethodGet, "/dir/", nil))
+	require.Contains(t, w.Header().Get("ETag"), `W/"legacy-dir-`)
+
+	// And the conditions are evaluated against them.
+	r = httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("new content"))
+	r.Header.Set("If-Match", `"legacy-file.txt-7"`)
+	require.Equal(t, http.StatusPreconditionFailed, doRequest(h, r).Code)
+	r = httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	r.Header.Set("If-None-Match", `W/"legacy-file.txt-7"`)
+	require.Equal(t, http.StatusNotModified, doRequest(h, r).Code)
+}
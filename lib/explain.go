@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// explainHeader is the header that requests an explanation of the permission
+// decision, instead of performing the request.
+const explainHeader = "X-WebDAV-Explain"
+
+type explanation struct {
+	Username    string               `json:"username"`
+	Method      string               `json:"method"`
+	Path        string               `json:"path"`
+	Destination string               `json:"destination,omitempty"`
+	Allowed     bool                 `json:"allowed"`
+	Read        bool                 `json:"read"`
+	ReadOnly    bool                 `json:"read_only"`
+	Disabled    bool                 `json:"disabled"`
+	Blocked     bool                 `json:"blocked,omitempty"`
+	Rule        *explainedRule       `json:"rule"`
+	Permissions explainedPermissions `json:"permissions"`
+}
+
+type explainedRule struct {
+	Index  int    `json:"index"`
+	Path   string `json:"path,omitempty"`
+	Regex  string `json:"regex,omitempty"`
+	Allow  bool   `json:"allow"`
+	Modify bool   `json:"modify"`
+}
+
+type explainedPermissions struct {
+	Scope  string `json:"scope"`
+	Modify bool   `json:"modify"`
+	Rules  int    `json:"rules"`
+}
+
+// serveExplanation replies with the permission decision for the request of the
+// user as JSON, including which rule matched, if any.
+func (h *handler) serveExplanation(w http.ResponseWriter, r *http.Request, user *handlerUser) {
+	d := user.decide(r.Method, r.URL.Path)
+	dst, _ := destinationPath(r)
+
+	e := explanation{
+		Username:    user.Username,
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		Destination: dst,
+		Allowed:     d.allowed && user.allowedDestination(r) && (d.read || !h.readOnly) && !h.disabledMethods[r.Method],
+		Read:        d.read,
+		ReadOnly:    h.readOnly,
+		Disabled:    h.disabledMethods[r.Method],
+		Blocked:     d.blocked,
+		Permissions: explainedPermissions{
+			Scope:  user.Scope,
+			Modify: user.Modify,
+			Rules:  len(user.Rules),
+		},
+	}
+
+	if d.rule >= 0 {
+		rule := user.Rules[d.rule]
+		e.Rule = &explainedRule{
+			Index:  d.rule,
+			Path:   rule.Path,
+			Allow:  rule.Allow,
+			Modify: rule.Modify,
+		}
+		if rule.Regexp != nil {
+			e.Rule.Regex = rule.Regexp.String()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(e)
+}
@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T, enabled, readOnly bool) (http.Handler, string) {
+		cfg := &Config{
+			Auth:     true,
+			Explain:  enabled,
+			ReadOnly: readOnly,
+			Users: []User{
+				{Username: "alice", Password: "alice", Permissions: Permissions{
+					Modify: true,
+					Rules: []*Rule{
+						{Path: "/public/", Allow: true, Modify: true},
+						{Path: "/dir", Allow: true, Modify: false},
+					},
+				}},
+			},
+		}
+		h := newTestHandler(t, cfg)
+		return h, cfg.Scope
+	}
+
+	explain := func(t *testing.T, h http.Handler, method, path string) explanation {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set(explainHeader, "1")
+		r.SetBasicAuth("alice", "alice")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var e explanation
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+		return e
+	}
+
+	t.Run("Allowed", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, true, false)
+		e := explain(t, h, http.MethodPut, "/file.txt")
+		require.Equal(t, explanation{
+			Username:    "alice",
+			Method:      http.MethodPut,
+			Path:        "/file.txt",
+			Allowed:     true,
+			Permissions: explainedPermissions{Scope: scope, Modify: true, Rules: 2},
+		}, e)
+
+		// The request was not performed.
+		data, err := os.ReadFile(filepath.Join(scope, "file.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "content", string(data))
+	})
+
+	t.Run("Denied", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, true, false)
+		e := explain(t, h, http.MethodDelete, "/dir/file.txt")
+		require.False(t, e.Allowed)
+		require.False(t, e.Read)
+		require.Equal(t, &explainedRule{Index: 1, Path: "/dir", Allow: true, Modify: false}, e.Rule)
+
+		require.DirExists(t, filepath.Join(scope, "dir"))
+
+		e = explain(t, h, http.MethodGet, "/dir/file.txt")
+		require.True(t, e.Allowed)
+		require.True(t, e.Read)
+		require.Equal(t, 1, e.Rule.Index)
+	})
+
+	t.Run("Read Only", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t, true, true)
+		e := explain(t, h, http.MethodPut, "/public/file.txt")
+		require.False(t, e.Allowed)
+		require.True(t, e.ReadOnly)
+		require.Equal(t, 0, e.Rule.Index)
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t, true, false)
+		r := httptest.NewRequest(http.MethodPut, "/file.txt", nil)
+		r.Header.Set(explainHeader, "1")
+		require.Equal(t, http.StatusUnauthorized, doRequest(h, r).Code)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t, false, false)
+		r := httptest.NewRequest(http.MethodDelete, "/dir/file.txt", nil)
+		r.Header.Set(explainHeader, "1")
+		r.SetBasicAuth("alice", "alice")
+		require.Equal(t, http.StatusForbidden, doRequest(h, r).Code)
+	})
+}
@@ -2,52 +2,350 @@ package lib
 
 import (
 	"context"
+	"io"
 	"mime"
+	"net/http"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
 	"golang.org/x/net/webdav"
 )
 
+const (
+	BackendDisk   = "disk"
+	BackendMemory = "memory"
+	BackendS3     = "s3"
+)
+
+// fileSystems creates the file systems for the scopes, according to the
+// configured backend. The memory file systems are kept so that users with
+// the same scope share the same contents.
+type fileSystems struct {
+	backend      string
+	noSniff      bool
+	contentTypes map[string]string
+	strictScope  bool
+	lowerScope   string
+	chown        bool
+	hidden       hiddenFiles
+	etag         string
+	etagFunc     ETagFunc
+	mounts       []Mount
+	memory       map[string]webdav.FileSystem
+	s3           *s3Client
+	checksums    map[string]*checksumCache
+	versioning   Versioning
+	deadProps    *deadPropsStore
+	caseListings map[string]*caseListings
+	dirETags     bool
+	emptyDirs    map[string]*emptyDirs
+	virtualFile  *virtualFile
+}
+
+func newFileSystems(c *Config) *fileSystems {
+	f := &fileSystems{
+		backend:      c.Backend,
+		noSniff:      c.NoSniff,
+		contentTypes: c.ContentTypes,
+		strictScope:  c.StrictScope,
+		lowerScope:   c.LowerScope,
+		chown:        c.Chown,
+		hidden:       hiddenFiles{dotfiles: c.HideDotfiles, patterns: c.HiddenFiles},
+		etag:         c.ETag,
+		etagFunc:     c.ETagFunc,
+		mounts:       c.Mounts,
+		memory:       map[string]webdav.FileSystem{},
+		s3:           newS3Client(c.S3),
+		versioning:   c.Versioning,
+		dirETags:     c.CollectionETags,
+		virtualFile:  newVirtualFile(c.VirtualFile),
+	}
+	if c.Checksums {
+		f.checksums = map[string]*checksumCache{}
+	}
+	if c.DeadProperties {
+		f.deadProps = &deadPropsStore{}
+	}
+	if c.CaseInsensitive {
+		f.caseListings = map[string]*caseListings{}
+	}
+	if c.HideEmptyDirs {
+		f.emptyDirs = map[string]*emptyDirs{}
+	}
+	return f
+}
+
+// user returns the file system of a user with the given scope, overlaid on
+// the lower scope if any, with the mounts on top of it, and the virtual file
+// in all of its collections.
+func (f *fileSystems) user(scope string) webdav.FileSystem {
+	fs := f.get(scope, true)
+	if len(f.mounts) > 0 {
+		mounts := make([]mountPoint, 0, len(f.mounts))
+		for _, mount := range f.mounts {
+			mounts = append(mounts, mountPoint{path: mount.Path, fs: f.get(mount.Scope, false)})
+		}
+		fs = newMountFS(fs, mounts)
+	}
+
+	if f.virtualFile != nil {
+		fs = virtualFS{FileSystem: fs, file: f.virtualFile}
+	}
+	return fs
+}
+
+// get returns the file system of the scope, with the configured features, and
+// overlaid on the lower scope if asked and configured.
+func (f *fileSystems) get(scope string, overlay bool) webdav.FileSystem {
+	fs := f.base(scope)
+	if overlay && f.lowerScope != "" && scope != f.lowerScope {
+		fs = overlayFS{lower: f.base(f.lowerScope), upper: fs}
+	}
+
+	// The checksums are cached by scope, as the names are relative to it.
+	var checksums *checksumCache
+	if f.checksums != nil {
+		checksums = f.checksums[scope]
+		if checksums == nil {
+			checksums = newChecksumCache()
+			f.checksums[scope] = checksums
+		}
+	}
+
+	var listings *caseListings
+	if f.caseListings != nil {
+		listings = f.caseListings[scope]
+		if listings == nil {
+			listings = newCaseListings()
+			f.caseListings[scope] = listings
+		}
+	}
+
+	var empty *emptyDirs
+	if f.emptyDirs != nil {
+		empty = f.emptyDirs[scope]
+		if empty == nil {
+			empty = newEmptyDirs()
+			f.emptyDirs[scope] = empty
+		}
+	}
+
+	return Dir{
+		FileSystem:   fs,
+		noSniff:      f.noSniff,
+		contentTypes: f.contentTypes,
+		etag:         f.etag,
+		etagFunc:     f.etagFunc,
+		hidden:       f.hidden,
+		checksums:    checksums,
+		versioning:   f.versioning,
+		deadProps:    f.deadProps,
+		caseListings: listings,
+		dirETags:     f.dirETags,
+		emptyDirs:    empty,
+	}
+}
+
+// base returns the file system of the backend for the scope.
+func (f *fileSystems) base(scope string) webdav.FileSystem {
+	var fs webdav.FileSystem
+	switch f.backend {
+	case BackendMemory:
+		fs = f.memory[scope]
+		if fs == nil {
+			fs = webdav.NewMemFS()
+			f.memory[scope] = fs
+		}
+	case BackendS3:
+		fs = newS3FS(f.s3, scope)
+	default:
+		if f.strictScope {
+			fs = newContainedDir(scope)
+		} else {
+			fs = webdav.Dir(scope)
+		}
+		if f.chown {
+			fs = ownedDir{FileSystem: fs, scope: scope}
+		}
+	}
+	return fs
+}
+
+// physical returns the directory a scope is stored in, so that the scopes that
+// point to the same directory can be told apart from the others.
+func (f *fileSystems) physical(scope string) string {
+	if f.backend == BackendMemory || f.backend == BackendS3 {
+		return scope
+	}
+
+	if abs, err := filepath.Abs(scope); err == nil {
+		scope = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(scope); err == nil {
+		scope = resolved
+	}
+	return scope
+}
+
+// Dir is a [webdav.FileSystem] that wraps another file system, adding the
+// configured features on top of it.
 type Dir struct {
-	webdav.Dir
-	noSniff bool
+	webdav.FileSystem
+	noSniff      bool
+	contentTypes map[string]string
+	etag         string
+	etagFunc     ETagFunc
+	hidden       hiddenFiles
+	checksums    *checksumCache
+	versioning   Versioning
+	deadProps    *deadPropsStore
+	caseListings *caseListings
+	dirETags     bool
+	emptyDirs    *emptyDirs
+}
+
+// wrapsFiles reports whether the files need to be wrapped, which is only the
+// case if any of the features is enabled.
+func (d Dir) wrapsFiles() bool {
+	return d.noSniff || len(d.contentTypes) > 0 || d.etag == ETagContent || d.etagFunc != nil || d.hidden.enabled() || d.checksums != nil || d.deadProps != nil || d.dirETags || d.emptyDirs != nil || d.uploadsTemporary()
+}
+
+// uploadsTemporary reports whether the uploads are written to temporary files,
+// which are hidden from the clients.
+func (d Dir) uploadsTemporary() bool {
+	return !atomicUploads(d.FileSystem)
+}
+
+// hides reports whether the path is hidden from the clients, which includes the
+// files the dead properties are stored in, and the temporary upload files.
+func (d Dir) hides(name string) bool {
+	return d.hidden.match(name) || (d.deadProps != nil && path.Base(name) == deadPropsFile) || (d.uploadsTemporary() && isUploadName(name))
+}
+
+func (d Dir) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name = d.resolve(ctx, name)
+	if d.hides(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrNotExist}
+	}
+	defer d.invalidateListing(name)
+	return d.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (d Dir) RemoveAll(ctx context.Context, name string) error {
+	name = d.resolve(ctx, name)
+	if d.hides(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	defer d.invalidateListing(name)
+	if err := d.FileSystem.RemoveAll(ctx, name); err != nil || d.deadProps == nil {
+		return err
+	}
+	return d.deadProps.remove(ctx, d.FileSystem, name)
+}
+
+func (d Dir) Rename(ctx context.Context, oldName, newName string) error {
+	// The new name is kept as is, so that the case of a file can be changed.
+	oldName, newName = d.resolve(ctx, oldName), d.resolveParent(ctx, newName)
+	if d.hides(oldName) || d.hides(newName) {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrNotExist}
+	}
+	defer d.invalidateListing(oldName)
+	defer d.invalidateListing(newName)
+	if err := d.FileSystem.Rename(ctx, oldName, newName); err != nil || d.deadProps == nil {
+		return err
+	}
+	return d.deadProps.move(ctx, d.FileSystem, oldName, newName)
 }
 
 func (d Dir) Stat(ctx context.Context, name string) (os.FileInfo, error) {
-	// Skip wrapping if NoSniff is off
-	if !d.noSniff {
-		return d.Dir.Stat(ctx, name)
+	name = d.resolve(ctx, name)
+	if d.hides(name) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
 	}
 
-	info, err := d.Dir.Stat(ctx, name)
+	if !d.wrapsFiles() {
+		return d.FileSystem.Stat(ctx, name)
+	}
+
+	info, err := d.FileSystem.Stat(ctx, name)
 	if err != nil {
 		return nil, err
 	}
 
-	return noSniffFileInfo{info}, nil
+	return fileInfo{FileInfo: info, dir: d, name: name}, nil
 }
 
 func (d Dir) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
-	// Skip wrapping if NoSniff is off
-	if !d.noSniff {
-		return d.Dir.OpenFile(ctx, name, flag, perm)
+	name = d.resolve(ctx, name)
+	if d.hides(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if flag&os.O_CREATE != 0 {
+		defer d.invalidateListing(name)
 	}
 
-	file, err := d.Dir.OpenFile(ctx, name, flag, perm)
+	// The previous version of the file is kept once the upload is complete, right
+	// before it replaces the file, so that the failed uploads make none.
+	versioning := d.versioning
+	if inVersions(name) {
+		versioning = Versioning{}
+	}
+
+	open := d.FileSystem.OpenFile
+	if isUpload(flag) && d.uploadsTemporary() {
+		open = func(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+			return openUpload(ctx, d.FileSystem, name, flag, perm, uploadCheckFrom(ctx), versioning)
+		}
+	} else if check := uploadCheckFrom(ctx); isUpload(flag) && (check != nil || versioning.Enabled) {
+		open = func(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+			file, err := d.FileSystem.OpenFile(ctx, name, flag, perm)
+			if err != nil {
+				return nil, err
+			}
+			if versioning.Enabled {
+				file = &versionedUpload{File: file, ctx: ctx, fs: d.FileSystem, name: name, versioning: versioning}
+			}
+			if check != nil {
+				file = &checkedUpload{File: file, ctx: ctx, fs: d.FileSystem, name: name, check: check}
+			}
+			return file, nil
+		}
+	}
+
+	if !d.wrapsFiles() {
+		return open(ctx, name, flag, perm)
+	}
+
+	file, err := open(ctx, name, flag, perm)
 	if err != nil {
 		return nil, err
 	}
 
-	return noSniffFile{File: file}, nil
+	return dirFile{File: file, ctx: ctx, dir: d, name: name, created: flag&os.O_CREATE != 0}, nil
 }
 
-type noSniffFileInfo struct {
+// fileInfo implements the optional [webdav.ContentTyper] and [webdav.ETager]
+// interfaces, according to the features enabled in the [Dir].
+type fileInfo struct {
 	os.FileInfo
+	dir  Dir
+	name string
 }
 
-func (w noSniffFileInfo) ContentType(ctx context.Context) (contentType string, err error) {
-	if mimeType := mime.TypeByExtension(path.Ext(w.FileInfo.Name())); mimeType != "" {
+func (fi fileInfo) ContentType(ctx context.Context) (contentType string, err error) {
+	// The configured content types take precedence over the built-in ones.
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(fi.FileInfo.Name()), "."))
+	if mimeType, ok := fi.dir.contentTypes[ext]; ok {
+		return mimeType, nil
+	}
+
+	if !fi.dir.noSniff {
+		return "", webdav.ErrNotImplemented
+	}
+
+	if mimeType := mime.TypeByExtension(path.Ext(fi.FileInfo.Name())); mimeType != "" {
 		// We can figure out the mime from the extension.
 		return mimeType, nil
 	} else {
@@ -56,27 +354,133 @@ func (w noSniffFileInfo) ContentType(ctx context.Context) (contentType string, e
 	}
 }
 
-type noSniffFile struct {
+func (fi fileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.IsDir() && fi.dir.dirETags {
+		return collectionETag(ctx, fi.dir, fi.name, fi.FileInfo)
+	}
+
+	if fi.dir.etagFunc != nil {
+		return fi.dir.etagFunc(fi.FileInfo)
+	}
+
+	if fi.dir.etag != ETagContent || fi.IsDir() {
+		// Keep the ETags of the file system, if it computes them.
+		if etager, ok := fi.FileInfo.(webdav.ETager); ok {
+			return etager.ETag(ctx)
+		}
+		return "", webdav.ErrNotImplemented
+	}
+
+	return contentETag(ctx, fi.dir.FileSystem, fi.name)
+}
+
+// setContentType sets the Content-Type header of the response to the content
+// type of the file, if the file system determines it.
+func setContentType(ctx context.Context, w http.ResponseWriter, info os.FileInfo) {
+	ctyper, ok := info.(webdav.ContentTyper)
+	if !ok {
+		return
+	}
+
+	if contentType, err := ctyper.ContentType(ctx); err == nil {
+		w.Header().Set("Content-Type", contentType)
+	}
+}
+
+type dirFile struct {
 	webdav.File
+	// ctx is the context the file was opened with, which is the one of the
+	// request.
+	ctx     context.Context
+	dir     Dir
+	name    string
+	created bool
 }
 
-func (f noSniffFile) Stat() (os.FileInfo, error) {
+func (f dirFile) Close() error {
+	// The uploads only replace the file once closed.
+	if f.created {
+		defer f.dir.invalidateListing(f.name)
+	}
+	return f.File.Close()
+}
+
+func (f dirFile) Stat() (os.FileInfo, error) {
 	info, err := f.File.Stat()
 	if err != nil {
 		return nil, err
 	}
 
-	return noSniffFileInfo{info}, nil
+	return fileInfo{FileInfo: info, dir: f.dir, name: f.name}, nil
+}
+
+// ReadFrom lets the wrapped file notice when reading the request body fails,
+// see [uploadFile].
+func (f dirFile) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := f.File.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{f.File}, r)
 }
 
-func (f noSniffFile) Readdir(count int) (fis []os.FileInfo, err error) {
+func (f dirFile) Readdir(count int) (fis []os.FileInfo, err error) {
 	fis, err = f.File.Readdir(count)
 	if err != nil {
 		return nil, err
 	}
 
-	for i := range fis {
-		fis[i] = noSniffFileInfo{fis[i]}
+	visible := fis[:0]
+	for _, fi := range fis {
+		if f.dir.hidden.matchName(fi.Name()) || (f.dir.deadProps != nil && fi.Name() == deadPropsFile) || (f.dir.uploadsTemporary() && isUploadName(fi.Name())) {
+			continue
+		}
+		name := path.Join(f.name, fi.Name())
+		if f.dir.emptyDirs != nil && fi.IsDir() && f.dir.isEmpty(f.ctx, name) {
+			continue
+		}
+		visible = append(visible, fileInfo{FileInfo: fi, dir: f.dir, name: name})
+	}
+	return visible, nil
+}
+
+// hiddenFiles matches the files that are hidden from the clients, either
+// because they are dotfiles, or because their name matches any of the glob
+// patterns. Everything inside of a hidden directory is hidden as well.
+type hiddenFiles struct {
+	dotfiles bool
+	patterns []string
+}
+
+func (h hiddenFiles) enabled() bool {
+	return h.dotfiles || len(h.patterns) > 0
+}
+
+// match reports whether the path, or any of its parents, is hidden.
+func (h hiddenFiles) match(name string) bool {
+	if !h.enabled() {
+		return false
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part != "" && h.matchName(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchName reports whether a file with the given name, regardless of its
+// parents, is hidden.
+func (h hiddenFiles) matchName(name string) bool {
+	if h.dotfiles && strings.HasPrefix(name, ".") {
+		return true
+	}
+
+	for _, pattern := range h.patterns {
+		// The patterns are validated beforehand.
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
 	}
-	return fis, nil
+	return false
 }
@@ -0,0 +1,267 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Backend:     BackendMemory,
+		NoSniff:     true,
+		Permissions: Permissions{Modify: true},
+	})
+
+	// The files in the scope directory are not visible.
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doRequest(h, httptest.NewRequest("MKCOL", "/folder", nil))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = doRequest(h, httptest.NewRequest(http.MethodPut, "/folder/hello.unknownext", strings.NewReader("<html>hello</html>")))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = doRequest(h, httptest.NewRequest(http.MethodGet, "/folder/hello.unknownext", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "<html>hello</html>", w.Body.String())
+
+	r := httptest.NewRequest("PROPFIND", "/folder/", nil)
+	r.Header.Set("Depth", "1")
+	w = doRequest(h, r)
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	require.Contains(t, w.Body.String(), "/folder/hello.unknownext")
+	// NoSniff applies on top of the memory backend.
+	require.Contains(t, w.Body.String(), "<D:getcontenttype>application/octet-stream</D:getcontenttype>")
+}
+
+func TestMemoryBackendSharedScope(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Auth:    true,
+		Backend: BackendMemory,
+		Users: []User{
+			{Username: "alice", Password: "alice", Permissions: Permissions{Scope: "/shared", Modify: true}},
+			{Username: "bob", Password: "bob", Permissions: Permissions{Scope: "/shared"}},
+			{Username: "carol", Password: "carol", Permissions: Permissions{Scope: "/other"}},
+		},
+	})
+
+	r := httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("shared"))
+	r.SetBasicAuth("alice", "alice")
+	require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+
+	r = httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	r.SetBasicAuth("bob", "bob")
+	require.Equal(t, http.StatusOK, doRequest(h, r).Code)
+
+	r = httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	r.SetBasicAuth("carol", "carol")
+	require.Equal(t, http.StatusNotFound, doRequest(h, r).Code)
+}
+
+func TestHiddenFiles(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Permissions:  Permissions{Modify: true},
+		HideDotfiles: true,
+		HiddenFiles:  []string{"*.tmp", "Thumbs.db"},
+	}
+	h := newTestHandler(t, cfg)
+	for _, name := range []string{".DS_Store", "draft.tmp", "Thumbs.db", "dir/.hidden", "visible.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, name), []byte("data"), 0666))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(cfg.Scope, ".git"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, ".git", "config"), []byte("data"), 0666))
+
+	t.Run("Listing", func(t *testing.T) {
+		t.Parallel()
+
+		r := httptest.NewRequest("PROPFIND", "/", nil)
+		r.Header.Set("Depth", "infinity")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+
+		body := w.Body.String()
+		require.Contains(t, body, "/visible.txt")
+		require.Contains(t, body, "/dir/")
+		for _, name := range []string{".DS_Store", "draft.tmp", "Thumbs.db", ".hidden", ".git"} {
+			require.NotContains(t, body, name)
+		}
+	})
+
+	t.Run("Direct Access", func(t *testing.T) {
+		t.Parallel()
+
+		for _, name := range []string{"/.DS_Store", "/draft.tmp", "/Thumbs.db", "/dir/.hidden", "/.git/config"} {
+			w := doRequest(h, httptest.NewRequest(http.MethodGet, name, nil))
+			require.Equal(t, http.StatusNotFound, w.Code, name)
+
+			r := httptest.NewRequest("PROPFIND", name, nil)
+			r.Header.Set("Depth", "0")
+			require.Equal(t, http.StatusNotFound, doRequest(h, r).Code, name)
+		}
+
+		require.Equal(t, http.StatusOK, doRequest(h, httptest.NewRequest(http.MethodGet, "/visible.txt", nil)).Code)
+	})
+
+	t.Run("Modification", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest(http.MethodDelete, "/.git", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.DirExists(t, filepath.Join(cfg.Scope, ".git"))
+
+		w = doRequest(h, httptest.NewRequest(http.MethodPut, "/new.tmp", strings.NewReader("data")))
+		require.GreaterOrEqual(t, w.Code, 400)
+		require.NoFileExists(t, filepath.Join(cfg.Scope, "new.tmp"))
+
+		r := httptest.NewRequest("MOVE", "/Thumbs.db", nil)
+		r.Header.Set("Destination", "/thumbs.txt")
+		require.GreaterOrEqual(t, doRequest(h, r).Code, 400)
+		require.FileExists(t, filepath.Join(cfg.Scope, "Thumbs.db"))
+	})
+}
+
+func TestConfigHiddenFiles(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{HiddenFiles: []string{"[invalid"}}
+	require.ErrorContains(t, cfg.Validate(), "hidden files pattern")
+}
+
+func TestHideEmptyDirs(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Permissions:   Permissions{Modify: true},
+		HideDotfiles:  true,
+		HideEmptyDirs: true,
+	}
+	h := newTestHandler(t, cfg)
+	for _, dir := range []string{"empty", "nested/empty/deeper", "dotfiles", "full/empty", "deep/a/b"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(cfg.Scope, dir), 0777))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "dotfiles", ".hidden"), []byte("data"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "full", "file.txt"), []byte("data"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "deep", "a", "b", "file.txt"), []byte("data"), 0666))
+
+	r := httptest.NewRequest("PROPFIND", "/", nil)
+	r.Header.Set("Depth", "infinity")
+	w := doRequest(h, r)
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+
+	// The directories with no visible files, even within their directories,
+	// are hidden, as are their members.
+	body := w.Body.String()
+	for _, name := range []string{"/dir/", "/empty/", "/nested/", "/dotfiles/", "/full/empty/"} {
+		require.NotContains(t, body, "<D:href>"+name+"</D:href>", name)
+	}
+	for _, name := range []string{"/file.txt", "/full/", "/full/file.txt", "/deep/a/b/file.txt"} {
+		require.Contains(t, body, "<D:href>"+name+"</D:href>", name)
+	}
+
+	// They can still be accessed directly, and uploaded to.
+	r = httptest.NewRequest("PROPFIND", "/empty/", nil)
+	r.Header.Set("Depth", "0")
+	require.Equal(t, http.StatusMultiStatus, doRequest(h, r).Code)
+	require.Equal(t, http.StatusCreated, doRequest(h, httptest.NewRequest(http.MethodPut, "/empty/file.txt", strings.NewReader("data"))).Code)
+
+	r = httptest.NewRequest("PROPFIND", "/", nil)
+	r.Header.Set("Depth", "1")
+	require.Contains(t, doRequest(h, r).Body.String(), "<D:href>/empty/</D:href>")
+}
+
+func TestEmptyDirsCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fs := webdav.NewMemFS()
+	require.NoError(t, fs.Mkdir(ctx, "/a", 0777))
+	require.NoError(t, fs.Mkdir(ctx, "/a/b", 0777))
+	d := Dir{FileSystem: fs, emptyDirs: newEmptyDirs()}
+
+	// The walks of the canceled requests stop, and are not kept.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.False(t, d.isEmpty(canceled, "/a"))
+	require.True(t, d.isEmpty(ctx, "/a"))
+
+	// The changes made behind the back of the server are not seen until the
+	// cache expires, unlike the ones made through it.
+	f, err := fs.OpenFile(ctx, "/a/b/file.txt", os.O_WRONLY|os.O_CREATE, 0666)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.True(t, d.isEmpty(ctx, "/a"))
+
+	require.NoError(t, d.Mkdir(ctx, "/a/b/c", 0777))
+	require.False(t, d.isEmpty(ctx, "/a"))
+	require.False(t, d.isEmpty(ctx, "/a/b"))
+}
+
+func TestContentTypes(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		ContentTypes: map[string]string{
+			".MD":  "text/markdown; charset=utf-8",
+			"html": "text/plain; charset=utf-8",
+		},
+	}
+	h := newTestHandler(t, cfg)
+	for _, name := range []string{"README.md", "index.html", "style.css"} {
+		require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, name), []byte("data"), 0666))
+	}
+
+	for name, expected := range map[string]string{
+		// A custom extension.
+		"/README.md": "text/markdown; charset=utf-8",
+		// A built-in mapping that is overridden.
+		"/index.html": "text/plain; charset=utf-8",
+		// The other built-in mappings still apply.
+		"/style.css": "text/css; charset=utf-8",
+	} {
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, name, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, expected, w.Header().Get("Content-Type"), name)
+
+		w = doRequest(h, httptest.NewRequest(http.MethodHead, name, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, expected, w.Header().Get("Content-Type"), name)
+	}
+
+	r := httptest.NewRequest("PROPFIND", "/README.md", nil)
+	r.Header.Set("Depth", "0")
+	w := doRequest(h, r)
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	require.Contains(t, w.Body.String(), "<D:getcontenttype>text/markdown; charset=utf-8</D:getcontenttype>")
+}
+
+func TestConfigContentTypes(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+content_types:
+  md: text/markdown
+  CONF: text/plain`, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, map[string]string{"md": "text/markdown", "conf": "text/plain"}, cfg.ContentTypes)
+
+	cfg = &Config{ContentTypes: map[string]string{"md": "not a type"}}
+	require.Error(t, cfg.Validate())
+
+	cfg = &Config{ContentTypes: map[string]string{".": "text/plain"}}
+	require.Error(t, cfg.Validate())
+}
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The groups of methods that can be granted independently.
+const (
+	GroupRead   = "read"
+	GroupCreate = "create"
+	GroupModify = "modify"
+	GroupDelete = "delete"
+	GroupLock   = "lock"
+)
+
+// methodGroup returns the group a method belongs to, for the requested path.
+// Copies read their source, and moves delete it, while the destination of both
+// requires the create grant, see [Permissions.allowedDestination]. Replacing a
+// file that already exists also requires the modify grant, and the delete
+// permission for the destination of a copy or a move, see
+// [handlerUser.allowedOverwrite].
+func methodGroup(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND", "COPY":
+		return GroupRead
+	case http.MethodPut, "MKCOL", "MKCALENDAR":
+		return GroupCreate
+	case http.MethodDelete, "MOVE":
+		return GroupDelete
+	case "LOCK", "UNLOCK":
+		return GroupLock
+	default:
+		return GroupModify
+	}
+}
+
+// Grants are the permissions of each group of methods. The groups that are not
+// set are granted according to Modify, except for reading, which is always
+// granted unless disabled.
+type Grants struct {
+	Read   *bool
+	Create *bool
+	Modify *bool
+	Delete *bool
+	Lock   *bool
+}
+
+// allows reports whether the group is granted, given the default for the
+// modifying groups.
+func (g *Grants) allows(group string, modify bool) bool {
+	var grant *bool
+	switch group {
+	case GroupRead:
+		if g.Read == nil {
+			return true
+		}
+		grant = g.Read
+	case GroupCreate:
+		grant = g.Create
+	case GroupDelete:
+		grant = g.Delete
+	case GroupLock:
+		grant = g.Lock
+	default:
+		grant = g.Modify
+	}
+
+	if grant == nil {
+		return modify
+	}
+	return *grant
+}
+
+// allowedOverwrite checks the permissions that depend on the files involved in
+// the request, beyond the group of its method. Replacing the destination of a
+// copy or a move deletes it first, which requires the delete permission on it.
+// Replacing an existing file also requires the modify grant, which only
+// applies if the default permissions were used for the request, as the rules
+// do not have grants.
+func (u *handlerUser) allowedOverwrite(r *http.Request, d decision) bool {
+	target := r.URL.Path
+	switch r.Method {
+	case http.MethodPut:
+		if u.Grants == nil || d.rule >= 0 {
+			return true
+		}
+	case "COPY", "MOVE":
+		if r.Header.Get("Overwrite") == "F" {
+			// The destination is never replaced.
+			return true
+		}
+		dst, ok := destinationPath(r)
+		if !ok {
+			// The WebDAV handler rejects the request.
+			return true
+		}
+		target = dst
+	default:
+		return true
+	}
+
+	if _, err := u.FileSystem.Stat(r.Context(), strings.TrimPrefix(target, u.Prefix)); err != nil {
+		return true
+	}
+	if r.Method != http.MethodPut && !u.decide(http.MethodDelete, target).allowed {
+		return false
+	}
+	if u.Grants == nil || d.rule >= 0 {
+		return true
+	}
+	return u.Grants.allows(GroupModify, u.Modify)
+}
+
+// destinationExists reports whether the request is a copy or a move that must
+// not replace its destination, and the destination exists.
+func (u *handlerUser) destinationExists(r *http.Request) bool {
+	if r.Header.Get("Overwrite") != "F" {
+		return false
+	}
+	name, ok := u.destinationName(r)
+	if !ok {
+		return false
+	}
+
+	_, err := u.FileSystem.Stat(r.Context(), name)
+	return err == nil
+}
@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrants(t *testing.T) {
+	t.Parallel()
+
+	yes, no := true, false
+	grants := &Grants{Create: &yes, Delete: &no}
+
+	require.True(t, grants.allows(GroupRead, false))
+	require.True(t, grants.allows(GroupCreate, false))
+	require.False(t, grants.allows(GroupDelete, true))
+	require.False(t, grants.allows(GroupModify, false))
+	require.True(t, grants.allows(GroupModify, true))
+	require.False(t, (&Grants{Read: &no}).allows(GroupRead, true))
+
+	p := Permissions{Grants: grants, Rules: []*Rule{{Path: "/public", Allow: true, Modify: true}}}
+	require.True(t, p.allowedMethod(http.MethodPut, "/file.txt"))
+	require.False(t, p.allowedMethod(http.MethodDelete, "/file.txt"))
+	require.False(t, p.allowedMethod("PROPPATCH", "/file.txt"))
+
+	// The rules take precedence over the grants.
+	require.True(t, p.allowedMethod(http.MethodDelete, "/public/file.txt"))
+}
+
+func TestHandlerGrants(t *testing.T) {
+	t.Parallel()
+
+	yes, no := true, false
+	newHandler := func(t *testing.T, modify bool) (http.Handler, string) {
+		cfg := &Config{
+			Permissions: Permissions{
+				Modify: modify,
+				Grants: &Grants{Read: &yes, Create: &yes, Delete: &no},
+			},
+		}
+		h := newTestHandler(t, cfg)
+		return h, cfg.Scope
+	}
+
+	request := func(h http.Handler, method, path, body string, header ...string) int {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		return doRequest(h, r).Code
+	}
+
+	t.Run("Upload But Not Delete", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, false)
+		require.Equal(t, http.StatusOK, request(h, http.MethodGet, "/file.txt", ""))
+		require.Equal(t, http.StatusCreated, request(h, http.MethodPut, "/new.txt", "new"))
+		require.Equal(t, http.StatusCreated, request(h, "MKCOL", "/new", ""))
+		require.Equal(t, http.StatusCreated, request(h, "COPY", "/file.txt", "", "Destination", "/copy.txt"))
+
+		// Deleting, and moving, which deletes the source, are forbidden.
+		require.Equal(t, http.StatusForbidden, request(h, http.MethodDelete, "/new.txt", ""))
+		require.Equal(t, http.StatusForbidden, request(h, "MOVE", "/new.txt", "", "Destination", "/moved.txt"))
+		require.FileExists(t, filepath.Join(scope, "new.txt"))
+
+		// So is replacing existing files, which requires the modify grant.
+		require.Equal(t, http.StatusForbidden, request(h, http.MethodPut, "/file.txt", "replaced"))
+		require.Equal(t, http.StatusForbidden, request(h, "COPY", "/new.txt", "", "Destination", "/file.txt"))
+		data, err := os.ReadFile(filepath.Join(scope, "file.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "content", string(data))
+
+		// The groups that are not granted fall back to Modify.
+		require.Equal(t, http.StatusForbidden, request(h, "LOCK", "/file.txt", lockBody))
+		require.Equal(t, http.StatusForbidden, request(h, "PROPPATCH", "/file.txt", ""))
+	})
+
+	t.Run("Modify But Not Delete", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, true)
+		require.Equal(t, http.StatusCreated, request(h, http.MethodPut, "/file.txt", "replaced"))
+		require.Equal(t, http.StatusOK, doRequest(h, lockRequest("/file.txt")).Code)
+		require.Equal(t, http.StatusForbidden, request(h, http.MethodDelete, "/dir", ""))
+		require.DirExists(t, filepath.Join(scope, "dir"))
+
+		// Replacing the destination of a copy deletes it first.
+		require.Equal(t, http.StatusForbidden, request(h, "COPY", "/file.txt", "", "Destination", "/dir"))
+		require.Equal(t, http.StatusCreated, request(h, "COPY", "/file.txt", "", "Destination", "/copy.txt"))
+		require.DirExists(t, filepath.Join(scope, "dir"))
+	})
+}
+
+func TestConfigGrants(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+auth: true
+modify: true
+allowed:
+  delete: false
+users:
+  - username: uploader
+    password: uploader
+    modify: false
+    allowed:
+      read: true
+      create: true
+      delete: false
+  - username: editor
+    password: editor`, ".yml")
+	require.NoError(t, cfg.Validate())
+
+	yes, no := true, false
+	require.Equal(t, &Grants{Delete: &no}, cfg.Grants)
+	require.Equal(t, &Grants{Read: &yes, Create: &yes, Delete: &no}, cfg.Users[0].Grants)
+
+	// The users without grants inherit the default ones.
+	require.Equal(t, cfg.Grants, cfg.Users[1].Grants)
+}
@@ -1,8 +1,16 @@
 package lib
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"os"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/cors"
 	"go.uber.org/zap"
@@ -12,101 +20,889 @@ import (
 type handlerUser struct {
 	User
 	webdav.Handler
+	limiter *rateLimiter
+	usage   *usageCache
+	// scope is the file system of the scope alone, as stored by the backend,
+	// whose usage counts towards the quota.
+	scope     webdav.FileSystem
+	transfers *transferStats
+	logger    *zap.Logger
 }
 
+// Handler is the WebDAV HTTP handler. Its configuration can be reloaded while
+// it is serving requests.
 type Handler struct {
-	user  *handlerUser
-	users map[string]*handlerUser
+	mu       sync.RWMutex
+	handler  *handler
+	closed   bool
+	inFlight sync.WaitGroup
 }
 
-func NewHandler(c *Config) (http.Handler, error) {
-	h := &Handler{
-		user: &handlerUser{
-			User: User{
-				Permissions: c.Permissions,
-			},
+func NewHandler(c *Config) (*Handler, error) {
+	h, err := newHandler(c, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Handler{handler: h}, nil
+}
+
+// Reload replaces the configuration of the handler. Requests that are being
+// served when the configuration is reloaded finish with the old configuration.
+// The locks and in-memory file systems are kept, as long as the users' scopes
+// do not change.
+func (h *Handler) Reload(c *Config) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	handler, err := newHandler(c, h.handler)
+	if err != nil {
+		return err
+	}
+
+	// The connections to the LDAP server of the previous configuration are
+	// closed, once the requests being served no longer need them.
+	if prev := h.handler; prev.ldap != nil {
+		prev.ldap.close()
+	}
+	if prev := h.handler; prev.reaper != handler.reaper {
+		prev.reaper.close()
+	}
+
+	h.handler = handler
+	return nil
+}
+
+// ServeHTTP serves the request with the current configuration. Once the
+// handler is shutting down, the requests are rejected with 503 Service
+// Unavailable.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	handler, closed := h.handler, h.closed
+	if !closed {
+		h.inFlight.Add(1)
+	}
+	h.mu.RUnlock()
+
+	if closed {
+		w.Header().Set("Connection", "close")
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.inFlight.Done()
+
+	handler.root.ServeHTTP(w, r)
+}
+
+// Shutdown stops accepting new requests, and waits for the requests being
+// served to finish, or for the context to be done, in which case its error is
+// returned.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closed = true
+	h.handler.reaper.close()
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handler serves the requests for a certain configuration.
+type handler struct {
+	root             http.Handler
+	logger           *zap.Logger
+	realm            string
+	user             *handlerUser
+	users            map[string]*handlerUser
+	userCache        *userCache
+	anonymous        *handlerUser
+	authenticator    Authenticator
+	authMethods      []string
+	uploadHook       UploadHook
+	chown            bool
+	fileSystems      *fileSystems
+	locks            Locks
+	sharedLocks      map[string]webdav.LockSystem
+	lockStore        *lockStore
+	reaper           *lockReaper
+	digest           *digestAuth
+	certificate      *certificateAuth
+	jwt              *jwtAuth
+	introspection    *introspectionAuth
+	ldap             *ldapAuth
+	lockout          *lockout
+	concurrency      *concurrencyLimit
+	proxies          trustedProxies
+	networks         *networkFilter
+	compressor       *compressor
+	propfindCache    *propfindCache
+	redactor         *redactor
+	requestIDHeader  string
+	accessLog        *accessLogger
+	audit            *auditLogger
+	metrics          *metrics
+	health           *health
+	admin            *admin
+	landing          *landing
+	metricsPath      string
+	readOnly         bool
+	disabledMethods  map[string]bool
+	unknownStatus    int
+	anonymousRead    bool
+	directoryListing bool
+	indexFiles       []string
+	listing          listingOptions
+	archives         bool
+	trailingSlash    bool
+	headers          map[string]string
+	server           string
+	poweredBy        string
+	partialUpdates   bool
+	caldav           bool
+	buffers          *bufferPool
+	idempotentDelete bool
+	caseInsensitive  bool
+	writeOnce        []string
+	createParentDirs bool
+	verifyDigest     bool
+	collectionETags  bool
+	precompressed    bool
+	disposition      string
+	writes           *writeGuard
+	concurrentWrites string
+	expectContinue   bool
+	requestTimeout   time.Duration
+	slowThreshold    time.Duration
+	maxPropfindDepth int
+	explain          bool
+	debugIfHeader    bool
+}
+
+// newHandler creates a handler from the configuration. If a previous handler
+// is given, the state that must survive a reload is taken from it.
+func newHandler(c *Config, prev *handler) (*handler, error) {
+	logger := c.Logger
+	if logger == nil {
+		var err error
+		logger, err = c.NewLogger()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The paths are redacted from all the logs of the handler.
+	redactor := newRedactor(c.Redaction)
+	logger = redactor.wrap(logger)
+
+	if err := c.checkScopes(); err != nil {
+		return nil, err
+	}
+
+	fileSystems := newFileSystems(c)
+	if prev != nil && prev.fileSystems.backend == c.Backend {
+		fileSystems.memory = prev.fileSystems.memory
+	}
+
+	// With shared locks, the users whose scopes are the same directory share
+	// the same lock system, keyed by that directory.
+	sharedLocks := map[string]webdav.LockSystem{}
+	if prev != nil && prev.locks == c.Locks && prev.fileSystems.backend == c.Backend {
+		sharedLocks = prev.sharedLocks
+	}
+
+	// Persistent locks are all kept in the same file, by user or, with shared
+	// locks, by directory.
+	var store *lockStore
+	if c.Locks.File != "" {
+		if prev != nil && prev.locks == c.Locks && prev.lockStore != nil {
+			store = prev.lockStore
+		} else {
+			var err error
+			store, err = newLockStore(c.Locks.File)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The expired locks are removed by the same reaper, as long as the locks
+	// are configured the same, since the lock systems are kept then.
+	var reaper *lockReaper
+	if c.Locks.ReapInterval > 0 {
+		if prev != nil && prev.locks == c.Locks && prev.reaper != nil {
+			reaper = prev.reaper
+		} else {
+			reaper = newLockReaper(c.Locks.ReapInterval, logger)
+		}
+	}
+
+	newUser := func(u User) *handlerUser {
+		user := &handlerUser{
+			User: u,
 			Handler: webdav.Handler{
-				Prefix: c.Prefix,
-				FileSystem: Dir{
-					Dir:     webdav.Dir(c.Scope),
-					noSniff: c.NoSniff,
-				},
-				LockSystem: webdav.NewMemLS(),
+				Prefix:     c.Prefix,
+				FileSystem: fileSystems.user(u.Scope),
+				LockSystem: newLockSystem(c.Locks, store, u.Username, reaper),
+				Logger:     logLockConflicts(logger, u.Username),
 			},
+			limiter:   newRateLimiter(u.RateLimit),
+			usage:     &usageCache{},
+			scope:     fileSystems.base(u.Scope),
+			transfers: &transferStats{},
+			logger:    logger,
+		}
+
+		var prevUser *handlerUser
+		if prev != nil {
+			prevUser = prev.user
+			if u.Username != "" {
+				prevUser = prev.users[u.Username]
+			}
+		}
+
+		// Keep counting the transfers of the user.
+		if prevUser != nil {
+			user.transfers = prevUser.transfers
+		}
+
+		// Keep the locks of the user, so that clients do not lose them.
+		if prev != nil && prev.locks == c.Locks && prevUser != nil && prevUser.Scope == u.Scope {
+			user.LockSystem = prevUser.LockSystem
+			user.usage = prevUser.usage
+		}
+
+		if c.Locks.Shared {
+			dir := fileSystems.physical(u.Scope)
+			if sharedLocks[dir] == nil {
+				sharedLocks[dir] = newLockSystem(c.Locks, store, dir, reaper)
+			}
+			user.LockSystem = sharedLocks[dir]
+		}
+
+		return user
+	}
+
+	h := &handler{
+		user: newUser(User{
+			Permissions:   c.Permissions,
+			RateLimit:     c.RateLimit,
+			MaxUploadSize: c.MaxUploadSize,
+		}),
+		logger:           logger,
+		redactor:         redactor,
+		realm:            c.Realm,
+		users:            map[string]*handlerUser{},
+		fileSystems:      fileSystems,
+		locks:            c.Locks,
+		lockStore:        store,
+		reaper:           reaper,
+		sharedLocks:      sharedLocks,
+		readOnly:         c.ReadOnly,
+		disabledMethods:  map[string]bool{},
+		unknownStatus:    c.UnknownStatus,
+		anonymousRead:    c.AnonymousRead,
+		directoryListing: c.DirectoryListing,
+		indexFiles:       c.IndexFiles,
+		listing: listingOptions{
+			sort:  c.ListingSort,
+			desc:  c.ListingOrder == ListingOrderDesc,
+			limit: c.ListingLimit,
 		},
-		users: map[string]*handlerUser{},
+		archives:         c.Archives,
+		trailingSlash:    c.TrailingSlash,
+		headers:          c.Headers,
+		server:           serverHeader(c),
+		poweredBy:        c.PoweredBy,
+		uploadHook:       c.UploadHook,
+		chown:            c.Chown,
+		partialUpdates:   c.PartialUpdates,
+		caldav:           c.CalDAV,
+		buffers:          newBufferPool(c.UploadBuffer),
+		idempotentDelete: c.IdempotentDelete,
+		caseInsensitive:  c.CaseInsensitive,
+		writeOnce:        c.WriteOnce,
+		createParentDirs: c.CreateParentDirs,
+		verifyDigest:     c.VerifyDigest,
+		collectionETags:  c.CollectionETags,
+		precompressed:    c.Precompressed,
+		disposition:      c.Disposition,
+		concurrentWrites: c.ConcurrentWrites,
+		expectContinue:   c.ExpectContinue,
+		requestTimeout:   c.RequestTimeout,
+		slowThreshold:    c.SlowThreshold,
+		maxPropfindDepth: c.MaxPropfindDepth,
+		explain:          c.Explain,
+		debugIfHeader:    c.DebugIfHeader,
+	}
+
+	for _, method := range c.DisabledMethods {
+		h.disabledMethods[method] = true
+	}
+
+	if c.Anonymous.Scope != "" {
+		h.anonymous = newUser(User{
+			Permissions:   c.Anonymous,
+			RateLimit:     c.RateLimit,
+			MaxUploadSize: c.MaxUploadSize,
+		})
+		h.anonymous.transfers = h.user.transfers
 	}
 
 	for _, u := range c.Users {
-		h.users[u.Username] = &handlerUser{
-			User: u,
-			Handler: webdav.Handler{
-				Prefix: c.Prefix,
-				FileSystem: Dir{
-					Dir:     webdav.Dir(u.Scope),
-					noSniff: c.NoSniff,
-				},
-				LockSystem: webdav.NewMemLS(),
-			},
+		if err := u.createHome(c.Backend); err != nil {
+			return nil, err
+		}
+		h.users[u.Username] = newUser(u)
+	}
+
+	// Users from the users file get the default settings. Users defined in the
+	// configuration take precedence.
+	if c.UsersFile != "" {
+		users, err := readHtpasswd(c.UsersFile)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, u := range users {
+			if _, ok := h.users[u.Username]; ok {
+				continue
+			}
+
+			u.Permissions = c.Permissions
+			u.RateLimit = c.RateLimit
+			u.MaxUploadSize = c.MaxUploadSize
+			if err := u.Validate(); err != nil {
+				return nil, err
+			}
+			if err := u.createHome(c.Backend); err != nil {
+				return nil, err
+			}
+
+			h.users[u.Username] = newUser(u)
+		}
+	}
+
+	if c.UserStore != nil {
+		h.userCache = newUserCache(c, newUser)
+	}
+
+	if c.Auth && len(h.users) == 0 && c.UserStore == nil && !c.usesAuthMethod(AuthMethodLDAP) {
+		return nil, errors.New("auth cannot be enabled without users")
+	}
+
+	h.authenticator = c.Authenticator
+	h.authMethods = c.AuthMethods
+	if h.authenticator == nil {
+		h.authenticator = usersAuthenticator{lookup: h.lookupUser, logger: logger}
+	}
+
+	if c.usesAuthMethod(AuthMethodDigest) {
+		// Keep the nonces issued before the reload valid.
+		if prev != nil && prev.digest != nil && prev.digest.timeout == c.Digest.NonceTimeout && prev.digest.realm == c.Realm {
+			h.digest = prev.digest
+		} else {
+			var err error
+			h.digest, err = newDigestAuth(c.Realm, c.Digest.NonceTimeout)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if c.usesAuthMethod(AuthMethodCertificate) {
+		h.certificate = newCertificateAuth(c.Certificate)
+	}
+
+	if c.usesAuthMethod(AuthMethodJWT) {
+		h.jwt = newJWTAuth(c.JWT)
+
+		// Keep the keys fetched from the JWKS URL.
+		if prev != nil && prev.jwt != nil && prev.jwt.jwks != nil && h.jwt.jwks != nil && prev.jwt.jwks.url == h.jwt.jwks.url {
+			h.jwt.jwks = prev.jwt.jwks
+		}
+	}
+
+	if c.usesAuthMethod(AuthMethodLDAP) {
+		var err error
+		h.ldap, err = newLDAPAuth(c, newUser)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.usesAuthMethod(AuthMethodIntrospection) {
+		h.introspection = newIntrospectionAuth(c.Introspection)
+
+		// Keep the cached introspections, as long as they come from the same
+		// endpoint, and still map to the same usernames.
+		if prev != nil && prev.introspection != nil && prev.introspection.url == h.introspection.url &&
+			prev.introspection.usernameField == h.introspection.usernameField && prev.introspection.cache.ttl == h.introspection.cache.ttl {
+			h.introspection.cache = prev.introspection.cache
+		}
+	}
+
+	// Keep the failed login attempts, so that reloading does not lift lockouts.
+	if c.Lockout.Enabled && prev != nil && prev.lockout != nil && prev.lockout.attempts == c.Lockout.Attempts &&
+		prev.lockout.window == c.Lockout.Window && prev.lockout.cooldown == c.Lockout.Cooldown {
+		h.lockout = prev.lockout
+	} else {
+		h.lockout = newLockout(c.Lockout)
+	}
+
+	// Keep the writes in progress, so that they are still serialized with the
+	// ones that come after the reload.
+	if c.ConcurrentWrites != ConcurrentWritesAllow {
+		if prev != nil && prev.writes != nil {
+			h.writes = prev.writes
+		} else {
+			h.writes = newWriteGuard()
+		}
+	}
+
+	h.proxies = newTrustedProxies(c.Proxy)
+	h.networks = newNetworkFilter(c.Networks)
+	h.compressor = newCompressor(c.Compression)
+	h.propfindCache = newPropfindCache(c.PropfindCache, c.CaseInsensitive)
+
+	if c.RequestID.Enabled {
+		h.requestIDHeader = c.RequestID.Header
+	}
+
+	if c.AccessLog.Enabled {
+		h.accessLog = &accessLogger{
+			format:   c.AccessLog.Format,
+			out:      os.Stdout,
+			logger:   logger,
+			redactor: redactor,
+		}
+	}
+
+	if c.Audit.Enabled {
+		// Keep the audit file open, rather than opening it again, but redact
+		// the records as configured now.
+		if prev != nil && prev.audit != nil && prev.audit.file == c.Audit.File && c.Audit.File != "" {
+			h.audit = &auditLogger{file: prev.audit.file, core: prev.audit.core, logger: redactor.wrap(zap.New(prev.audit.core))}
+		} else {
+			var err error
+			h.audit, err = newAuditLogger(c.Audit, logger, redactor)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Keep the limit, so that the requests being served still count towards it.
+	if prev != nil && prev.concurrency != nil && prev.concurrency.config == c.Concurrency {
+		h.concurrency = prev.concurrency
+	} else {
+		h.concurrency = newConcurrencyLimit(c.Concurrency)
+	}
+
+	if c.Metrics.Enabled {
+		h.metricsPath = c.Metrics.Path
+
+		// Keep the metrics, so that they are not reset.
+		if prev != nil && prev.metrics != nil && prev.metrics.prefix == c.Metrics.Prefix {
+			h.metrics = prev.metrics
+		} else {
+			h.metrics = newMetrics(c.Metrics)
+		}
+	}
+
+	if c.Health.Enabled {
+		h.health = &health{path: c.Health.Path, started: time.Now()}
+		if c.Health.CheckScope && c.Backend == BackendDisk {
+			h.health.scope = c.Scope
+			if strings.Contains(c.Scope, userPlaceholder) {
+				h.health.scope = scopeRoot(c.Scope)
+			}
+		}
+
+		// Keep the uptime, since the server was not restarted.
+		if prev != nil && prev.health != nil {
+			h.health.started = prev.health.started
+		}
+	}
+
+	if c.Admin.Enabled {
+		var err error
+		h.admin, err = newAdmin(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Landing.Redirect != "" || c.Landing.Page != "" {
+		var err error
+		h.landing, err = newLanding(c.Landing)
+		if err != nil {
+			return nil, err
 		}
 	}
 
+	h.root = h
 	if c.CORS.Enabled {
-		return cors.New(cors.Options{
-			AllowCredentials:   c.CORS.Credentials,
-			AllowedOrigins:     c.CORS.AllowedHosts,
-			AllowedMethods:     c.CORS.AllowedMethods,
-			AllowedHeaders:     c.CORS.AllowedHeaders,
-			OptionsPassthrough: false,
-		}).Handler(h), nil
+		h.root = cors.New(corsOptions(c.CORS)).Handler(h)
 	}
 
 	return h, nil
 }
 
 // ServeHTTP determines if the request is for this plugin, and if all prerequisites are met.
-func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	user := h.user
 
-	// Authentication
-	if len(h.users) > 0 {
-		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+	// The interim response goes to the connection itself, as the wrappers below
+	// only expect the final one.
+	interim := w
+
+	// Every log of the request includes its ID, which is also sent back, so
+	// that the request can be traced across the services.
+	if h.requestIDHeader != "" {
+		r = h.withRequestID(w, r)
+	}
+
+	// Behind trusted reverse proxies, use the address of the actual client for
+	// the logs and the lockouts.
+	if len(h.proxies) > 0 {
+		r.RemoteAddr = h.proxies.clientAddress(r)
+	}
+
+	// Only the allowed networks can reach the server at all.
+	if h.rejectNetwork(w, r) {
+		return
+	}
 
-		// Gets the correct user for this request.
-		username, password, ok := r.BasicAuth()
-		zap.L().Info("login attempt", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
+	// The server identifies itself, unless hidden. The configured headers are
+	// set afterwards, so that they can override it.
+	if h.server != "" {
+		w.Header().Set("Server", h.server)
+	}
+	if h.poweredBy != "" {
+		w.Header().Set("X-Powered-By", h.poweredBy)
+	}
+
+	// The configured headers go to every response, except the empty ones. They
+	// are set first, so that the ones the WebDAV handler sets take precedence.
+	for name, value := range h.headers {
+		if value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+
+	// The metrics endpoint bypasses authentication and WebDAV.
+	if h.metrics != nil && r.URL.Path == h.metricsPath {
+		h.metrics.handler.ServeHTTP(w, r)
+		return
+	}
+
+	// So does the health check endpoint, which is not logged either, since it
+	// is requested periodically.
+	if h.health != nil && r.URL.Path == h.health.path {
+		h.health.ServeHTTP(w, r)
+		return
+	}
+
+	// Measure and log the request after it has been served.
+	if h.accessLog != nil || h.metrics != nil || h.slowThreshold > 0 {
+		rw := &recordingResponseWriter{ResponseWriter: w}
+		w = rw
+
+		entry := accessLogEntry{start: time.Now(), method: r.Method}
+		path := r.URL.Path
+		if h.metrics != nil {
+			h.metrics.inFlight.Inc()
+		}
+
+		defer func() {
+			if user != nil {
+				entry.username = user.Username
+			}
+
+			duration := time.Since(entry.start)
+			if h.metrics != nil {
+				h.metrics.inFlight.Dec()
+				h.metrics.observe(entry.method, rw.Status(), duration)
+			}
+
+			if h.accessLog != nil {
+				h.accessLog.log(r, rw, entry)
+			}
+
+			// Requests taking unusually long may reveal a stalled storage.
+			if h.slowThreshold > 0 && duration > h.slowThreshold {
+				requestLogger(r, h.logger).Warn("slow request",
+					zap.String("method", entry.method),
+					zap.String("path", path),
+					zap.String("username", entry.username),
+					zap.Int("status", rw.Status()),
+					zap.Duration("duration", duration),
+				)
+			}
+		}()
+	}
+
+	// Reject the paths that try to traverse out of the scope, rather than rely
+	// on the file systems to contain them.
+	if !sanitizePath(r) {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if status := sanitizeDestination(r); status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	// Limit the requests served at the same time, so that load spikes do not
+	// exhaust the resources of the server.
+	if h.concurrency != nil {
+		done, ok := h.limitConcurrency(w, r)
 		if !ok {
-			http.Error(w, "Not authorized", http.StatusUnauthorized)
 			return
 		}
+		defer done()
+	}
+
+	// Abort the requests that stall, for example because the client stopped
+	// sending the body or reading the response.
+	if h.requestTimeout > 0 {
+		var stop func()
+		w, r, stop = withIdleTimeout(w, r, h.requestTimeout)
+		defer stop()
+	}
 
-		user, ok = h.users[username]
+	// The landing, which is meant for browsers, does not require authentication.
+	if h.landing != nil && h.landing.matches(r) {
+		h.landing.ServeHTTP(w, r)
+		return
+	}
+
+	// The headers are only logged when debugging, and never the credentials.
+	requestLogger(r, h.logger).Debug("request headers", zap.String("method", r.Method), zap.String("path", r.URL.Path), h.redactor.httpHeaders("headers", r.Header))
+
+	// Explain the permission decision, instead of performing the request.
+	explain := h.explain && r.Header.Get(explainHeader) != ""
+
+	// The methods the server does not support are answered consistently,
+	// rather than by the WebDAV handler, which does not know all of them.
+	if !h.supportsMethod(r.Method) && !explain {
+		requestLogger(r, h.logger).Info("unsupported method", zap.String("method", r.Method), zap.String("path", r.URL.Path), zap.String("remote_address", r.RemoteAddr))
+		w.Header().Set("Allow", strings.Join(h.enabledMethods(), ", "))
+		serveError(w, r, h.unknownStatus, http.StatusText(h.unknownStatus), "")
+		return
+	}
+
+	// In read-only mode, nobody can modify the contents, regardless of their
+	// permissions. Therefore, there is no need to even authenticate.
+	if h.readOnly && !isReadMethod(r.Method) && !explain {
+		w.Header().Set("Allow", strings.Join(readMethods, ", "))
+		serveError(w, r, http.StatusMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+
+	// Neither can anybody use the disabled methods.
+	if h.disabledMethods[r.Method] && !explain {
+		w.Header().Set("Allow", strings.Join(h.enabledMethods(), ", "))
+		serveError(w, r, http.StatusMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+
+	// Authentication. With anonymous read, the requests that only read and
+	// carry no credentials are served as the anonymous user, if configured, or
+	// as the default user.
+	anonymous := h.anonymousRead && isReadMethod(r.Method) && !hasCredentials(r)
+	if anonymous && h.anonymous != nil {
+		user = h.anonymous
+	} else if (len(h.users) > 0 || h.ldap != nil || h.userCache != nil) && !anonymous {
+		var ok bool
+		switch {
+		case len(h.authMethods) > 0:
+			user, ok = h.multiAuthenticate(w, r)
+		case h.digest != nil:
+			user, ok = h.digestAuthenticate(w, r)
+		case h.certificate != nil:
+			user, ok = h.certificateAuthenticate(w, r)
+		case h.jwt != nil:
+			user, ok = h.jwtAuthenticate(w, r)
+		case h.introspection != nil:
+			user, ok = h.introspectionAuthenticate(w, r)
+		case h.ldap != nil:
+			user, ok = h.ldapAuthenticate(w, r)
+		default:
+			user, ok = h.basicAuthenticate(w, r)
+		}
 		if !ok {
-			http.Error(w, "Not authorized", http.StatusUnauthorized)
 			return
 		}
+	}
 
-		if !user.checkPassword(password) {
-			zap.L().Info("invalid password", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
-			http.Error(w, "Not authorized", http.StatusUnauthorized)
+	// Limit the requests of each user too, now that the user is known. Without
+	// authentication, all the requests are of the default user, which the
+	// other limits already cover.
+	if h.concurrency != nil && user.Username != "" {
+		done, ok := h.limitUserConcurrency(w, r, user.Username)
+		if !ok {
 			return
 		}
+		defer done()
+	}
+
+	// Requests for collections are served the same with or without trailing
+	// slash, including for the rules matching on them.
+	if h.trailingSlash {
+		user.addTrailingSlash(r)
+	}
+
+	if explain {
+		h.serveExplanation(w, r, user)
+		return
+	}
+
+	// The admin endpoint requires authentication, but bypasses WebDAV.
+	if h.admin != nil && r.URL.Path == h.admin.path {
+		h.admin.serve(w, r, user)
+		return
+	}
+
+	// Count the bytes transferred by the user. The bodies of the responses are
+	// counted as they are sent, so after compression and without the ones of
+	// HEAD requests.
+	if r.Body != nil {
+		r.Body = transferReader{ReadCloser: r.Body, total: &user.transfers.in}
+	}
+	w = &recordingResponseWriter{ResponseWriter: w, total: &user.transfers.out}
+
+	// Audit the requests that may modify the contents, once they are served,
+	// including the ones that are rejected.
+	if h.audit != nil && !isReadMethod(r.Method) {
+		rw := &recordingResponseWriter{ResponseWriter: w}
+		w = rw
 
-		zap.L().Info("user authorized", zap.String("username", username))
+		entry := newAuditEntry(r, user)
+		defer func() {
+			h.audit.log(r, entry, rw.Status())
+		}()
 	}
 
 	// Checks for user permissions relatively to this PATH.
-	allowed := user.Allowed(r)
+	decision := user.decide(r.Method, r.URL.Path)
+
+	requestLogger(r, h.logger).Debug("allowed & method & path", zap.Bool("allowed", decision.allowed), zap.String("method", r.Method), zap.String("path", r.URL.Path), zap.Int("rule", decision.rule))
+
+	if !decision.allowed || !user.allowedDestination(r) || !user.allowedOverwrite(r, decision) {
+		serveForbidden(w, r, decision.allowed)
+		return
+	}
+
+	if r.Method == http.MethodOptions {
+		h.serveOptions(w, r, user)
+		return
+	}
+
+	// Log why the conditional requests failed, since the WebDAV handler only
+	// replies with the status.
+	if h.debugIfHeader && r.Header.Get("If") != "" {
+		rw := &recordingResponseWriter{ResponseWriter: w}
+		w = rw
+		defer func() {
+			if status := rw.Status(); status == http.StatusBadRequest || status == http.StatusPreconditionFailed || status == http.StatusLocked {
+				h.logIfHeader(r, user, status)
+			}
+		}()
+	}
+
+	// Deep PROPFIND requests can be expensive on large trees.
+	if r.Method == "PROPFIND" && depthExceeds(r.Header.Get("Depth"), h.maxPropfindDepth) {
+		servePropfindDepthError(w)
+		return
+	}
+
+	// Partial updates upload file contents, just like PUT requests do.
+	partialUpdate := h.partialUpdates && r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, user.Prefix)
+	upload := r.Method == http.MethodPut || partialUpdate
 
-	zap.L().Debug("allowed & method & path", zap.Bool("allowed", allowed), zap.String("method", r.Method), zap.String("path", r.URL.Path))
+	// Serialize the uploads to the same file, before checking the conditions,
+	// so that they hold until the upload is done.
+	if upload && h.writes != nil {
+		release, ok := h.guardWrite(w, r, user, user.name(r))
+		if !ok {
+			return
+		}
+		defer release()
+	}
 
-	if !allowed {
-		w.WriteHeader(http.StatusForbidden)
+	if !user.checkConditions(w, r, user.name(r)) {
 		return
 	}
+	if r.Method == "PROPFIND" && h.collectionETags && !user.checkPropfindConditions(w, r, user.name(r)) {
+		return
+	}
+
+	// Throttle the transfer of file contents, if the user is rate limited.
+	if user.limiter != nil && (r.Method == http.MethodGet || upload) {
+		r.Body = rateLimitedReader{ReadCloser: r.Body, ctx: r.Context(), limiter: user.limiter}
+		w = rateLimitedWriter{ResponseWriter: w, ctx: r.Context(), limiter: user.limiter}
+	}
+
+	// Reject uploads larger than the maximum upload size. If the size is not
+	// known in advance, the upload is aborted once the limit is reached.
+	if upload && user.MaxUploadSize > 0 {
+		if r.ContentLength > user.MaxUploadSize {
+			http.Error(w, "Request entity too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		w, _ = limitBody(w, r, user.MaxUploadSize, http.StatusRequestEntityTooLarge, errBodyTooLarge)
+	}
+
+	// Make sure uploads fit within the user's quota.
+	if upload && user.Quota > 0 {
+		qw, done, ok := user.enforceQuota(w, r, user.name(r))
+		if !ok {
+			return
+		}
+		w = qw
+		defer done()
+	}
+
+	// Make sure the files created fit within the user's maximum number of files.
+	if user.MaxFiles > 0 && (r.Method == http.MethodPut || r.Method == "MKCOL" || r.Method == "MKCALENDAR" || r.Method == "COPY") {
+		if !user.enforceMaxFiles(w, r) {
+			return
+		}
+	}
+
+	// All the checks passed, so the client can send the body. Unless told so,
+	// the standard library only sends 100 Continue once the body is read.
+	if upload && h.expectContinue && expectsContinue(r) {
+		interim.WriteHeader(http.StatusContinue)
+	}
+
+	// Compress the response, unless it is a HEAD request, which has no body
+	// but must have the same headers as if the body was sent uncompressed.
+	if h.compressor != nil && r.Method != http.MethodHead {
+		if cw := h.compressor.wrap(w, r); cw != nil {
+			w = cw
+			defer func() {
+				if err := cw.close(); err != nil {
+					requestLogger(r, h.logger).Debug("failed to write compressed response", zap.Error(err))
+				}
+			}()
+		}
+	}
 
 	if r.Method == "HEAD" {
-		w = responseWriterNoBody{w}
+		w = &recordingResponseWriter{ResponseWriter: w, noBody: true}
 	}
 
 	// Excerpt from RFC4918, section 9.4:
@@ -115,26 +911,439 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	//		"index.html" resource, a human-readable view of the contents of
 	//		the collection, or something else altogether.
 	//
-	// Get, when applied to collection, will return the same as PROPFIND method.
-	if r.Method == "GET" && strings.HasPrefix(r.URL.Path, user.Prefix) {
-		info, err := user.FileSystem.Stat(r.Context(), strings.TrimPrefix(r.URL.Path, user.Prefix))
+	// Get, when applied to collection, will return its index file, if there is
+	// one. Otherwise, it will return the same as PROPFIND method, unless the
+	// directory listing is enabled and the client is a browser.
+	if (r.Method == "GET" || r.Method == "HEAD") && strings.HasPrefix(r.URL.Path, user.Prefix) {
+		name := user.name(r)
+		info, err := user.FileSystem.Stat(r.Context(), name)
+
+		// Download the whole directory as an archive, if asked so.
+		if err == nil && info.IsDir() && h.archives && r.Method == http.MethodGet && r.URL.Query().Has("archive") {
+			user.serveArchive(w, r, name)
+			return
+		}
+
+		// Serve the index file of the directory, if there is one, as if it had
+		// been requested.
+		if err == nil && info.IsDir() {
+			if index, indexInfo, ok := h.indexFile(r, user, name); ok {
+				r.URL.Path = path.Join(r.URL.Path, index)
+				info = indexInfo
+			}
+		}
+
 		if err == nil && info.IsDir() {
+			// The collections answer HEAD requests with the headers of the
+			// GET ones, including the length of the body that is not sent.
+			if r.Method == "HEAD" {
+				hw := &headResponseWriter{ResponseWriter: w}
+				w = hw
+				defer hw.finish()
+			}
+
+			if h.directoryListing {
+				w.Header().Add("Vary", "Accept")
+				if acceptsHTML(r.Header.Get("Accept")) {
+					user.serveListing(w, r, name, h.listing)
+					return
+				}
+			}
+
 			r.Method = "PROPFIND"
 
-			if r.Header.Get("Depth") == "" {
-				r.Header.Add("Depth", "1")
+			// The maximum depth is at least 1, so the listing is not rejected.
+			if r.Header.Get("Depth") == "" || depthExceeds(r.Header.Get("Depth"), h.maxPropfindDepth) {
+				r.Header.Set("Depth", "1")
+			}
+		} else if err == nil && !info.IsDir() {
+			// The WebDAV handler lets the standard library sniff the content
+			// type of the files, which would ignore the configured ones.
+			setContentType(r.Context(), w, info)
+
+			// The files are downloaded or displayed as asked by the query, or
+			// as configured.
+			if !setDisposition(w, r, info.Name(), h.disposition) {
+				return
+			}
+
+			if h.precompressed {
+				user.usePrecompressed(w, r)
 			}
 		}
 	}
 
-	// Runs the WebDAV.
-	user.ServeHTTP(w, r)
+	// Deleting what is already missing succeeds, so that the clients retrying
+	// a deletion do not fail.
+	if r.Method == http.MethodDelete && h.idempotentDelete && strings.HasPrefix(r.URL.Path, user.Prefix) {
+		_, err := user.FileSystem.Stat(r.Context(), user.name(r))
+		if errors.Is(err, os.ErrNotExist) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	// Changing the case of a name must not overwrite the file itself.
+	if h.caseInsensitive {
+		var ok bool
+		if r, ok = withCaseChange(r); !ok {
+			serveError(w, r, http.StatusForbidden, "Forbidden", "")
+			return
+		}
+	}
+
+	// The virtual file is read-only, and is not copied with its collections.
+	if h.fileSystems.virtualFile.modifiedBy(r, user) {
+		serveError(w, r, http.StatusForbidden, "Forbidden", "")
+		return
+	}
+	if r.Method == "COPY" && h.fileSystems.virtualFile != nil {
+		r = withoutVirtualFile(r)
+	}
+
+	// The existing files of the write-once paths can be neither modified nor
+	// removed, whatever the permissions of the user.
+	if len(h.writeOnce) > 0 && user.violatesWriteOnce(r, h.writeOnce) {
+		serveError(w, r, http.StatusForbidden, "Forbidden", "")
+		return
+	}
+
+	// Copies and moves that must not replace their destination fail upfront,
+	// rather than after the locks have been confirmed.
+	if user.destinationExists(r) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	// The files created by the user are owned by their owner on disk.
+	if h.chown && user.owner != nil {
+		r = withOwner(r, user.owner)
+	}
+
+	// Uploading to a missing collection creates it, rather than failing.
+	if r.Method == http.MethodPut && h.createParentDirs {
+		user.createParents(r.Context(), r.URL.Path)
+	}
+
+	// Verify the digests of the uploaded files, as they are received.
+	var digest *bodyDigest
+	if r.Method == http.MethodPut && h.verifyDigest && !partialUpdate {
+		var err error
+		if digest, err = newBodyDigest(r.Header); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if digest != nil {
+			r.Body = digest.reader(r.Body)
+		}
+	}
+
+	// The uploads and the copies are written with the buffers of the pool.
+	if upload || r.Method == "COPY" {
+		r = withBufferPool(r, h.buffers)
+	}
+
+	// Check the uploaded files before they are made available.
+	var check *uploadCheck
+	if r.Method == http.MethodPut && (h.uploadHook != nil || digest != nil) {
+		r, check = withUploadCheck(r, h.uploadHook, digest, &user.User, path.Clean("/"+user.name(r)))
+		w = &rejectedUploadResponseWriter{ResponseWriter: w, check: check}
+	}
+
+	switch {
+	case partialUpdate:
+		// The WebDAV handler does not support partial updates.
+		user.servePartialUpdate(w, r, user.name(r))
+	case r.Method == "MKCALENDAR" && h.caldav:
+		user.serveMkcalendar(w, r, user.name(r))
+	case r.Method == "PROPFIND" && h.propfindCache != nil:
+		h.servePropfind(w, r, user)
+	default:
+		// Runs the WebDAV.
+		user.ServeHTTP(w, r)
+	}
+
+	if check != nil && check.err != nil {
+		requestLogger(r, h.logger).Info("upload rejected", zap.String("username", user.Username), zap.String("path", check.name), zap.Error(check.err))
+	}
+
+	// Any modification may change the usage, so it has to be computed again.
+	if (user.Quota > 0 || user.MaxFiles > 0) && !isReadMethod(r.Method) {
+		user.usage.invalidate()
+	}
+
+	// And so may the properties of the modified resources and their parents.
+	if h.propfindCache != nil && !isReadMethod(r.Method) {
+		h.invalidatePropfinds(r, user)
+	}
+}
+
+// addTrailingSlash appends a trailing slash to the path of the request if it
+// is a directory, and does not end with one.
+func (u *handlerUser) addTrailingSlash(r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/") || !strings.HasPrefix(r.URL.Path+"/", u.Prefix) {
+		return
+	}
+
+	name := u.name(r)
+	if r.URL.Path+"/" == u.Prefix {
+		name = "/"
+	}
+
+	if info, err := u.FileSystem.Stat(r.Context(), name); err == nil && info.IsDir() {
+		r.URL.Path += "/"
+		if r.URL.RawPath != "" {
+			r.URL.RawPath += "/"
+		}
+	}
+}
+
+// serverHeader returns the value of the Server header of the responses, which
+// defaults to the name of the server along with its version.
+func serverHeader(c *Config) string {
+	switch {
+	case c.HideServer:
+		return ""
+	case c.Server != "":
+		return c.Server
+	}
+
+	// Development builds have no version worth advertising.
+	if version := buildVersion(); strings.HasPrefix(version, "v") {
+		return "webdav/" + version
+	}
+	return "webdav"
+}
+
+// hasCredentials reports whether the request carries any kind of credentials.
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || clientCertificate(r) != nil
+}
+
+// basicAuthenticate authenticates the request using HTTP Basic authentication.
+// If it fails, the response is written and false is returned.
+func (h *handler) basicAuthenticate(w http.ResponseWriter, r *http.Request) (*handlerUser, bool) {
+	h.setChallenge(w, AuthMethodBasic, `Basic realm="`+h.realm+`"`)
+
+	// Gets the correct user for this request.
+	username, _, ok := r.BasicAuth()
+	requestLogger(r, h.logger).Info("login attempt", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
+	if !ok {
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	}
+
+	if h.rejectLockedOut(w, r, username) {
+		return nil, false
+	}
+
+	authenticated, ok := h.authenticator.Authenticate(r)
+	if !ok {
+		h.loginFailed(r, username)
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	}
+
+	user, ok := h.lookupUser(authenticated.Username)
+	if !ok {
+		requestLogger(r, h.logger).Info("unknown user", zap.String("username", authenticated.Username), zap.String("remote_address", r.RemoteAddr))
+		h.loginFailed(r, username)
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	}
+
+	h.loginSucceeded(r, username)
+	requestLogger(r, h.logger).Info("user authorized", zap.String("username", user.Username))
+	return user, true
 }
 
-type responseWriterNoBody struct {
+// digestAuthenticate authenticates the request using HTTP Digest authentication.
+// If it fails, the response is written and false is returned.
+func (h *handler) digestAuthenticate(w http.ResponseWriter, r *http.Request) (*handlerUser, bool) {
+	creds, ok := parseDigestCredentials(r.Header.Get("Authorization"))
+	requestLogger(r, h.logger).Info("login attempt", zap.String("username", creds["username"]), zap.String("remote_address", r.RemoteAddr))
+	if !ok {
+		h.setChallenge(w, AuthMethodDigest, h.digest.challenge(false))
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	}
+
+	if h.rejectLockedOut(w, r, creds["username"]) {
+		return nil, false
+	}
+
+	user, ok := h.lookupUser(creds["username"])
+	if !ok || !h.digest.verify(creds, r.Method, r.RequestURI, user.Password) {
+		requestLogger(r, h.logger).Info("invalid password", zap.String("username", creds["username"]), zap.String("remote_address", r.RemoteAddr))
+		h.loginFailed(r, creds["username"])
+		h.setChallenge(w, AuthMethodDigest, h.digest.challenge(false))
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	}
+
+	// Only check the nonce after the response, so that the client is only told
+	// that the nonce is stale if the credentials are otherwise correct.
+	valid, expired := h.digest.checkNonce(creds["nonce"])
+	if !valid || expired {
+		h.setChallenge(w, AuthMethodDigest, h.digest.challenge(valid && expired))
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	}
+
+	// The nonce count must increase, so that the request is not a replay. The
+	// client is told to retry with a new nonce, in case the requests it sent
+	// with the same one were received out of order.
+	if !h.digest.useNonce(creds["nonce"], creds["nc"]) {
+		requestLogger(r, h.logger).Info("reused digest nonce", zap.String("username", creds["username"]), zap.String("remote_address", r.RemoteAddr))
+		h.setChallenge(w, AuthMethodDigest, h.digest.challenge(true))
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	}
+
+	h.loginSucceeded(r, creds["username"])
+	requestLogger(r, h.logger).Info("user authorized", zap.String("username", creds["username"]))
+	return user, true
+}
+
+// certificateAuthenticate authenticates the request using the TLS client
+// certificate. If the client did not present a certificate, it falls back to
+// HTTP Basic authentication. If it fails, the response is written and false is
+// returned.
+func (h *handler) certificateAuthenticate(w http.ResponseWriter, r *http.Request) (*handlerUser, bool) {
+	cert := clientCertificate(r)
+	if cert == nil {
+		requestLogger(r, h.logger).Debug("no client certificate, falling back to basic auth", zap.String("remote_address", r.RemoteAddr))
+		return h.basicAuthenticate(w, r)
+	}
+
+	for _, username := range h.certificate.usernames(cert) {
+		if user, ok := h.lookupUser(username); ok {
+			requestLogger(r, h.logger).Info("user authorized", zap.String("username", username), zap.String("certificate", cert.Subject.String()))
+			return user, true
+		}
+	}
+
+	requestLogger(r, h.logger).Info("unknown client certificate", zap.String("certificate", cert.Subject.String()), zap.String("remote_address", r.RemoteAddr))
+	serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+	return nil, false
+}
+
+// jwtAuthenticate authenticates the request using a JWT bearer token. If no
+// token is given and anonymous access is allowed, the default user is used. If
+// it fails, the response is written and false is returned.
+func (h *handler) jwtAuthenticate(w http.ResponseWriter, r *http.Request) (*handlerUser, bool) {
+	token, ok := bearerToken(r)
+	if !ok {
+		if h.jwt.anonymous {
+			return h.user, true
+		}
+
+		h.setChallenge(w, AuthMethodJWT, `Bearer realm="`+h.realm+`"`)
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	}
+
+	username, err := h.jwt.username(r.Context(), token)
+	if err != nil {
+		requestLogger(r, h.logger).Info("invalid token", zap.String("remote_address", r.RemoteAddr), zap.Error(err))
+		h.setChallenge(w, AuthMethodJWT, `Bearer realm="`+h.realm+`", error="invalid_token"`)
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	}
+
+	user, ok := h.lookupUser(username)
+	if !ok {
+		requestLogger(r, h.logger).Info("unknown token user", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
+		h.setChallenge(w, AuthMethodJWT, `Bearer realm="`+h.realm+`", error="invalid_token"`)
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	}
+
+	requestLogger(r, h.logger).Info("user authorized", zap.String("username", username))
+	return user, true
+}
+
+// recordingResponseWriter records the status code and the amount of bytes
+// written to the response, so that they can be logged and measured afterwards.
+// If total is set, the bytes written are also added to it. With noBody, the
+// body is discarded, as for HEAD requests, but its length is still recorded.
+type recordingResponseWriter struct {
 	http.ResponseWriter
+	status int
+	bytes  int64
+	total  *atomic.Int64
+	noBody bool
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
 }
 
-func (w responseWriterNoBody) Write(data []byte) (int, error) {
-	return 0, nil
+func (w *recordingResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.noBody {
+		w.bytes += int64(len(data))
+		return len(data), nil
+	}
+
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += int64(n)
+	if w.total != nil {
+		w.total.Add(int64(n))
+	}
+	return n, err
+}
+
+func (w *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// headResponseWriter discards the body of a response, holding the status back
+// until it is finished, so that the length of the body can be sent instead.
+type headResponseWriter struct {
+	http.ResponseWriter
+	status int
+	length int
+}
+
+func (w *headResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *headResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.length += len(data)
+	return len(data), nil
+}
+
+// finish sends the status, with the length of the body if it has one.
+func (w *headResponseWriter) finish() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.length > 0 && w.Header().Get("Content-Length") == "" {
+		w.Header().Set("Content-Length", strconv.Itoa(w.length))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *headResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status returns the status code sent to the client.
+func (w *recordingResponseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
 }
@@ -0,0 +1,579 @@
+package lib
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// newTestHandler creates a [Handler] from the given configuration, whose scope
+// is a temporary directory containing a "file.txt" file and a "dir" directory.
+func newTestHandler(t *testing.T, cfg *Config) http.Handler {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0666))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "dir"), 0777))
+
+	if cfg.Scope == "" {
+		cfg.Scope = dir
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = zaptest.NewLogger(t)
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultPrefix
+	}
+	for i := range cfg.Users {
+		if cfg.Users[i].Scope == "" {
+			cfg.Users[i].Scope = cfg.Scope
+		}
+	}
+	require.NoError(t, cfg.Validate())
+
+	h, err := NewHandler(cfg)
+	require.NoError(t, err)
+	return h
+}
+
+func doRequest(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandlerReadOnly(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Permissions: Permissions{Modify: true},
+		ReadOnly:    true,
+	})
+
+	for _, method := range []string{
+		http.MethodPut,
+		http.MethodDelete,
+		"MKCOL",
+		"COPY",
+		"MOVE",
+		"PROPPATCH",
+		"LOCK",
+		"UNLOCK",
+	} {
+		t.Run(method, func(t *testing.T) {
+			t.Parallel()
+
+			w := doRequest(h, httptest.NewRequest(method, "/file.txt", nil))
+			require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+			require.Equal(t, "GET, HEAD, OPTIONS, PROPFIND", w.Header().Get("Allow"))
+		})
+	}
+
+	t.Run("Read", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = doRequest(h, httptest.NewRequest("PROPFIND", "/dir/", nil))
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+	})
+
+	t.Run("Authenticated", func(t *testing.T) {
+		t.Parallel()
+
+		h := newTestHandler(t, &Config{
+			Auth:        true,
+			Permissions: Permissions{Modify: true},
+			ReadOnly:    true,
+			Users:       []User{{Username: "admin", Password: "admin", Permissions: Permissions{Modify: true}}},
+		})
+
+		r := httptest.NewRequest(http.MethodPut, "/new.txt", nil)
+		r.SetBasicAuth("admin", "admin")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
+
+func TestHandlerAnonymousRead(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Auth:          true,
+		AnonymousRead: true,
+		Users: []User{
+			{Username: "admin", Password: "admin", Permissions: Permissions{Modify: true}},
+		},
+	})
+
+	t.Run("Anonymous Read", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "content", w.Body.String())
+
+		w = doRequest(h, httptest.NewRequest("PROPFIND", "/dir/", nil))
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+
+		w = doRequest(h, httptest.NewRequest(http.MethodHead, "/file.txt", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Anonymous Write", func(t *testing.T) {
+		t.Parallel()
+
+		for _, method := range []string{http.MethodPut, http.MethodDelete, "MKCOL", "LOCK"} {
+			w := doRequest(h, httptest.NewRequest(method, "/new.txt", nil))
+			require.Equal(t, http.StatusUnauthorized, w.Code, method)
+			require.NotEmpty(t, w.Header().Get("WWW-Authenticate"), method)
+		}
+	})
+
+	t.Run("Authenticated", func(t *testing.T) {
+		t.Parallel()
+
+		r := httptest.NewRequest(http.MethodPut, "/new.txt", nil)
+		r.SetBasicAuth("admin", "admin")
+		require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+
+		// Wrong credentials are rejected, even for reading.
+		r = httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.SetBasicAuth("admin", "wrong")
+		require.Equal(t, http.StatusUnauthorized, doRequest(h, r).Code)
+	})
+}
+
+func TestHandlerAnonymousScope(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "private.txt"), []byte("private"), 0666))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "public"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "public", "file.txt"), []byte("public"), 0666))
+
+	h := newTestHandler(t, &Config{
+		Auth:          true,
+		AnonymousRead: true,
+		Permissions:   Permissions{Scope: dir},
+		Anonymous:     Permissions{Scope: filepath.Join(dir, "public")},
+		Users: []User{
+			{Username: "admin", Password: "admin"},
+		},
+	})
+
+	// The anonymous requests are confined to the public directory.
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "public", w.Body.String())
+
+	for _, name := range []string{"/private.txt", "/public/file.txt"} {
+		w = doRequest(h, httptest.NewRequest(http.MethodGet, name, nil))
+		require.Equal(t, http.StatusNotFound, w.Code, name)
+	}
+	w = doRequest(h, httptest.NewRequest(http.MethodGet, "/../private.txt", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doRequest(h, httptest.NewRequest(http.MethodPut, "/new.txt", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// The authenticated users keep their own scope.
+	r := httptest.NewRequest(http.MethodGet, "/private.txt", nil)
+	r.SetBasicAuth("admin", "admin")
+	w = doRequest(h, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "private", w.Body.String())
+}
+
+func TestHandlerDisabledMethods(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Permissions:     Permissions{Modify: true},
+		DisabledMethods: []string{"LOCK", "UNLOCK", "MKCOL"},
+	})
+
+	for _, method := range []string{"LOCK", "UNLOCK", "MKCOL"} {
+		w := doRequest(h, httptest.NewRequest(method, "/new", nil))
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code, method)
+		require.Equal(t, "OPTIONS, GET, HEAD, POST, DELETE, PROPPATCH, COPY, MOVE, PROPFIND, PUT", w.Header().Get("Allow"), method)
+	}
+	require.NoDirExists(t, filepath.Join(h.(*Handler).handler.user.Scope, "new"))
+
+	// The other methods still work.
+	require.Equal(t, http.StatusOK, doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil)).Code)
+	require.Equal(t, http.StatusCreated, doRequest(h, httptest.NewRequest(http.MethodPut, "/new.txt", nil)).Code)
+
+	// Nor are they advertised, nor is locking.
+	w := doRequest(h, httptest.NewRequest(http.MethodOptions, "/file.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "OPTIONS, GET, HEAD, POST, DELETE, PROPPATCH, COPY, MOVE, PROPFIND, PUT", w.Header().Get("Allow"))
+	require.Equal(t, "1", w.Header().Get("DAV"))
+}
+
+func TestHandlerIdempotentDelete(t *testing.T) {
+	t.Parallel()
+
+	for _, idempotent := range []bool{false, true} {
+		h := newTestHandler(t, &Config{Permissions: Permissions{Modify: true}, IdempotentDelete: idempotent})
+		scope := h.(*Handler).handler.user.Scope
+
+		require.Equal(t, http.StatusNoContent, doRequest(h, httptest.NewRequest(http.MethodDelete, "/file.txt", nil)).Code)
+		require.NoFileExists(t, filepath.Join(scope, "file.txt"))
+
+		// Retrying the deletion only succeeds if deletions are idempotent.
+		w := doRequest(h, httptest.NewRequest(http.MethodDelete, "/file.txt", nil))
+		if idempotent {
+			require.Equal(t, http.StatusNoContent, w.Code)
+		} else {
+			require.Equal(t, http.StatusNotFound, w.Code)
+		}
+	}
+}
+
+func TestHandlerOverwrite(t *testing.T) {
+	t.Parallel()
+
+	for _, method := range []string{"COPY", "MOVE"} {
+		h := newTestHandler(t, &Config{Permissions: Permissions{Modify: true}})
+		scope := h.(*Handler).handler.user.Scope
+		require.NoError(t, os.WriteFile(filepath.Join(scope, "other.txt"), []byte("other"), 0666))
+
+		request := func(dst, overwrite string) int {
+			r := httptest.NewRequest(method, "/file.txt", nil)
+			r.Header.Set("Destination", dst)
+			r.Header.Set("Overwrite", overwrite)
+			return doRequest(h, r).Code
+		}
+
+		// The existing destination is not replaced, even if locked.
+		lock := doRequest(h, lockRequest("/other.txt"))
+		require.Equal(t, http.StatusOK, lock.Code, method)
+		require.Equal(t, http.StatusPreconditionFailed, request("/other.txt", "F"), method)
+		data, err := os.ReadFile(filepath.Join(scope, "other.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "other", string(data), method)
+
+		require.Equal(t, http.StatusCreated, request("/new.txt", "F"), method)
+		require.FileExists(t, filepath.Join(scope, "new.txt"), method)
+	}
+}
+
+func TestHandlerCreateParentDirs(t *testing.T) {
+	t.Parallel()
+
+	for _, create := range []bool{false, true} {
+		h := newTestHandler(t, &Config{
+			Permissions: Permissions{
+				Modify: true,
+				Rules: []*Rule{
+					{Path: "/locked/", Allow: true, Modify: false},
+					{Path: "/locked/sub/", Allow: true, Modify: true},
+				},
+			},
+			CreateParentDirs: create,
+		})
+		scope := h.(*Handler).handler.user.Scope
+
+		w := doRequest(h, httptest.NewRequest(http.MethodPut, "/a/b/c/file.txt", strings.NewReader("content")))
+		if create {
+			require.Equal(t, http.StatusCreated, w.Code)
+			require.FileExists(t, filepath.Join(scope, "a", "b", "c", "file.txt"))
+		} else {
+			require.Equal(t, http.StatusConflict, w.Code)
+			require.NoDirExists(t, filepath.Join(scope, "a"))
+		}
+
+		// The parents that the user cannot create are left missing.
+		w = doRequest(h, httptest.NewRequest(http.MethodPut, "/locked/sub/file.txt", strings.NewReader("content")))
+		require.Equal(t, http.StatusConflict, w.Code)
+		require.NoDirExists(t, filepath.Join(scope, "locked"))
+	}
+}
+
+func TestHandlerHeaders(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		NoSniff: true,
+		Headers: map[string]string{
+			"cache-control":   "no-store",
+			"X-Frame-Options": "DENY",
+			"Allow":           "GET",
+		},
+	})
+
+	for _, method := range []string{http.MethodGet, "PROPFIND"} {
+		w := doRequest(h, httptest.NewRequest(method, "/file.txt", nil))
+		require.Less(t, w.Code, 300, method)
+		require.Equal(t, "no-store", w.Header().Get("Cache-Control"), method)
+		require.Equal(t, "DENY", w.Header().Get("X-Frame-Options"), method)
+		require.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"), method)
+	}
+
+	// The headers set by the WebDAV handler take precedence.
+	w := doRequest(h, httptest.NewRequest(http.MethodOptions, "/file.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotEqual(t, "GET", w.Header().Get("Allow"))
+	require.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+}
+
+func TestHandlerServerHeader(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		cfg       Config
+		server    string
+		poweredBy string
+	}{
+		"Default": {cfg: Config{}, server: serverHeader(&Config{})},
+		"Custom":  {cfg: Config{Server: "files", PoweredBy: "coffee"}, server: "files", poweredBy: "coffee"},
+		"Hidden":  {cfg: Config{HideServer: true}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := newTestHandler(t, &tc.cfg)
+			w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, tc.server, w.Header().Get("Server"))
+			require.Equal(t, tc.poweredBy, w.Header().Get("X-Powered-By"))
+		})
+	}
+
+	require.True(t, strings.HasPrefix(serverHeader(&Config{}), "webdav"))
+
+	cfg := &Config{Server: "files", HideServer: true}
+	require.ErrorContains(t, cfg.Validate(), "cannot be both set and hidden")
+}
+
+func TestHandlerTrailingSlash(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Collections", func(t *testing.T) {
+		t.Parallel()
+
+		h := newTestHandler(t, &Config{TrailingSlash: true})
+		for _, path := range []string{"/dir", "/dir/"} {
+			w := doRequest(h, httptest.NewRequest("PROPFIND", path, nil))
+			require.Equal(t, http.StatusMultiStatus, w.Code, path)
+			require.Contains(t, w.Body.String(), "<D:href>/dir/</D:href>", path)
+
+			// GET is still served as PROPFIND.
+			require.Equal(t, http.StatusMultiStatus, doRequest(h, httptest.NewRequest(http.MethodGet, path, nil)).Code, path)
+		}
+
+		// Files and missing paths are left alone.
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "content", w.Body.String())
+		require.Equal(t, http.StatusNotFound, doRequest(h, httptest.NewRequest(http.MethodGet, "/missing", nil)).Code)
+	})
+
+	t.Run("Rules", func(t *testing.T) {
+		t.Parallel()
+
+		rules := []*Rule{{Path: "/dir/", Allow: false}}
+		h := newTestHandler(t, &Config{Permissions: Permissions{Rules: rules}, TrailingSlash: true})
+		require.Equal(t, http.StatusForbidden, doRequest(h, httptest.NewRequest("PROPFIND", "/dir/", nil)).Code)
+		require.Equal(t, http.StatusForbidden, doRequest(h, httptest.NewRequest("PROPFIND", "/dir", nil)).Code)
+
+		// Without the normalization, the rule does not match.
+		h = newTestHandler(t, &Config{Permissions: Permissions{Rules: rules}})
+		require.Equal(t, http.StatusMultiStatus, doRequest(h, httptest.NewRequest("PROPFIND", "/dir", nil)).Code)
+	})
+
+	t.Run("Prefix", func(t *testing.T) {
+		t.Parallel()
+
+		h := newTestHandler(t, &Config{Prefix: "/dav/", TrailingSlash: true})
+		require.Equal(t, http.StatusMultiStatus, doRequest(h, httptest.NewRequest("PROPFIND", "/dav", nil)).Code)
+		require.Equal(t, http.StatusMultiStatus, doRequest(h, httptest.NewRequest("PROPFIND", "/dav/dir", nil)).Code)
+	})
+}
+
+func TestHandlerRealm(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Auth:  true,
+		Realm: "Team Files",
+		Users: []User{{Username: "admin", Password: "admin"}},
+	})
+
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, `Basic realm="Team Files"`, w.Header().Get("WWW-Authenticate"))
+
+	// Without a realm, the default one is used.
+	h = newTestHandler(t, &Config{
+		Auth:  true,
+		Users: []User{{Username: "admin", Password: "admin"}},
+	})
+
+	w = doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+	require.Equal(t, `Basic realm="`+DefaultRealm+`"`, w.Header().Get("WWW-Authenticate"))
+}
+
+func TestHandlerRanges(t *testing.T) {
+	t.Parallel()
+
+	// parts returns the Content-Range and the contents of the parts of a
+	// multipart/byteranges response.
+	parts := func(t *testing.T, w *httptest.ResponseRecorder) [][2]string {
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		require.NoError(t, err)
+		require.Equal(t, "multipart/byteranges", mediaType)
+
+		var result [][2]string
+		reader := multipart.NewReader(w.Body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return result
+			}
+			require.NoError(t, err)
+			require.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+
+			data, err := io.ReadAll(part)
+			require.NoError(t, err)
+			result = append(result, [2]string{part.Header.Get("Content-Range"), string(data)})
+		}
+	}
+
+	get := func(h http.Handler, ranges string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.Header.Set("Range", ranges)
+		r.Header.Set("Accept-Encoding", "gzip")
+		return doRequest(h, r)
+	}
+
+	for name, cfg := range map[string]*Config{
+		"Default": {},
+		// Partial contents are never compressed.
+		"Compression": {Compression: Compression{Enabled: true, Level: DefaultCompressionLevel, MinSize: 1, ContentTypes: []string{"text/plain"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := newTestHandler(t, cfg)
+
+			w := get(h, "bytes=0-1,4-6")
+			require.Empty(t, w.Header().Get("Content-Encoding"))
+			require.Equal(t, [][2]string{{"bytes 0-1/7", "co"}, {"bytes 4-6/7", "ent"}}, parts(t, w))
+
+			w = get(h, "bytes=0-2,1-3")
+			require.Equal(t, [][2]string{{"bytes 0-2/7", "con"}, {"bytes 1-3/7", "ont"}}, parts(t, w))
+
+			// A single range is not sent as multipart.
+			w = get(h, "bytes=1-2")
+			require.Equal(t, http.StatusPartialContent, w.Code)
+			require.Equal(t, "bytes 1-2/7", w.Header().Get("Content-Range"))
+			require.Equal(t, "on", w.Body.String())
+
+			// Ranges adding up to more than the file are ignored.
+			w = get(h, "bytes=0-5,1-6")
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Empty(t, w.Header().Get("Content-Range"))
+		})
+	}
+}
+
+func TestHandlerScope(t *testing.T) {
+	t.Parallel()
+
+	newConfig := func(t *testing.T, scope string) *Config {
+		cfg := &Config{
+			Logger: zaptest.NewLogger(t),
+			Prefix: DefaultPrefix,
+			Auth:   true,
+			Users: []User{
+				{Username: "alice", Password: "alice", Permissions: Permissions{Scope: t.TempDir()}},
+				{Username: "bob", Password: "bob", Permissions: Permissions{Scope: scope + "/bob"}},
+			},
+		}
+		cfg.Scope = scope
+		require.NoError(t, cfg.Validate())
+		return cfg
+	}
+
+	t.Run("Missing", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := NewHandler(newConfig(t, filepath.Join(t.TempDir(), "missing")))
+		require.ErrorContains(t, err, "invalid scope")
+	})
+
+	t.Run("Not a Directory", func(t *testing.T) {
+		t.Parallel()
+
+		scope := filepath.Join(t.TempDir(), "file.txt")
+		require.NoError(t, os.WriteFile(scope, []byte("content"), 0666))
+		cfg := newConfig(t, scope)
+		cfg.CreateScope = true
+		_, err := NewHandler(cfg)
+		require.ErrorContains(t, err, "not a directory")
+	})
+
+	t.Run("Created", func(t *testing.T) {
+		t.Parallel()
+
+		scope := filepath.Join(t.TempDir(), "created")
+		cfg := newConfig(t, scope)
+		cfg.CreateScope = true
+		cfg.ScopeMode = "0750"
+		_, err := NewHandler(cfg)
+		require.NoError(t, err)
+
+		for _, name := range []string{scope, filepath.Join(scope, "bob")} {
+			info, err := os.Stat(name)
+			require.NoError(t, err)
+			require.True(t, info.IsDir())
+			require.Equal(t, os.FileMode(0750), info.Mode().Perm())
+		}
+	})
+}
+
+func TestHandlerHead(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{DirectoryListing: true})
+
+	t.Run("File", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest(http.MethodHead, "/file.txt", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "7", w.Header().Get("Content-Length"))
+		require.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+		require.Empty(t, w.Body.String())
+	})
+
+	t.Run("Collection", func(t *testing.T) {
+		t.Parallel()
+
+		for _, accept := range []string{"", "text/html"} {
+			r := httptest.NewRequest(http.MethodGet, "/dir/", nil)
+			r.Header.Set("Accept", accept)
+			get := doRequest(h, r)
+
+			r = httptest.NewRequest(http.MethodHead, "/dir/", nil)
+			r.Header.Set("Accept", accept)
+			w := doRequest(h, r)
+			require.Equal(t, get.Code, w.Code, accept)
+			require.Equal(t, get.Header().Get("Content-Type"), w.Header().Get("Content-Type"), accept)
+			require.Equal(t, strconv.Itoa(get.Body.Len()), w.Header().Get("Content-Length"), accept)
+			require.Empty(t, w.Body.String(), accept)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// health serves the health check endpoint, which reports whether the server
+// is up and, optionally, whether the scope directory is reachable.
+type health struct {
+	path    string
+	started time.Time
+	scope   string
+}
+
+type healthResponse struct {
+	Status string  `json:"status"`
+	Uptime float64 `json:"uptime"`
+	Error  string  `json:"error,omitempty"`
+}
+
+func (h *health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	res := healthResponse{
+		Status: "ok",
+		Uptime: time.Since(h.started).Seconds(),
+	}
+
+	status := http.StatusOK
+	if h.scope != "" {
+		if err := checkDir(h.scope); err != nil {
+			status = http.StatusServiceUnavailable
+			res.Status = "unavailable"
+			res.Error = err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func checkDir(name string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", name)
+	}
+	return nil
+}
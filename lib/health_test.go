@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestHandlerHealth(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	h := newTestHandler(t, &Config{
+		Logger:    zap.New(core),
+		Auth:      true,
+		AccessLog: AccessLog{Enabled: true, Format: AccessLogStructured},
+		Health:    Health{Enabled: true, Path: "/healthz"},
+		Users:     []User{{Username: "admin", Password: "admin"}},
+	})
+
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var res healthResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	require.Equal(t, "ok", res.Status)
+	require.GreaterOrEqual(t, res.Uptime, 0.0)
+
+	// Health checks are not logged.
+	require.Empty(t, logs.FilterMessage("request").AllUntimed())
+
+	// Other paths still require authentication.
+	w = doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestHandlerHealthCheckScope(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Health: Health{Enabled: true, Path: "/healthz", CheckScope: true},
+	}
+	h := newTestHandler(t, cfg)
+
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.NoError(t, os.RemoveAll(cfg.Scope))
+
+	w = doRequest(h, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	var res healthResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+	require.Equal(t, "unavailable", res.Status)
+	require.NotEmpty(t, res.Error)
+}
+
+func TestHandlerHealthDisabled(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{})
+
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
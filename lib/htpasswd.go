@@ -0,0 +1,178 @@
+package lib
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// readHtpasswd reads the users from an Apache-style htpasswd file. Each user
+// only has the username and the password hash set.
+func readHtpasswd(filename string) ([]User, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users, err := parseHtpasswd(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid users file: %w", err)
+	}
+	return users, nil
+}
+
+func parseHtpasswd(r io.Reader) ([]User, error) {
+	var users []User
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		username, password, ok := strings.Cut(text, ":")
+		if !ok || username == "" || password == "" {
+			return nil, fmt.Errorf("line %d: expected username:password", line)
+		}
+
+		if !isHtpasswdHash(password) {
+			return nil, fmt.Errorf("line %d: unsupported password format for user %q", line, username)
+		}
+
+		users = append(users, User{Username: username, Password: password})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// isHtpasswdHash reports whether the password is a hash in one of the formats
+// supported in htpasswd files.
+func isHtpasswdHash(password string) bool {
+	return isBcryptHash(password) || isMD5CryptHash(password) || isSHA1Hash(password)
+}
+
+const (
+	apr1Magic     = "$apr1$"
+	md5CryptMagic = "$1$"
+	sha1Prefix    = "{SHA}"
+)
+
+func isMD5CryptHash(password string) bool {
+	return strings.HasPrefix(password, apr1Magic) || strings.HasPrefix(password, md5CryptMagic)
+}
+
+func isSHA1Hash(password string) bool {
+	return strings.HasPrefix(password, sha1Prefix)
+}
+
+// checkMD5Crypt checks the password against an MD5-crypt hash, either in the
+// Apache ($apr1$) or the original ($1$) variant.
+func checkMD5Crypt(hash, password string) bool {
+	magic := md5CryptMagic
+	if strings.HasPrefix(hash, apr1Magic) {
+		magic = apr1Magic
+	}
+
+	salt, _, ok := strings.Cut(strings.TrimPrefix(hash, magic), "$")
+	if !ok {
+		return false
+	}
+
+	expected := md5Crypt([]byte(password), []byte(salt), []byte(magic))
+	return subtle.ConstantTimeCompare(expected, []byte(hash)) == 1
+}
+
+// checkSHA1 checks the password against a {SHA} hash.
+func checkSHA1(hash, password string) bool {
+	sum := sha1.Sum([]byte(password))
+	expected := sha1Prefix + base64.StdEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+}
+
+const md5CryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// md5Crypt implements the MD5-crypt algorithm, as originally implemented by
+// Poul-Henning Kamp for FreeBSD, and returns the full hash.
+func md5Crypt(password, salt, magic []byte) []byte {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	d := md5.New()
+	d.Write(password)
+	d.Write(magic)
+	d.Write(salt)
+
+	d2 := md5.New()
+	d2.Write(password)
+	d2.Write(salt)
+	d2.Write(password)
+	mixin := d2.Sum(nil)
+
+	for i := len(password); i > 0; i -= 16 {
+		d.Write(mixin[:min(i, 16)])
+	}
+
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(password[:1])
+		}
+	}
+
+	final := d.Sum(nil)
+
+	// Slow things down, as in the original implementation.
+	for i := 0; i < 1000; i++ {
+		d := md5.New()
+		if i&1 == 1 {
+			d.Write(password)
+		} else {
+			d.Write(final)
+		}
+		if i%3 != 0 {
+			d.Write(salt)
+		}
+		if i%7 != 0 {
+			d.Write(password)
+		}
+		if i&1 == 1 {
+			d.Write(final)
+		} else {
+			d.Write(password)
+		}
+		final = d.Sum(nil)
+	}
+
+	result := make([]byte, 0, len(magic)+len(salt)+1+22)
+	result = append(result, magic...)
+	result = append(result, salt...)
+	result = append(result, '$')
+
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			result = append(result, md5CryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(final[i[0]])<<16|uint(final[i[1]])<<8|uint(final[i[2]]), 4)
+	}
+	encode(uint(final[11]), 2)
+
+	return result
+}
@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMD5Crypt(t *testing.T) {
+	t.Parallel()
+
+	// Generated with "openssl passwd -apr1" and "openssl passwd -1".
+	require.Equal(t, "$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/", string(md5Crypt([]byte("secret"), []byte("abcdefgh"), []byte(apr1Magic))))
+	require.Equal(t, "$1$abcdefgh$cHJi5PXp/ki/ktXzqlk6I1", string(md5Crypt([]byte("secret"), []byte("abcdefgh"), []byte(md5CryptMagic))))
+
+	require.True(t, checkMD5Crypt("$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/", "secret"))
+	require.False(t, checkMD5Crypt("$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/", "wrong"))
+	require.False(t, checkMD5Crypt("$apr1$nosalt", "secret"))
+}
+
+func TestSHA1(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, checkSHA1("{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "secret"))
+	require.False(t, checkSHA1("{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "wrong"))
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	t.Parallel()
+
+	users, err := parseHtpasswd(strings.NewReader(`
+# Comment
+alice:$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/
+
+bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=
+`))
+	require.NoError(t, err)
+	require.Equal(t, []User{
+		{Username: "alice", Password: "$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/"},
+		{Username: "bob", Password: "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="},
+	}, users)
+
+	_, err = parseHtpasswd(strings.NewReader("alice"))
+	require.ErrorContains(t, err, "line 1")
+
+	_, err = parseHtpasswd(strings.NewReader("alice:plaintext"))
+	require.ErrorContains(t, err, "unsupported password format")
+}
+
+func TestUsersFile(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("carol"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	usersFile := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(t, os.WriteFile(usersFile, []byte(strings.Join([]string{
+		"alice:$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/",
+		"bob:$1$abcdefgh$cHJi5PXp/ki/ktXzqlk6I1",
+		"carol:" + string(hash),
+		"dave:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+	}, "\n")), 0666))
+
+	h := newTestHandler(t, &Config{
+		Auth:        true,
+		UsersFile:   usersFile,
+		Permissions: Permissions{Modify: true},
+		Users: []User{
+			// Users in the configuration override the ones in the file.
+			{Username: "dave", Password: "dave"},
+		},
+	})
+
+	for username, password := range map[string]string{
+		"alice": "secret",
+		"bob":   "secret",
+		"carol": "carol",
+		"dave":  "dave",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.SetBasicAuth(username, password)
+		require.Equal(t, http.StatusOK, doRequest(h, r).Code, username)
+
+		r = httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.SetBasicAuth(username, "wrong")
+		require.Equal(t, http.StatusUnauthorized, doRequest(h, r).Code, username)
+	}
+
+	// Users from the file get the default permissions.
+	r := httptest.NewRequest(http.MethodPut, "/new.txt", strings.NewReader("content"))
+	r.SetBasicAuth("alice", "secret")
+	require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+
+	r = httptest.NewRequest(http.MethodPut, "/new.txt", strings.NewReader("content"))
+	r.SetBasicAuth("dave", "dave")
+	require.Equal(t, http.StatusForbidden, doRequest(h, r).Code)
+}
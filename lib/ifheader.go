@@ -0,0 +1,255 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+)
+
+// ifList is a list of conditions of the If header, which must all be true for
+// the list to match, and the resource they apply to, if tagged.
+//
+// See https://www.rfc-editor.org/rfc/rfc4918#section-10.4
+type ifList struct {
+	resource   string
+	conditions []webdav.Condition
+}
+
+// parseIfHeader parses the If header in the same way as [webdav.Handler] does,
+// but reports why it is malformed, if it is.
+func parseIfHeader(header string) ([]ifList, error) {
+	var (
+		lists   []ifList
+		list    *ifList
+		tagged  bool
+		tag     string
+		tagged0 = -1 // Index of the first list of the current tag.
+		not     bool
+	)
+
+	s := strings.TrimSpace(header)
+	for offset := 0; ; {
+		for offset < len(s) && (s[offset] == ' ' || s[offset] == '\t') {
+			offset++
+		}
+		if offset == len(s) {
+			break
+		}
+
+		switch c := s[offset]; {
+		case c == '<' || c == '[':
+			closing := byte('>')
+			if c == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(s[offset:], closing)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated %q at offset %d", c, offset)
+			}
+			value := s[offset+1 : offset+end]
+
+			switch {
+			case list != nil && c == '<':
+				list.conditions = append(list.conditions, webdav.Condition{Not: not, Token: value})
+				not = false
+			case list != nil:
+				list.conditions = append(list.conditions, webdav.Condition{Not: not, ETag: value})
+				not = false
+			case c == '[':
+				return nil, fmt.Errorf("entity tag outside of a list at offset %d", offset)
+			case len(lists) > 0 && !tagged:
+				return nil, fmt.Errorf("resource tag after untagged lists at offset %d", offset)
+			case tagged && tagged0 == len(lists):
+				return nil, fmt.Errorf("resource tag without lists at offset %d", offset)
+			default:
+				tagged, tag, tagged0 = true, value, len(lists)
+			}
+			offset += end + 1
+
+		case c == '(':
+			if list != nil {
+				return nil, fmt.Errorf("nested list at offset %d", offset)
+			}
+			list = &ifList{resource: tag}
+			offset++
+
+		case c == ')':
+			switch {
+			case list == nil:
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, offset)
+			case not:
+				return nil, fmt.Errorf("\"Not\" without a condition at offset %d", offset)
+			case len(list.conditions) == 0:
+				return nil, fmt.Errorf("empty list at offset %d", offset)
+			}
+			lists = append(lists, *list)
+			list = nil
+			offset++
+
+		default:
+			end := strings.IndexAny(s[offset:], " \t()<>[]")
+			if end < 0 {
+				end = len(s) - offset
+			}
+			word := s[offset : offset+end]
+
+			switch {
+			case list == nil:
+				return nil, fmt.Errorf("unexpected %q at offset %d", word, offset)
+			case word == "Not" && not:
+				return nil, fmt.Errorf("repeated \"Not\" at offset %d", offset)
+			case word == "Not":
+				not = true
+			default:
+				// Like the WebDAV handler, accept tokens that are not enclosed
+				// in angle brackets.
+				list.conditions = append(list.conditions, webdav.Condition{Not: not, Token: word})
+				not = false
+			}
+			offset += end
+		}
+	}
+
+	switch {
+	case list != nil:
+		return nil, fmt.Errorf("unterminated list")
+	case len(lists) == 0:
+		return nil, fmt.Errorf("no lists")
+	case tagged && tagged0 == len(lists):
+		return nil, fmt.Errorf("resource tag without lists")
+	}
+	return lists, nil
+}
+
+// evaluatedList is the outcome of the evaluation of a list of the If header.
+type evaluatedList struct {
+	Resource   string               `json:"resource"`
+	Matched    bool                 `json:"matched"`
+	Error      string               `json:"error,omitempty"`
+	Conditions []evaluatedCondition `json:"conditions"`
+}
+
+// evaluatedCondition is the outcome of the evaluation of a single condition.
+// State tokens match if they identify a lock on the resource, and entity tags
+// if they are the current entity tag of the resource. Note that the lock
+// system, and therefore the WebDAV handler, ignores entity tags and "Not".
+type evaluatedCondition struct {
+	Not     bool   `json:"not,omitempty"`
+	Token   string `json:"token,omitempty"`
+	ETag    string `json:"etag,omitempty"`
+	Matched bool   `json:"matched"`
+	Current string `json:"current_etag,omitempty"`
+}
+
+// evaluateIfHeader evaluates each list of the If header against the lock
+// system, in the same way the WebDAV handler does, and each of their
+// conditions on their own, so that it is possible to tell which ones
+// mismatched.
+func (u *handlerUser) evaluateIfHeader(r *http.Request, lists []ifList) []evaluatedList {
+	src := u.resourceName(r.URL.Path)
+
+	// Moves and copies must also be allowed to modify the destination.
+	dst := ""
+	if r.Method == "MOVE" || r.Method == "COPY" {
+		if d, err := url.Parse(r.Header.Get("Destination")); err == nil && d.Path != "" {
+			dst = u.resourceName(d.Path)
+		}
+	}
+
+	evaluated := make([]evaluatedList, 0, len(lists))
+	for _, l := range lists {
+		e := evaluatedList{Resource: src}
+		if l.resource != "" {
+			resource, err := url.Parse(l.resource)
+			switch {
+			case err != nil:
+				e.Error = "invalid resource tag"
+			case resource.Host != r.Host:
+				e.Error = "resource on another host"
+			default:
+				e.Resource = u.resourceName(resource.Path)
+			}
+		}
+
+		for _, c := range l.conditions {
+			ec := evaluatedCondition{Not: c.Not, Token: c.Token, ETag: c.ETag}
+			if e.Error == "" {
+				if c.ETag != "" {
+					ec.Current = u.currentETag(r, e.Resource)
+					ec.Matched = ec.Current == c.ETag
+				} else {
+					ec.Matched = u.confirm(e.Resource, "", webdav.Condition{Token: c.Token})
+				}
+			}
+			e.Conditions = append(e.Conditions, ec)
+		}
+
+		if e.Error == "" {
+			e.Matched = u.confirm(e.Resource, dst, l.conditions...)
+		}
+		evaluated = append(evaluated, e)
+	}
+	return evaluated
+}
+
+// resourceName returns the name of the resource at the path, as known to the
+// lock system.
+func (u *handlerUser) resourceName(p string) string {
+	return path.Clean("/" + strings.TrimPrefix(p, u.Prefix))
+}
+
+// confirm reports whether the lock system confirms the conditions, releasing
+// the locks right away.
+func (u *handlerUser) confirm(name0, name1 string, conditions ...webdav.Condition) bool {
+	release, err := u.LockSystem.Confirm(time.Now(), name0, name1, conditions...)
+	if err != nil {
+		return false
+	}
+	release()
+	return true
+}
+
+// currentETag returns the entity tag of the resource, or an empty string if it
+// does not exist.
+func (u *handlerUser) currentETag(r *http.Request, name string) string {
+	info, err := u.FileSystem.Stat(r.Context(), name)
+	if err != nil {
+		return ""
+	}
+	etag, err := fileETag(r.Context(), info)
+	if err != nil {
+		return ""
+	}
+	return etag
+}
+
+// logIfHeader logs the If header of a conditional request that failed, along
+// with its evaluation.
+func (h *handler) logIfHeader(r *http.Request, user *handlerUser, status int) {
+	header := r.Header.Get("If")
+	fields := []zap.Field{
+		zap.String("username", user.Username),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.Int("status", status),
+		zap.String("if", header),
+	}
+
+	lists, err := parseIfHeader(header)
+	if err != nil {
+		requestLogger(r, h.logger).Info("malformed if header", append(fields, zap.Error(err))...)
+		return
+	}
+	if status == http.StatusBadRequest {
+		// The request failed for another reason.
+		return
+	}
+
+	requestLogger(r, h.logger).Info("conditional request failed", append(fields, zap.Any("lists", user.evaluateIfHeader(r, lists)))...)
+}
@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/net/webdav"
+)
+
+func TestParseIfHeader(t *testing.T) {
+	t.Parallel()
+
+	lists, err := parseIfHeader(`(<urn:a> ["etag"]) (Not <urn:b>)`)
+	require.NoError(t, err)
+	require.Equal(t, []ifList{
+		{conditions: []webdav.Condition{{Token: "urn:a"}, {ETag: `"etag"`}}},
+		{conditions: []webdav.Condition{{Not: true, Token: "urn:b"}}},
+	}, lists)
+
+	lists, err = parseIfHeader(`<http://example.com/a> (<urn:a>) (<urn:b>) </b> (Not ["etag"])`)
+	require.NoError(t, err)
+	require.Equal(t, []ifList{
+		{resource: "http://example.com/a", conditions: []webdav.Condition{{Token: "urn:a"}}},
+		{resource: "http://example.com/a", conditions: []webdav.Condition{{Token: "urn:b"}}},
+		{resource: "/b", conditions: []webdav.Condition{{Not: true, ETag: `"etag"`}}},
+	}, lists)
+
+	for _, header := range []string{
+		"",
+		"urn:a",
+		"(<urn:a>",
+		"(<urn:a)",
+		"()",
+		"(Not)",
+		"(Not Not <urn:a>)",
+		"((<urn:a>))",
+		"(<urn:a>) <http://example.com/a> (<urn:b>)",
+		"<http://example.com/a> <http://example.com/b> (<urn:b>)",
+		"<http://example.com/a>",
+		`["etag"]`,
+	} {
+		t.Run(header, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := parseIfHeader(header)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestIfHeaderLogging(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T, enabled bool) (http.Handler, *observer.ObservedLogs, string) {
+		core, logs := observer.New(zapcore.InfoLevel)
+		h := newTestHandler(t, &Config{
+			Logger:        zap.New(core),
+			DebugIfHeader: enabled,
+			Permissions:   Permissions{Modify: true},
+		})
+
+		w := doRequest(h, lockRequest("/file.txt"))
+		require.Equal(t, http.StatusOK, w.Code)
+		token := strings.Trim(w.Header().Get("Lock-Token"), "<>")
+		require.NotEmpty(t, token)
+
+		return h, logs, token
+	}
+
+	put := func(h http.Handler, header string) int {
+		r := httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("new content"))
+		r.Header.Set("If", header)
+		return doRequest(h, r).Code
+	}
+
+	t.Run("Mismatched", func(t *testing.T) {
+		t.Parallel()
+
+		h, logs, token := newHandler(t, true)
+		require.Equal(t, http.StatusPreconditionFailed, put(h, "<http://example.com/file.txt> (<opaquelocktoken:wrong>) <http://other.com/file.txt> (<"+token+">)"))
+
+		entries := logs.FilterMessage("conditional request failed").AllUntimed()
+		require.Len(t, entries, 1)
+
+		fields := entries[0].ContextMap()
+		require.Equal(t, http.MethodPut, fields["method"])
+		require.Equal(t, "/file.txt", fields["path"])
+		require.Equal(t, int64(http.StatusPreconditionFailed), fields["status"])
+		require.Equal(t, []evaluatedList{
+			{Resource: "/file.txt", Conditions: []evaluatedCondition{{Token: "opaquelocktoken:wrong"}}},
+			{Resource: "/file.txt", Error: "resource on another host", Conditions: []evaluatedCondition{{Token: token}}},
+		}, fields["lists"])
+	})
+
+	t.Run("Entity Tags", func(t *testing.T) {
+		t.Parallel()
+
+		h, logs, token := newHandler(t, true)
+		require.Equal(t, http.StatusCreated, put(h, `(<`+token+`> ["wrong"])`))
+
+		// The lock system ignores the entity tags, so the token is enough.
+		require.Zero(t, logs.FilterMessage("conditional request failed").Len())
+
+		require.Equal(t, http.StatusPreconditionFailed, put(h, `(<opaquelocktoken:wrong> ["wrong"])`))
+		entries := logs.FilterMessage("conditional request failed").AllUntimed()
+		require.Len(t, entries, 1)
+
+		lists := entries[0].ContextMap()["lists"].([]evaluatedList)
+		require.Len(t, lists, 1)
+		require.Len(t, lists[0].Conditions, 2)
+		require.False(t, lists[0].Conditions[1].Matched)
+		require.Equal(t, `"wrong"`, lists[0].Conditions[1].ETag)
+		require.NotEmpty(t, lists[0].Conditions[1].Current)
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		t.Parallel()
+
+		h, logs, token := newHandler(t, true)
+		require.Equal(t, http.StatusBadRequest, put(h, "(<"+token+">"))
+
+		entries := logs.FilterMessage("malformed if header").AllUntimed()
+		require.Len(t, entries, 1)
+		require.Equal(t, "unterminated list", entries[0].ContextMap()["error"])
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		h, logs, _ := newHandler(t, false)
+		require.Equal(t, http.StatusPreconditionFailed, put(h, "(<opaquelocktoken:wrong>)"))
+		require.Equal(t, http.StatusBadRequest, put(h, "(<opaquelocktoken:wrong>"))
+		require.Zero(t, logs.FilterMessage("conditional request failed").Len())
+		require.Zero(t, logs.FilterMessage("malformed if header").Len())
+	})
+}
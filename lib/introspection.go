@@ -0,0 +1,198 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var errInactiveToken = errors.New("token is not active")
+
+// introspectionAuth authenticates users by a bearer token, which is checked
+// against an OAuth 2.0 token introspection endpoint, as defined in RFC 7662.
+// The results are cached, so that the endpoint is not requested every time.
+type introspectionAuth struct {
+	url           string
+	clientID      string
+	clientSecret  string
+	usernameField string
+	client        *http.Client
+	cache         *introspectionCache
+}
+
+func newIntrospectionAuth(c Introspection) *introspectionAuth {
+	return &introspectionAuth{
+		url:           c.URL,
+		clientID:      c.ClientID,
+		clientSecret:  c.ClientSecret,
+		usernameField: c.UsernameField,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		cache:         newIntrospectionCache(c.CacheTTL),
+	}
+}
+
+// username introspects the token and returns the username it belongs to.
+func (a *introspectionAuth) username(ctx context.Context, token string) (string, error) {
+	now := time.Now()
+	key := sha256.Sum256([]byte(token))
+	if entry, ok := a.cache.get(now, key); ok {
+		return entry.username, entry.err
+	}
+
+	username, expires, err := a.introspect(ctx, token)
+	if err != nil && !errors.Is(err, errInactiveToken) {
+		// The endpoint may be temporarily unavailable, so nothing is cached.
+		return "", err
+	}
+
+	a.cache.put(now, key, username, expires, err)
+	return username, err
+}
+
+// introspect requests the endpoint, and returns the username and when the
+// token expires, if known.
+func (a *introspectionAuth) introspect(ctx context.Context, token string) (string, time.Time, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.clientID), url.QueryEscape(a.clientSecret))
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to introspect token: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("failed to introspect token: unexpected status %d", res.StatusCode)
+	}
+
+	var fields map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&fields); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to decode introspection response: %w", err)
+	}
+
+	var expires time.Time
+	if exp, ok := fields["exp"].(float64); ok {
+		expires = time.Unix(int64(exp), 0)
+	}
+
+	// Do not trust the endpoint to check the expiration.
+	if active, _ := fields["active"].(bool); !active || (!expires.IsZero() && !time.Now().Before(expires)) {
+		return "", expires, errInactiveToken
+	}
+
+	username, ok := fields[a.usernameField].(string)
+	if !ok || username == "" {
+		return "", expires, fmt.Errorf("field %q is missing", a.usernameField)
+	}
+
+	return username, expires, nil
+}
+
+// introspectionCache keeps the results of the introspections, by hash of the
+// token, so that the tokens themselves are not kept in memory.
+type introspectionCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[[sha256.Size]byte]introspectionEntry
+	lastSweep time.Time
+}
+
+type introspectionEntry struct {
+	username string
+	err      error
+	expires  time.Time
+}
+
+func newIntrospectionCache(ttl time.Duration) *introspectionCache {
+	return &introspectionCache{ttl: ttl, entries: map[[sha256.Size]byte]introspectionEntry{}}
+}
+
+func (c *introspectionCache) get(now time.Time, key [sha256.Size]byte) (introspectionEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !now.Before(entry.expires) {
+		return introspectionEntry{}, false
+	}
+	return entry, true
+}
+
+// put caches the result for the TTL, or until the token expires, if sooner.
+func (c *introspectionCache) put(now time.Time, key [sha256.Size]byte, username string, expires time.Time, err error) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweep(now)
+
+	if expires.IsZero() || expires.After(now.Add(c.ttl)) {
+		expires = now.Add(c.ttl)
+	}
+	c.entries[key] = introspectionEntry{username: username, err: err, expires: expires}
+}
+
+// sweep removes the expired entries, so that the map does not grow
+// indefinitely. It runs at most once per TTL.
+func (c *introspectionCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// introspectionAuthenticate authenticates the request using a bearer token
+// checked against the introspection endpoint. If it fails, the response is
+// written and false is returned.
+func (h *handler) introspectionAuthenticate(w http.ResponseWriter, r *http.Request) (*handlerUser, bool) {
+	token, ok := bearerToken(r)
+	if !ok {
+		h.setChallenge(w, AuthMethodIntrospection, `Bearer realm="`+h.realm+`"`)
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	}
+
+	username, err := h.introspection.username(r.Context(), token)
+	if err != nil {
+		requestLogger(r, h.logger).Info("invalid token", zap.String("remote_address", r.RemoteAddr), zap.Error(err))
+		h.setChallenge(w, AuthMethodIntrospection, `Bearer realm="`+h.realm+`", error="invalid_token"`)
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	}
+
+	user, ok := h.lookupUser(username)
+	if !ok {
+		requestLogger(r, h.logger).Info("unknown token user", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
+		h.setChallenge(w, AuthMethodIntrospection, `Bearer realm="`+h.realm+`", error="invalid_token"`)
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	}
+
+	requestLogger(r, h.logger).Info("user authorized", zap.String("username", username))
+	return user, true
+}
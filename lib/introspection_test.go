@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newIntrospectionServer returns a mocked introspection endpoint, which knows
+// the given tokens, and counts the requests it gets.
+func newIntrospectionServer(t *testing.T, tokens map[string]map[string]interface{}) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "webdav" || secret != "secret" || r.Method != http.MethodPost {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		response, ok := tokens[r.PostFormValue("token")]
+		if !ok {
+			response = map[string]interface{}{"active": false}
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestHandlerIntrospection(t *testing.T) {
+	t.Parallel()
+
+	server, requests := newIntrospectionServer(t, map[string]map[string]interface{}{
+		"alice":   {"active": true, "username": "alice"},
+		"cached":  {"active": true, "username": "alice"},
+		"bob":     {"active": true, "username": "bob"},
+		"anon":    {"active": true},
+		"expired": {"active": true, "username": "alice", "exp": time.Now().Add(-time.Minute).Unix()},
+	})
+
+	h := newTestHandler(t, &Config{
+		Auth:       true,
+		AuthMethod: AuthMethodIntrospection,
+		Introspection: Introspection{
+			URL:          server.URL,
+			ClientID:     "webdav",
+			ClientSecret: "secret",
+			CacheTTL:     time.Minute,
+		},
+		Users: []User{{Username: "alice"}},
+	})
+
+	t.Run("Active", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, bearerRequest("alice"))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "content", w.Body.String())
+	})
+
+	for name, token := range map[string]string{
+		"Inactive":      "unknown",
+		"Unknown User":  "bob",
+		"Missing Field": "anon",
+		"No Token":      "",
+		"Basic Instead": "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			r := bearerRequest(token)
+			if name == "Basic Instead" {
+				r.SetBasicAuth("alice", "")
+			}
+
+			w := doRequest(h, r)
+			require.Equal(t, http.StatusUnauthorized, w.Code)
+			require.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+		})
+	}
+
+	// Not parallel, so that the other requests are not counted.
+	t.Run("Cache", func(t *testing.T) {
+		// Only the first request goes to the endpoint.
+		before := requests.Load()
+		for i := 0; i < 3; i++ {
+			require.Equal(t, http.StatusOK, doRequest(h, bearerRequest("cached")).Code)
+		}
+		require.Equal(t, before+1, requests.Load())
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		t.Parallel()
+
+		require.Equal(t, http.StatusUnauthorized, doRequest(h, bearerRequest("expired")).Code)
+	})
+}
+
+func TestIntrospectionCache(t *testing.T) {
+	t.Parallel()
+
+	server, requests := newIntrospectionServer(t, map[string]map[string]interface{}{
+		"alice": {"active": true, "username": "alice"},
+		"soon":  {"active": true, "username": "alice", "exp": time.Now().Add(2 * time.Second).Unix()},
+	})
+
+	a := newIntrospectionAuth(Introspection{URL: server.URL, ClientID: "webdav", ClientSecret: "secret", UsernameField: "username", CacheTTL: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		username, err := a.username(context.Background(), "alice")
+		require.NoError(t, err)
+		require.Equal(t, "alice", username)
+
+		_, err = a.username(context.Background(), "inactive")
+		require.ErrorIs(t, err, errInactiveToken)
+	}
+	require.EqualValues(t, 2, requests.Load())
+
+	// The entries expire with the TTL, or with the token.
+	key := sha256.Sum256([]byte("alice"))
+	_, ok := a.cache.get(time.Now().Add(time.Minute+time.Second), key)
+	require.False(t, ok)
+
+	_, err := a.username(context.Background(), "soon")
+	require.NoError(t, err)
+	_, ok = a.cache.get(time.Now().Add(5*time.Second), sha256.Sum256([]byte("soon")))
+	require.False(t, ok)
+
+	// The failures of the endpoint are not cached.
+	a.clientSecret = "wrong"
+	for i := 0; i < 2; i++ {
+		_, err := a.username(context.Background(), "other")
+		require.Error(t, err)
+		require.NotErrorIs(t, err, errInactiveToken)
+	}
+	require.EqualValues(t, 5, requests.Load())
+
+	// Without a TTL, nothing is cached.
+	a = newIntrospectionAuth(Introspection{URL: server.URL, ClientID: "webdav", ClientSecret: "secret", UsernameField: "username"})
+	for i := 0; i < 2; i++ {
+		_, err := a.username(context.Background(), "alice")
+		require.NoError(t, err)
+	}
+	require.EqualValues(t, 7, requests.Load())
+}
+
+func TestConfigIntrospection(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+auth: true
+auth_method: introspection
+introspection:
+  url: https://idp.example.com/introspect
+  client_id: webdav
+users:
+  - username: alice`, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, DefaultIntrospectionUsernameField, cfg.Introspection.UsernameField)
+	require.Equal(t, DefaultIntrospectionCacheTTL, cfg.Introspection.CacheTTL)
+
+	for name, c := range map[string]Introspection{
+		"No URL":       {ClientID: "webdav"},
+		"Relative URL": {URL: "/introspect", ClientID: "webdav"},
+		"No Client ID": {URL: "https://idp.example.com/introspect"},
+		"Negative TTL": {URL: "https://idp.example.com/introspect", ClientID: "webdav", CacheTTL: -time.Second},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := &Config{Auth: true, AuthMethod: AuthMethodIntrospection, Introspection: c, Users: []User{{Username: "alice"}}}
+			require.Error(t, cfg.Validate())
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package lib
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// jwksRefreshInterval is how often the keys are fetched from the JWKS URL.
+	jwksRefreshInterval = time.Hour
+
+	// jwksMinRefreshInterval is the minimum time between two fetches, when a
+	// token is signed with an unknown key.
+	jwksMinRefreshInterval = time.Minute
+)
+
+// jwtAlgorithms are the supported signing algorithms.
+var jwtAlgorithms = map[string]bool{
+	"HS256": true, "HS384": true, "HS512": true,
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+}
+
+// jwtAuth authenticates users by a JWT bearer token. The token is either
+// signed with a shared secret, or with one of the keys published at a JWKS URL.
+type jwtAuth struct {
+	algorithm     string
+	usernameClaim string
+	anonymous     bool
+	secret        []byte
+	jwks          *jwks
+}
+
+func newJWTAuth(c JWT) *jwtAuth {
+	a := &jwtAuth{
+		algorithm:     c.Algorithm,
+		usernameClaim: c.UsernameClaim,
+		anonymous:     c.Anonymous,
+	}
+
+	if c.JWKSURL != "" {
+		a.jwks = &jwks{url: c.JWKSURL, client: &http.Client{Timeout: 10 * time.Second}}
+	} else {
+		a.secret = []byte(c.Secret)
+	}
+
+	return a
+}
+
+// bearerToken returns the bearer token of the request, if any.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// username validates the token and returns the username it holds.
+func (a *jwtAuth) username(ctx context.Context, token string) (string, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if a.jwks == nil {
+			return a.secret, nil
+		}
+
+		kid, _ := t.Header["kid"].(string)
+		return a.jwks.key(ctx, kid)
+	}, jwt.WithValidMethods([]string{a.algorithm}))
+	if err != nil {
+		return "", err
+	}
+
+	username, ok := claims[a.usernameClaim].(string)
+	if !ok || username == "" {
+		return "", fmt.Errorf("claim %q is missing", a.usernameClaim)
+	}
+
+	return username, nil
+}
+
+// jwks fetches and caches the public keys published at a JWKS URL.
+type jwks struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]interface{}
+	fetched time.Time
+
+	// attempted is when the keys were last fetched, successfully or not, and
+	// err is the error of that attempt. fetching is closed when the fetch in
+	// progress, if any, is done.
+	attempted time.Time
+	err       error
+	fetching  chan struct{}
+}
+
+// key returns the key with the given ID. The keys are fetched again if they
+// are outdated or if the key is unknown, as it may have been rotated. Only one
+// fetch is made at a time, and the known keys are used in the meantime.
+func (s *jwks) key(ctx context.Context, kid string) (interface{}, error) {
+	s.mu.Lock()
+	for {
+		key, ok := s.keys[kid]
+		if ok && time.Since(s.fetched) < jwksRefreshInterval {
+			s.mu.Unlock()
+			return key, nil
+		}
+
+		if time.Since(s.attempted) < jwksMinRefreshInterval || (ok && s.fetching != nil) {
+			err := s.err
+			s.mu.Unlock()
+			if ok {
+				// Keep using the known keys if the JWKS URL is temporarily unavailable.
+				return key, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+
+		if s.fetching == nil {
+			break
+		}
+		fetching := s.fetching
+		s.mu.Unlock()
+		select {
+		case <-fetching:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		s.mu.Lock()
+	}
+
+	fetching := make(chan struct{})
+	s.fetching = fetching
+	s.mu.Unlock()
+
+	// The fetch is shared with the other requests, so it is not canceled with
+	// this one.
+	keys, err := s.fetch(context.WithoutCancel(ctx))
+
+	s.mu.Lock()
+	s.attempted = time.Now()
+	s.err = err
+	if err == nil {
+		s.keys = keys
+		s.fetched = s.attempted
+	}
+	s.fetching = nil
+	close(fetching)
+	key, ok := s.keys[kid]
+	s.mu.Unlock()
+
+	switch {
+	case ok:
+		return key, nil
+	case err != nil:
+		return nil, err
+	default:
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (s *jwks) fetch(ctx context.Context) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: unexpected status %d", res.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			// Skip the keys that are not supported, so that the others can be used.
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,259 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func signJWT(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	require.NoError(t, err)
+	return s
+}
+
+func bearerRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestHandlerJWT(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("secret")
+	h := newTestHandler(t, &Config{
+		Auth:       true,
+		AuthMethod: AuthMethodJWT,
+		JWT: JWT{
+			Secret:        string(secret),
+			Algorithm:     "HS256",
+			UsernameClaim: "preferred_username",
+		},
+		Users: []User{{Username: "alice"}},
+	})
+
+	valid := jwt.MapClaims{"preferred_username": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+
+	t.Run("Valid", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, bearerRequest(signJWT(t, jwt.SigningMethodHS256, secret, "", valid)))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	for name, token := range map[string]string{
+		"Expired":           signJWT(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"preferred_username": "alice", "exp": time.Now().Add(-time.Minute).Unix()}),
+		"Wrong Secret":      signJWT(t, jwt.SigningMethodHS256, []byte("wrong"), "", valid),
+		"Wrong Algorithm":   signJWT(t, jwt.SigningMethodHS512, secret, "", valid),
+		"Unknown User":      signJWT(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"preferred_username": "bob"}),
+		"Missing Claim":     signJWT(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"sub": "alice"}),
+		"Malformed":         "not-a-token",
+		"Unsigned":          signJWT(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", valid),
+		"Basic Credentials": "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			r := bearerRequest(token)
+			if token == "" {
+				r.SetBasicAuth("alice", "")
+			}
+
+			w := doRequest(h, r)
+			require.Equal(t, http.StatusUnauthorized, w.Code)
+			require.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+		})
+	}
+}
+
+func TestHandlerJWTAnonymous(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Permissions: Permissions{Modify: false},
+		Auth:        true,
+		AuthMethod:  AuthMethodJWT,
+		JWT: JWT{
+			Secret:        "secret",
+			Algorithm:     "HS256",
+			UsernameClaim: "sub",
+			Anonymous:     true,
+		},
+		Users: []User{{Username: "alice", Permissions: Permissions{Modify: true}}},
+	})
+
+	// Without a token, the default user is used.
+	require.Equal(t, http.StatusOK, doRequest(h, bearerRequest("")).Code)
+	require.Equal(t, http.StatusForbidden, doRequest(h, httptest.NewRequest(http.MethodDelete, "/file.txt", nil)).Code)
+
+	// An invalid token is still rejected.
+	require.Equal(t, http.StatusUnauthorized, doRequest(h, bearerRequest("not-a-token")).Code)
+}
+
+func TestHandlerJWKS(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{"kty": "oct", "kid": "ignored", "k": "c2VjcmV0"},
+				{
+					"kty": "RSA",
+					"kid": "key",
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	h := newTestHandler(t, &Config{
+		Auth:       true,
+		AuthMethod: AuthMethodJWT,
+		JWT: JWT{
+			JWKSURL:       server.URL,
+			Algorithm:     "RS256",
+			UsernameClaim: "sub",
+		},
+		Users: []User{{Username: "alice"}},
+	})
+
+	token := signJWT(t, jwt.SigningMethodRS256, key, "key", jwt.MapClaims{"sub": "alice"})
+	require.Equal(t, http.StatusOK, doRequest(h, bearerRequest(token)).Code)
+	require.Equal(t, http.StatusOK, doRequest(h, bearerRequest(token)).Code)
+	require.Equal(t, 1, fetches)
+
+	// Unknown keys do not cause the keys to be fetched on every request.
+	token = signJWT(t, jwt.SigningMethodRS256, key, "other", jwt.MapClaims{"sub": "alice"})
+	require.Equal(t, http.StatusUnauthorized, doRequest(h, bearerRequest(token)).Code)
+	require.Equal(t, http.StatusUnauthorized, doRequest(h, bearerRequest(token)).Code)
+	require.Equal(t, 1, fetches)
+}
+
+func TestJWKSFetch(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Failed", func(t *testing.T) {
+		t.Parallel()
+
+		var fetches atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetches.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		// The failed fetches are not retried on every request either.
+		s := &jwks{url: server.URL, client: server.Client()}
+		_, err := s.key(context.Background(), "key")
+		require.ErrorContains(t, err, "unexpected status 500")
+		_, err = s.key(context.Background(), "key")
+		require.ErrorContains(t, err, "unexpected status 500")
+		require.EqualValues(t, 1, fetches.Load())
+	})
+
+	t.Run("Known Keys", func(t *testing.T) {
+		t.Parallel()
+
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []interface{}{}})
+		}))
+		defer server.Close()
+		defer close(release)
+
+		s := &jwks{
+			url:     server.URL,
+			client:  server.Client(),
+			keys:    map[string]interface{}{"key": "known"},
+			fetched: time.Now().Add(-2 * jwksRefreshInterval),
+		}
+
+		// The outdated keys are fetched again by the first request, while the
+		// others keep using them.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = s.key(context.Background(), "key")
+		}()
+		require.Eventually(t, func() bool {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return s.fetching != nil
+		}, time.Second, time.Millisecond)
+
+		key, err := s.key(context.Background(), "key")
+		require.NoError(t, err)
+		require.Equal(t, "known", key)
+
+		// Until the fetch is done, and the key is no longer published.
+		release <- struct{}{}
+		<-done
+		_, err = s.key(context.Background(), "key")
+		require.ErrorContains(t, err, "unknown key")
+	})
+}
+
+func TestConfigJWT(t *testing.T) {
+	t.Parallel()
+
+	for name, c := range map[string]JWT{
+		"No Key":            {Algorithm: "HS256", UsernameClaim: "sub"},
+		"Secret And JWKS":   {Secret: "secret", JWKSURL: "https://example.com", Algorithm: "HS256", UsernameClaim: "sub"},
+		"Unknown Algorithm": {Secret: "secret", Algorithm: "none", UsernameClaim: "sub"},
+		"Secret With RS256": {Secret: "secret", Algorithm: "RS256", UsernameClaim: "sub"},
+		"JWKS With HS256":   {JWKSURL: "https://example.com", Algorithm: "HS256", UsernameClaim: "sub"},
+		"Empty Claim":       {Secret: "secret", Algorithm: "HS256"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := &Config{Auth: true, AuthMethod: AuthMethodJWT, JWT: c, Users: []User{{Username: "alice"}}}
+			require.Error(t, cfg.Validate())
+		})
+	}
+
+	t.Run("Passwords Not Required", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{Auth: true, AuthMethod: AuthMethodJWT, JWT: JWT{Secret: "secret", Algorithm: "HS256", UsernameClaim: "sub"}, Users: []User{{Username: "alice"}}}
+		require.NoError(t, cfg.Validate())
+
+		cfg = &Config{Auth: true, Users: []User{{Username: "alice"}}}
+		require.Error(t, cfg.Validate())
+	})
+}
+
+func TestConfigJWTSecretEnv(t *testing.T) {
+	t.Setenv("WD_TEST_JWT_SECRET", "secret")
+
+	cfg := &Config{Auth: true, AuthMethod: AuthMethodJWT, JWT: JWT{Secret: "{env}WD_TEST_JWT_SECRET", Algorithm: "HS256", UsernameClaim: "sub"}, Users: []User{{Username: "alice"}}}
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, "secret", cfg.JWT.Secret)
+}
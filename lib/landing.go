@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"time"
+)
+
+// landing answers the GET requests to a path, usually the root, with either a
+// redirect or a static page, so that browsers do not get raw WebDAV responses.
+type landing struct {
+	path     string
+	redirect string
+	page     []byte
+	modTime  time.Time
+}
+
+func newLanding(c Landing) (*landing, error) {
+	l := &landing{path: c.Path, redirect: c.Redirect}
+	if c.Page != "" {
+		info, err := os.Stat(c.Page)
+		if err != nil {
+			return nil, err
+		}
+		l.page, err = os.ReadFile(c.Page)
+		if err != nil {
+			return nil, err
+		}
+		l.modTime = info.ModTime()
+	}
+	return l, nil
+}
+
+// matches reports whether the request is for the landing. Only GET and HEAD
+// requests are, since the WebDAV clients use the other methods on the path.
+func (l *landing) matches(r *http.Request) bool {
+	return (r.Method == http.MethodGet || r.Method == http.MethodHead) && r.URL.Path == l.path
+}
+
+func (l *landing) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if l.redirect != "" {
+		http.Redirect(w, r, l.redirect, http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, "", l.modTime, bytes.NewReader(l.page))
+}
@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLanding(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Redirect", func(t *testing.T) {
+		t.Parallel()
+
+		h := newTestHandler(t, &Config{
+			Auth:    true,
+			Users:   []User{{Username: "admin", Password: "admin"}},
+			Landing: Landing{Redirect: "https://example.com/help"},
+		})
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, "https://example.com/help", w.Header().Get("Location"))
+
+		// The other methods, and the other paths, behave normally.
+		r := httptest.NewRequest("PROPFIND", "/", nil)
+		require.Equal(t, http.StatusUnauthorized, doRequest(h, r).Code)
+		r.SetBasicAuth("admin", "admin")
+		require.Equal(t, http.StatusMultiStatus, doRequest(h, r).Code)
+
+		r = httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.SetBasicAuth("admin", "admin")
+		w = doRequest(h, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "content", w.Body.String())
+	})
+
+	t.Run("Page", func(t *testing.T) {
+		t.Parallel()
+
+		page := filepath.Join(t.TempDir(), "info.html")
+		require.NoError(t, os.WriteFile(page, []byte("<h1>Files</h1>"), 0666))
+
+		h := newTestHandler(t, &Config{Landing: Landing{Path: "/info", Page: page}})
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/info", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		require.Equal(t, "<h1>Files</h1>", w.Body.String())
+
+		w = doRequest(h, httptest.NewRequest(http.MethodHead, "/info", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Empty(t, w.Body.String())
+
+		// The root is not the landing anymore.
+		require.Equal(t, http.StatusMultiStatus, doRequest(h, httptest.NewRequest(http.MethodGet, "/", nil)).Code)
+	})
+}
+
+func TestConfigLanding(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+landing:
+  redirect: https://example.com`, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, Landing{Path: "/", Redirect: "https://example.com"}, cfg.Landing)
+
+	for name, landing := range map[string]Landing{
+		"Both":          {Redirect: "https://example.com", Page: "info.html"},
+		"Relative Path": {Path: "info", Redirect: "https://example.com"},
+		"Invalid URL":   {Redirect: "http://[::1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := &Config{Landing: landing}
+			require.Error(t, cfg.Validate())
+		})
+	}
+
+	// The page must exist.
+	cfg = &Config{Landing: Landing{Page: filepath.Join(t.TempDir(), "missing.html")}}
+	require.NoError(t, cfg.Validate())
+	_, err := NewHandler(cfg)
+	require.Error(t, err)
+}
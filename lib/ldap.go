@@ -0,0 +1,236 @@
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-ldap/ldap/v3"
+	"go.uber.org/zap"
+)
+
+var errLDAPGroupDenied = errors.New("user is not a member of any LDAP group")
+
+// ldapAuth authenticates users by binding to an LDAP server with their HTTP
+// Basic credentials. The users do not need to be configured: they get the
+// permissions of the first group they are a member of, or the default ones if
+// no groups are configured.
+type ldapAuth struct {
+	pool            *ldapPool
+	baseDN          string
+	userAttribute   string
+	groupBaseDN     string
+	memberAttribute string
+	groups          []LDAPGroup
+	defaults        User
+	backend         string
+	newUser         func(User) *handlerUser
+
+	mu    sync.Mutex
+	users map[string]ldapUser
+}
+
+// ldapUser is a user authenticated with LDAP, along with the index of the group
+// its permissions come from, or -1 for the default ones.
+type ldapUser struct {
+	group int
+	user  *handlerUser
+}
+
+func newLDAPAuth(c *Config, newUser func(User) *handlerUser) (*ldapAuth, error) {
+	u, err := url.Parse(c.LDAP.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &ldapPool{url: c.LDAP.URL, startTLS: c.LDAP.StartTLS, timeout: c.LDAP.Timeout, size: c.LDAP.PoolSize}
+
+	if u.Scheme == "ldaps" || c.LDAP.StartTLS {
+		pool.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+		if c.LDAP.CA != "" {
+			data, err := os.ReadFile(c.LDAP.CA)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read LDAP CA: %w", err)
+			}
+
+			pool.tls.RootCAs = x509.NewCertPool()
+			if !pool.tls.RootCAs.AppendCertsFromPEM(data) {
+				return nil, errors.New("failed to parse LDAP CA")
+			}
+		}
+	}
+
+	return &ldapAuth{
+		pool:            pool,
+		baseDN:          c.LDAP.BaseDN,
+		userAttribute:   c.LDAP.UserAttribute,
+		groupBaseDN:     c.LDAP.GroupBaseDN,
+		memberAttribute: c.LDAP.MemberAttribute,
+		groups:          c.LDAP.Groups,
+		defaults: User{
+			Permissions:   c.Permissions,
+			RateLimit:     c.RateLimit,
+			MaxUploadSize: c.MaxUploadSize,
+		},
+		backend: c.Backend,
+		newUser: newUser,
+		users:   map[string]ldapUser{},
+	}, nil
+}
+
+// authenticate binds with the credentials, and returns the index of the group
+// the user gets the permissions of, or -1 for the default ones.
+func (a *ldapAuth) authenticate(ctx context.Context, username, password string) (int, error) {
+	// An empty password makes an unauthenticated bind, which servers accept.
+	if username == "" || password == "" {
+		return -1, errLDAPInvalidCredentials
+	}
+
+	dn := a.userAttribute + "=" + ldap.EscapeDN(username) + "," + a.baseDN
+
+	var group int
+	err := a.withConn(ctx, func(conn *ldap.Conn) error {
+		if err := conn.Bind(dn, password); err != nil {
+			if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+				return fmt.Errorf("%w: %w", errLDAPInvalidCredentials, err)
+			}
+			return err
+		}
+
+		group = -1
+		if len(a.groups) == 0 {
+			return nil
+		}
+
+		res, err := conn.Search(ldap.NewSearchRequest(
+			a.groupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			"("+a.memberAttribute+"="+ldap.EscapeFilter(dn)+")", []string{"cn"}, nil,
+		))
+		if err != nil {
+			return err
+		}
+
+		for i, g := range a.groups {
+			for _, entry := range res.Entries {
+				for _, name := range entry.GetEqualFoldAttributeValues("cn") {
+					if strings.EqualFold(name, g.Name) {
+						group = i
+						return nil
+					}
+				}
+			}
+		}
+		return errLDAPGroupDenied
+	})
+	return group, err
+}
+
+// withConn calls fn with a connection of the pool. An idle connection may have
+// been closed by the server in the meantime, in which case fn is retried once
+// with a new connection.
+func (a *ldapAuth) withConn(ctx context.Context, fn func(*ldap.Conn) error) error {
+	conn, reused, err := a.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(conn)
+	if err != nil && reused && ldapBroken(conn, err) {
+		a.pool.put(conn, err)
+		if conn, err = a.pool.dial(ctx); err != nil {
+			return err
+		}
+		err = fn(conn)
+	}
+	a.pool.put(conn, err)
+	return err
+}
+
+// user returns the user with the permissions of the group. The users are kept,
+// so that their locks and usage are shared by their requests.
+func (a *ldapAuth) user(username string, group int) (*handlerUser, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if u, ok := a.users[username]; ok && u.group == group {
+		return u.user, nil
+	}
+
+	u := a.defaults
+	u.Username = username
+	if group >= 0 {
+		u.Permissions = a.groups[group].Permissions
+	}
+	if err := u.validate(false); err != nil {
+		return nil, err
+	}
+	if err := u.createHome(a.backend); err != nil {
+		return nil, err
+	}
+
+	user := a.newUser(u)
+	a.users[username] = ldapUser{group: group, user: user}
+	return user, nil
+}
+
+func (a *ldapAuth) close() {
+	a.pool.close()
+}
+
+// ldapAuthenticate authenticates the request using HTTP Basic authentication,
+// checked against the LDAP server. The configured users take precedence over
+// the group permissions. If it fails, the response is written and false is
+// returned.
+func (h *handler) ldapAuthenticate(w http.ResponseWriter, r *http.Request) (*handlerUser, bool) {
+	h.setChallenge(w, AuthMethodLDAP, `Basic realm="`+h.realm+`"`)
+
+	username, password, ok := r.BasicAuth()
+	requestLogger(r, h.logger).Info("login attempt", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
+	if !ok {
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	}
+
+	if h.rejectLockedOut(w, r, username) {
+		return nil, false
+	}
+
+	group, err := h.ldap.authenticate(r.Context(), username, password)
+	switch {
+	case errors.Is(err, errLDAPInvalidCredentials):
+		requestLogger(r, h.logger).Info("invalid password", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
+		h.loginFailed(r, username)
+		serveError(w, r, http.StatusUnauthorized, "Not authorized", "")
+		return nil, false
+	case errors.Is(err, errLDAPGroupDenied):
+		requestLogger(r, h.logger).Info("user denied by LDAP groups", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
+		serveError(w, r, http.StatusForbidden, "Forbidden", "")
+		return nil, false
+	case err != nil:
+		requestLogger(r, h.logger).Error("failed to authenticate with LDAP", zap.String("username", username), zap.Error(err))
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return nil, false
+	}
+
+	h.loginSucceeded(r, username)
+
+	user, ok := h.lookupUser(username)
+	if !ok {
+		user, err = h.ldap.user(username, group)
+		if err != nil {
+			requestLogger(r, h.logger).Error("failed to create LDAP user", zap.String("username", username), zap.Error(err))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return nil, false
+		}
+	}
+
+	requestLogger(r, h.logger).Info("user authorized", zap.String("username", username))
+	return user, true
+}
@@ -0,0 +1,260 @@
+package lib
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+const testLDAPBaseDN = "ou=people,dc=example,dc=com"
+
+// fakeLDAP is an LDAP server that knows the passwords of the users, and the
+// members of the groups, by DN.
+type fakeLDAP struct {
+	url       string
+	passwords map[string]string
+	groups    map[string][]string
+
+	mu    sync.Mutex
+	conns int
+}
+
+func newFakeLDAP(t *testing.T, config *tls.Config) *fakeLDAP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	url := "ldap://" + listener.Addr().String()
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+		url = "ldaps://" + listener.Addr().String()
+	}
+
+	s := &fakeLDAP{
+		url: url,
+		passwords: map[string]string{
+			"uid=alice," + testLDAPBaseDN: "alice",
+			"uid=bob," + testLDAPBaseDN:   "bob",
+			"uid=carol," + testLDAPBaseDN: "carol",
+		},
+		groups: map[string][]string{
+			"staff":   {"uid=alice," + testLDAPBaseDN},
+			"readers": {"uid=alice," + testLDAPBaseDN, "uid=bob," + testLDAPBaseDN},
+		},
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeLDAP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		message, err := ber.ReadPacket(conn)
+		if err != nil || len(message.Children) < 2 {
+			return
+		}
+
+		id, op := message.Children[0].Value, message.Children[1]
+		reply := func(op *ber.Packet) {
+			envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+			envelope.AppendChild(op)
+			_, _ = conn.Write(envelope.Bytes())
+		}
+		octetString := func(value string) *ber.Packet {
+			return ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "")
+		}
+		constructed := func(class ber.Class, tag ber.Tag, children ...*ber.Packet) *ber.Packet {
+			p := ber.Encode(class, ber.TypeConstructed, tag, nil, "")
+			for _, child := range children {
+				p.AppendChild(child)
+			}
+			return p
+		}
+		result := func(tag ber.Tag, code int) *ber.Packet {
+			return constructed(ber.ClassApplication, tag,
+				ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""),
+				octetString(""), octetString(""))
+		}
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			if password, ok := s.passwords[op.Children[1].Data.String()]; ok && password == op.Children[2].Data.String() {
+				reply(result(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess))
+			} else {
+				reply(result(ldap.ApplicationBindResponse, ldap.LDAPResultInvalidCredentials))
+			}
+		case ldap.ApplicationSearchRequest:
+			filter := op.Children[6]
+			for cn, members := range s.groups {
+				for _, member := range members {
+					if member == filter.Children[1].Data.String() {
+						reply(constructed(ber.ClassApplication, ldap.ApplicationSearchResultEntry,
+							octetString("cn="+cn+",ou=groups,dc=example,dc=com"),
+							constructed(ber.ClassUniversal, ber.TagSequence, constructed(ber.ClassUniversal, ber.TagSequence,
+								octetString("cn"),
+								constructed(ber.ClassUniversal, ber.TagSet, octetString(cn)),
+							)),
+						))
+					}
+				}
+			}
+			reply(result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		default:
+			return
+		}
+	}
+}
+
+func (s *fakeLDAP) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+func newLDAPTestHandler(t *testing.T, ldap LDAP) http.Handler {
+	ldap.BaseDN = testLDAPBaseDN
+	ldap.Groups = []LDAPGroup{
+		{Name: "staff", Permissions: Permissions{Modify: true}},
+		{Name: "readers"},
+	}
+	return newTestHandler(t, &Config{Auth: true, AuthMethod: AuthMethodLDAP, LDAP: ldap})
+}
+
+func TestHandlerLDAP(t *testing.T) {
+	t.Parallel()
+
+	request := func(h http.Handler, method, username, password string) int {
+		r := httptest.NewRequest(method, "/file.txt", strings.NewReader("new content"))
+		r.SetBasicAuth(username, password)
+		return doRequest(h, r).Code
+	}
+
+	t.Run("Groups", func(t *testing.T) {
+		t.Parallel()
+
+		server := newFakeLDAP(t, nil)
+		h := newLDAPTestHandler(t, LDAP{URL: server.url})
+
+		// The users get the permissions of the first group they are in.
+		require.Equal(t, http.StatusOK, request(h, http.MethodGet, "bob", "bob"))
+		require.Equal(t, http.StatusForbidden, request(h, http.MethodPut, "bob", "bob"))
+		require.Equal(t, http.StatusCreated, request(h, http.MethodPut, "alice", "alice"))
+		require.Equal(t, http.StatusOK, request(h, http.MethodGet, "alice", "alice"))
+
+		// The connection is reused by the requests.
+		require.Equal(t, 1, server.connections())
+	})
+
+	t.Run("Bad Password", func(t *testing.T) {
+		t.Parallel()
+
+		h := newLDAPTestHandler(t, LDAP{URL: newFakeLDAP(t, nil).url})
+		require.Equal(t, http.StatusUnauthorized, request(h, http.MethodGet, "alice", "wrong"))
+		require.Equal(t, http.StatusUnauthorized, request(h, http.MethodGet, "alice", ""))
+		require.Equal(t, http.StatusUnauthorized, request(h, http.MethodGet, "dave", "dave"))
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Equal(t, `Basic realm="`+DefaultRealm+`"`, w.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("Group Denied", func(t *testing.T) {
+		t.Parallel()
+
+		h := newLDAPTestHandler(t, LDAP{URL: newFakeLDAP(t, nil).url})
+		require.Equal(t, http.StatusForbidden, request(h, http.MethodGet, "carol", "carol"))
+	})
+
+	t.Run("Unavailable", func(t *testing.T) {
+		t.Parallel()
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		require.NoError(t, listener.Close())
+
+		h := newLDAPTestHandler(t, LDAP{URL: "ldap://" + listener.Addr().String()})
+		require.Equal(t, http.StatusServiceUnavailable, request(h, http.MethodGet, "alice", "alice"))
+	})
+
+	t.Run("TLS", func(t *testing.T) {
+		t.Parallel()
+
+		// Borrow the certificate of a test server, which is valid for 127.0.0.1.
+		ts := httptest.NewTLSServer(http.NotFoundHandler())
+		config := &tls.Config{Certificates: ts.TLS.Certificates}
+		ca := filepath.Join(t.TempDir(), "ca.pem")
+		require.NoError(t, os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0666))
+		ts.Close()
+
+		h := newLDAPTestHandler(t, LDAP{URL: newFakeLDAP(t, config).url, CA: ca})
+		require.Equal(t, http.StatusOK, request(h, http.MethodGet, "bob", "bob"))
+
+		// Without the CA, the certificate of the server is not trusted.
+		h = newLDAPTestHandler(t, LDAP{URL: newFakeLDAP(t, config).url})
+		require.Equal(t, http.StatusServiceUnavailable, request(h, http.MethodGet, "bob", "bob"))
+	})
+}
+
+func TestConfigLDAP(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+auth: true
+auth_method: ldap
+scope: /data
+modify: true
+ldap:
+  url: ldaps://ldap.example.com
+  base_dn: ou=people,dc=example,dc=com
+  groups:
+    - name: readers
+      modify: false
+    - name: admins
+`, ".yml")
+	require.Equal(t, DefaultLDAPUserAttribute, cfg.LDAP.UserAttribute)
+	require.Equal(t, DefaultLDAPMemberAttribute, cfg.LDAP.MemberAttribute)
+	require.Equal(t, cfg.LDAP.BaseDN, cfg.LDAP.GroupBaseDN)
+	require.Equal(t, DefaultLDAPPoolSize, cfg.LDAP.PoolSize)
+	require.Equal(t, DefaultLDAPTimeout, cfg.LDAP.Timeout)
+	require.Len(t, cfg.LDAP.Groups, 2)
+	require.False(t, cfg.LDAP.Groups[0].Modify)
+	require.True(t, cfg.LDAP.Groups[1].Modify)
+	require.Equal(t, cfg.Scope, cfg.LDAP.Groups[1].Scope)
+
+	for ldap, err := range map[*LDAP]string{
+		{URL: "http://ldap.example.com", BaseDN: "dc=example"}:                          "invalid LDAP URL",
+		{URL: "ldaps://ldap.example.com", BaseDN: "dc=example", StartTLS: true}:         "StartTLS cannot be used with an ldaps URL",
+		{URL: "ldap://ldap.example.com"}:                                                "base DN must be defined",
+		{URL: "ldap://ldap.example.com", BaseDN: "dc=example", PoolSize: -1}:            "cannot be negative",
+		{URL: "ldap://ldap.example.com", BaseDN: "dc=example", Groups: []LDAPGroup{{}}}: "group name must be defined",
+	} {
+		cfg := &Config{Auth: true, AuthMethod: AuthMethodLDAP, LDAP: *ldap}
+		require.ErrorContains(t, cfg.Validate(), err)
+	}
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var errLDAPInvalidCredentials = errors.New("invalid LDAP credentials")
+
+// ldapPool keeps the connections to the LDAP server open, so that they are not
+// dialed for every authentication.
+type ldapPool struct {
+	url      string
+	tls      *tls.Config
+	startTLS bool
+	timeout  time.Duration
+	size     int
+
+	mu     sync.Mutex
+	idle   []*ldap.Conn
+	closed bool
+}
+
+// get returns an idle connection, or dials a new one, in which case reused is
+// false.
+func (p *ldapPool) get(ctx context.Context) (conn *ldap.Conn, reused bool, err error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		conn = p.idle[n-1]
+		p.idle = p.idle[:n-1]
+	}
+	p.mu.Unlock()
+
+	if conn != nil {
+		conn.SetTimeout(p.requestTimeout(ctx))
+		return conn, true, nil
+	}
+
+	conn, err = p.dial(ctx)
+	return conn, false, err
+}
+
+// requestTimeout returns the timeout of the operations made for the request,
+// which do not outlive its deadline.
+func (p *ldapPool) requestTimeout(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return max(min(p.timeout, time.Until(deadline)), time.Millisecond)
+	}
+	return p.timeout
+}
+
+func (p *ldapPool) dial(ctx context.Context) (*ldap.Conn, error) {
+	timeout := p.requestTimeout(ctx)
+	conn, err := ldap.DialURL(p.url, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}), ldap.DialWithTLSConfig(p.tls))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+	conn.SetTimeout(timeout)
+
+	if p.startTLS {
+		if err := conn.StartTLS(p.tls); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS with LDAP server: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// ldapBroken reports whether the connection must not be used anymore, because
+// an operation failed other than by a result of the server.
+func ldapBroken(conn *ldap.Conn, err error) bool {
+	return conn.IsClosing() || ldap.IsErrorWithCode(err, ldap.ErrorNetwork)
+}
+
+// put returns the connection to the pool, unless the error of its last
+// operation broke it, or the pool is full or closed.
+func (p *ldapPool) put(conn *ldap.Conn, err error) {
+	p.mu.Lock()
+	if ldapBroken(conn, err) || p.closed || len(p.idle) >= p.size {
+		p.mu.Unlock()
+		closeLDAPConn(conn)
+		return
+	}
+	p.idle = append(p.idle, conn)
+	p.mu.Unlock()
+}
+
+// close closes the idle connections, and the ones put back afterwards.
+func (p *ldapPool) close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		closeLDAPConn(conn)
+	}
+}
+
+// closeLDAPConn unbinds the connection, which closes it, or only closes it if
+// it is already broken.
+func closeLDAPConn(conn *ldap.Conn) {
+	conn.SetTimeout(time.Second)
+	if conn.Unbind() != nil {
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// limitedBody is a request body that fails once more than a certain amount of
+// bytes has been read.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	err       error
+	exceeded  bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		b.exceeded = true
+		return 0, b.err
+	}
+	return n, err
+}
+
+// limitedResponseWriter replaces the status written by the WebDAV handler if
+// the limit of the request body was exceeded while it was being read. Otherwise,
+// the WebDAV handler would reply with 405 Method Not Allowed.
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	body        *limitedBody
+	status      int
+	wroteHeader bool
+}
+
+func (w *limitedResponseWriter) WriteHeader(status int) {
+	if w.body.exceeded && !w.wroteHeader {
+		status = w.status
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *limitedResponseWriter) Write(data []byte) (int, error) {
+	if w.body.exceeded {
+		if !w.wroteHeader {
+			w.WriteHeader(w.status)
+		}
+		// Discard the status text written by the WebDAV handler.
+		return len(data), nil
+	}
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(data)
+}
+
+// limitBody limits the body of the request to the given amount of bytes. If it
+// is exceeded, the request fails with the given status, and the upload is
+// discarded.
+func limitBody(w http.ResponseWriter, r *http.Request, limit int64, status int, err error) (http.ResponseWriter, *limitedBody) {
+	body := &limitedBody{ReadCloser: r.Body, remaining: limit, err: err}
+	r.Body = body
+
+	return &limitedResponseWriter{ResponseWriter: w, body: body, status: status}, body
+}
+
+// expectsContinue reports whether the client waits for 100 Continue before
+// sending the body of the request.
+func expectsContinue(r *http.Request) bool {
+	return r.ProtoAtLeast(1, 1) && r.ContentLength != 0 && strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxUploadSize(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T) (http.Handler, string) {
+		cfg := &Config{
+			Auth:          true,
+			MaxUploadSize: 10,
+			Users: []User{
+				{Username: "admin", Password: "admin", Permissions: Permissions{Modify: true}, MaxUploadSize: 10},
+				{Username: "trusted", Password: "trusted", Permissions: Permissions{Modify: true}, MaxUploadSize: 100},
+			},
+		}
+		h := newTestHandler(t, cfg)
+		return h, cfg.Scope
+	}
+
+	put := func(h http.Handler, username string, body io.Reader, contentLength int64) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, "/upload.bin", body)
+		r.ContentLength = contentLength
+		r.SetBasicAuth(username, username)
+		return doRequest(h, r)
+	}
+
+	t.Run("Within Limit", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t)
+		require.Equal(t, http.StatusCreated, put(h, "admin", bytes.NewReader(make([]byte, 10)), 10).Code)
+		require.Equal(t, http.StatusCreated, put(h, "admin", bytes.NewReader(make([]byte, 10)), -1).Code)
+	})
+
+	t.Run("Content-Length", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		body := &countingReader{Reader: bytes.NewReader(make([]byte, 11))}
+		require.Equal(t, http.StatusRequestEntityTooLarge, put(h, "admin", body, 11).Code)
+		require.Zero(t, body.n)
+		require.NoFileExists(t, filepath.Join(scope, "upload.bin"))
+	})
+
+	t.Run("Streaming", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		w := put(h, "admin", bytes.NewReader(make([]byte, 50)), -1)
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		require.NoFileExists(t, filepath.Join(scope, "upload.bin"))
+	})
+
+	t.Run("User Override", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		require.Equal(t, http.StatusCreated, put(h, "trusted", bytes.NewReader(make([]byte, 50)), -1).Code)
+
+		info, err := os.Stat(filepath.Join(scope, "upload.bin"))
+		require.NoError(t, err)
+		require.EqualValues(t, 50, info.Size())
+	})
+}
+
+func TestExpectContinue(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T) (http.Handler, string) {
+		cfg := &Config{
+			Auth:           true,
+			ExpectContinue: true,
+			Users: []User{
+				{Username: "admin", Password: "admin", Permissions: Permissions{Modify: true}, MaxUploadSize: 10, Quota: 15},
+				{Username: "reader", Password: "reader"},
+			},
+		}
+		h := newTestHandler(t, cfg)
+		return h, cfg.Scope
+	}
+
+	t.Run("Rejected", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		for _, tc := range []struct {
+			name          string
+			username      string
+			contentLength int64
+			status        int
+		}{
+			{"Unauthorized", "", 5, http.StatusUnauthorized},
+			{"Forbidden", "reader", 5, http.StatusForbidden},
+			{"Too Large", "admin", 11, http.StatusRequestEntityTooLarge},
+			{"Over Quota", "admin", 9, http.StatusInsufficientStorage},
+		} {
+			body := &countingReader{Reader: bytes.NewReader(make([]byte, tc.contentLength))}
+			r := httptest.NewRequest(http.MethodPut, "/upload.bin", body)
+			r.ContentLength = tc.contentLength
+			r.Header.Set("Expect", "100-continue")
+			if tc.username != "" {
+				r.SetBasicAuth(tc.username, tc.username)
+			}
+
+			require.Equal(t, tc.status, doRequest(h, r).Code, tc.name)
+			require.Zero(t, body.n, tc.name)
+		}
+		require.NoFileExists(t, filepath.Join(scope, "upload.bin"))
+	})
+
+	t.Run("Connection", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		srv := httptest.NewServer(h)
+		t.Cleanup(srv.Close)
+
+		// The client only sends the body once it got 100 Continue.
+		put := func(t *testing.T, username string) (*bufio.Reader, net.Conn, *http.Response) {
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = conn.Close() })
+
+			r, err := http.NewRequest(http.MethodPut, srv.URL+"/upload.bin", nil)
+			require.NoError(t, err)
+			r.SetBasicAuth(username, username)
+			_, err = fmt.Fprintf(conn, "PUT /upload.bin HTTP/1.1\r\nHost: %s\r\nAuthorization: %s\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n",
+				r.Host, r.Header.Get("Authorization"))
+			require.NoError(t, err)
+
+			br := bufio.NewReader(conn)
+			res, err := http.ReadResponse(br, r)
+			require.NoError(t, err)
+			return br, conn, res
+		}
+
+		_, _, res := put(t, "reader")
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+		br, conn, res := put(t, "admin")
+		require.Equal(t, http.StatusContinue, res.StatusCode)
+
+		_, err := io.Copy(conn, strings.NewReader("hello"))
+		require.NoError(t, err)
+		res, err = http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+
+		data, err := os.ReadFile(filepath.Join(scope, "upload.bin"))
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(data))
+	})
+}
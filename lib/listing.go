@@ -0,0 +1,336 @@
+package lib
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Index of {{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.25em 1em; text-align: left; }
+td.size { text-align: right; }
+</style>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<thead><tr><th><a href="{{.SortName}}">Name</a></th><th><a href="{{.SortSize}}">Size</a></th><th><a href="{{.SortMTime}}">Modified</a></th></tr></thead>
+<tbody>
+{{- if .Parent}}
+<tr><td><a href="{{.Parent}}">../</a></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td class="size">{{.Size}}</td><td>{{.Modified}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- if or .Previous .Next}}
+<p>
+{{- if .Previous}}<a href="{{.Previous}}">Previous</a>{{end}}
+{{- if and .Previous .Next}} | {{end}}
+{{- if .Next}}<a href="{{.Next}}">Next</a>{{end}}
+</p>
+{{- end}}
+</body>
+</html>
+`))
+
+type listing struct {
+	Path      string
+	Parent    string
+	Entries   []listingEntry
+	SortName  string
+	SortSize  string
+	SortMTime string
+	Previous  string
+	Next      string
+}
+
+// The orders the directory listings can be sorted by.
+const (
+	ListingSortName  = "name"
+	ListingSortSize  = "size"
+	ListingSortMTime = "mtime"
+
+	ListingOrderAsc  = "asc"
+	ListingOrderDesc = "desc"
+)
+
+var listingSorts = map[string]bool{
+	ListingSortName:  true,
+	ListingSortSize:  true,
+	ListingSortMTime: true,
+}
+
+// listingOptions are how the entries of a directory listing are sorted, and
+// which of them are shown. A limit of 0 shows all of them.
+type listingOptions struct {
+	sort   string
+	desc   bool
+	offset int
+	limit  int
+}
+
+// parseListingOptions overrides the default options with the "sort", "order",
+// "offset" and "limit" query parameters, if given.
+func parseListingOptions(query url.Values, defaults listingOptions) (listingOptions, error) {
+	options := defaults
+	options.offset = 0
+
+	if value := query.Get("sort"); value != "" {
+		if !listingSorts[value] {
+			return options, fmt.Errorf("unknown sort %q", value)
+		}
+		options.sort = value
+	}
+
+	switch value := query.Get("order"); value {
+	case "":
+	case ListingOrderAsc, ListingOrderDesc:
+		options.desc = value == ListingOrderDesc
+	default:
+		return options, fmt.Errorf("unknown order %q", value)
+	}
+
+	for name, dst := range map[string]*int{"offset": &options.offset, "limit": &options.limit} {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return options, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*dst = n
+		}
+	}
+	return options, nil
+}
+
+// sortInfos sorts the entries, always with the directories first. The entries
+// that are equal otherwise are sorted by name.
+func sortInfos(infos []os.FileInfo, options listingOptions) {
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := infos[i], infos[j]
+		if a.IsDir() != b.IsDir() {
+			return a.IsDir()
+		}
+		if options.desc {
+			a, b = b, a
+		}
+
+		switch {
+		case options.sort == ListingSortSize && a.Size() != b.Size():
+			return a.Size() < b.Size()
+		case options.sort == ListingSortMTime && !a.ModTime().Equal(b.ModTime()):
+			return a.ModTime().Before(b.ModTime())
+		}
+		return a.Name() < b.Name()
+	})
+}
+
+// query returns the query of the listing with the options, keeping the limit
+// only if it is not the default one.
+func (o listingOptions) query(defaults listingOptions) string {
+	query := url.Values{"sort": {o.sort}}
+	if o.desc {
+		query.Set("order", ListingOrderDesc)
+	}
+	if o.offset > 0 {
+		query.Set("offset", strconv.Itoa(o.offset))
+	}
+	if o.limit != defaults.limit {
+		query.Set("limit", strconv.Itoa(o.limit))
+	}
+	return "?" + query.Encode()
+}
+
+type listingEntry struct {
+	Name     string
+	Href     string
+	Size     string
+	Modified string
+}
+
+// acceptsHTML reports whether the client prefers HTML over XML, which is the
+// case for browsers, but not for WebDAV clients.
+func acceptsHTML(header string) bool {
+	html, xml := acceptQualities(header)
+	return html > 0 && html >= xml
+}
+
+// acceptsXML reports whether the Accept header prefers XML to HTML.
+func acceptsXML(header string) bool {
+	html, xml := acceptQualities(header)
+	return xml > 0 && xml > html
+}
+
+// acceptQualities returns the qualities of HTML and XML in the Accept header.
+func acceptQualities(header string) (html, xml float64) {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			quality, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "text/html", "application/xhtml+xml":
+			html = max(html, quality)
+		case "application/xml", "text/xml":
+			xml = max(xml, quality)
+		}
+	}
+	return html, xml
+}
+
+// indexFile returns the first of the index files that exists in the
+// directory, and that the user is allowed to read.
+func (h *handler) indexFile(r *http.Request, user *handlerUser, name string) (string, os.FileInfo, bool) {
+	for _, index := range h.indexFiles {
+		if !user.allowedMethod(r.Method, path.Join(r.URL.Path, index)) {
+			continue
+		}
+
+		info, err := user.FileSystem.Stat(r.Context(), path.Join(name, index))
+		if err == nil && !info.IsDir() {
+			return index, info, true
+		}
+	}
+	return "", nil, false
+}
+
+// serveListing renders the contents of the directory as HTML, sorted and
+// paginated as requested, or with the default options.
+func (u *handlerUser) serveListing(w http.ResponseWriter, r *http.Request, name string, defaults listingOptions) {
+	options, err := parseListingOptions(r.URL.Query(), defaults)
+	if err != nil {
+		http.Error(w, "Invalid listing query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, err := u.FileSystem.OpenFile(r.Context(), name, os.O_RDONLY, 0)
+	if err != nil {
+		serveListingError(w, err)
+		return
+	}
+	defer f.Close()
+
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		serveListingError(w, err)
+		return
+	}
+
+	// The whole directory is sorted, before the page is sliced out of it.
+	sortInfos(infos, options)
+	total := len(infos)
+	infos = infos[min(options.offset, total):]
+	if options.limit > 0 && len(infos) > options.limit {
+		infos = infos[:options.limit]
+	}
+
+	dir := r.URL.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+
+	data := listing{Path: dir}
+	for _, column := range []struct {
+		sort string
+		href *string
+	}{
+		{ListingSortName, &data.SortName},
+		{ListingSortSize, &data.SortSize},
+		{ListingSortMTime, &data.SortMTime},
+	} {
+		// Sorting by the same column again reverses the order.
+		sorted := listingOptions{sort: column.sort, desc: column.sort == options.sort && !options.desc, limit: options.limit}
+		*column.href = sorted.query(defaults)
+	}
+
+	if options.limit > 0 {
+		if options.offset > 0 {
+			previous := options
+			previous.offset = max(options.offset-options.limit, 0)
+			data.Previous = previous.query(defaults)
+		}
+		if options.offset+options.limit < total {
+			next := options
+			next.offset = options.offset + options.limit
+			data.Next = next.query(defaults)
+		}
+	}
+	if dir != u.Prefix && dir != u.Prefix+"/" {
+		data.Parent = escapePath(strings.TrimSuffix(path.Dir(strings.TrimSuffix(dir, "/")), "/") + "/")
+	}
+
+	for _, info := range infos {
+		entry := listingEntry{
+			Name:     info.Name(),
+			Href:     escapePath(dir + info.Name()),
+			Modified: info.ModTime().UTC().Format(time.DateTime),
+		}
+		if info.IsDir() {
+			entry.Name += "/"
+			entry.Href += "/"
+		} else {
+			entry.Size = formatSize(info.Size())
+		}
+		data.Entries = append(data.Entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := listingTemplate.Execute(w, data); err != nil {
+		requestLogger(r, u.logger).Debug("failed to write directory listing", zap.String("path", name), zap.Error(err))
+	}
+}
+
+func escapePath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+func serveListingError(w http.ResponseWriter, err error) {
+	switch {
+	case os.IsNotExist(err):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case os.IsPermission(err):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// formatSize formats the size in bytes in a human-readable way.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,284 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptsHTML(t *testing.T) {
+	t.Parallel()
+
+	for header, expected := range map[string]bool{
+		"":    false,
+		"*/*": false,
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": true,
+		"text/html;q=0.5, application/xml":                                false,
+		"text/html;q=0":                                                   false,
+		"TEXT/HTML":                                                       true,
+		"application/xml, text/xml":                                       false,
+	} {
+		require.Equal(t, expected, acceptsHTML(header), header)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "0 B", formatSize(0))
+	require.Equal(t, "1023 B", formatSize(1023))
+	require.Equal(t, "1.0 KiB", formatSize(1024))
+	require.Equal(t, "1.5 MiB", formatSize(3<<19))
+}
+
+func TestHandlerDirectoryListing(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T, enabled bool) http.Handler {
+		cfg := &Config{
+			DirectoryListing: enabled,
+			HideDotfiles:     true,
+		}
+		h := newTestHandler(t, cfg)
+		require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "dir", "a & b.txt"), []byte("content"), 0666))
+		require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "dir", ".hidden"), []byte("content"), 0666))
+		require.NoError(t, os.Mkdir(filepath.Join(cfg.Scope, "dir", "sub"), 0777))
+		return h
+	}
+
+	get := func(h http.Handler, path, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept", accept)
+		return doRequest(h, r)
+	}
+
+	t.Run("HTML", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHandler(t, true)
+		w := get(h, "/dir", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		require.Contains(t, w.Header().Values("Vary"), "Accept")
+
+		body := w.Body.String()
+		require.Contains(t, body, "<title>Index of /dir/</title>")
+		require.Contains(t, body, `<a href="/">../</a>`)
+		require.Contains(t, body, `<a href="/dir/sub/">sub/</a>`)
+		require.Contains(t, body, `<a href="/dir/a%20&amp;%20b.txt">a &amp; b.txt</a>`)
+		require.Contains(t, body, "7 B")
+		require.NotContains(t, body, ".hidden")
+
+		// Directories are listed first.
+		require.Less(t, strings.Index(body, "sub/"), strings.Index(body, "a &amp; b.txt"))
+	})
+
+	t.Run("Root", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHandler(t, true)
+		w := get(h, "/", "text/html")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `<a href="/file.txt">file.txt</a>`)
+		require.NotContains(t, w.Body.String(), "../")
+	})
+
+	t.Run("WebDAV Client", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHandler(t, true)
+		w := get(h, "/dir", "application/xml")
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.Contains(t, w.Body.String(), "<D:multistatus")
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHandler(t, false)
+		w := get(h, "/dir", "text/html")
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.Empty(t, w.Header().Values("Vary"))
+	})
+
+	t.Run("Files", func(t *testing.T) {
+		t.Parallel()
+
+		h := newHandler(t, true)
+		w := get(h, "/file.txt", "text/html")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "content", w.Body.String())
+	})
+}
+
+func TestHandlerIndexFiles(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T) (http.Handler, string) {
+		cfg := &Config{
+			DirectoryListing: true,
+			IndexFiles:       []string{"index.html", "index.htm"},
+			Permissions: Permissions{
+				Rules: []*Rule{{Path: "/private/index.html", Allow: false}},
+			},
+		}
+		h := newTestHandler(t, cfg)
+		require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "dir", "index.htm"), []byte("<p>htm</p>"), 0666))
+		return h, cfg.Scope
+	}
+
+	get := func(h http.Handler, method, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Accept", "text/html")
+		return doRequest(h, r)
+	}
+
+	t.Run("Index File", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		w := get(h, http.MethodGet, "/dir/")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "<p>htm</p>", w.Body.String())
+		require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+
+		// The index files are tried in order.
+		require.NoError(t, os.WriteFile(filepath.Join(scope, "dir", "index.html"), []byte("<p>html</p>"), 0666))
+		w = get(h, http.MethodGet, "/dir")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "<p>html</p>", w.Body.String())
+
+		w = get(h, http.MethodHead, "/dir/")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "11", w.Header().Get("Content-Length"))
+		require.Empty(t, w.Body.String())
+	})
+
+	t.Run("No Index File", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		w := get(h, http.MethodGet, "/")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), "<title>Index of /</title>")
+
+		// Directories named like an index file are not served.
+		require.NoError(t, os.Mkdir(filepath.Join(scope, "index.html"), 0777))
+		w = get(h, http.MethodGet, "/")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), "<title>Index of /</title>")
+
+		// Neither are the index files the user cannot read.
+		require.NoError(t, os.Mkdir(filepath.Join(scope, "private"), 0777))
+		require.NoError(t, os.WriteFile(filepath.Join(scope, "private", "index.html"), []byte("secret"), 0666))
+		w = get(h, http.MethodGet, "/private/")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), "<title>Index of /private/</title>")
+		require.NotContains(t, w.Body.String(), "secret")
+
+		// WebDAV clients still get the properties of the directory.
+		r := httptest.NewRequest("PROPFIND", "/dir/", nil)
+		require.Equal(t, http.StatusMultiStatus, doRequest(h, r).Code)
+	})
+}
+
+func TestConfigIndexFiles(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+index_files:
+  - index.html
+  - README.md`, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, []string{"index.html", "README.md"}, cfg.IndexFiles)
+
+	for _, index := range []string{"", "..", "docs/index.html"} {
+		cfg := &Config{IndexFiles: []string{index}}
+		require.Error(t, cfg.Validate(), index)
+	}
+}
+
+func TestHandlerListingSort(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{DirectoryListing: true, ListingLimit: 2}
+	h := newTestHandler(t, cfg)
+
+	now := time.Now()
+	for i, name := range []string{"b.txt", "c.txt", "a.txt"} {
+		p := filepath.Join(cfg.Scope, "dir", name)
+		require.NoError(t, os.WriteFile(p, []byte(strings.Repeat("x", (i+1)*10)), 0666))
+		require.NoError(t, os.Chtimes(p, now, now.Add(time.Duration(i)*time.Hour)))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(cfg.Scope, "dir", "z"), 0777))
+
+	// entries returns the names of the entries listed, in order, without the
+	// link to the parent directory.
+	entries := func(query string) ([]string, string) {
+		r := httptest.NewRequest(http.MethodGet, "/dir/"+query, nil)
+		r.Header.Set("Accept", "text/html")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusOK, w.Code, query)
+
+		var names []string
+		for _, match := range regexp.MustCompile(`<td><a href="[^"]*">([^<]*)</a>`).FindAllStringSubmatch(w.Body.String(), -1) {
+			if match[1] != "../" {
+				names = append(names, match[1])
+			}
+		}
+		return names, w.Body.String()
+	}
+
+	for query, expected := range map[string][]string{
+		"?limit=0":                       {"z/", "a.txt", "b.txt", "c.txt"},
+		"?limit=0&sort=size":             {"z/", "b.txt", "c.txt", "a.txt"},
+		"?limit=0&sort=mtime&order=desc": {"z/", "a.txt", "c.txt", "b.txt"},
+		"?limit=0&order=desc":            {"z/", "c.txt", "b.txt", "a.txt"},
+		"":                               {"z/", "a.txt"},
+		"?offset=2":                      {"b.txt", "c.txt"},
+		"?sort=size&offset=1":            {"b.txt", "c.txt"},
+		"?offset=10":                     nil,
+	} {
+		names, _ := entries(query)
+		require.Equal(t, expected, names, query)
+	}
+
+	_, body := entries("?offset=1")
+	require.Contains(t, body, `<a href="?sort=name">Previous</a>`)
+	require.Contains(t, body, `<a href="?offset=3&amp;sort=name">Next</a>`)
+
+	_, body = entries("?sort=size")
+	require.Contains(t, body, `<a href="?order=desc&amp;sort=size">Size</a>`)
+	require.NotContains(t, body, "Previous")
+
+	for _, query := range []string{"?sort=owner", "?order=up", "?offset=-1", "?limit=x"} {
+		r := httptest.NewRequest(http.MethodGet, "/dir/"+query, nil)
+		r.Header.Set("Accept", "text/html")
+		require.Equal(t, http.StatusBadRequest, doRequest(h, r).Code, query)
+	}
+}
+
+func TestConfigListingSort(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, "directory_listing: true", ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, ListingSortName, cfg.ListingSort)
+	require.Equal(t, ListingOrderAsc, cfg.ListingOrder)
+	require.Zero(t, cfg.ListingLimit)
+
+	for _, cfg := range []*Config{
+		{ListingSort: "owner"},
+		{ListingOrder: "up"},
+		{ListingLimit: -1},
+	} {
+		require.Error(t, cfg.Validate())
+	}
+}
@@ -0,0 +1,268 @@
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// lockStore keeps the locks of all the lock systems in a file, so that they
+// survive restarts, and the clients holding them do not lose them. The file is
+// rewritten whenever a lock is created, refreshed or removed.
+type lockStore struct {
+	file string
+
+	mu    sync.Mutex
+	locks map[string]*storedLock
+	held  map[string]bool
+}
+
+// storedLock is the representation of a lock in the file. The lock systems
+// sharing the file are told apart by namespace.
+type storedLock struct {
+	Namespace string        `json:"namespace"`
+	Root      string        `json:"root"`
+	Duration  time.Duration `json:"duration"`
+	OwnerXML  string        `json:"owner_xml,omitempty"`
+	ZeroDepth bool          `json:"zero_depth"`
+	Expires   time.Time     `json:"expires"`
+}
+
+func (l *storedLock) expired(now time.Time) bool {
+	return l.Duration >= 0 && !now.Before(l.Expires)
+}
+
+func (l *storedLock) details() webdav.LockDetails {
+	return webdav.LockDetails{Root: l.Root, Duration: l.Duration, OwnerXML: l.OwnerXML, ZeroDepth: l.ZeroDepth}
+}
+
+// newLockStore loads the locks from the file, if it exists, leaving out the
+// ones that expired in the meantime.
+func newLockStore(file string) (*lockStore, error) {
+	s := &lockStore{file: file, locks: map[string]*storedLock{}, held: map[string]bool{}}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read locks file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.locks); err != nil {
+		return nil, fmt.Errorf("failed to decode locks file: %w", err)
+	}
+	s.collectExpired(time.Now())
+	return s, nil
+}
+
+// collectExpired removes the expired locks, except the ones being held.
+func (s *lockStore) collectExpired(now time.Time) {
+	for token, l := range s.locks {
+		if !s.held[token] && l.expired(now) {
+			delete(s.locks, token)
+		}
+	}
+}
+
+// save replaces the file atomically, so that it is never left half-written.
+func (s *lockStore) save() error {
+	data, err := json.Marshal(s.locks)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), "."+filepath.Base(s.file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file)
+}
+
+// fileLockSystem is a [webdav.LockSystem] whose locks are kept in a
+// [lockStore], under its namespace. It follows the semantics of
+// [webdav.NewMemLS].
+type fileLockSystem struct {
+	store     *lockStore
+	namespace string
+}
+
+func newLockToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	h := hex.EncodeToString(b[:])
+	return "urn:uuid:" + h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+// lookup returns the token of the lock matching one of the conditions that
+// covers the name, if not held.
+func (ls fileLockSystem) lookup(name string, conditions ...webdav.Condition) string {
+	for _, c := range conditions {
+		l := ls.store.locks[c.Token]
+		if l == nil || l.Namespace != ls.namespace || ls.store.held[c.Token] {
+			continue
+		}
+		if name == l.Root {
+			return c.Token
+		}
+		if l.ZeroDepth {
+			continue
+		}
+		if l.Root == "/" || strings.HasPrefix(name, l.Root+"/") {
+			return c.Token
+		}
+	}
+	return ""
+}
+
+func (ls fileLockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	s := ls.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.collectExpired(now)
+
+	var token0, token1 string
+	if name0 != "" {
+		if token0 = ls.lookup(lockName(name0), conditions...); token0 == "" {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	if name1 != "" {
+		if token1 = ls.lookup(lockName(name1), conditions...); token1 == "" {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+
+	for _, token := range []string{token0, token1} {
+		if token != "" {
+			s.held[token] = true
+		}
+	}
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.held, token0)
+		delete(s.held, token1)
+	}, nil
+}
+
+func (ls fileLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	s := ls.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.collectExpired(now)
+
+	root := lockName(details.Root)
+	for _, l := range s.locks {
+		if l.Namespace != ls.namespace {
+			continue
+		}
+		// The root itself, an ancestor locked with infinite depth, or, for an
+		// infinite depth lock, a descendant is already locked.
+		if l.Root == root ||
+			(!l.ZeroDepth && (l.Root == "/" || strings.HasPrefix(root, l.Root+"/"))) ||
+			(!details.ZeroDepth && (root == "/" || strings.HasPrefix(l.Root, root+"/"))) {
+			return "", webdav.ErrLocked
+		}
+	}
+
+	token, err := newLockToken()
+	if err != nil {
+		return "", err
+	}
+
+	l := &storedLock{
+		Namespace: ls.namespace,
+		Root:      root,
+		Duration:  details.Duration,
+		OwnerXML:  details.OwnerXML,
+		ZeroDepth: details.ZeroDepth,
+	}
+	if l.Duration >= 0 {
+		l.Expires = now.Add(l.Duration)
+	}
+
+	s.locks[token] = l
+	if err := s.save(); err != nil {
+		delete(s.locks, token)
+		return "", err
+	}
+	return token, nil
+}
+
+func (ls fileLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	s := ls.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.collectExpired(now)
+
+	l := s.locks[token]
+	if l == nil || l.Namespace != ls.namespace {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	if s.held[token] {
+		return webdav.LockDetails{}, webdav.ErrLocked
+	}
+
+	prev := *l
+	l.Duration = duration
+	l.Expires = time.Time{}
+	if duration >= 0 {
+		l.Expires = now.Add(duration)
+	}
+	if err := s.save(); err != nil {
+		*l = prev
+		return webdav.LockDetails{}, err
+	}
+	return l.details(), nil
+}
+
+func (ls fileLockSystem) Unlock(now time.Time, token string) error {
+	s := ls.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.collectExpired(now)
+
+	l := s.locks[token]
+	if l == nil || l.Namespace != ls.namespace {
+		return webdav.ErrNoSuchLock
+	}
+	if s.held[token] {
+		return webdav.ErrLocked
+	}
+
+	delete(s.locks, token)
+	if err := s.save(); err != nil {
+		s.locks[token] = l
+		return err
+	}
+	return nil
+}
+
+// lockName cleans the name of a locked resource, as [webdav.NewMemLS] does.
+func lockName(name string) string {
+	return path.Clean("/" + name)
+}
@@ -0,0 +1,167 @@
+package lib
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// lockout keeps track of the failed login attempts, in order to temporarily
+// reject further attempts after too many failures within a certain window.
+// Failures are tracked by key, such as the remote address or the username.
+type lockout struct {
+	attempts int
+	window   time.Duration
+	cooldown time.Duration
+
+	mu        sync.Mutex
+	failures  map[string]*loginFailures
+	lastSweep time.Time
+}
+
+type loginFailures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+func newLockout(c Lockout) *lockout {
+	if !c.Enabled {
+		return nil
+	}
+
+	return &lockout{
+		attempts: c.Attempts,
+		window:   c.Window,
+		cooldown: c.Cooldown,
+		failures: map[string]*loginFailures{},
+	}
+}
+
+// lockoutKeys returns the keys under which the failures of a login attempt
+// are tracked.
+func lockoutKeys(r *http.Request, username string) []string {
+	return []string{"address:" + remoteHost(r), "username:" + username}
+}
+
+// remoteHost returns the host of the remote address of the request, without
+// the port.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// locked reports whether any of the keys is locked out and, if so, for how
+// much longer.
+func (l *lockout) locked(now time.Time, keys ...string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var remaining time.Duration
+	for _, key := range keys {
+		if f, ok := l.failures[key]; ok && now.Before(f.lockedUntil) {
+			remaining = max(remaining, f.lockedUntil.Sub(now))
+		}
+	}
+
+	return remaining, remaining > 0
+}
+
+// fail records a failed login attempt for the keys, and reports whether any of
+// them got locked out as a result.
+func (l *lockout) fail(now time.Time, keys ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	lockedOut := false
+	for _, key := range keys {
+		f, ok := l.failures[key]
+		if !ok || now.Sub(f.first) > l.window {
+			f = &loginFailures{first: now}
+			l.failures[key] = f
+		}
+
+		f.count++
+		if f.count >= l.attempts {
+			f.count = 0
+			f.first = now
+			f.lockedUntil = now.Add(l.cooldown)
+			lockedOut = true
+		}
+	}
+
+	return lockedOut
+}
+
+// reset forgets the failed login attempts of the keys.
+func (l *lockout) reset(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		delete(l.failures, key)
+	}
+}
+
+// sweep removes the failures that no longer matter, so that the map does not
+// grow indefinitely. It runs at most once per window.
+func (l *lockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+
+	for key, f := range l.failures {
+		if now.Sub(f.first) > l.window && !now.Before(f.lockedUntil) {
+			delete(l.failures, key)
+		}
+	}
+}
+
+// rejectLockedOut checks whether the login attempt must be rejected due to too
+// many failures. If so, the response is written and true is returned.
+func (h *handler) rejectLockedOut(w http.ResponseWriter, r *http.Request, username string) bool {
+	if h.lockout == nil {
+		return false
+	}
+
+	remaining, locked := h.lockout.locked(time.Now(), lockoutKeys(r, username)...)
+	if !locked {
+		return false
+	}
+
+	requestLogger(r, h.logger).Info("login attempt while locked out", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	return true
+}
+
+// loginFailed records a failed login attempt.
+func (h *handler) loginFailed(r *http.Request, username string) {
+	if h.lockout == nil {
+		return
+	}
+
+	if h.lockout.fail(time.Now(), lockoutKeys(r, username)...) {
+		requestLogger(r, h.logger).Warn("too many failed login attempts, locking out", zap.String("username", username), zap.String("remote_address", r.RemoteAddr), zap.Duration("cooldown", h.lockout.cooldown))
+	}
+}
+
+// loginSucceeded forgets the failed login attempts.
+func (h *handler) loginSucceeded(r *http.Request, username string) {
+	if h.lockout == nil {
+		return
+	}
+
+	h.lockout.reset(lockoutKeys(r, username)...)
+}
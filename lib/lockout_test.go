@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLockout(t *testing.T) {
+	t.Parallel()
+
+	newTestLockout := func() *lockout {
+		return newLockout(Lockout{Enabled: true, Attempts: 3, Window: time.Minute, Cooldown: 10 * time.Minute})
+	}
+	now := time.Now()
+
+	t.Run("Locks Out", func(t *testing.T) {
+		t.Parallel()
+
+		l := newTestLockout()
+		require.False(t, l.fail(now, "key"))
+		require.False(t, l.fail(now.Add(time.Second), "key"))
+		_, locked := l.locked(now.Add(time.Second), "key")
+		require.False(t, locked)
+
+		require.True(t, l.fail(now.Add(2*time.Second), "key"))
+		remaining, locked := l.locked(now.Add(2*time.Second), "key", "other")
+		require.True(t, locked)
+		require.Equal(t, 10*time.Minute, remaining)
+
+		_, locked = l.locked(now, "other")
+		require.False(t, locked)
+
+		// The lockout is lifted after the cooldown.
+		_, locked = l.locked(now.Add(11*time.Minute), "key")
+		require.False(t, locked)
+	})
+
+	t.Run("Window", func(t *testing.T) {
+		t.Parallel()
+
+		l := newTestLockout()
+		require.False(t, l.fail(now, "key"))
+		require.False(t, l.fail(now.Add(time.Second), "key"))
+		require.False(t, l.fail(now.Add(2*time.Minute), "key"))
+
+		_, locked := l.locked(now.Add(2*time.Minute), "key")
+		require.False(t, locked)
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		t.Parallel()
+
+		l := newTestLockout()
+		require.False(t, l.fail(now, "key"))
+		require.False(t, l.fail(now, "key"))
+		l.reset("key")
+		require.False(t, l.fail(now, "key"))
+		require.False(t, l.fail(now, "key"))
+	})
+
+	t.Run("Sweep", func(t *testing.T) {
+		t.Parallel()
+
+		l := newTestLockout()
+		require.False(t, l.fail(now, "old"))
+		require.False(t, l.fail(now.Add(2*time.Minute), "new"))
+		require.Len(t, l.failures, 1)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		require.Nil(t, newLockout(Lockout{Attempts: 3, Window: time.Minute, Cooldown: time.Minute}))
+	})
+}
+
+func TestHandlerLockout(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	h := newTestHandler(t, &Config{
+		Logger: zap.New(core),
+		Auth:   true,
+		Lockout: Lockout{
+			Enabled:  true,
+			Attempts: 3,
+			Window:   time.Minute,
+			Cooldown: 200 * time.Millisecond,
+		},
+		Users: []User{
+			{Username: "admin", Password: "admin"},
+			{Username: "other", Password: "other"},
+		},
+	})
+
+	request := func(username, password, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.RemoteAddr = remoteAddr
+		r.SetBasicAuth(username, password)
+		return doRequest(h, r)
+	}
+
+	// A successful login resets the failures.
+	require.Equal(t, http.StatusUnauthorized, request("admin", "wrong", "10.0.0.1:1234").Code)
+	require.Equal(t, http.StatusUnauthorized, request("admin", "wrong", "10.0.0.1:1234").Code)
+	require.Equal(t, http.StatusOK, request("admin", "admin", "10.0.0.1:1234").Code)
+	require.Equal(t, http.StatusUnauthorized, request("admin", "wrong", "10.0.0.1:1234").Code)
+	require.Equal(t, http.StatusUnauthorized, request("admin", "wrong", "10.0.0.1:1234").Code)
+
+	// The third failure within the window trips the lockout, even with the
+	// right password afterwards.
+	require.Equal(t, http.StatusUnauthorized, request("admin", "wrong", "10.0.0.1:1234").Code)
+	w := request("admin", "admin", "10.0.0.1:1234")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// The username is locked out from other addresses, and the address is
+	// locked out for other usernames.
+	require.Equal(t, http.StatusTooManyRequests, request("admin", "admin", "10.0.0.2:1234").Code)
+	require.Equal(t, http.StatusTooManyRequests, request("other", "other", "10.0.0.1:1234").Code)
+	require.Equal(t, http.StatusOK, request("other", "other", "10.0.0.2:1234").Code)
+
+	require.Len(t, logs.FilterMessage("too many failed login attempts, locking out").AllUntimed(), 1)
+
+	// The lockout is cleared after the cooldown.
+	time.Sleep(250 * time.Millisecond)
+	require.Equal(t, http.StatusOK, request("admin", "admin", "10.0.0.1:1234").Code)
+}
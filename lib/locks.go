@@ -0,0 +1,255 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+)
+
+// timeoutLockSystem is a [webdav.LockSystem] that gives the infinite locks a
+// default duration, and caps the duration of the locks, including infinite
+// ones, so that locks held by clients that crashed eventually expire.
+type timeoutLockSystem struct {
+	webdav.LockSystem
+	defaultTimeout time.Duration
+	maxTimeout     time.Duration
+}
+
+// newLockSystem creates a lock system. If a store is given, the locks are kept
+// in it, under the namespace, rather than in memory. If a reaper is given, the
+// expired locks are removed by it.
+func newLockSystem(c Locks, store *lockStore, namespace string, reaper *lockReaper) webdav.LockSystem {
+	ls := webdav.NewMemLS()
+	if store != nil {
+		ls = fileLockSystem{store: store, namespace: namespace}
+	}
+	if reaper != nil {
+		ls = &reapedLockSystem{LockSystem: ls, reaper: reaper, expiries: map[string]time.Time{}, roots: map[string]string{}}
+	}
+	if c.DefaultTimeout > 0 || c.MaxTimeout > 0 {
+		ls = timeoutLockSystem{LockSystem: ls, defaultTimeout: c.DefaultTimeout, maxTimeout: c.MaxTimeout}
+	}
+	return ls
+}
+
+func (ls timeoutLockSystem) capDuration(duration time.Duration) time.Duration {
+	if duration < 0 && ls.defaultTimeout > 0 {
+		duration = ls.defaultTimeout
+	}
+	if ls.maxTimeout > 0 && (duration < 0 || duration > ls.maxTimeout) {
+		return ls.maxTimeout
+	}
+	return duration
+}
+
+func (ls timeoutLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	details.Duration = ls.capDuration(details.Duration)
+	return ls.LockSystem.Create(now, details)
+}
+
+func (ls timeoutLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	return ls.LockSystem.Refresh(now, token, ls.capDuration(duration))
+}
+
+// lockReaper periodically removes the expired locks of the lock systems, so
+// that the locks of the clients that crashed do not linger until the locked
+// resources are accessed again. Only the lock systems holding locks that can
+// expire are scanned.
+type lockReaper struct {
+	logger   *zap.Logger
+	interval time.Duration
+	start    sync.Once
+	stop     chan struct{}
+	stopped  sync.Once
+
+	mu      sync.Mutex
+	systems map[*reapedLockSystem]bool
+}
+
+// newLockReaper creates a lock reaper, which scans the lock systems at every
+// interval, until it is closed. It only starts once there are locks to scan.
+func newLockReaper(interval time.Duration, logger *zap.Logger) *lockReaper {
+	return &lockReaper{logger: logger, interval: interval, stop: make(chan struct{}), systems: map[*reapedLockSystem]bool{}}
+}
+
+func (r *lockReaper) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			r.reap(now)
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *lockReaper) add(ls *reapedLockSystem) {
+	r.start.Do(func() { go r.run() })
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.systems[ls] = true
+}
+
+// reap removes the locks that expired by now. The lock systems left without
+// locks are no longer scanned, until they hold some again.
+func (r *lockReaper) reap(now time.Time) {
+	r.mu.Lock()
+	systems := make([]*reapedLockSystem, 0, len(r.systems))
+	for ls := range r.systems {
+		systems = append(systems, ls)
+	}
+	r.mu.Unlock()
+
+	for _, ls := range systems {
+		for token, root := range ls.reap(now) {
+			r.logger.Info("expired lock removed", zap.String("path", root), zap.String("token", token))
+		}
+
+		r.mu.Lock()
+		if ls.empty() {
+			delete(r.systems, ls)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// close stops the reaper. It can be called more than once.
+func (r *lockReaper) close() {
+	if r == nil {
+		return
+	}
+	r.stopped.Do(func() { close(r.stop) })
+}
+
+// reapedLockSystem is a [webdav.LockSystem] that keeps track of when its locks
+// expire, for the [lockReaper] to remove them.
+type reapedLockSystem struct {
+	webdav.LockSystem
+	reaper *lockReaper
+
+	mu       sync.Mutex
+	expiries map[string]time.Time
+	roots    map[string]string
+}
+
+// track records the expiry of the lock. The infinite locks never expire, so
+// they are not tracked.
+func (ls *reapedLockSystem) track(now time.Time, token string, details webdav.LockDetails) {
+	ls.mu.Lock()
+	if details.Duration < 0 {
+		ls.forget(token)
+		ls.mu.Unlock()
+		return
+	}
+	ls.expiries[token] = now.Add(details.Duration)
+	ls.roots[token] = details.Root
+	ls.mu.Unlock()
+
+	// The lock system is added once the lock is tracked, so that the reaper
+	// does not drop it in between.
+	ls.reaper.add(ls)
+}
+
+func (ls *reapedLockSystem) forget(token string) {
+	delete(ls.expiries, token)
+	delete(ls.roots, token)
+}
+
+func (ls *reapedLockSystem) empty() bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return len(ls.expiries) == 0
+}
+
+// reap removes the locks that expired by now, and returns their roots by token.
+// The locks being held by requests are left for the next scan.
+func (ls *reapedLockSystem) reap(now time.Time) map[string]string {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	reaped := map[string]string{}
+	for token, expiry := range ls.expiries {
+		if now.Before(expiry) {
+			continue
+		}
+		// The lock system may have removed the lock already, when it was
+		// accessed after it expired.
+		if err := ls.LockSystem.Unlock(now, token); err != nil && !errors.Is(err, webdav.ErrNoSuchLock) {
+			continue
+		}
+		reaped[token] = ls.roots[token]
+		ls.forget(token)
+	}
+	return reaped
+}
+
+func (ls *reapedLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	token, err := ls.LockSystem.Create(now, details)
+	if err == nil {
+		ls.track(now, token, details)
+	}
+	return token, err
+}
+
+func (ls *reapedLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	details, err := ls.LockSystem.Refresh(now, token, duration)
+	switch {
+	case err == nil:
+		ls.track(now, token, details)
+	case errors.Is(err, webdav.ErrNoSuchLock):
+		ls.mu.Lock()
+		ls.forget(token)
+		ls.mu.Unlock()
+	}
+	return details, err
+}
+
+func (ls *reapedLockSystem) Unlock(now time.Time, token string) error {
+	err := ls.LockSystem.Unlock(now, token)
+	if err == nil || errors.Is(err, webdav.ErrNoSuchLock) {
+		ls.mu.Lock()
+		ls.forget(token)
+		ls.mu.Unlock()
+	}
+	return err
+}
+
+// lockTokenRegexp matches the lock tokens, for example in the If header.
+var lockTokenRegexp = regexp.MustCompile(`<([^>]+)>`)
+
+// lockTokens returns the lock tokens submitted with the request.
+func lockTokens(r *http.Request) []string {
+	var tokens []string
+	for _, header := range []string{"If", "Lock-Token"} {
+		for _, match := range lockTokenRegexp.FindAllStringSubmatch(r.Header.Get(header), -1) {
+			tokens = append(tokens, match[1])
+		}
+	}
+	return tokens
+}
+
+// logLockConflicts returns a function, meant to be used as [webdav.Handler]'s
+// Logger, that logs the requests that failed due to a lock being held.
+func logLockConflicts(logger *zap.Logger, username string) func(*http.Request, error) {
+	return func(r *http.Request, err error) {
+		if !errors.Is(err, webdav.ErrLocked) {
+			return
+		}
+
+		requestLogger(r, logger).Info("lock conflict",
+			zap.String("username", username),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Strings("tokens", lockTokens(r)),
+			zap.String("remote_address", r.RemoteAddr),
+		)
+	}
+}
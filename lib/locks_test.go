@@ -0,0 +1,350 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/net/webdav"
+)
+
+const lockBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:">
+	<D:lockscope><D:exclusive/></D:lockscope>
+	<D:locktype><D:write/></D:locktype>
+	<D:owner>test</D:owner>
+</D:lockinfo>`
+
+func lockRequest(path string) *http.Request {
+	r := httptest.NewRequest("LOCK", path, strings.NewReader(lockBody))
+	r.Header.Set("Timeout", "Infinite")
+	return r
+}
+
+func TestLockConflictLogging(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	h := newTestHandler(t, &Config{
+		Logger: zap.New(core),
+		Auth:   true,
+		Users: []User{
+			{Username: "admin", Password: "admin", Permissions: Permissions{Modify: true}},
+		},
+	})
+
+	r := lockRequest("/file.txt")
+	r.SetBasicAuth("admin", "admin")
+	w := doRequest(h, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	token := w.Header().Get("Lock-Token")
+	require.NotEmpty(t, token)
+
+	// Writing without the lock token conflicts with the held lock.
+	r = httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("new content"))
+	r.SetBasicAuth("admin", "admin")
+	require.Equal(t, http.StatusLocked, doRequest(h, r).Code)
+
+	// Writing with a wrong lock token fails the precondition.
+	r = httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("new content"))
+	r.Header.Set("If", "(<opaquelocktoken:wrong>)")
+	r.SetBasicAuth("admin", "admin")
+	require.Equal(t, http.StatusPreconditionFailed, doRequest(h, r).Code)
+
+	// Writing with the lock token succeeds.
+	r = httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("new content"))
+	r.Header.Set("If", "("+token+")")
+	r.SetBasicAuth("admin", "admin")
+	require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+
+	entries := logs.FilterMessage("lock conflict").AllUntimed()
+	require.Len(t, entries, 2)
+
+	fields := entries[0].ContextMap()
+	require.Equal(t, "admin", fields["username"])
+	require.Equal(t, http.MethodPut, fields["method"])
+	require.Equal(t, "/file.txt", fields["path"])
+	require.Empty(t, fields["tokens"])
+
+	fields = entries[1].ContextMap()
+	require.Equal(t, []interface{}{"opaquelocktoken:wrong"}, fields["tokens"])
+}
+
+func TestLockMaxTimeout(t *testing.T) {
+	t.Parallel()
+
+	ls := newLockSystem(Locks{MaxTimeout: time.Minute}, nil, "", nil)
+
+	now := time.Now()
+	_, err := ls.Create(now, webdav.LockDetails{Root: "/file.txt", Duration: -1})
+	require.NoError(t, err)
+
+	_, err = ls.Create(now.Add(30*time.Second), webdav.LockDetails{Root: "/file.txt", Duration: -1})
+	require.ErrorIs(t, err, webdav.ErrLocked)
+
+	// The infinite lock expired after the maximum timeout.
+	_, err = ls.Create(now.Add(2*time.Minute), webdav.LockDetails{Root: "/file.txt", Duration: -1})
+	require.NoError(t, err)
+}
+
+func TestLockNoMaxTimeout(t *testing.T) {
+	t.Parallel()
+
+	ls := newLockSystem(Locks{}, nil, "", nil)
+
+	now := time.Now()
+	_, err := ls.Create(now, webdav.LockDetails{Root: "/file.txt", Duration: -1})
+	require.NoError(t, err)
+
+	_, err = ls.Create(now.Add(24*time.Hour), webdav.LockDetails{Root: "/file.txt", Duration: -1})
+	require.ErrorIs(t, err, webdav.ErrLocked)
+}
+
+func TestLockDefaultTimeout(t *testing.T) {
+	t.Parallel()
+
+	ls := newLockSystem(Locks{DefaultTimeout: time.Minute, MaxTimeout: time.Hour}, nil, "", nil)
+
+	now := time.Now()
+	_, err := ls.Create(now, webdav.LockDetails{Root: "/file.txt", Duration: -1})
+	require.NoError(t, err)
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/other.txt", Duration: 2 * time.Hour})
+	require.NoError(t, err)
+
+	// The infinite lock expired after the default timeout, while the other
+	// one is only capped.
+	_, err = ls.Create(now.Add(2*time.Minute), webdav.LockDetails{Root: "/file.txt", Duration: -1})
+	require.NoError(t, err)
+	_, err = ls.Create(now.Add(30*time.Minute), webdav.LockDetails{Root: "/other.txt", Duration: -1})
+	require.ErrorIs(t, err, webdav.ErrLocked)
+}
+
+func TestLockReaper(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	reaper := newLockReaper(time.Hour, zap.New(core))
+	t.Cleanup(reaper.close)
+	ls := newLockSystem(Locks{}, nil, "", reaper)
+
+	now := time.Now()
+	expired, err := ls.Create(now, webdav.LockDetails{Root: "/expired.txt", Duration: time.Second})
+	require.NoError(t, err)
+	active, err := ls.Create(now, webdav.LockDetails{Root: "/active.txt", Duration: time.Minute})
+	require.NoError(t, err)
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/infinite.txt", Duration: -1})
+	require.NoError(t, err)
+
+	reaper.reap(now.Add(2 * time.Second))
+	entries := logs.FilterMessage("expired lock removed").All()
+	require.Len(t, entries, 1)
+	require.Equal(t, "/expired.txt", entries[0].ContextMap()["path"])
+	require.Equal(t, expired, entries[0].ContextMap()["token"])
+
+	// The active and infinite locks survive.
+	_, err = ls.Create(now.Add(2*time.Second), webdav.LockDetails{Root: "/active.txt", Duration: time.Minute})
+	require.ErrorIs(t, err, webdav.ErrLocked)
+	_, err = ls.Create(now.Add(2*time.Second), webdav.LockDetails{Root: "/infinite.txt", Duration: -1})
+	require.ErrorIs(t, err, webdav.ErrLocked)
+
+	// Once unlocked, the lock system is no longer scanned.
+	require.NoError(t, ls.Unlock(now.Add(2*time.Second), active))
+	reaper.reap(now.Add(time.Hour))
+	require.Empty(t, reaper.systems)
+	require.Len(t, logs.FilterMessage("expired lock removed").All(), 1)
+}
+
+func TestLockReaperInterval(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	h, err := NewHandler(&Config{
+		Logger: zap.New(core),
+		Permissions: Permissions{
+			Scope:  t.TempDir(),
+			Modify: true,
+		},
+		Prefix: DefaultPrefix,
+		Locks:  Locks{ReapInterval: 10 * time.Millisecond},
+	})
+	require.NoError(t, err)
+
+	r := lockRequest("/file.txt")
+	r.Header.Set("Timeout", "Second-1")
+	require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+	require.Eventually(t, func() bool {
+		return logs.FilterMessage("expired lock removed").Len() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The reaper stops when the handler shuts down.
+	require.NoError(t, h.Shutdown(context.Background()))
+	select {
+	case <-h.handler.reaper.stop:
+	default:
+		t.Fatal("reaper not stopped")
+	}
+}
+
+func TestSharedLocks(t *testing.T) {
+	t.Parallel()
+
+	newSharedLocksHandler := func(t *testing.T, shared bool) http.Handler {
+		other := t.TempDir()
+		link := filepath.Join(t.TempDir(), "link")
+
+		cfg := &Config{
+			Auth:  true,
+			Locks: Locks{Shared: shared},
+			Users: []User{
+				{Username: "alice", Password: "alice", Permissions: Permissions{Modify: true}},
+				{Username: "bob", Password: "bob", Permissions: Permissions{Modify: true}},
+				{Username: "carol", Password: "carol", Permissions: Permissions{Modify: true, Scope: other}},
+			},
+		}
+		h := newTestHandler(t, cfg)
+
+		// The scope must exist by the time the user is added.
+		require.NoError(t, os.Symlink(cfg.Scope, link))
+		cfg.Users = append(cfg.Users, User{Username: "dave", Password: "dave", Permissions: Permissions{Modify: true, Scope: link}})
+		require.NoError(t, cfg.Validate())
+		require.NoError(t, h.(*Handler).Reload(cfg))
+		return h
+	}
+
+	put := func(h http.Handler, username string) int {
+		r := httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("new content"))
+		r.SetBasicAuth(username, username)
+		return doRequest(h, r).Code
+	}
+
+	t.Run("Shared", func(t *testing.T) {
+		t.Parallel()
+
+		h := newSharedLocksHandler(t, true)
+
+		r := lockRequest("/file.txt")
+		r.SetBasicAuth("alice", "alice")
+		require.Equal(t, http.StatusOK, doRequest(h, r).Code)
+
+		// The lock is honored for the users with the same scope, even through
+		// a symlink, but not for the users with another scope.
+		require.Equal(t, http.StatusLocked, put(h, "bob"))
+		require.Equal(t, http.StatusLocked, put(h, "dave"))
+		require.Equal(t, http.StatusCreated, put(h, "carol"))
+	})
+
+	t.Run("Not Shared", func(t *testing.T) {
+		t.Parallel()
+
+		h := newSharedLocksHandler(t, false)
+
+		r := lockRequest("/file.txt")
+		r.SetBasicAuth("alice", "alice")
+		require.Equal(t, http.StatusOK, doRequest(h, r).Code)
+
+		require.Equal(t, http.StatusCreated, put(h, "bob"))
+	})
+}
+
+func TestPersistentLocks(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Restart", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0666))
+		file := filepath.Join(t.TempDir(), "locks.json")
+
+		newHandler := func() http.Handler {
+			return newTestHandler(t, &Config{
+				Permissions: Permissions{Scope: dir, Modify: true},
+				Locks:       Locks{File: file},
+			})
+		}
+
+		h := newHandler()
+		w := doRequest(h, lockRequest("/file.txt"))
+		require.Equal(t, http.StatusOK, w.Code)
+		token := w.Header().Get("Lock-Token")
+		require.NotEmpty(t, token)
+		require.FileExists(t, file)
+
+		// The lock is still held by a new handler, as after a restart.
+		h = newHandler()
+		r := httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("new content"))
+		require.Equal(t, http.StatusLocked, doRequest(h, r).Code)
+
+		r = httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("new content"))
+		r.Header.Set("If", "("+token+")")
+		require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+
+		r = httptest.NewRequest("UNLOCK", "/file.txt", nil)
+		r.Header.Set("Lock-Token", token)
+		require.Equal(t, http.StatusNoContent, doRequest(h, r).Code)
+
+		h = newHandler()
+		r = httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("newer content"))
+		require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+	})
+
+	t.Run("Expiration", func(t *testing.T) {
+		t.Parallel()
+
+		file := filepath.Join(t.TempDir(), "locks.json")
+		store, err := newLockStore(file)
+		require.NoError(t, err)
+		ls := newLockSystem(Locks{}, store, "", nil)
+
+		now := time.Now()
+		token, err := ls.Create(now, webdav.LockDetails{Root: "/file.txt", Duration: time.Minute})
+		require.NoError(t, err)
+
+		store, err = newLockStore(file)
+		require.NoError(t, err)
+		ls = newLockSystem(Locks{}, store, "", nil)
+
+		_, err = ls.Create(now.Add(30*time.Second), webdav.LockDetails{Root: "/file.txt", Duration: time.Minute})
+		require.ErrorIs(t, err, webdav.ErrLocked)
+
+		// Refreshing the lock postpones its expiration.
+		details, err := ls.Refresh(now.Add(30*time.Second), token, time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, "/file.txt", details.Root)
+
+		_, err = ls.Create(now.Add(80*time.Second), webdav.LockDetails{Root: "/file.txt", Duration: time.Minute})
+		require.ErrorIs(t, err, webdav.ErrLocked)
+
+		_, err = ls.Create(now.Add(2*time.Minute), webdav.LockDetails{Root: "/file.txt", Duration: time.Minute})
+		require.NoError(t, err)
+		require.ErrorIs(t, ls.Unlock(now.Add(2*time.Minute), token), webdav.ErrNoSuchLock)
+	})
+
+	t.Run("Namespaces", func(t *testing.T) {
+		t.Parallel()
+
+		store, err := newLockStore(filepath.Join(t.TempDir(), "locks.json"))
+		require.NoError(t, err)
+		alice := newLockSystem(Locks{}, store, "alice", nil)
+		bob := newLockSystem(Locks{}, store, "bob", nil)
+
+		now := time.Now()
+		token, err := alice.Create(now, webdav.LockDetails{Root: "/dir", Duration: -1})
+		require.NoError(t, err)
+
+		_, err = alice.Create(now, webdav.LockDetails{Root: "/dir/file.txt", Duration: -1, ZeroDepth: true})
+		require.ErrorIs(t, err, webdav.ErrLocked)
+		_, err = bob.Create(now, webdav.LockDetails{Root: "/dir/file.txt", Duration: -1, ZeroDepth: true})
+		require.NoError(t, err)
+		require.ErrorIs(t, bob.Unlock(now, token), webdav.ErrNoSuchLock)
+	})
+}
@@ -0,0 +1,25 @@
+package lib
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewLogger creates a logger based on the log level and format of the
+// configuration. With debug enabled, the log level is always debug.
+func (c *Config) NewLogger() (*zap.Logger, error) {
+	level, err := zapcore.ParseLevel(c.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	if c.Debug {
+		level = zap.DebugLevel
+	}
+
+	loggerConfig := zap.NewProductionConfig()
+	loggerConfig.DisableCaller = true
+	loggerConfig.Level = zap.NewAtomicLevelAt(level)
+	loggerConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	loggerConfig.Encoding = c.LogFormat
+	return loggerConfig.Build()
+}
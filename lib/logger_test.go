@@ -0,0 +1,38 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestConfigNewLogger(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Level", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{LogLevel: "warn", LogFormat: "json"}
+		logger, err := cfg.NewLogger()
+		require.NoError(t, err)
+		require.False(t, logger.Core().Enabled(zap.InfoLevel))
+		require.True(t, logger.Core().Enabled(zap.WarnLevel))
+	})
+
+	t.Run("Debug", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{LogLevel: "error", LogFormat: "json", Debug: true}
+		logger, err := cfg.NewLogger()
+		require.NoError(t, err)
+		require.True(t, logger.Core().Enabled(zap.DebugLevel))
+	})
+
+	t.Run("Invalid Level", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{LogLevel: "loud"}
+		require.ErrorContains(t, cfg.Validate(), "loud")
+	})
+}
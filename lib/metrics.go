@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsMethods are the methods that are used as label values. Any other
+// method is recorded as "OTHER", so that clients cannot create arbitrarily
+// many time series.
+var metricsMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+	"PROPFIND":         true,
+	"PROPPATCH":        true,
+	"MKCOL":            true,
+	"MKCALENDAR":       true,
+	"COPY":             true,
+	"MOVE":             true,
+	"LOCK":             true,
+	"UNLOCK":           true,
+}
+
+// metrics records the requests served by the handler, and exposes them in the
+// Prometheus format.
+type metrics struct {
+	prefix   string
+	handler  http.Handler
+	requests *prometheus.CounterVec
+	inFlight prometheus.Gauge
+	duration *prometheus.HistogramVec
+}
+
+func newMetrics(c Metrics) *metrics {
+	m := &metrics{
+		prefix: c.Prefix,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.Prefix,
+			Name:      "requests_total",
+			Help:      "Total number of requests, by method and status code.",
+		}, []string{"method", "status"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: c.Prefix,
+			Name:      "requests_in_flight",
+			Help:      "Number of requests currently being served.",
+		}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: c.Prefix,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of the requests, by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+	}
+
+	// Use a dedicated registry, so that multiple handlers can coexist.
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(m.requests, m.inFlight, m.duration)
+	m.handler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+
+	return m
+}
+
+func (m *metrics) observe(method string, status int, duration time.Duration) {
+	if !metricsMethods[method] {
+		method = "OTHER"
+	}
+
+	m.requests.WithLabelValues(method, strconv.Itoa(status)).Inc()
+	m.duration.WithLabelValues(method).Observe(duration.Seconds())
+}
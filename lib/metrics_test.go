@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Auth:    true,
+		Metrics: Metrics{Enabled: true, Path: "/metrics", Prefix: "test"},
+		Users:   []User{{Username: "admin", Password: "admin"}},
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	r.SetBasicAuth("admin", "admin")
+	require.Equal(t, http.StatusOK, doRequest(h, r).Code)
+	require.Equal(t, http.StatusUnauthorized, doRequest(h, httptest.NewRequest("PROPFIND", "/", nil)).Code)
+	require.Equal(t, http.StatusNotImplemented, doRequest(h, httptest.NewRequest("FOOBAR", "/", nil)).Code)
+
+	// The metrics endpoint does not require authentication.
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	require.Contains(t, body, `test_requests_total{method="GET",status="200"} 1`)
+	require.Contains(t, body, `test_requests_total{method="PROPFIND",status="401"} 1`)
+	require.Contains(t, body, `test_requests_total{method="OTHER",status="501"} 1`)
+	require.Contains(t, body, `test_request_duration_seconds_count{method="GET"} 1`)
+	require.Contains(t, body, `test_requests_in_flight 0`)
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{})
+
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,220 @@
+package lib
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+var errCrossMount = errors.New("cannot move across mounts")
+
+// mountFS is a [webdav.FileSystem] that serves other file systems at certain
+// paths, on top of the root file system. The file system with the longest
+// matching path serves each request.
+type mountFS struct {
+	root   webdav.FileSystem
+	mounts []mountPoint
+}
+
+type mountPoint struct {
+	path string
+	fs   webdav.FileSystem
+}
+
+func newMountFS(root webdav.FileSystem, mounts []mountPoint) *mountFS {
+	// The longest paths must match first, as mounts can be nested.
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i].path) > len(mounts[j].path)
+	})
+
+	return &mountFS{root: root, mounts: mounts}
+}
+
+// resolve returns the file system serving the path, and the path within it.
+func (m *mountFS) resolve(name string) (webdav.FileSystem, string) {
+	fs, name, _ := m.resolveMount(name)
+	return fs, name
+}
+
+// resolveMount is like resolve, but also returns the index of the mount, or -1
+// for the root file system.
+func (m *mountFS) resolveMount(name string) (webdav.FileSystem, string, int) {
+	name = path.Clean("/" + name)
+	for i, mount := range m.mounts {
+		if name == mount.path {
+			return mount.fs, "/", i
+		}
+		if rest, ok := strings.CutPrefix(name, mount.path+"/"); ok {
+			return mount.fs, "/" + rest, i
+		}
+	}
+	return m.root, name, -1
+}
+
+// containsMount reports whether the path is a mount point, or contains one.
+// Such paths cannot be removed or renamed.
+func (m *mountFS) containsMount(name string) bool {
+	name = path.Clean("/" + name)
+	for _, mount := range m.mounts {
+		if mount.path == name || name == "/" || strings.HasPrefix(mount.path, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mountFS) isMountPoint(name string) bool {
+	name = path.Clean("/" + name)
+	for _, mount := range m.mounts {
+		if mount.path == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mountFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if m.isMountPoint(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+
+	fs, name := m.resolve(name)
+	return fs.Mkdir(ctx, name, perm)
+}
+
+func (m *mountFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	fs, rel := m.resolve(name)
+	file, err := fs.OpenFile(ctx, rel, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mountDir{File: file, ctx: ctx, fs: m, name: path.Clean("/" + name)}, nil
+}
+
+func (m *mountFS) RemoveAll(ctx context.Context, name string) error {
+	if m.containsMount(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+
+	fs, name := m.resolve(name)
+	return fs.RemoveAll(ctx, name)
+}
+
+func (m *mountFS) Rename(ctx context.Context, oldName, newName string) error {
+	if m.containsMount(oldName) || m.containsMount(newName) {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrPermission}
+	}
+
+	fs, oldRel, oldMount := m.resolveMount(oldName)
+	_, newRel, newMount := m.resolveMount(newName)
+	if oldMount != newMount {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: errCrossMount}
+	}
+	return fs.Rename(ctx, oldRel, newRel)
+}
+
+func (m *mountFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	fs, rel := m.resolve(name)
+	info, err := fs.Stat(ctx, rel)
+	if err != nil {
+		return nil, err
+	}
+
+	// The root of the mounted file system is named after the mount point.
+	if m.isMountPoint(name) {
+		info = mountInfo{FileInfo: info, name: path.Base(path.Clean("/" + name))}
+	}
+	return info, nil
+}
+
+// mountDir lists the mount points within a directory, in addition to its
+// contents, hiding the files the mount points are on top of.
+type mountDir struct {
+	webdav.File
+	ctx    context.Context
+	fs     *mountFS
+	name   string
+	listed bool
+}
+
+func (d *mountDir) Stat() (os.FileInfo, error) {
+	info, err := d.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.fs.isMountPoint(d.name) {
+		info = mountInfo{FileInfo: info, name: path.Base(d.name)}
+	}
+	return info, nil
+}
+
+// ReadFrom lets the wrapped file notice when reading the request body fails,
+// see [uploadFile].
+func (d *mountDir) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := d.File.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{d.File}, r)
+}
+
+func (d *mountDir) DeadProps() (map[xml.Name]webdav.Property, error) {
+	return wrappedDeadProps(d.File)
+}
+
+func (d *mountDir) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return wrappedPatch(d.File, patches)
+}
+
+func (d *mountDir) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := d.File.Readdir(count)
+	if d.listed || (err != nil && err != io.EOF) {
+		return infos, err
+	}
+	d.listed = true
+
+	var mounted []os.FileInfo
+	names := map[string]bool{}
+	for _, mount := range d.fs.mounts {
+		if path.Dir(mount.path) != d.name {
+			continue
+		}
+
+		info, err := mount.fs.Stat(d.ctx, "/")
+		if err != nil {
+			continue
+		}
+		name := path.Base(mount.path)
+		mounted = append(mounted, mountInfo{FileInfo: info, name: name})
+		names[name] = true
+	}
+	if len(mounted) == 0 {
+		return infos, err
+	}
+
+	visible := infos[:0]
+	for _, info := range infos {
+		if !names[info.Name()] {
+			visible = append(visible, info)
+		}
+	}
+	return append(visible, mounted...), nil
+}
+
+// mountInfo renames the root of a mounted file system.
+type mountInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi mountInfo) Name() string {
+	return fi.name
+}
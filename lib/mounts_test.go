@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMounts(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T) (http.Handler, string, string, string) {
+		photos, docs, archive := t.TempDir(), t.TempDir(), t.TempDir()
+		cfg := &Config{
+			Auth: true,
+			Mounts: []Mount{
+				{Path: "/photos", Scope: photos},
+				{Path: "/docs/", Scope: docs},
+				{Path: "/docs/archive", Scope: archive},
+			},
+			Users: []User{
+				{Username: "admin", Password: "admin", Permissions: Permissions{Modify: true}},
+				{Username: "guest", Password: "guest", Permissions: Permissions{
+					Modify: true,
+					Rules:  []*Rule{{Path: "/docs", Allow: true, Modify: false}},
+				}},
+			},
+		}
+		h := newTestHandler(t, cfg)
+		return h, photos, docs, archive
+	}
+
+	request := func(h http.Handler, username, method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.SetBasicAuth(username, username)
+		return doRequest(h, r)
+	}
+
+	t.Run("Isolation", func(t *testing.T) {
+		t.Parallel()
+
+		h, photos, docs, _ := newHandler(t)
+		require.Equal(t, http.StatusCreated, request(h, "admin", http.MethodPut, "/photos/cat.jpg", "cat").Code)
+		require.Equal(t, http.StatusCreated, request(h, "admin", http.MethodPut, "/docs/notes.txt", "notes").Code)
+
+		data, err := os.ReadFile(filepath.Join(photos, "cat.jpg"))
+		require.NoError(t, err)
+		require.Equal(t, "cat", string(data))
+		require.NoFileExists(t, filepath.Join(docs, "cat.jpg"))
+
+		data, err = os.ReadFile(filepath.Join(docs, "notes.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "notes", string(data))
+		require.NoFileExists(t, filepath.Join(photos, "notes.txt"))
+
+		w := request(h, "admin", http.MethodGet, "/photos/cat.jpg", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "cat", w.Body.String())
+		require.Equal(t, http.StatusNotFound, request(h, "admin", http.MethodGet, "/docs/cat.jpg", "").Code)
+
+		// The scope is still served outside of the mounts.
+		require.Equal(t, http.StatusOK, request(h, "admin", http.MethodGet, "/file.txt", "").Code)
+	})
+
+	t.Run("Nested Mounts", func(t *testing.T) {
+		t.Parallel()
+
+		h, _, docs, archive := newHandler(t)
+		require.Equal(t, http.StatusCreated, request(h, "admin", http.MethodPut, "/docs/archive/old.txt", "old").Code)
+		require.FileExists(t, filepath.Join(archive, "old.txt"))
+		require.NoDirExists(t, filepath.Join(docs, "archive"))
+	})
+
+	t.Run("Listing", func(t *testing.T) {
+		t.Parallel()
+
+		h, _, _, _ := newHandler(t)
+		r := httptest.NewRequest("PROPFIND", "/", nil)
+		r.Header.Set("Depth", "1")
+		r.SetBasicAuth("admin", "admin")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.Contains(t, w.Body.String(), "<D:href>/photos/</D:href>")
+		require.Contains(t, w.Body.String(), "<D:href>/docs/</D:href>")
+		require.Contains(t, w.Body.String(), "<D:href>/file.txt</D:href>")
+
+		r = httptest.NewRequest("PROPFIND", "/docs/", nil)
+		r.Header.Set("Depth", "1")
+		r.SetBasicAuth("admin", "admin")
+		w = doRequest(h, r)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.Contains(t, w.Body.String(), "<D:href>/docs/archive/</D:href>")
+		require.Contains(t, w.Body.String(), "<D:displayname>archive</D:displayname>")
+	})
+
+	t.Run("Permissions", func(t *testing.T) {
+		t.Parallel()
+
+		h, photos, docs, _ := newHandler(t)
+		require.Equal(t, http.StatusCreated, request(h, "guest", http.MethodPut, "/photos/dog.jpg", "dog").Code)
+		require.FileExists(t, filepath.Join(photos, "dog.jpg"))
+
+		require.Equal(t, http.StatusForbidden, request(h, "guest", http.MethodPut, "/docs/notes.txt", "notes").Code)
+		require.NoFileExists(t, filepath.Join(docs, "notes.txt"))
+	})
+
+	t.Run("Mount Points", func(t *testing.T) {
+		t.Parallel()
+
+		h, photos, _, _ := newHandler(t)
+		require.Equal(t, http.StatusCreated, request(h, "admin", http.MethodPut, "/photos/cat.jpg", "cat").Code)
+
+		// The mount points cannot be removed or moved.
+		require.GreaterOrEqual(t, request(h, "admin", http.MethodDelete, "/photos", "").Code, 400)
+		require.GreaterOrEqual(t, request(h, "admin", http.MethodDelete, "/docs", "").Code, 400)
+		require.FileExists(t, filepath.Join(photos, "cat.jpg"))
+
+		r := httptest.NewRequest("MOVE", "/photos", nil)
+		r.Header.Set("Destination", "/pictures")
+		r.SetBasicAuth("admin", "admin")
+		require.GreaterOrEqual(t, doRequest(h, r).Code, 400)
+		require.Equal(t, http.StatusMethodNotAllowed, request(h, "admin", "MKCOL", "/photos", "").Code)
+	})
+
+	t.Run("Across Mounts", func(t *testing.T) {
+		t.Parallel()
+
+		h, photos, docs, _ := newHandler(t)
+		require.Equal(t, http.StatusCreated, request(h, "admin", http.MethodPut, "/photos/cat.jpg", "cat").Code)
+
+		r := httptest.NewRequest("MOVE", "/photos/cat.jpg", nil)
+		r.Header.Set("Destination", "/docs/cat.jpg")
+		r.SetBasicAuth("admin", "admin")
+		require.GreaterOrEqual(t, doRequest(h, r).Code, 400)
+		require.FileExists(t, filepath.Join(photos, "cat.jpg"))
+
+		// Copying works, since it does not rely on renaming.
+		r = httptest.NewRequest("COPY", "/photos/cat.jpg", nil)
+		r.Header.Set("Destination", "/docs/cat.jpg")
+		r.SetBasicAuth("admin", "admin")
+		require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+		require.FileExists(t, filepath.Join(docs, "cat.jpg"))
+	})
+}
+
+func TestConfigMounts(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+mounts:
+  - path: /photos/
+    scope: /srv/photos
+  - path: /docs
+    scope: /srv/docs`, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, []Mount{{Path: "/photos", Scope: "/srv/photos"}, {Path: "/docs", Scope: "/srv/docs"}}, cfg.Mounts)
+
+	for name, mounts := range map[string][]Mount{
+		"Relative Path": {{Path: "photos", Scope: "/srv/photos"}},
+		"Root":          {{Path: "/", Scope: "/srv/photos"}},
+		"Duplicate":     {{Path: "/photos", Scope: "/srv/photos"}, {Path: "/photos/", Scope: "/srv/other"}},
+		"No Scope":      {{Path: "/photos"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := &Config{Mounts: mounts}
+			require.Error(t, cfg.Validate())
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"net/http"
+	"net/netip"
+
+	"go.uber.org/zap"
+)
+
+// networkFilter restricts the networks the requests can come from. The denied
+// networks take precedence over the allowed ones and, if any network is
+// allowed, the requests from the other networks are denied.
+type networkFilter struct {
+	allowed []netip.Prefix
+	denied  []netip.Prefix
+}
+
+func newNetworkFilter(c Networks) *networkFilter {
+	if len(c.Allowed) == 0 && len(c.Denied) == 0 {
+		return nil
+	}
+
+	// The networks are validated beforehand.
+	parse := func(networks []string) []netip.Prefix {
+		prefixes := make([]netip.Prefix, 0, len(networks))
+		for _, network := range networks {
+			if prefix, err := parsePrefix(network); err == nil {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+		return prefixes
+	}
+
+	return &networkFilter{allowed: parse(c.Allowed), denied: parse(c.Denied)}
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether the requests from the address are allowed. Addresses
+// that cannot be parsed are only allowed if no network is.
+func (f *networkFilter) allows(address string) bool {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return len(f.allowed) == 0
+	}
+	addr = addr.Unmap()
+
+	if containsAddr(f.denied, addr) {
+		return false
+	}
+	return len(f.allowed) == 0 || containsAddr(f.allowed, addr)
+}
+
+// rejectNetwork checks whether the request comes from a network that is not
+// allowed. If so, the response is written and true is returned.
+func (h *handler) rejectNetwork(w http.ResponseWriter, r *http.Request) bool {
+	if h.networks == nil || h.networks.allows(remoteHost(r)) {
+		return false
+	}
+
+	requestLogger(r, h.logger).Debug("request from denied network", zap.String("remote_address", r.RemoteAddr))
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return true
+}
@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerNetworks(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Auth:  true,
+		Users: []User{{Username: "admin", Password: "admin"}},
+		Networks: Networks{
+			Allowed: []string{"10.0.0.0/8", "2001:db8::/32"},
+			Denied:  []string{"10.0.0.13"},
+		},
+		Proxy: Proxy{Enabled: true, Trusted: []string{"127.0.0.1"}},
+	})
+
+	request := func(remoteAddr, forwardedFor string) int {
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		r.SetBasicAuth("admin", "admin")
+		return doRequest(h, r).Code
+	}
+
+	t.Run("Allowed", func(t *testing.T) {
+		t.Parallel()
+
+		require.Equal(t, http.StatusOK, request("10.1.2.3:1234", ""))
+		require.Equal(t, http.StatusOK, request("[2001:db8::1]:1234", ""))
+		require.Equal(t, http.StatusOK, request("[::ffff:10.1.2.3]:1234", ""))
+	})
+
+	t.Run("Denied", func(t *testing.T) {
+		t.Parallel()
+
+		require.Equal(t, http.StatusForbidden, request("10.0.0.13:1234", ""))
+	})
+
+	t.Run("Not Allowed", func(t *testing.T) {
+		t.Parallel()
+
+		require.Equal(t, http.StatusForbidden, request("192.0.2.1:1234", ""))
+		require.Equal(t, http.StatusForbidden, request("invalid", ""))
+
+		// Even without credentials, the request is rejected before
+		// authentication.
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Empty(t, w.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("Proxy", func(t *testing.T) {
+		t.Parallel()
+
+		// The address of the client is used, and not the one of the proxy.
+		require.Equal(t, http.StatusOK, request("127.0.0.1:1234", "10.1.2.3"))
+		require.Equal(t, http.StatusForbidden, request("127.0.0.1:1234", "10.0.0.13"))
+		require.Equal(t, http.StatusForbidden, request("127.0.0.1:1234", ""))
+
+		// The header cannot be spoofed by untrusted clients.
+		require.Equal(t, http.StatusForbidden, request("192.0.2.1:1234", "10.1.2.3"))
+	})
+}
+
+func TestHandlerNetworksDenyOnly(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{Networks: Networks{Denied: []string{"192.0.2.0/24"}}})
+
+	r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	require.Equal(t, http.StatusForbidden, doRequest(h, r).Code)
+
+	r.RemoteAddr = "198.51.100.1:1234"
+	require.Equal(t, http.StatusOK, doRequest(h, r).Code)
+}
+
+func TestConfigNetworks(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+networks:
+  allowed:
+    - 10.0.0.0/8
+  denied:
+    - 10.0.0.1`, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, Networks{Allowed: []string{"10.0.0.0/8"}, Denied: []string{"10.0.0.1"}}, cfg.Networks)
+
+	cfg = &Config{Networks: Networks{Denied: []string{"10.0.0.0/33"}}}
+	require.Error(t, cfg.Validate())
+}
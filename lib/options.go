@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"net/http"
+	"strings"
+)
+
+// davClasses are the WebDAV compliance classes supported by the server: class 1
+// and, since locking is supported, class 2.
+//
+// See http://www.webdav.org/specs/rfc4918.html#dav.compliance.classes
+const davClasses = "1, 2"
+
+var (
+	// missingResourceMethods can be used on a path where nothing exists yet.
+	missingResourceMethods = []string{http.MethodOptions, "LOCK", http.MethodPut, "MKCOL"}
+
+	// collectionMethods can be used on a collection.
+	collectionMethods = []string{http.MethodOptions, "LOCK", http.MethodGet, http.MethodHead, http.MethodDelete, "PROPPATCH", "COPY", "MOVE", "UNLOCK", "PROPFIND"}
+
+	// fileMethods can be used on a file.
+	fileMethods = []string{http.MethodOptions, "LOCK", http.MethodGet, http.MethodHead, http.MethodPost, http.MethodDelete, "PROPPATCH", "COPY", "MOVE", "UNLOCK", "PROPFIND", http.MethodPut}
+
+	// serverMethods are all the methods supported by the server, on any kind
+	// of resource.
+	serverMethods = append(fileMethods[:len(fileMethods):len(fileMethods)], "MKCOL", http.MethodPatch, "MKCALENDAR")
+)
+
+// isServerMethod reports whether the method is supported by the server.
+func isServerMethod(method string) bool {
+	for _, m := range serverMethods {
+		if method == m {
+			return true
+		}
+	}
+
+	return false
+}
+
+// supportsMethod reports whether the method is supported by the server, as
+// configured, even if it is disabled.
+func (h *handler) supportsMethod(method string) bool {
+	switch method {
+	case http.MethodPatch:
+		return h.partialUpdates
+	case "MKCALENDAR":
+		return h.caldav
+	}
+	return isServerMethod(method)
+}
+
+// enabledMethods returns the methods the server supports, leaving out the ones
+// disabled by the configuration.
+func (h *handler) enabledMethods() []string {
+	var enabled []string
+	for _, method := range serverMethods {
+		if h.disabledMethods[method] || (h.readOnly && !isReadMethod(method)) || !h.supportsMethod(method) {
+			continue
+		}
+		enabled = append(enabled, method)
+	}
+	return enabled
+}
+
+// davCompliance returns the compliance classes, which only include locking if
+// the lock methods are enabled.
+func (h *handler) davCompliance() string {
+	classes := davClasses
+	if h.disabledMethods["LOCK"] || h.disabledMethods["UNLOCK"] {
+		classes = "1"
+	}
+	if h.partialUpdates {
+		classes += ", " + partialUpdateClass
+	}
+	if h.caldav {
+		classes += ", " + calendarAccessClass
+	}
+	return classes
+}
+
+// serveOptions replies to an OPTIONS request, advertising only the methods the
+// user is actually allowed to use on the path.
+func (h *handler) serveOptions(w http.ResponseWriter, r *http.Request, user *handlerUser) {
+	methods := missingResourceMethods
+	if h.caldav {
+		methods = append(methods[:len(methods):len(methods)], "MKCALENDAR")
+	}
+	if info, err := user.FileSystem.Stat(r.Context(), user.name(r)); err == nil {
+		if info.IsDir() {
+			methods = collectionMethods
+		} else {
+			methods = fileMethods
+			if h.partialUpdates {
+				methods = append(methods[:len(methods):len(methods)], http.MethodPatch)
+			}
+		}
+	}
+
+	var allowed []string
+	for _, method := range methods {
+		if h.disabledMethods[method] || (h.readOnly && !isReadMethod(method)) {
+			continue
+		}
+
+		if user.allowedMethod(method, r.URL.Path) {
+			allowed = append(allowed, method)
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	w.Header().Set("DAV", h.davCompliance())
+	if h.partialUpdates {
+		w.Header().Set("Accept-Patch", partialUpdateContentType)
+	}
+	// http://msdn.microsoft.com/en-au/library/cc250217.aspx
+	w.Header().Set("MS-Author-Via", "DAV")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Auth: true,
+		Users: []User{
+			{Username: "reader", Password: "reader"},
+			{Username: "writer", Password: "writer", Permissions: Permissions{
+				Modify: true,
+				Rules:  []*Rule{{Path: "/dir/", Allow: true, Modify: false}},
+			}},
+		},
+	})
+
+	options := func(username, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodOptions, path, nil)
+		r.SetBasicAuth(username, username)
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "1, 2", w.Header().Get("DAV"))
+		return w
+	}
+
+	for _, tc := range []struct {
+		username string
+		path     string
+		allow    string
+	}{
+		// Copying only reads the source, the destination is checked on request.
+		{"reader", "/file.txt", "OPTIONS, GET, HEAD, COPY, PROPFIND"},
+		{"reader", "/dir/", "OPTIONS, GET, HEAD, COPY, PROPFIND"},
+		{"reader", "/missing.txt", "OPTIONS"},
+		{"writer", "/file.txt", "OPTIONS, LOCK, GET, HEAD, POST, DELETE, PROPPATCH, COPY, MOVE, UNLOCK, PROPFIND, PUT"},
+		{"writer", "/", "OPTIONS, LOCK, GET, HEAD, DELETE, PROPPATCH, COPY, MOVE, UNLOCK, PROPFIND"},
+		{"writer", "/missing.txt", "OPTIONS, LOCK, PUT, MKCOL"},
+		{"writer", "/dir/", "OPTIONS, GET, HEAD, COPY, PROPFIND"},
+	} {
+		w := options(tc.username, tc.path)
+		require.Equal(t, tc.allow, w.Header().Get("Allow"), "%s %s", tc.username, tc.path)
+	}
+}
+
+func TestOptionsReadOnly(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Permissions: Permissions{Modify: true},
+		ReadOnly:    true,
+	})
+
+	w := doRequest(h, httptest.NewRequest(http.MethodOptions, "/file.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "OPTIONS, GET, HEAD, PROPFIND", w.Header().Get("Allow"))
+}
+
+func TestUnknownMethods(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{Permissions: Permissions{Modify: true}})
+	for _, method := range []string{"FOOBAR", "MKCALENDAR", http.MethodPatch} {
+		w := doRequest(h, httptest.NewRequest(method, "/file.txt", nil))
+		require.Equal(t, http.StatusNotImplemented, w.Code, method)
+		require.Equal(t, "OPTIONS, LOCK, GET, HEAD, POST, DELETE, PROPPATCH, COPY, MOVE, UNLOCK, PROPFIND, PUT, MKCOL", w.Header().Get("Allow"), method)
+	}
+
+	h = newTestHandler(t, &Config{
+		Permissions:    Permissions{Modify: true},
+		PartialUpdates: true,
+		ReadOnly:       true,
+		UnknownStatus:  http.StatusMethodNotAllowed,
+	})
+	w := doRequest(h, httptest.NewRequest("FOOBAR", "/file.txt", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	require.Equal(t, "OPTIONS, GET, HEAD, PROPFIND", w.Header().Get("Allow"))
+}
+
+func TestConfigUnknownMethodStatus(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, "scope: /\n", ".yml")
+	require.Equal(t, http.StatusNotImplemented, cfg.UnknownStatus)
+
+	cfg = &Config{UnknownStatus: http.StatusTeapot}
+	require.ErrorContains(t, cfg.Validate(), "unknown method status")
+}
@@ -0,0 +1,381 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// whiteoutPrefix starts the names of the whiteouts, the markers that the upper
+// layer of an overlay keeps for the entries deleted from the lower layer.
+const whiteoutPrefix = ".wh."
+
+// overlayFS is a [webdav.FileSystem] that overlays a writable upper layer on
+// top of a read-only lower layer. The entries are read from the upper layer if
+// there, or else from the lower one, and are only ever written to the upper
+// layer, the files of the lower layer being copied up first if need be.
+//
+// The entries deleted from the lower layer are hidden by a whiteout next to
+// them in the upper layer. A directory of the upper layer with a whiteout hides
+// the directory of the lower layer with the same name, so that a deleted
+// directory can be created again, empty.
+type overlayFS struct {
+	lower webdav.FileSystem
+	upper webdav.FileSystem
+}
+
+// isWhiteout reports whether the path is, or is within, a whiteout, which the
+// clients cannot access.
+func isWhiteout(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, whiteoutPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// whiteoutName returns the name of the whiteout of the path.
+func whiteoutName(name string) string {
+	dir, base := path.Split(path.Clean("/" + name))
+	return path.Join(dir, whiteoutPrefix+base)
+}
+
+func exists(ctx context.Context, fs webdav.FileSystem, name string) bool {
+	_, err := fs.Stat(ctx, name)
+	return err == nil
+}
+
+// inLower returns the entry of the lower layer with the given name, unless the
+// upper layer hides it with a whiteout, or with a file in place of one of its
+// directories.
+func (o overlayFS) inLower(ctx context.Context, name string) (os.FileInfo, bool) {
+	name = path.Clean("/" + name)
+	if name != "/" {
+		current := "/"
+		for _, part := range strings.Split(name[1:], "/") {
+			if info, err := o.upper.Stat(ctx, current); err == nil && !info.IsDir() {
+				return nil, false
+			}
+			current = path.Join(current, part)
+			if exists(ctx, o.upper, whiteoutName(current)) {
+				return nil, false
+			}
+		}
+	}
+
+	info, err := o.lower.Stat(ctx, name)
+	return info, err == nil
+}
+
+// copyUpParents creates the parent directories of the path in the upper layer,
+// as they are in the lower layer.
+func (o overlayFS) copyUpParents(ctx context.Context, name string) error {
+	dir := path.Dir(path.Clean("/" + name))
+	if dir == "/" {
+		return nil
+	}
+
+	current := "/"
+	for _, part := range strings.Split(dir[1:], "/") {
+		current = path.Join(current, part)
+		if exists(ctx, o.upper, current) {
+			continue
+		}
+
+		info, ok := o.inLower(ctx, current)
+		if !ok || !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: current, Err: os.ErrNotExist}
+		}
+		// The copies are writable, even if the originals are not.
+		if err := o.upper.Mkdir(ctx, current, info.Mode().Perm()|0700); err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies a file to the upper layer.
+func (o overlayFS) copyFile(ctx context.Context, fs webdav.FileSystem, src, dst string, perm os.FileMode) error {
+	in, err := fs.OpenFile(ctx, src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := o.upper.OpenFile(ctx, dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyTree copies an entry of the overlay, and all its members, to another path
+// of the upper layer.
+func (o overlayFS) copyTree(ctx context.Context, src, dst string) error {
+	info, err := o.Stat(ctx, src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return o.copyFile(ctx, o, src, dst, info.Mode().Perm())
+	}
+
+	if err := o.upper.Mkdir(ctx, dst, info.Mode().Perm()|0700); err != nil {
+		return err
+	}
+
+	members, err := o.readdir(ctx, src)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if err := o.copyTree(ctx, path.Join(src, member.Name()), path.Join(dst, member.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// whiteout hides the entry of the lower layer with the given name.
+func (o overlayFS) whiteout(ctx context.Context, name string) error {
+	if err := o.copyUpParents(ctx, name); err != nil {
+		return err
+	}
+
+	f, err := o.upper.OpenFile(ctx, whiteoutName(name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// readdir returns the members of a directory, from both layers.
+func (o overlayFS) readdir(ctx context.Context, name string) ([]os.FileInfo, error) {
+	var members []os.FileInfo
+	seen := map[string]bool{}
+	if f, err := o.upper.OpenFile(ctx, name, os.O_RDONLY, 0); err == nil {
+		infos, err := f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if !strings.HasPrefix(info.Name(), whiteoutPrefix) {
+				seen[info.Name()] = true
+				members = append(members, info)
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if info, ok := o.inLower(ctx, name); !ok || !info.IsDir() {
+		return members, nil
+	}
+
+	f, err := o.lower.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if seen[info.Name()] || strings.HasPrefix(info.Name(), whiteoutPrefix) || exists(ctx, o.upper, whiteoutName(path.Join(name, info.Name()))) {
+			continue
+		}
+		members = append(members, info)
+	}
+	return members, nil
+}
+
+func (o overlayFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if isWhiteout(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrNotExist}
+	}
+	if _, ok := o.inLower(ctx, name); ok {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	if err := o.copyUpParents(ctx, name); err != nil {
+		return err
+	}
+	return o.upper.Mkdir(ctx, name, perm)
+}
+
+func (o overlayFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if isWhiteout(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) == 0 {
+		return o.open(ctx, name)
+	}
+
+	if _, err := o.upper.Stat(ctx, name); !errors.Is(err, os.ErrNotExist) {
+		return o.upper.OpenFile(ctx, name, flag, perm)
+	}
+
+	// The files of the lower layer are copied up before they are written to,
+	// unless they are replaced.
+	lowerInfo, ok := o.inLower(ctx, name)
+	switch {
+	case ok && lowerInfo.IsDir():
+		return nil, &os.PathError{Op: "open", Path: name, Err: errIsDirectory}
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	if err := o.copyUpParents(ctx, name); err != nil {
+		return nil, err
+	}
+	if ok && flag&os.O_TRUNC == 0 {
+		if err := o.copyFile(ctx, o.lower, name, name, lowerInfo.Mode().Perm()); err != nil {
+			return nil, err
+		}
+	}
+	return o.upper.OpenFile(ctx, name, flag, perm)
+}
+
+// open opens an entry for reading, from the upper layer if there.
+func (o overlayFS) open(ctx context.Context, name string) (webdav.File, error) {
+	f, err := o.upper.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		if _, ok := o.inLower(ctx, name); !ok {
+			return nil, err
+		}
+		f, err = o.lower.OpenFile(ctx, name, os.O_RDONLY, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		return &overlayDir{File: f, fs: o, ctx: ctx, name: name}, nil
+	}
+	return f, nil
+}
+
+func (o overlayFS) RemoveAll(ctx context.Context, name string) error {
+	if isWhiteout(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	if path.Clean("/"+name) == "/" {
+		return o.upper.RemoveAll(ctx, name)
+	}
+
+	_, ok := o.inLower(ctx, name)
+	if err := o.upper.RemoveAll(ctx, name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if ok {
+		return o.whiteout(ctx, name)
+	}
+	return nil
+}
+
+func (o overlayFS) Rename(ctx context.Context, oldName, newName string) error {
+	if isWhiteout(oldName) || isWhiteout(newName) {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrNotExist}
+	}
+
+	_, oldInLower := o.inLower(ctx, oldName)
+	_, newInLower := o.inLower(ctx, newName)
+	if err := o.copyUpParents(ctx, newName); err != nil {
+		return err
+	}
+
+	// The entries with members in the lower layer are copied, as it is never
+	// written to. The others are moved within the upper layer.
+	upperInfo, err := o.upper.Stat(ctx, oldName)
+	if oldInLower && (err != nil || upperInfo.IsDir()) {
+		if err := o.copyTree(ctx, oldName, newName); err != nil {
+			return err
+		}
+		if err := o.RemoveAll(ctx, oldName); err != nil {
+			return err
+		}
+	} else {
+		if err := o.upper.Rename(ctx, oldName, newName); err != nil {
+			return err
+		}
+		if oldInLower {
+			if err := o.whiteout(ctx, oldName); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The moved entry hides the one of the lower layer it replaces.
+	if newInLower {
+		return o.whiteout(ctx, newName)
+	}
+	return nil
+}
+
+func (o overlayFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if isWhiteout(name) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+
+	info, err := o.upper.Stat(ctx, name)
+	if !errors.Is(err, os.ErrNotExist) {
+		return info, err
+	}
+	if info, ok := o.inLower(ctx, name); ok {
+		return info, nil
+	}
+	return nil, err
+}
+
+// overlayDir is a directory of an overlay, whose members are the ones of both
+// layers.
+type overlayDir struct {
+	webdav.File
+	fs      overlayFS
+	ctx     context.Context
+	name    string
+	members []os.FileInfo
+	listed  bool
+}
+
+func (d *overlayDir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.listed {
+		members, err := d.fs.readdir(d.ctx, d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.members, d.listed = members, true
+	}
+
+	if count <= 0 {
+		members := d.members
+		d.members = nil
+		return members, nil
+	}
+	if len(d.members) == 0 {
+		return nil, io.EOF
+	}
+
+	n := min(count, len(d.members))
+	members := d.members[:n]
+	d.members = d.members[n:]
+	return members, nil
+}
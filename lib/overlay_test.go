@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerOverlay(t *testing.T) {
+	t.Parallel()
+
+	newOverlayHandler := func(t *testing.T) (http.Handler, string, string) {
+		lower := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(lower, "shared.txt"), []byte("shared"), 0444))
+		require.NoError(t, os.Mkdir(filepath.Join(lower, "docs"), 0555))
+		require.NoError(t, os.WriteFile(filepath.Join(lower, "docs", "a.txt"), []byte("a"), 0444))
+		t.Cleanup(func() {
+			// The temporary directory must be writable to be removed.
+			_ = os.Chmod(filepath.Join(lower, "docs"), 0755)
+		})
+
+		cfg := &Config{
+			Permissions: Permissions{Modify: true},
+			LowerScope:  lower,
+		}
+		return newTestHandler(t, cfg), lower, cfg.Scope
+	}
+
+	do := func(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if method == "PROPFIND" {
+			r.Header.Set("Depth", "1")
+		}
+		return doRequest(h, r)
+	}
+
+	t.Run("Read Through", func(t *testing.T) {
+		t.Parallel()
+
+		h, _, _ := newOverlayHandler(t)
+
+		w := do(h, http.MethodGet, "/shared.txt", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "shared", w.Body.String())
+
+		w = do(h, "PROPFIND", "/", "")
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		for _, name := range []string{"shared.txt", "file.txt", "docs", "dir"} {
+			require.Contains(t, w.Body.String(), "<D:href>/"+name, name)
+		}
+	})
+
+	t.Run("Write To Upper", func(t *testing.T) {
+		t.Parallel()
+
+		h, lower, upper := newOverlayHandler(t)
+
+		require.Equal(t, http.StatusCreated, do(h, http.MethodPut, "/shared.txt", "mine").Code)
+		require.Equal(t, "mine", do(h, http.MethodGet, "/shared.txt", "").Body.String())
+		require.Equal(t, http.StatusCreated, do(h, http.MethodPut, "/docs/b.txt", "b").Code)
+
+		data, err := os.ReadFile(filepath.Join(lower, "shared.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "shared", string(data))
+		data, err = os.ReadFile(filepath.Join(upper, "docs", "b.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "b", string(data))
+
+		w := do(h, "PROPFIND", "/docs/", "")
+		require.Contains(t, w.Body.String(), "/docs/a.txt")
+		require.Contains(t, w.Body.String(), "/docs/b.txt")
+
+		// The moves copy the files of the lower scope.
+		r := httptest.NewRequest("MOVE", "/docs/a.txt", nil)
+		r.Header.Set("Destination", "/moved.txt")
+		require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+		require.Equal(t, "a", do(h, http.MethodGet, "/moved.txt", "").Body.String())
+		require.Equal(t, http.StatusNotFound, do(h, http.MethodGet, "/docs/a.txt", "").Code)
+		require.FileExists(t, filepath.Join(lower, "docs", "a.txt"))
+	})
+
+	t.Run("Delete Via Whiteout", func(t *testing.T) {
+		t.Parallel()
+
+		h, lower, upper := newOverlayHandler(t)
+
+		require.Equal(t, http.StatusNoContent, do(h, http.MethodDelete, "/docs/a.txt", "").Code)
+		require.Equal(t, http.StatusNotFound, do(h, http.MethodGet, "/docs/a.txt", "").Code)
+		require.FileExists(t, filepath.Join(lower, "docs", "a.txt"))
+		require.FileExists(t, filepath.Join(upper, "docs", ".wh.a.txt"))
+
+		// The whiteouts cannot be seen, nor accessed.
+		w := do(h, "PROPFIND", "/docs/", "")
+		require.NotContains(t, w.Body.String(), "a.txt")
+		require.Equal(t, http.StatusNotFound, do(h, http.MethodGet, "/docs/.wh.a.txt", "").Code)
+
+		// A file can be created again in place of a deleted one.
+		require.Equal(t, http.StatusCreated, do(h, http.MethodPut, "/docs/a.txt", "new").Code)
+		require.Equal(t, "new", do(h, http.MethodGet, "/docs/a.txt", "").Body.String())
+
+		// And a deleted directory is created again empty.
+		require.Equal(t, http.StatusNoContent, do(h, http.MethodDelete, "/docs/", "").Code)
+		require.Equal(t, http.StatusNotFound, do(h, "PROPFIND", "/docs/", "").Code)
+		require.Equal(t, http.StatusCreated, do(h, "MKCOL", "/docs/", "").Code)
+		w = do(h, "PROPFIND", "/docs/", "")
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.NotContains(t, w.Body.String(), "a.txt")
+		require.DirExists(t, filepath.Join(lower, "docs"))
+	})
+}
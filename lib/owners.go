@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// owner is the user and the group that own the files created by a user, -1
+// leaving either unchanged, as with [os.Lchown].
+type owner struct {
+	uid int
+	gid int
+}
+
+// parseOwner parses an owner given as "uid", "uid:gid" or ":gid".
+func parseOwner(s string) (*owner, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	uid, gid, _ := strings.Cut(s, ":")
+	o := &owner{uid: -1, gid: -1}
+	for _, id := range []struct {
+		value string
+		dst   *int
+	}{{uid, &o.uid}, {gid, &o.gid}} {
+		if id.value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(id.value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid owner %q", s)
+		}
+		*id.dst = n
+	}
+
+	if o.uid < 0 && o.gid < 0 {
+		return nil, fmt.Errorf("invalid owner %q", s)
+	}
+	return o, nil
+}
+
+type ownerKey struct{}
+
+// withOwner makes the files created while serving the request owned by the
+// given owner.
+func withOwner(r *http.Request, o *owner) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), ownerKey{}, o))
+}
+
+func ownerFrom(ctx context.Context) *owner {
+	o, _ := ctx.Value(ownerKey{}).(*owner)
+	return o
+}
+
+// ownedDir is a directory on disk whose created files and directories are
+// given the owner of the request, if any. Changing the owner of the files
+// requires privileges.
+type ownedDir struct {
+	webdav.FileSystem
+	scope string
+}
+
+// chown gives the owner of the request to the file with the given name.
+func (d ownedDir) chown(ctx context.Context, name string) error {
+	o := ownerFrom(ctx)
+	if o == nil {
+		return nil
+	}
+
+	return os.Lchown(filepath.Join(d.scope, filepath.FromSlash(path.Clean("/"+name))), o.uid, o.gid)
+}
+
+func (d ownedDir) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := d.FileSystem.Mkdir(ctx, name, perm); err != nil {
+		return err
+	}
+	return d.chown(ctx, name)
+}
+
+func (d ownedDir) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	file, err := d.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil || flag&os.O_CREATE == 0 {
+		return file, err
+	}
+
+	if err := d.chown(ctx, name); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
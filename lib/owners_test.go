@@ -0,0 +1,85 @@
+//go:build unix
+
+package lib
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerChown(t *testing.T) {
+	t.Parallel()
+
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of files requires root")
+	}
+
+	cfg := &Config{
+		Auth:  true,
+		Chown: true,
+		Users: []User{
+			{Username: "alice", Password: "alice", Owner: "1234:5678", Permissions: Permissions{Modify: true}},
+			{Username: "bob", Password: "bob", Permissions: Permissions{Modify: true}},
+		},
+	}
+	h := newTestHandler(t, cfg)
+
+	do := func(method, path, username string) {
+		var body io.Reader
+		if method == http.MethodPut {
+			body = strings.NewReader("content")
+		}
+		r := httptest.NewRequest(method, path, body)
+		r.SetBasicAuth(username, username)
+		require.Equal(t, http.StatusCreated, doRequest(h, r).Code, path)
+	}
+	ownerOf := func(name string) (uint32, uint32) {
+		info, err := os.Lstat(filepath.Join(cfg.Scope, name))
+		require.NoError(t, err)
+		stat := info.Sys().(*syscall.Stat_t)
+		return stat.Uid, stat.Gid
+	}
+
+	do(http.MethodPut, "/alice.txt", "alice")
+	do("MKCOL", "/alice", "alice")
+	do(http.MethodPut, "/bob.txt", "bob")
+
+	for _, name := range []string{"alice.txt", "alice"} {
+		uid, gid := ownerOf(name)
+		require.Equal(t, uint32(1234), uid, name)
+		require.Equal(t, uint32(5678), gid, name)
+	}
+
+	uid, gid := ownerOf("bob.txt")
+	require.Equal(t, uint32(os.Geteuid()), uid)
+	require.Equal(t, uint32(os.Getegid()), gid)
+}
+
+func TestParseOwner(t *testing.T) {
+	t.Parallel()
+
+	o, err := parseOwner("1000:100")
+	require.NoError(t, err)
+	require.Equal(t, &owner{uid: 1000, gid: 100}, o)
+
+	o, err = parseOwner(":100")
+	require.NoError(t, err)
+	require.Equal(t, &owner{uid: -1, gid: 100}, o)
+
+	o, err = parseOwner("")
+	require.NoError(t, err)
+	require.Nil(t, o)
+
+	for _, s := range []string{":", "alice", "-1", "1000:-1"} {
+		_, err := parseOwner(s)
+		require.Error(t, err, s)
+	}
+}
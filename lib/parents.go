@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+)
+
+// createParents creates the missing parent collections of a file about to be
+// uploaded, for the clients that do not create them first. Each of them must
+// be allowed to be created by the user. Otherwise, or if one of them cannot be
+// created, the remaining ones are left missing, and the WebDAV handler rejects
+// the upload with 409 Conflict.
+func (u *handlerUser) createParents(ctx context.Context, urlPath string) {
+	if !strings.HasPrefix(urlPath, u.Prefix) {
+		return
+	}
+
+	name := path.Clean("/" + strings.TrimPrefix(urlPath, u.Prefix))
+	dir := "/"
+	for _, segment := range strings.Split(strings.Trim(path.Dir(name), "/"), "/") {
+		if segment == "" {
+			return
+		}
+		dir = path.Join(dir, segment)
+
+		info, err := u.FileSystem.Stat(ctx, dir)
+		if err == nil && info.IsDir() {
+			continue
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return
+		}
+
+		if !u.decide("MKCOL", path.Join(u.Prefix, dir)+"/").allowed {
+			return
+		}
+		if err := u.FileSystem.Mkdir(ctx, dir, 0777); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+)
+
+// partialUpdateContentType is the content type of the SabreDAV partial update
+// PATCH requests, which write a byte range of an existing file.
+//
+// See https://sabre.io/dav/http-patch/
+const partialUpdateContentType = "application/x-sabredav-partialupdate"
+
+// partialUpdateClass is advertised in the DAV header when partial updates are
+// enabled.
+const partialUpdateClass = "sabredav-partialupdate"
+
+var errInvalidUpdateRange = errors.New("invalid update range")
+
+// parseUpdateRange parses the X-Update-Range header against the current size of
+// the file. It returns the offset to start writing at, and the amount of bytes
+// to write, which is -1 if it is only known from the request body.
+func parseUpdateRange(header string, size int64) (offset, length int64, err error) {
+	header = strings.TrimSpace(header)
+	if header == "append" {
+		return size, -1, nil
+	}
+
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, 0, errInvalidUpdateRange
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, errInvalidUpdateRange
+	}
+
+	// A suffix range, such as "bytes=-5", overwrites the last bytes.
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || n > size {
+			return 0, 0, errInvalidUpdateRange
+		}
+		return size - n, n, nil
+	}
+
+	offset, err = strconv.ParseInt(first, 10, 64)
+	// Writing past the end of the file would leave a hole.
+	if err != nil || offset < 0 || offset > size {
+		return 0, 0, errInvalidUpdateRange
+	}
+	if last == "" {
+		return offset, -1, nil
+	}
+
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < offset {
+		return 0, 0, errInvalidUpdateRange
+	}
+	return offset, end - offset + 1, nil
+}
+
+// servePartialUpdate writes the body of a SabreDAV partial update request into
+// the range of the file given by the X-Update-Range header.
+func (u *handlerUser) servePartialUpdate(w http.ResponseWriter, r *http.Request, name string) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != partialUpdateContentType {
+		http.Error(w, "Unsupported media type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	header := r.Header.Get("X-Update-Range")
+	if header == "" {
+		http.Error(w, "Missing X-Update-Range header", http.StatusBadRequest)
+		return
+	}
+
+	release, err := u.confirmLocks(r, name)
+	if err != nil {
+		if errors.Is(err, webdav.ErrLocked) {
+			if u.Logger != nil {
+				u.Logger(r, err)
+			}
+			http.Error(w, "Locked", http.StatusLocked)
+		} else {
+			http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+		}
+		return
+	}
+	defer release()
+
+	f, err := u.FileSystem.OpenFile(r.Context(), name, os.O_WRONLY, 0)
+	if err != nil {
+		switch {
+		case os.IsNotExist(err):
+			http.Error(w, "Not found", http.StatusNotFound)
+		case os.IsPermission(err):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		default:
+			// Directories cannot be opened for writing.
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		requestLogger(r, u.logger).Error("failed to stat file", zap.String("path", name), zap.Error(err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	offset, length, err := parseUpdateRange(header, info.Size())
+	if err != nil {
+		w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(info.Size(), 10))
+		http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if length >= 0 {
+		if r.ContentLength >= 0 && r.ContentLength != length {
+			http.Error(w, "Content-Length does not match X-Update-Range", http.StatusBadRequest)
+			return
+		}
+		body = io.LimitReader(r.Body, length)
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		requestLogger(r, u.logger).Error("failed to seek file", zap.String("path", name), zap.Error(err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	n, err := copyUpload(r.Context(), f, body)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		requestLogger(r, u.logger).Debug("failed to write partial update", zap.String("path", name), zap.Error(err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if length >= 0 && n != length {
+		http.Error(w, "Request body is shorter than X-Update-Range", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// confirmLocks makes sure that the file is not locked by anybody else, in the
+// same way the WebDAV handler does: if the request holds lock tokens, one of
+// them must cover the file and, otherwise, the file must not be locked at all.
+func (u *handlerUser) confirmLocks(r *http.Request, name string) (func(), error) {
+	now := time.Now()
+
+	if r.Header.Get("If") == "" {
+		token, err := u.LockSystem.Create(now, webdav.LockDetails{Root: name, Duration: -1, ZeroDepth: true})
+		if err != nil {
+			return nil, err
+		}
+		return func() { _ = u.LockSystem.Unlock(now, token) }, nil
+	}
+
+	var conditions []webdav.Condition
+	for _, token := range lockTokens(r) {
+		conditions = append(conditions, webdav.Condition{Token: token})
+	}
+	return u.LockSystem.Confirm(now, name, "", conditions...)
+}
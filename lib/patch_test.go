@@ -0,0 +1,177 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUpdateRange(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		header string
+		offset int64
+		length int64
+		valid  bool
+	}{
+		{"append", 10, -1, true},
+		{"bytes=2-4", 2, 3, true},
+		{"bytes=0-0", 0, 1, true},
+		{"bytes=8-20", 8, 13, true},
+		{"bytes=10-", 10, -1, true},
+		{"bytes=3-", 3, -1, true},
+		{"bytes=-4", 6, 4, true},
+		{"bytes=11-", 0, 0, false},
+		{"bytes=4-2", 0, 0, false},
+		{"bytes=-11", 0, 0, false},
+		{"bytes=-0", 0, 0, false},
+		{"bytes=a-b", 0, 0, false},
+		{"bytes=5", 0, 0, false},
+		{"lines=1-2", 0, 0, false},
+		{"", 0, 0, false},
+	} {
+		offset, length, err := parseUpdateRange(tc.header, 10)
+		if !tc.valid {
+			require.ErrorIs(t, err, errInvalidUpdateRange, tc.header)
+			continue
+		}
+
+		require.NoError(t, err, tc.header)
+		require.Equal(t, tc.offset, offset, tc.header)
+		require.Equal(t, tc.length, length, tc.header)
+	}
+}
+
+func TestPartialUpdates(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T, enabled bool) (http.Handler, string) {
+		cfg := &Config{
+			PartialUpdates: enabled,
+			Permissions:    Permissions{Modify: true},
+		}
+		h := newTestHandler(t, cfg)
+		return h, cfg.Scope
+	}
+
+	patch := func(h http.Handler, name, updateRange, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPatch, name, strings.NewReader(body))
+		r.Header.Set("Content-Type", partialUpdateContentType)
+		r.Header.Set("X-Update-Range", updateRange)
+		return doRequest(h, r)
+	}
+
+	requireContent := func(t *testing.T, scope, expected string) {
+		data, err := os.ReadFile(filepath.Join(scope, "file.txt"))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(data))
+	}
+
+	t.Run("Append", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, true)
+		require.Equal(t, http.StatusNoContent, patch(h, "/file.txt", "append", " appended").Code)
+		requireContent(t, scope, "content appended")
+
+		// Resuming from the current size is the same as appending.
+		require.Equal(t, http.StatusNoContent, patch(h, "/file.txt", "bytes=16-", " again").Code)
+		requireContent(t, scope, "content appended again")
+	})
+
+	t.Run("Mid-File", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, true)
+		require.Equal(t, http.StatusNoContent, patch(h, "/file.txt", "bytes=1-3", "ONT").Code)
+		requireContent(t, scope, "cONTent")
+
+		// The written range can extend past the end of the file.
+		require.Equal(t, http.StatusNoContent, patch(h, "/file.txt", "bytes=5-9", "NTS!!").Code)
+		requireContent(t, scope, "cONTeNTS!!")
+
+		require.Equal(t, http.StatusNoContent, patch(h, "/file.txt", "bytes=-2", "..").Code)
+		requireContent(t, scope, "cONTeNTS..")
+	})
+
+	t.Run("Invalid Range", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, true)
+		w := patch(h, "/file.txt", "bytes=8-", "data")
+		require.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+		require.Equal(t, "bytes */7", w.Header().Get("Content-Range"))
+
+		require.Equal(t, http.StatusRequestedRangeNotSatisfiable, patch(h, "/file.txt", "lines=1-2", "data").Code)
+		requireContent(t, scope, "content")
+	})
+
+	t.Run("Length Mismatch", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, true)
+		require.Equal(t, http.StatusBadRequest, patch(h, "/file.txt", "bytes=0-1", "data").Code)
+		requireContent(t, scope, "content")
+	})
+
+	t.Run("Bad Requests", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t, true)
+		require.Equal(t, http.StatusNotFound, patch(h, "/missing.txt", "append", "data").Code)
+		require.Equal(t, http.StatusMethodNotAllowed, patch(h, "/dir", "append", "data").Code)
+		require.Equal(t, http.StatusBadRequest, patch(h, "/file.txt", "", "data").Code)
+
+		r := httptest.NewRequest(http.MethodPatch, "/file.txt", strings.NewReader("data"))
+		r.Header.Set("Content-Type", "text/plain")
+		r.Header.Set("X-Update-Range", "append")
+		require.Equal(t, http.StatusUnsupportedMediaType, doRequest(h, r).Code)
+	})
+
+	t.Run("Locked", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, true)
+		w := doRequest(h, lockRequest("/file.txt"))
+		require.Equal(t, http.StatusOK, w.Code)
+		token := w.Header().Get("Lock-Token")
+
+		require.Equal(t, http.StatusLocked, patch(h, "/file.txt", "append", "!").Code)
+		requireContent(t, scope, "content")
+
+		r := httptest.NewRequest(http.MethodPatch, "/file.txt", strings.NewReader("!"))
+		r.Header.Set("Content-Type", partialUpdateContentType)
+		r.Header.Set("X-Update-Range", "append")
+		r.Header.Set("If", "("+token+")")
+		require.Equal(t, http.StatusNoContent, doRequest(h, r).Code)
+		requireContent(t, scope, "content!")
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t, true)
+		w := doRequest(h, httptest.NewRequest(http.MethodOptions, "/file.txt", nil))
+		require.Equal(t, "1, 2, sabredav-partialupdate", w.Header().Get("DAV"))
+		require.Equal(t, partialUpdateContentType, w.Header().Get("Accept-Patch"))
+		require.Contains(t, strings.Split(w.Header().Get("Allow"), ", "), "PATCH")
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t, false)
+		// The server does not implement the PATCH method then.
+		require.Equal(t, http.StatusNotImplemented, patch(h, "/file.txt", "append", "data").Code)
+		requireContent(t, scope, "content")
+
+		w := doRequest(h, httptest.NewRequest(http.MethodOptions, "/file.txt", nil))
+		require.NotContains(t, strings.Split(w.Header().Get("Allow"), ", "), "PATCH")
+	})
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// cleanPath cleans the path of a request, as the rules and the file systems
+// see it, keeping its trailing slash.
+func cleanPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// traverses reports whether the path has ".." segments, or NUL bytes. The path
+// is the decoded one, so that encoded traversals, such as "..%2f", are caught
+// as well. Backslashes are taken as separators, since they are on Windows.
+func traverses(p string) bool {
+	if strings.ContainsRune(p, 0) {
+		return true
+	}
+
+	for _, segment := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizePath rejects the requests whose path tries to traverse out of the
+// scope, and otherwise cleans the path of the request, so that all the names
+// derived from it are consistent. The OPTIONS requests for the whole server are
+// left as they are.
+func sanitizePath(r *http.Request) bool {
+	if r.URL.Path == "*" {
+		return true
+	}
+
+	if traverses(r.URL.Path) {
+		return false
+	}
+
+	if cleaned := cleanPath(r.URL.Path); cleaned != r.URL.Path {
+		r.URL.Path = cleaned
+		r.URL.RawPath = ""
+	}
+	return true
+}
+
+// sanitizeDestination rewrites the Destination header of a move or a copy,
+// either an absolute URL or an absolute path, to its clean and escaped path, so
+// that the rules, the hooks and the WebDAV handler all see the same destination.
+// If it is not valid, or tries to traverse out of the scope, 400 is returned. If
+// it is on another server, 502 is returned, as defined in RFC 4918, section
+// 9.8.5. Otherwise, 0 is returned. Without the header, the WebDAV handler
+// rejects the request.
+func sanitizeDestination(r *http.Request) int {
+	destination := r.Header.Get("Destination")
+	if (r.Method != "MOVE" && r.Method != "COPY") || destination == "" {
+		return 0
+	}
+
+	u, err := url.Parse(destination)
+	switch {
+	case err != nil, u.Opaque != "", !strings.HasPrefix(u.Path, "/"), traverses(u.Path):
+		return http.StatusBadRequest
+	case u.Host != "" && !sameHost(u.Scheme, u.Host, r.Host):
+		return http.StatusBadGateway
+	}
+
+	r.Header.Set("Destination", (&url.URL{Path: cleanPath(u.Path)}).EscapedPath())
+	return 0
+}
+
+// sameHost reports whether the host of a destination URL is the host of the
+// request, regardless of case and of default ports. The scheme of the request
+// is not known behind a proxy, so its host may have either default port.
+func sameHost(scheme, host, requestHost string) bool {
+	if port := map[string]string{"http": ":80", "https": ":443"}[strings.ToLower(scheme)]; port != "" {
+		host = strings.TrimSuffix(host, port)
+	}
+	requestHost = strings.TrimSuffix(strings.TrimSuffix(requestHost, ":80"), ":443")
+	return strings.EqualFold(host, requestHost)
+}
+
+// name returns the name of the request in the file system of the user.
+func (u *handlerUser) name(r *http.Request) string {
+	return strings.TrimPrefix(r.URL.Path, u.Prefix)
+}
+
+// destinationName returns the name of the destination of a move or a copy in
+// the file system of the user, or false if there is none.
+func (u *handlerUser) destinationName(r *http.Request) (string, bool) {
+	dst, ok := destinationPath(r)
+	return strings.TrimPrefix(dst, u.Prefix), ok
+}
+
+// matchesPath reports whether the path, or one of its parents, matches one of
+// the glob patterns.
+func matchesPath(patterns []string, name string) bool {
+	for current := path.Clean("/" + name); ; current = path.Dir(current) {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, current); ok {
+				return true
+			}
+		}
+		if current == "/" {
+			return false
+		}
+	}
+}
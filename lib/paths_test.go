@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerPathTraversal(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{Permissions: Permissions{Modify: true}})
+
+	for _, target := range []string{
+		"/../file.txt",
+		"/dir/../file.txt",
+		"/dir/..",
+		"/..%2ffile.txt",
+		"/dir%2f..%2ffile.txt",
+		"/%2e%2e/file.txt",
+		"/dir/..%5cfile.txt",
+		"/file.txt%00",
+	} {
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code, target)
+	}
+
+	for _, destination := range []string{"/../moved.txt", "/dir/..%2fmoved.txt", "http://example.com/dir/../moved.txt"} {
+		r := httptest.NewRequest("MOVE", "/file.txt", nil)
+		r.Header.Set("Destination", destination)
+		require.Equal(t, http.StatusBadRequest, doRequest(h, r).Code, destination)
+	}
+
+	// The paths are otherwise cleaned.
+	for target, status := range map[string]int{"//file.txt": http.StatusOK, "/./file.txt": http.StatusOK, "/dir//": http.StatusMultiStatus} {
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(t, status, w.Code, target)
+	}
+}
+
+func TestHandlerDestination(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Prefix: "/dav/",
+		Permissions: Permissions{
+			Modify: true,
+			Rules:  []*Rule{{Path: "/dav/dir/", Allow: true, Modify: false}},
+		},
+	}
+	h := newTestHandler(t, cfg)
+
+	copyTo := func(dst string) int {
+		r := httptest.NewRequest("COPY", "http://example.com/dav/file.txt", nil)
+		r.Header.Set("Destination", dst)
+		return doRequest(h, r).Code
+	}
+
+	// Absolute URLs on the same host, whatever the case or the default port.
+	require.Equal(t, http.StatusCreated, copyTo("http://example.com/dav/url.txt"))
+	require.Equal(t, http.StatusCreated, copyTo("https://EXAMPLE.com:443/dav/tls.txt"))
+	require.FileExists(t, filepath.Join(cfg.Scope, "url.txt"))
+	require.FileExists(t, filepath.Join(cfg.Scope, "tls.txt"))
+
+	// Absolute paths, percent-encoded or not.
+	require.Equal(t, http.StatusCreated, copyTo("/dav/path.txt"))
+	require.Equal(t, http.StatusCreated, copyTo("/dav/a%20b%23c.txt"))
+	require.FileExists(t, filepath.Join(cfg.Scope, "path.txt"))
+	require.FileExists(t, filepath.Join(cfg.Scope, "a b#c.txt"))
+
+	// The rules are checked against the clean destination.
+	require.Equal(t, http.StatusForbidden, copyTo("/dav//dir/./copy.txt"))
+	require.Equal(t, http.StatusForbidden, copyTo("http://example.com/dav/%64ir/copy.txt"))
+	require.NoFileExists(t, filepath.Join(cfg.Scope, "dir", "copy.txt"))
+
+	// Other hosts are other servers.
+	require.Equal(t, http.StatusBadGateway, copyTo("http://other.com/dav/copy.txt"))
+	require.Equal(t, http.StatusBadGateway, copyTo("http://example.com:8080/dav/copy.txt"))
+
+	for _, dst := range []string{"copy.txt", "mailto:copy.txt", "%zz"} {
+		require.Equal(t, http.StatusBadRequest, copyTo(dst), dst)
+	}
+
+	entries, err := os.ReadDir(cfg.Scope)
+	require.NoError(t, err)
+	require.Len(t, entries, 6)
+}
+
+func TestCleanPath(t *testing.T) {
+	t.Parallel()
+
+	for p, cleaned := range map[string]string{
+		"":            "/",
+		"/":           "/",
+		"file.txt":    "/file.txt",
+		"//file.txt":  "/file.txt",
+		"/./dir//":    "/dir/",
+		"/dir/./file": "/dir/file",
+	} {
+		require.Equal(t, cleaned, cleanPath(p), p)
+	}
+}
@@ -3,6 +3,8 @@ package lib
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"path"
 	"regexp"
 	"strings"
 )
@@ -14,11 +16,31 @@ var readMethods = []string{
 	"PROPFIND",
 }
 
+// isReadMethod reports whether the method only reads, and never modifies, the
+// resources it is applied to.
+func isReadMethod(method string) bool {
+	for _, m := range readMethods {
+		if method == m {
+			return true
+		}
+	}
+
+	return false
+}
+
+// userPlaceholder is replaced by the username in the path of the rules.
+const userPlaceholder = "${user}"
+
 type Rule struct {
 	Regex  bool
+	Glob   bool
 	Allow  bool
 	Modify bool
 	Path   string
+	// Inherit is whether the rule also applies to the descendants of the paths
+	// it matches, which is the default. Otherwise, they are decided by the
+	// other rules, as if the rule did not match them.
+	Inherit *bool
 	// TODO: remove Regex and replace by this. It encodes
 	Regexp *regexp.Regexp `mapstructure:"-"`
 }
@@ -32,11 +54,79 @@ func (r *Rule) Validate() error {
 		r.Regexp = rp
 		r.Path = ""
 		r.Regex = false
+	} else if r.Glob {
+		rp, err := regexp.Compile(globToRegexp(r.Path))
+		if err != nil {
+			return fmt.Errorf("invalid rule: %w", err)
+		}
+		r.Regexp = rp
+		r.Path = ""
+		r.Glob = false
 	}
 
 	return nil
 }
 
+// forUser returns a copy of the rule where the user placeholder in the path
+// is replaced by the given username.
+func (r *Rule) forUser(username string) *Rule {
+	rule := *r
+	switch {
+	case r.Regex:
+		username = regexp.QuoteMeta(username)
+	case r.Glob:
+		username = globEscaper.Replace(username)
+	}
+	rule.Path = strings.ReplaceAll(r.Path, userPlaceholder, username)
+	return &rule
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`)
+
+// globToRegexp converts a glob pattern into a regular expression. A single
+// star matches within a path segment, while a double star matches across
+// segments. A trailing "/**" also matches the directory itself.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '/':
+			if glob[i:] == "/**" {
+				b.WriteString("(/.*)?")
+				i = len(glob)
+			} else {
+				b.WriteByte('/')
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+	return b.String()
+}
+
 // Matches checks if [Rule] matches the given path.
 func (r *Rule) Matches(path string) bool {
 	if r.Regexp != nil {
@@ -46,33 +136,190 @@ func (r *Rule) Matches(path string) bool {
 	return strings.HasPrefix(path, r.Path)
 }
 
+// specificity returns the length of the path the rule applies to, which is the
+// literal prefix of its pattern for the regex and glob rules. The unanchored
+// regexes, which match anywhere, are the least specific.
+func (r *Rule) specificity() int {
+	if r.Regexp == nil {
+		return len(r.Path)
+	}
+
+	pattern, ok := strings.CutPrefix(r.Regexp.String(), "^")
+	if !ok {
+		return 0
+	}
+	n := 0
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			// The escaped letters and digits are classes, such as \d.
+			if i++; isAlphanumeric(pattern[i]) {
+				return n
+			}
+		case strings.IndexByte(`.+*?()|[]{}^$`, c) >= 0:
+			return n
+		}
+		// The literals made optional by the next character do not count either.
+		if i+1 < len(pattern) && strings.IndexByte(`*?{`, pattern[i+1]) >= 0 {
+			return n
+		}
+		n++
+	}
+	return n
+}
+
+func isAlphanumeric(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// appliesTo checks if [Rule] applies to the given path, that is, if it matches
+// it and, unless it is inherited, does not match its parent too.
+func (r *Rule) appliesTo(p string) bool {
+	if !r.Matches(p) {
+		return false
+	}
+	if r.Inherit == nil || *r.Inherit || p == "/" {
+		return true
+	}
+
+	// The parent is matched as a file and as a collection, since the rules
+	// may or may not have a trailing slash.
+	parent := path.Dir(strings.TrimSuffix(p, "/"))
+	return !r.Matches(parent) && (parent == "/" || !r.Matches(parent+"/"))
+}
+
 type Permissions struct {
 	Scope  string
 	Modify bool
 	Rules  []*Rule
+	// Grants are the permissions of each group of methods, which are used when
+	// no rule matches. They are configured as "allowed", since the Allowed
+	// method cannot be shadowed.
+	Grants *Grants `mapstructure:"allowed"`
+	// BlockedExtensions are the extensions of the files that cannot be
+	// uploaded, whatever the rules, such as the ones of executables.
+	BlockedExtensions []string `mapstructure:"blocked_extensions"`
 }
 
-// Allowed checks if the user has permission to access a directory/file
+// Allowed checks if the user has permission to access a directory/file, and,
+// for moves and copies, to write to the destination.
 func (p Permissions) Allowed(r *http.Request) bool {
-	// Determine whether or not it is a read or write request.
-	readRequest := false
-	for _, method := range readMethods {
-		if r.Method == method {
-			readRequest = true
-			break
+	return p.allowedMethod(r.Method, r.URL.Path) && p.allowedDestination(r)
+}
+
+// allowedDestination checks if the user has permission to write to the
+// destination of a move or a copy, as if uploading to it. Other requests have
+// no destination, and are always allowed.
+func (p Permissions) allowedDestination(r *http.Request) bool {
+	dst, ok := destinationPath(r)
+	if !ok {
+		return true
+	}
+	return p.decide(http.MethodPut, dst).allowed
+}
+
+// destinationPath returns the path of the Destination header of a move or a
+// copy, cleaned so that it can be matched against the rules. If the header is
+// missing or invalid, false is returned, and the WebDAV handler rejects the
+// request.
+func destinationPath(r *http.Request) (string, bool) {
+	if r.Method != "MOVE" && r.Method != "COPY" {
+		return "", false
+	}
+
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || u.Path == "" {
+		return "", false
+	}
+
+	return cleanPath(u.Path), true
+}
+
+// allowedMethod checks if the user has permission to use the method on the path.
+func (p Permissions) allowedMethod(method, path string) bool {
+	return p.decide(method, path).allowed
+}
+
+// decision is the outcome of checking the permissions, along with what led to
+// it.
+type decision struct {
+	allowed bool
+	// read is whether the method only reads.
+	read bool
+	// rule is the index of the rule that matched the path, or -1 if none did,
+	// in which case the default permissions apply.
+	rule int
+	// blocked is whether the extension of the uploaded file is blocked.
+	blocked bool
+}
+
+// decide checks if the user has permission to use the method on the path.
+func (p Permissions) decide(method, path string) decision {
+	// Determine whether or not it is a read or write request. Copies only read
+	// the source, the destination is checked separately.
+	readRequest := isReadMethod(method)
+	readAccess := readRequest || method == "COPY"
+
+	// The files with a blocked extension cannot be uploaded, even where the
+	// rules allow it. The destinations of moves and copies are checked as
+	// uploads.
+	if method == http.MethodPut && p.blocksExtension(path) {
+		return decision{read: readRequest, rule: -1, blocked: true}
+	}
+
+	// The most specific of the rules that apply decides, which is the one with
+	// the longest path, or the last one of those.
+	best := -1
+	for i, rule := range p.Rules {
+		if rule.appliesTo(path) && (best < 0 || rule.specificity() >= p.Rules[best].specificity()) {
+			best = i
 		}
 	}
+	if best >= 0 {
+		rule := p.Rules[best]
+		return decision{allowed: rule.Allow && (readAccess || rule.Modify), read: readRequest, rule: best}
+	}
 
-	// Go through rules beginning from the last one.
-	for i := len(p.Rules) - 1; i >= 0; i-- {
-		rule := p.Rules[i]
+	if p.Grants != nil {
+		return decision{allowed: p.Grants.allows(methodGroup(method), p.Modify), read: readRequest, rule: -1}
+	}
 
-		if rule.Matches(r.URL.Path) {
-			return rule.Allow && (readRequest || rule.Modify)
+	return decision{allowed: readAccess || p.Modify, read: readRequest, rule: -1}
+}
+
+// expandUser replaces the user placeholder in the rules by the given username.
+// Rules are copied, instead of being modified, as they can be shared with
+// other users.
+func (p *Permissions) expandUser(username string) {
+	var rules []*Rule
+	for i, rule := range p.Rules {
+		if !strings.Contains(rule.Path, userPlaceholder) {
+			continue
+		}
+
+		if rules == nil {
+			rules = make([]*Rule, len(p.Rules))
+			copy(rules, p.Rules)
 		}
+		rules[i] = rule.forUser(username)
 	}
 
-	return readRequest || p.Modify
+	if rules != nil {
+		p.Rules = rules
+	}
+}
+
+// blocksExtension reports whether the final extension of the file is blocked,
+// regardless of case. The trailing dots and spaces, which Windows ignores, are
+// ignored as well.
+func (p Permissions) blocksExtension(name string) bool {
+	ext := strings.ToLower(path.Ext(strings.TrimRight(name, ". ")))
+	for _, blocked := range p.BlockedExtensions {
+		if ext == blocked {
+			return true
+		}
+	}
+	return false
 }
 
 func (p *Permissions) Validate() error {
@@ -82,5 +329,16 @@ func (p *Permissions) Validate() error {
 		}
 	}
 
+	for i, ext := range p.BlockedExtensions {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if ext == "." || strings.ContainsAny(ext[1:], "./\\") {
+			return fmt.Errorf("invalid permissions: invalid blocked extension %q", p.BlockedExtensions[i])
+		}
+		p.BlockedExtensions[i] = ext
+	}
+
 	return nil
 }
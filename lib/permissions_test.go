@@ -0,0 +1,304 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func checkAllowed(t *testing.T, p Permissions, method, path string, expected bool) {
+	t.Helper()
+	r := httptest.NewRequest(method, path, nil)
+	require.Equal(t, expected, p.Allowed(r), "%s %s", method, path)
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	t.Parallel()
+
+	for glob, expected := range map[string]string{
+		"/public/**":      `^/public(/.*)?$`,
+		"/a/*/b":          `^/a/[^/]*/b$`,
+		"/a/**/b":         `^/a/(.*/)?b$`,
+		"/file?.txt":      `^/file[^/]\.txt$`,
+		`/literal\*.txt`:  `^/literal\*\.txt$`,
+		"/**/*.js":        `^/(.*/)?[^/]*\.js$`,
+		"/no/wildcards/":  `^/no/wildcards/$`,
+		"/dots.and+plus/": `^/dots\.and\+plus/$`,
+	} {
+		require.Equal(t, expected, globToRegexp(glob), glob)
+	}
+}
+
+func TestRuleSpecificity(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		rule        Rule
+		specificity int
+	}{
+		{Rule{Path: "/docs/"}, 6},
+		{Rule{Path: "/docs/**/*.secret", Glob: true}, 6},
+		{Rule{Path: "/public/**", Glob: true}, 7},
+		{Rule{Path: `^/a\.b/\d+`, Regex: true}, 5},
+		{Rule{Path: `^/files?/`, Regex: true}, 5},
+		{Rule{Path: `\.secret$`, Regex: true}, 0},
+	} {
+		rule := test.rule
+		require.NoError(t, rule.Validate())
+		require.Equal(t, test.specificity, rule.specificity(), test.rule.Path)
+	}
+}
+
+func TestPermissionsRules(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Overlapping", func(t *testing.T) {
+		t.Parallel()
+
+		p := Permissions{
+			Modify: false,
+			Rules: []*Rule{
+				{Path: "/public/**", Glob: true, Allow: true},
+				{Path: "/public/uploads/**", Glob: true, Allow: true, Modify: true},
+			},
+		}
+		require.NoError(t, p.Validate())
+
+		checkAllowed(t, p, http.MethodGet, "/public/file.txt", true)
+		checkAllowed(t, p, http.MethodPut, "/public/file.txt", false)
+		checkAllowed(t, p, http.MethodGet, "/public/uploads/file.txt", true)
+		checkAllowed(t, p, http.MethodPut, "/public/uploads/file.txt", true)
+		checkAllowed(t, p, http.MethodPut, "/public/uploads", true)
+		checkAllowed(t, p, http.MethodPut, "/other/file.txt", false)
+	})
+
+	t.Run("Deny Overrides", func(t *testing.T) {
+		t.Parallel()
+
+		p := Permissions{
+			Modify: true,
+			Rules: []*Rule{
+				{Path: "/docs/", Allow: true, Modify: true},
+				{Path: "/docs/**/*.secret", Glob: true, Allow: false},
+			},
+		}
+		require.NoError(t, p.Validate())
+
+		checkAllowed(t, p, http.MethodPut, "/docs/file.txt", true)
+		checkAllowed(t, p, http.MethodGet, "/docs/a/b/key.secret", false)
+		checkAllowed(t, p, http.MethodGet, "/docs/key.secret", false)
+		checkAllowed(t, p, http.MethodGet, "/key.secret", true)
+	})
+
+	t.Run("Overridden Deny On Subpath", func(t *testing.T) {
+		t.Parallel()
+
+		// The more specific rule applies, even if listed before.
+		p := Permissions{
+			Modify: false,
+			Rules: []*Rule{
+				{Path: "/docs/private/", Allow: false},
+				{Path: "/docs/private/shared/", Allow: true},
+				{Path: "/docs/", Allow: true, Modify: true},
+			},
+		}
+		require.NoError(t, p.Validate())
+
+		checkAllowed(t, p, http.MethodPut, "/docs/file.txt", true)
+		checkAllowed(t, p, http.MethodGet, "/docs/private/file.txt", false)
+		checkAllowed(t, p, http.MethodPut, "/docs/private/file.txt", false)
+		checkAllowed(t, p, http.MethodGet, "/docs/private/shared/file.txt", true)
+		checkAllowed(t, p, http.MethodPut, "/docs/private/shared/file.txt", false)
+
+		// As long as they are as specific, the last one applies.
+		p.Rules = append(p.Rules, &Rule{Path: "/docs/private/", Allow: true})
+		require.NoError(t, p.Validate())
+		checkAllowed(t, p, http.MethodGet, "/docs/private/file.txt", true)
+	})
+
+	t.Run("Inheritance", func(t *testing.T) {
+		t.Parallel()
+
+		noInherit := false
+		p := Permissions{
+			Modify: false,
+			Rules: []*Rule{
+				{Path: "/projects/", Allow: true, Modify: true},
+				{Path: "/projects/archive/", Allow: false},
+				{Path: "/projects/shared/", Allow: true, Modify: false, Inherit: &noInherit},
+				{Path: "/projects/*/releases", Glob: true, Allow: false, Inherit: &noInherit},
+			},
+		}
+		require.NoError(t, p.Validate())
+
+		// The rules apply to all the descendants.
+		checkAllowed(t, p, http.MethodPut, "/projects/a/b/file.txt", true)
+		checkAllowed(t, p, http.MethodGet, "/projects/archive/a/file.txt", false)
+
+		// Unless they are not inherited, where the other rules apply.
+		checkAllowed(t, p, http.MethodDelete, "/projects/shared/", false)
+		checkAllowed(t, p, http.MethodGet, "/projects/shared/", true)
+		checkAllowed(t, p, http.MethodPut, "/projects/shared/file.txt", true)
+		checkAllowed(t, p, http.MethodDelete, "/projects/shared/dir/", true)
+		checkAllowed(t, p, http.MethodGet, "/projects/a/releases", false)
+		checkAllowed(t, p, http.MethodPut, "/projects/a/releases/v1.zip", true)
+		checkAllowed(t, p, http.MethodGet, "/projects/archive/releases/v1.zip", false)
+	})
+
+	t.Run("User Substitution", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{
+			Auth: true,
+			Permissions: Permissions{
+				Scope: "/",
+				Rules: []*Rule{
+					{Path: "/private/**", Glob: true, Allow: false},
+					{Path: "/private/${user}/**", Glob: true, Allow: true, Modify: true},
+					{Path: "^/regex/${user}/", Regex: true, Allow: true, Modify: true},
+				},
+			},
+		}
+		cfg.Users = []User{
+			{Username: "alice", Password: "alice", Permissions: cfg.Permissions},
+			{Username: "b.o*b", Password: "bob", Permissions: cfg.Permissions},
+		}
+		require.NoError(t, cfg.Validate())
+
+		alice, bob := cfg.Users[0].Permissions, cfg.Users[1].Permissions
+
+		checkAllowed(t, alice, http.MethodPut, "/private/alice/file.txt", true)
+		checkAllowed(t, alice, http.MethodGet, "/private/b.o*b/file.txt", false)
+		checkAllowed(t, alice, http.MethodPut, "/regex/alice/file.txt", true)
+
+		checkAllowed(t, bob, http.MethodPut, "/private/b.o*b/file.txt", true)
+		checkAllowed(t, bob, http.MethodGet, "/private/bxoob/file.txt", false)
+		checkAllowed(t, bob, http.MethodPut, "/regex/b.o*b/file.txt", true)
+		checkAllowed(t, bob, http.MethodPut, "/regex/bxoob/file.txt", false)
+		checkAllowed(t, bob, http.MethodGet, "/private/alice/file.txt", false)
+
+		// Rules without the placeholder are still shared.
+		require.Same(t, alice.Rules[0], bob.Rules[0])
+	})
+}
+
+func TestPermissionsDestination(t *testing.T) {
+	t.Parallel()
+
+	p := Permissions{
+		Modify: true,
+		Rules: []*Rule{
+			{Path: "/archive/", Allow: true, Modify: false},
+			{Path: "/secret/", Allow: false},
+		},
+	}
+
+	allowed := func(method, src, dst string) bool {
+		r := httptest.NewRequest(method, src, nil)
+		r.Header.Set("Destination", dst)
+		return p.Allowed(r)
+	}
+
+	require.True(t, allowed("MOVE", "/file.txt", "/other.txt"))
+	require.True(t, allowed("MOVE", "/file.txt", "http://example.com/dir/other.txt"))
+
+	// The destination must be writable.
+	require.False(t, allowed("MOVE", "/file.txt", "/archive/file.txt"))
+	require.False(t, allowed("COPY", "/file.txt", "/secret/file.txt"))
+	require.False(t, allowed("MOVE", "/file.txt", "/dir/../archive/file.txt"))
+
+	// Moving from a read-only path deletes the source, copying only reads it.
+	require.False(t, allowed("MOVE", "/archive/file.txt", "/file.txt"))
+	require.True(t, allowed("COPY", "/archive/file.txt", "/file.txt"))
+	require.False(t, allowed("COPY", "/secret/file.txt", "/file.txt"))
+}
+
+func TestHandlerMoveDestination(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0666))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "dir"), 0777))
+
+	h := newTestHandler(t, &Config{
+		Permissions: Permissions{
+			Scope:  dir,
+			Modify: true,
+			Rules:  []*Rule{{Path: "/dir/", Allow: true, Modify: false}},
+		},
+	})
+
+	move := func(method, src, dst string) int {
+		r := httptest.NewRequest(method, src, nil)
+		r.Header.Set("Destination", dst)
+		return doRequest(h, r).Code
+	}
+
+	require.Equal(t, http.StatusForbidden, move("MOVE", "/file.txt", "/dir/file.txt"))
+	require.FileExists(t, filepath.Join(dir, "file.txt"))
+	require.NoFileExists(t, filepath.Join(dir, "dir", "file.txt"))
+
+	require.Equal(t, http.StatusForbidden, move("COPY", "/file.txt", "/dir/file.txt"))
+	require.NoFileExists(t, filepath.Join(dir, "dir", "file.txt"))
+
+	require.Equal(t, http.StatusCreated, move("MOVE", "/file.txt", "/moved.txt"))
+	require.FileExists(t, filepath.Join(dir, "moved.txt"))
+}
+
+func TestHandlerBlockedExtensions(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Auth: true,
+		Permissions: Permissions{
+			Modify:            true,
+			BlockedExtensions: []string{".exe", "PHP"},
+		},
+		Users: []User{
+			{Username: "guest", Password: "guest", Permissions: Permissions{Modify: true, BlockedExtensions: []string{".exe", "PHP"}}},
+			{Username: "admin", Password: "admin", Permissions: Permissions{Modify: true}},
+		},
+	}
+	h := newTestHandler(t, cfg)
+
+	request := func(username, method, path, destination string) int {
+		r := httptest.NewRequest(method, path, strings.NewReader("content"))
+		r.SetBasicAuth(username, username)
+		if destination != "" {
+			r.Header.Set("Destination", destination)
+		}
+		return doRequest(h, r).Code
+	}
+
+	for path, status := range map[string]int{
+		"/setup.exe":       http.StatusForbidden,
+		"/dir/index.php":   http.StatusForbidden,
+		"/Setup.EXE":       http.StatusForbidden,
+		"/index.PhP.%20":   http.StatusForbidden,
+		"/index.php.txt":   http.StatusCreated,
+		"/notes.txt":       http.StatusCreated,
+		"/exe":             http.StatusCreated,
+		"/archive.exe.tar": http.StatusCreated,
+	} {
+		require.Equal(t, status, request("guest", http.MethodPut, path, ""), path)
+	}
+	require.NoFileExists(t, filepath.Join(cfg.Scope, "Setup.EXE"))
+
+	// Nor can the files be renamed to a blocked extension.
+	require.Equal(t, http.StatusForbidden, request("guest", "COPY", "/file.txt", "/file.exe"))
+	require.Equal(t, http.StatusForbidden, request("guest", "MOVE", "/file.txt", "/dir/file.Php"))
+	require.Equal(t, http.StatusCreated, request("guest", "COPY", "/file.txt", "/copy.txt"))
+
+	// Trusted users can.
+	require.Equal(t, http.StatusCreated, request("admin", http.MethodPut, "/setup.exe", ""))
+	require.Equal(t, http.StatusCreated, request("admin", "MOVE", "/file.txt", "/file.php"))
+
+	// But others can still download or rename them away.
+	require.Equal(t, http.StatusOK, request("guest", http.MethodGet, "/setup.exe", ""))
+	require.Equal(t, http.StatusCreated, request("guest", "MOVE", "/setup.exe", "/setup.txt"))
+}
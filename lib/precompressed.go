@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// precompressedVariants are the encodings of the precompressed variants of the
+// files, along with the extensions of their names, by order of preference.
+var precompressedVariants = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{encodingGzip, ".gz"},
+}
+
+// encodingQuality returns the quality of the encoding in the value of an
+// Accept-Encoding header. The encoding itself takes precedence over "*".
+func encodingQuality(header, encoding string) float64 {
+	quality, wildcard := -1.0, 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encoding && name != "*" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		if name == "*" {
+			wildcard = q
+		} else {
+			quality = q
+		}
+	}
+
+	if quality < 0 {
+		return wildcard
+	}
+	return quality
+}
+
+// usePrecompressed makes the request serve the precompressed variant of the
+// file, stored next to it, if there is one that the client accepts and that the
+// user can read. The variant is served with the content type of the file.
+func (u *handlerUser) usePrecompressed(w http.ResponseWriter, r *http.Request) {
+	name := u.name(r)
+	header := r.Header.Get("Accept-Encoding")
+
+	encoding, extension, best, found := "", "", 0.0, false
+	for _, v := range precompressedVariants {
+		if !u.allowedMethod(r.Method, r.URL.Path+v.extension) {
+			continue
+		}
+		info, err := u.FileSystem.Stat(r.Context(), name+v.extension)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		found = true
+		if quality := encodingQuality(header, v.encoding); quality > best {
+			encoding, extension, best = v.encoding, v.extension, quality
+		}
+	}
+
+	// The response depends on the encodings accepted as soon as there is a
+	// variant, even if the file itself is served.
+	if !found {
+		return
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if encoding == "" {
+		return
+	}
+
+	// Otherwise, the content type would be the one of the variant.
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", u.sniffContentType(r, name))
+	}
+	w.Header().Set("Content-Encoding", encoding)
+	r.URL.Path += extension
+	if r.URL.RawPath != "" {
+		r.URL.RawPath += extension
+	}
+}
+
+// sniffContentType returns the content type of the file, as the standard
+// library would: from the extension of its name, or from its contents.
+func (u *handlerUser) sniffContentType(r *http.Request, name string) string {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType
+	}
+
+	f, err := u.FileSystem.OpenFile(r.Context(), name, os.O_RDONLY, 0)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+
+	var data [512]byte
+	n, _ := io.ReadFull(f, data[:])
+	return http.DetectContentType(data[:n])
+}
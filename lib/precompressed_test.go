@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerPrecompressed(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T, enabled bool) http.Handler {
+		cfg := &Config{
+			Precompressed: enabled,
+			Permissions: Permissions{
+				Rules: []*Rule{{Path: "/secret.js.br", Allow: false}},
+			},
+		}
+		h := newTestHandler(t, cfg)
+		for name, content := range map[string]string{
+			"app.js":       "plain",
+			"app.js.br":    "brotli",
+			"app.js.gz":    "gzip",
+			"style.css":    "plain",
+			"style.css.gz": "gzip",
+			"secret.js":    "plain",
+			"secret.js.br": "brotli",
+			"data":         "<html>plain</html>",
+			"data.gz":      "gzip",
+			"conflict.txt": "plain",
+		} {
+			require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, name), []byte(content), 0666))
+		}
+		require.NoError(t, os.Mkdir(filepath.Join(cfg.Scope, "conflict.txt.br"), 0777))
+		return h
+	}
+
+	get := func(h http.Handler, path, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusOK, w.Code, path)
+		return w
+	}
+
+	h := newHandler(t, true)
+
+	t.Run("Brotli Preferred", func(t *testing.T) {
+		t.Parallel()
+
+		w := get(h, "/app.js", "gzip, deflate, br")
+		require.Equal(t, "brotli", w.Body.String())
+		require.Equal(t, "br", w.Header().Get("Content-Encoding"))
+		require.Equal(t, "text/javascript; charset=utf-8", w.Header().Get("Content-Type"))
+		require.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	})
+
+	t.Run("Gzip Preferred", func(t *testing.T) {
+		t.Parallel()
+
+		w := get(h, "/app.js", "br;q=0.5, gzip")
+		require.Equal(t, "gzip", w.Body.String())
+		require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+		// The best variant that exists is served.
+		w = get(h, "/style.css", "br, gzip;q=0.1")
+		require.Equal(t, "gzip", w.Body.String())
+		require.Equal(t, "text/css; charset=utf-8", w.Header().Get("Content-Type"))
+
+		// The content type is sniffed from the file, not from the variant.
+		w = get(h, "/data", "*")
+		require.Equal(t, "gzip", w.Body.String())
+		require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	})
+
+	t.Run("No Variant", func(t *testing.T) {
+		t.Parallel()
+
+		w := get(h, "/file.txt", "br, gzip")
+		require.Equal(t, "content", w.Body.String())
+		require.Empty(t, w.Header().Get("Content-Encoding"))
+		require.Empty(t, w.Header().Get("Vary"))
+
+		// Nor are the variants that cannot be read, or are not files.
+		w = get(h, "/secret.js", "br")
+		require.Equal(t, "plain", w.Body.String())
+		w = get(h, "/conflict.txt", "br")
+		require.Equal(t, "plain", w.Body.String())
+	})
+
+	t.Run("No Encoding", func(t *testing.T) {
+		t.Parallel()
+
+		for _, acceptEncoding := range []string{"", "deflate", "br;q=0, gzip;q=0", "identity"} {
+			w := get(h, "/app.js", acceptEncoding)
+			require.Equal(t, "plain", w.Body.String(), acceptEncoding)
+			require.Empty(t, w.Header().Get("Content-Encoding"), acceptEncoding)
+			require.Equal(t, "Accept-Encoding", w.Header().Get("Vary"), acceptEncoding)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		w := get(newHandler(t, false), "/app.js", "br, gzip")
+		require.Equal(t, "plain", w.Body.String())
+		require.Empty(t, w.Header().Get("Content-Encoding"))
+	})
+}
+
+func TestEncodingQuality(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, 1.0, encodingQuality("gzip, br", "br"))
+	require.Equal(t, 0.5, encodingQuality("gzip, br;q=0.5", "br"))
+	require.Equal(t, 0.2, encodingQuality("*;q=0.2, gzip", "br"))
+	require.Equal(t, 0.0, encodingQuality("*, br;q=0", "br"))
+	require.Equal(t, 0.0, encodingQuality("", "br"))
+}
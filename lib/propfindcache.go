@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// propfindCache keeps the responses to the PROPFIND requests, so that the same
+// trees are not walked again and again. The responses are dropped after the
+// TTL, or as soon as a request may have modified what they describe. When it is
+// full, the least recently used response is dropped.
+type propfindCache struct {
+	size            int
+	ttl             time.Duration
+	caseInsensitive bool
+
+	mu      sync.Mutex
+	entries map[propfindKey]*list.Element
+	lru     *list.List
+	// generation is incremented by each invalidation, so that the responses
+	// computed while a modification is in progress are not kept.
+	generation uint64
+}
+
+// propfindKey identifies a response. The user is part of it, since the rules
+// and the hidden files may differ between users, and so is the body, which
+// selects the properties.
+type propfindKey struct {
+	user  *handlerUser
+	path  string
+	depth string
+	body  [sha256.Size]byte
+}
+
+type propfindEntry struct {
+	key     propfindKey
+	name    string
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func newPropfindCache(c PropfindCache, caseInsensitive bool) *propfindCache {
+	if !c.Enabled {
+		return nil
+	}
+	return &propfindCache{
+		size:            c.Size,
+		ttl:             c.TTL,
+		caseInsensitive: caseInsensitive,
+		entries:         map[propfindKey]*list.Element{},
+		lru:             list.New(),
+	}
+}
+
+// resource returns the resource of the name in the scope of the user, so that
+// the users sharing the same directory invalidate the responses of each other.
+func (c *propfindCache) resource(user *handlerUser, name string) string {
+	resource := path.Join(user.Scope, "/"+name)
+	if c.caseInsensitive {
+		resource = strings.ToLower(resource)
+	}
+	return resource
+}
+
+func (c *propfindCache) get(now time.Time, key propfindKey) (*propfindEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*propfindEntry)
+	if !now.Before(entry.expires) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+	return entry, true
+}
+
+// put caches the response, unless the cache was invalidated since the given
+// generation, when the response started to be computed.
+func (c *propfindCache) put(now time.Time, generation uint64, entry *propfindEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	entry.expires = now.Add(c.ttl)
+	if element, ok := c.entries[entry.key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*propfindEntry).key)
+	}
+}
+
+func (c *propfindCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// invalidate drops the responses describing the resources, which are their
+// ancestors, as their members may be listed, and their descendants.
+func (c *propfindCache) invalidate(resources ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for key, element := range c.entries {
+		name := element.Value.(*propfindEntry).name
+		for _, resource := range resources {
+			if within(resource, name) || within(name, resource) {
+				c.lru.Remove(element)
+				delete(c.entries, key)
+				break
+			}
+		}
+	}
+}
+
+// within reports whether the resource is the directory, or is in it.
+func within(resource, dir string) bool {
+	return resource == dir || dir == "/" || strings.HasPrefix(resource, dir+"/")
+}
+
+// propfindRecorder passes the response through, while keeping a copy of it.
+type propfindRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *propfindRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *propfindRecorder) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *propfindRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// servePropfind serves the PROPFIND request from the cache, if possible, or
+// with the WebDAV handler, caching its response.
+func (h *handler) servePropfind(w http.ResponseWriter, r *http.Request, user *handlerUser) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	key := propfindKey{user: user, path: r.URL.Path, depth: r.Header.Get("Depth"), body: sha256.Sum256(body)}
+	if entry, ok := h.propfindCache.get(time.Now(), key); ok {
+		for name, values := range entry.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(entry.status)
+		_, _ = w.Write(entry.body)
+		return
+	}
+
+	generation := h.propfindCache.currentGeneration()
+	before := w.Header().Clone()
+	rw := &propfindRecorder{ResponseWriter: w}
+	user.ServeHTTP(rw, r)
+
+	// Only the complete listings are kept, not the errors.
+	if rw.status != http.StatusMultiStatus {
+		return
+	}
+
+	// The headers set before, such as the ones of CORS, depend on the request,
+	// so only the ones of the WebDAV handler are kept.
+	header := http.Header{}
+	for name, values := range rw.header {
+		if strings.Join(before[name], "\x00") != strings.Join(values, "\x00") {
+			header[name] = values
+		}
+	}
+
+	h.propfindCache.put(time.Now(), generation, &propfindEntry{
+		key:    key,
+		name:   h.propfindCache.resource(user, user.name(r)),
+		status: rw.status,
+		header: header,
+		body:   rw.body.Bytes(),
+	})
+}
+
+// invalidatePropfinds drops the cached responses that the request may have
+// made stale, including the ones of the destination of a move or a copy.
+func (h *handler) invalidatePropfinds(r *http.Request, user *handlerUser) {
+	resources := []string{h.propfindCache.resource(user, user.name(r))}
+	if dst, ok := user.destinationName(r); ok {
+		resources = append(resources, h.propfindCache.resource(user, dst))
+	}
+	h.propfindCache.invalidate(resources...)
+}
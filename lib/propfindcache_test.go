@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerPropfindCache(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Permissions:   Permissions{Modify: true},
+		PropfindCache: PropfindCache{Enabled: true},
+	}
+	h := newTestHandler(t, cfg)
+
+	propfind := func(path, depth string) string {
+		r := httptest.NewRequest("PROPFIND", path, nil)
+		r.Header.Set("Depth", depth)
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.Contains(t, w.Header().Get("Content-Type"), "xml")
+		return w.Body.String()
+	}
+
+	listing := propfind("/dir/", "1")
+	root := propfind("/", "infinity")
+
+	// The files created outside of the server are not seen, since the
+	// responses are cached.
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "dir", "outside.txt"), []byte("content"), 0666))
+	require.Equal(t, listing, propfind("/dir/", "1"))
+	require.Equal(t, root, propfind("/", "infinity"))
+	require.NotContains(t, listing, "outside.txt")
+
+	// Other depths are other responses.
+	require.Contains(t, propfind("/", "1"), "dir")
+
+	// The uploads drop the responses of their parents.
+	r := httptest.NewRequest(http.MethodPut, "/dir/new.txt", strings.NewReader("content"))
+	require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+	listing = propfind("/dir/", "1")
+	require.Contains(t, listing, "outside.txt")
+	require.Contains(t, listing, "new.txt")
+	require.Contains(t, propfind("/", "infinity"), "new.txt")
+
+	// And the deletions the ones of their members.
+	require.Contains(t, propfind("/dir/new.txt", "0"), "new.txt")
+	require.Equal(t, http.StatusNoContent, doRequest(h, httptest.NewRequest(http.MethodDelete, "/dir/", nil)).Code)
+	w := doRequest(h, httptest.NewRequest("PROPFIND", "/dir/new.txt", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPropfindCache(t *testing.T) {
+	t.Parallel()
+
+	c := newPropfindCache(PropfindCache{Enabled: true, Size: 2, TTL: time.Minute}, true)
+	now := time.Now()
+	put := func(path string) {
+		c.put(now, c.currentGeneration(), &propfindEntry{key: propfindKey{path: path}, name: strings.ToLower(path)})
+	}
+	cached := func(path string, now time.Time) bool {
+		_, ok := c.get(now, propfindKey{path: path})
+		return ok
+	}
+
+	// The least recently used response is dropped first.
+	put("/a")
+	put("/b")
+	require.True(t, cached("/a", now))
+	put("/c")
+	require.True(t, cached("/a", now))
+	require.False(t, cached("/b", now))
+	require.True(t, cached("/c", now))
+
+	// The responses expire after the TTL.
+	require.False(t, cached("/a", now.Add(time.Minute)))
+
+	// The responses computed during a modification are not kept.
+	generation := c.currentGeneration()
+	c.invalidate("/c/file.txt")
+	require.False(t, cached("/c", now))
+	c.put(now, generation, &propfindEntry{key: propfindKey{path: "/d"}, name: "/d"})
+	require.False(t, cached("/d", now))
+
+	// The resources are compared regardless of case.
+	require.Equal(t, "/data/dir/file.txt", c.resource(&handlerUser{User: User{Permissions: Permissions{Scope: "/data"}}}, "Dir/File.txt"))
+}
+
+func TestConfigPropfindCache(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+propfind_cache:
+  enabled: true`, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, DefaultPropfindCacheSize, cfg.PropfindCache.Size)
+	require.Equal(t, DefaultPropfindCacheTTL, cfg.PropfindCache.TTL)
+
+	cfg = &Config{PropfindCache: PropfindCache{Enabled: true, TTL: -time.Second}}
+	require.ErrorContains(t, cfg.Validate(), "cannot be negative")
+}
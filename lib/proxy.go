@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies resolves the address of the clients from the headers set by
+// the reverse proxies. The headers are only honored if the request comes from
+// one of the trusted proxies, as anyone else could spoof them.
+type trustedProxies []netip.Prefix
+
+func newTrustedProxies(c Proxy) trustedProxies {
+	if !c.Enabled {
+		return nil
+	}
+
+	proxies := make(trustedProxies, 0, len(c.Trusted))
+	for _, trusted := range c.Trusted {
+		// The trusted proxies are validated beforehand.
+		if prefix, err := parsePrefix(trusted); err == nil {
+			proxies = append(proxies, prefix)
+		}
+	}
+	return proxies
+}
+
+// parsePrefix parses a CIDR, or a single address, which is the same as a CIDR
+// that only contains that address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked(), nil
+}
+
+func (p trustedProxies) trusts(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddress returns the address of the client that made the request. With
+// X-Forwarded-For, the addresses are walked from the closest to the furthest
+// hop, and the first one that is not a trusted proxy is the client.
+func (p trustedProxies) clientAddress(r *http.Request) string {
+	addr, err := netip.ParseAddr(remoteHost(r))
+	if err != nil || !p.trusts(addr) {
+		return r.RemoteAddr
+	}
+
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		hops := strings.Split(strings.Join(values, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				// Nothing beyond an invalid hop can be relied on.
+				break
+			}
+
+			addr = hop
+			if !p.trusts(hop) {
+				break
+			}
+		}
+		return addr.Unmap().String()
+	}
+
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap().String()
+	}
+
+	return r.RemoteAddr
+}
@@ -0,0 +1,162 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestClientAddress(t *testing.T) {
+	t.Parallel()
+
+	proxies := newTrustedProxies(Proxy{Enabled: true, Trusted: []string{"10.0.0.0/8", "::1"}})
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		expected   string
+	}{
+		{
+			name:       "Untrusted Proxy",
+			remoteAddr: "192.0.2.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1"}, "X-Real-Ip": {"203.0.113.2"}},
+			expected:   "192.0.2.1:1234",
+		},
+		{
+			name:       "No Headers",
+			remoteAddr: "10.0.0.1:1234",
+			expected:   "10.0.0.1:1234",
+		},
+		{
+			name:       "Forwarded For",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1"}},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "IPv6 Proxy",
+			remoteAddr: "[::1]:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"2001:db8::1"}},
+			expected:   "2001:db8::1",
+		},
+		{
+			name:       "Spoofed Hops",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.1, 10.0.0.2"}},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "Multiple Headers",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1", "10.0.0.2"}},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "Only Trusted Hops",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			expected:   "10.0.0.3",
+		},
+		{
+			name:       "Invalid Hop",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1, invalid, 10.0.0.2"}},
+			expected:   "10.0.0.2",
+		},
+		{
+			name:       "Real IP",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Real-Ip": {"203.0.113.1"}},
+			expected:   "203.0.113.1",
+		},
+		{
+			name:       "Invalid Real IP",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Real-Ip": {"invalid"}},
+			expected:   "10.0.0.1:1234",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for key, values := range tc.headers {
+				r.Header[key] = values
+			}
+			require.Equal(t, tc.expected, proxies.clientAddress(r))
+		})
+	}
+}
+
+func TestHandlerTrustedProxies(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	h := newTestHandler(t, &Config{
+		Logger:  zap.New(core),
+		Auth:    true,
+		Proxy:   Proxy{Enabled: true, Trusted: []string{"10.0.0.1"}},
+		Lockout: Lockout{Enabled: true, Attempts: 1, Window: time.Minute, Cooldown: time.Minute},
+		Users: []User{
+			{Username: "admin", Password: "admin"},
+			{Username: "other", Password: "other"},
+		},
+	})
+
+	request := func(username, password, remoteAddr, forwardedFor string) int {
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		r.SetBasicAuth(username, password)
+		return doRequest(h, r).Code
+	}
+
+	// The client behind the trusted proxy is locked out, but not the other
+	// clients behind the same proxy.
+	require.Equal(t, http.StatusUnauthorized, request("admin", "wrong", "10.0.0.1:1234", "203.0.113.1"))
+	require.Equal(t, http.StatusTooManyRequests, request("other", "other", "10.0.0.1:1234", "203.0.113.1"))
+	require.Equal(t, http.StatusOK, request("other", "other", "10.0.0.1:1234", "203.0.113.2"))
+
+	entries := logs.FilterMessage("invalid password").AllUntimed()
+	require.Len(t, entries, 1)
+	require.Equal(t, "203.0.113.1", entries[0].ContextMap()["remote_address"])
+
+	// The headers of an untrusted source are ignored, so that it cannot pretend
+	// to be one of the clients behind the proxy.
+	require.Equal(t, http.StatusOK, request("other", "other", "192.0.2.1:1234", "203.0.113.1"))
+}
+
+func TestConfigProxy(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+proxy:
+  enabled: true
+  trusted:
+    - 10.0.0.0/8
+    - 127.0.0.1
+    - ::1`, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, []string{"10.0.0.0/8", "127.0.0.1", "::1"}, cfg.Proxy.Trusted)
+
+	for name, c := range map[string]Proxy{
+		"No Trusted Proxies": {Enabled: true},
+		"Invalid Address":    {Enabled: true, Trusted: []string{"localhost"}},
+		"Invalid CIDR":       {Enabled: true, Trusted: []string{"10.0.0.0/33"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := &Config{Proxy: c}
+			require.Error(t, cfg.Validate())
+		})
+	}
+}
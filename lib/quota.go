@@ -0,0 +1,264 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+)
+
+// usageCacheTTL is for how long the computed usage of a user is cached.
+const usageCacheTTL = 10 * time.Second
+
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// usage is the storage used in a directory: the total size of its files, and
+// the number of its files and directories.
+type usage struct {
+	size  int64
+	files int64
+}
+
+// dirUsage returns the storage used in the given directory of the file system,
+// recursively.
+func dirUsage(ctx context.Context, fs webdav.FileSystem, name string) (usage, error) {
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return usage{}, err
+	}
+	infos, err := f.Readdir(-1)
+	_ = f.Close()
+	if err != nil {
+		return usage{}, err
+	}
+
+	var total usage
+	for _, info := range infos {
+		total.files++
+		if info.IsDir() {
+			u, err := dirUsage(ctx, fs, path.Join(name, info.Name()))
+			if err != nil {
+				return usage{}, err
+			}
+			total.size += u.size
+			total.files += u.files
+		} else {
+			total.size += info.Size()
+		}
+	}
+
+	return total, nil
+}
+
+// scopeUsage returns the storage used in the given directory of the scope, as
+// stored by the backend, recursively. The previous versions of the files and
+// the temporary upload files are left out, and so are the directories that
+// cannot be read, such as the ones removed while walking the tree.
+func scopeUsage(ctx context.Context, fs webdav.FileSystem, name string) (usage, error) {
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return usage{}, err
+	}
+	infos, err := f.Readdir(-1)
+	_ = f.Close()
+	if err != nil {
+		return usage{}, err
+	}
+
+	var total usage
+	for _, info := range infos {
+		if isUploadName(info.Name()) || (info.IsDir() && info.Name() == versionsDir) {
+			continue
+		}
+
+		total.files++
+		if !info.IsDir() {
+			total.size += info.Size()
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return usage{}, err
+		}
+		if u, err := scopeUsage(ctx, fs, path.Join(name, info.Name())); err == nil {
+			total.size += u.size
+			total.files += u.files
+		}
+	}
+
+	return total, nil
+}
+
+// usageCache caches the storage used by a user, in order to avoid walking the
+// whole tree on every request. It also keeps the bytes reserved by the uploads
+// in flight, which count towards the usage until they are done.
+type usageCache struct {
+	mu       sync.Mutex
+	usage    usage
+	expires  time.Time
+	reserved int64
+}
+
+func (c *usageCache) get(ctx context.Context, fs webdav.FileSystem) (usage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.load(ctx, fs)
+}
+
+// load returns the usage, computing it again if outdated. The mutex must be
+// held.
+func (c *usageCache) load(ctx context.Context, fs webdav.FileSystem) (usage, error) {
+	if time.Now().Before(c.expires) {
+		return c.usage, nil
+	}
+
+	// The scope may not have been created yet.
+	u, err := scopeUsage(ctx, fs, "/")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return usage{}, err
+	}
+
+	c.usage = u
+	c.expires = time.Now().Add(usageCacheTTL)
+	return u, nil
+}
+
+// reserve reserves the bytes of an upload of the given size, or of an unknown
+// size if negative, within the quota, given the bytes freed by the file it
+// replaces. It returns the bytes reserved, which the upload is limited to and
+// which must be released once it is done, and whether it fits.
+func (c *usageCache) reserve(ctx context.Context, fs webdav.FileSystem, quota, freed, size int64) (int64, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	u, err := c.load(ctx, fs)
+	if err != nil {
+		return 0, false, err
+	}
+
+	remaining := max(quota-(u.size-freed)-c.reserved, 0)
+	if size > remaining {
+		return 0, false, nil
+	}
+
+	reserved := remaining
+	if size >= 0 {
+		reserved = size
+	}
+	c.reserved += reserved
+	return reserved, true, nil
+}
+
+func (c *usageCache) release(reserved int64) {
+	c.mu.Lock()
+	c.reserved -= reserved
+	c.mu.Unlock()
+}
+
+func (c *usageCache) invalidate() {
+	c.mu.Lock()
+	c.expires = time.Time{}
+	c.mu.Unlock()
+}
+
+// enforceQuota checks whether the upload fits within the user's quota. If
+// it does not, the response is written and false is returned. Otherwise, the
+// bytes of the upload are reserved, which is all the remaining quota if its size
+// is unknown, the request body is limited to them, and the returned function
+// must be called after the request has been served.
+func (u *handlerUser) enforceQuota(w http.ResponseWriter, r *http.Request, name string) (http.ResponseWriter, func(), bool) {
+	ctx := r.Context()
+
+	// The file being overwritten will no longer count towards the usage. This
+	// does not apply to partial updates, which only write a part of the file.
+	var freed int64
+	if info, err := u.FileSystem.Stat(ctx, name); err == nil && !info.IsDir() && r.Method == http.MethodPut {
+		freed = info.Size()
+	}
+
+	reserved, ok, err := u.usage.reserve(ctx, u.scope, u.Quota, freed, r.ContentLength)
+	if err != nil {
+		requestLogger(r, u.logger).Error("failed to compute usage", zap.String("username", u.Username), zap.Error(err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if !ok {
+		requestLogger(r, u.logger).Info("quota exceeded", zap.String("username", u.Username), zap.String("path", name), zap.Int64("quota", u.Quota))
+		http.Error(w, "Insufficient storage", http.StatusInsufficientStorage)
+		return nil, nil, false
+	}
+
+	w, body := limitBody(w, r, reserved, http.StatusInsufficientStorage, errQuotaExceeded)
+	return w, func() {
+		// The upload is done, so it counts towards the usage computed again.
+		u.usage.release(reserved)
+		u.usage.invalidate()
+		if body.exceeded {
+			requestLogger(r, u.logger).Info("quota exceeded", zap.String("username", u.Username), zap.String("path", name), zap.Int64("quota", u.Quota))
+		}
+	}, true
+}
+
+// enforceMaxFiles checks whether the files and directories that the request
+// creates fit within the user's maximum number of files. An upload creates one,
+// unless it replaces a file, and a copy as many as it copies, minus the ones it
+// replaces. If they do not fit, the response is written and false is returned.
+func (u *handlerUser) enforceMaxFiles(w http.ResponseWriter, r *http.Request) bool {
+	ctx := r.Context()
+
+	current, err := u.usage.get(ctx, u.scope)
+	if err != nil {
+		requestLogger(r, u.logger).Error("failed to compute usage", zap.String("username", u.Username), zap.Error(err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+
+	var created int64
+	switch r.Method {
+	case http.MethodPut:
+		if _, err := u.FileSystem.Stat(ctx, u.name(r)); err != nil {
+			created = 1
+		}
+	case "MKCOL", "MKCALENDAR":
+		created = 1
+	case "COPY":
+		dst, ok := u.destinationName(r)
+		if !ok {
+			// The WebDAV handler rejects the request.
+			return true
+		}
+
+		created = u.countFiles(ctx, u.name(r), r.Header.Get("Depth") != "0")
+		if r.Header.Get("Overwrite") != "F" {
+			created -= u.countFiles(ctx, dst, true)
+		}
+	}
+
+	if current.files+created > u.MaxFiles {
+		requestLogger(r, u.logger).Info("max files exceeded", zap.String("username", u.Username), zap.String("path", u.name(r)), zap.Int64("max_files", u.MaxFiles))
+		http.Error(w, "Insufficient storage", http.StatusInsufficientStorage)
+		return false
+	}
+	return true
+}
+
+// countFiles returns the number of files and directories at the name, which is
+// 1 for a file, or a directory if not recursive, and 0 if there is nothing.
+func (u *handlerUser) countFiles(ctx context.Context, name string, recursive bool) int64 {
+	info, err := u.FileSystem.Stat(ctx, name)
+	if err != nil {
+		return 0
+	}
+	if !info.IsDir() || !recursive {
+		return 1
+	}
+
+	// If the directory cannot be walked, the WebDAV handler fails as well.
+	inside, _ := dirUsage(ctx, u.FileSystem, name)
+	return 1 + inside.files
+}
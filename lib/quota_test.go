@@ -0,0 +1,210 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuota(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T) (http.Handler, string) {
+		cfg := &Config{
+			Auth: true,
+			Users: []User{{
+				Username:    "admin",
+				Password:    "admin",
+				Permissions: Permissions{Modify: true},
+				// "file.txt" already uses 7 bytes.
+				Quota: 20,
+			}},
+		}
+		h := newTestHandler(t, cfg)
+		return h, cfg.Scope
+	}
+
+	put := func(h http.Handler, path string, body io.Reader, contentLength int64) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, path, body)
+		r.ContentLength = contentLength
+		r.SetBasicAuth("admin", "admin")
+		return doRequest(h, r)
+	}
+
+	t.Run("Within Quota", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t)
+		w := put(h, "/new.txt", strings.NewReader("0123456789"), 10)
+		require.Equal(t, http.StatusCreated, w.Code)
+	})
+
+	t.Run("Content-Length Exceeds Quota", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		body := &countingReader{Reader: bytes.NewReader(make([]byte, 14))}
+		w := put(h, "/new.txt", body, 14)
+		require.Equal(t, http.StatusInsufficientStorage, w.Code)
+		require.Zero(t, body.n)
+		require.NoFileExists(t, filepath.Join(scope, "new.txt"))
+	})
+
+	t.Run("Overwrite Within Quota", func(t *testing.T) {
+		t.Parallel()
+
+		// Overwriting "file.txt" frees its 7 bytes.
+		h, _ := newHandler(t)
+		w := put(h, "/file.txt", bytes.NewReader(make([]byte, 20)), 20)
+		require.Equal(t, http.StatusCreated, w.Code)
+	})
+
+	t.Run("Chunked Exceeds Quota", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		w := put(h, "/new.txt", io.MultiReader(bytes.NewReader(make([]byte, 10)), bytes.NewReader(make([]byte, 10))), -1)
+		require.Equal(t, http.StatusInsufficientStorage, w.Code)
+		require.NotContains(t, w.Body.String(), "Method Not Allowed")
+		require.NoFileExists(t, filepath.Join(scope, "new.txt"))
+	})
+
+	t.Run("Concurrent Uploads", func(t *testing.T) {
+		t.Parallel()
+
+		// The first upload holds its bytes while it is in flight, so that the
+		// second one cannot exceed the quota along with it.
+		h, _ := newHandler(t)
+		started, release := make(chan struct{}), make(chan struct{})
+		done := make(chan int)
+		go func() {
+			body := &blockingReader{Reader: strings.NewReader("0123456789"), started: started, release: release}
+			done <- put(h, "/a.txt", body, 10).Code
+		}()
+		<-started
+
+		require.Equal(t, http.StatusInsufficientStorage, put(h, "/b.txt", strings.NewReader("0123456789"), 10).Code)
+		close(release)
+		require.Equal(t, http.StatusCreated, <-done)
+
+		// The bytes are no longer reserved, but uploaded.
+		require.Equal(t, http.StatusCreated, put(h, "/b.txt", strings.NewReader("012"), 3).Code)
+		require.Equal(t, http.StatusInsufficientStorage, put(h, "/c.txt", strings.NewReader("0"), 1).Code)
+	})
+
+	t.Run("Scope Only", func(t *testing.T) {
+		t.Parallel()
+
+		// Only the files of the scope count, not their previous versions.
+		h, scope := newHandler(t)
+		require.NoError(t, os.MkdirAll(filepath.Join(scope, ".versions", "file.txt"), 0777))
+		require.NoError(t, os.WriteFile(filepath.Join(scope, ".versions", "file.txt", "old"), make([]byte, 100), 0666))
+		require.Equal(t, http.StatusCreated, put(h, "/new.txt", strings.NewReader("0123456789"), 10).Code)
+	})
+
+	t.Run("Usage Is Cached", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		w := put(h, "/a.txt", strings.NewReader("0123456789"), 10)
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		w = put(h, "/b.txt", strings.NewReader("0123"), 4)
+		require.Equal(t, http.StatusInsufficientStorage, w.Code)
+
+		// Changes made behind our back are not seen until the cache expires.
+		require.NoError(t, os.Remove(filepath.Join(scope, "a.txt")))
+		w = put(h, "/b.txt", strings.NewReader("0123"), 4)
+		require.Equal(t, http.StatusInsufficientStorage, w.Code)
+
+		// Changes made through WebDAV invalidate the cache.
+		r := httptest.NewRequest(http.MethodDelete, "/file.txt", nil)
+		r.SetBasicAuth("admin", "admin")
+		require.Equal(t, http.StatusNoContent, doRequest(h, r).Code)
+
+		w = put(h, "/b.txt", strings.NewReader("0123"), 4)
+		require.Equal(t, http.StatusCreated, w.Code)
+	})
+}
+
+func TestMaxFiles(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Auth: true,
+		Users: []User{{
+			Username:    "admin",
+			Password:    "admin",
+			Permissions: Permissions{Modify: true},
+			// "file.txt" and "dir" are already there.
+			MaxFiles: 4,
+		}},
+	}
+	h := newTestHandler(t, cfg)
+
+	request := func(method, path, destination string) int {
+		r := httptest.NewRequest(method, path, strings.NewReader("content"))
+		r.SetBasicAuth("admin", "admin")
+		if destination != "" {
+			r.Header.Set("Destination", destination)
+		}
+		return doRequest(h, r).Code
+	}
+
+	require.Equal(t, http.StatusCreated, request(http.MethodPut, "/a.txt", ""))
+	require.Equal(t, http.StatusCreated, request(http.MethodPut, "/dir/b.txt", ""))
+	require.Equal(t, http.StatusInsufficientStorage, request(http.MethodPut, "/c.txt", ""))
+	require.NoFileExists(t, filepath.Join(cfg.Scope, "c.txt"))
+	require.Equal(t, http.StatusInsufficientStorage, request("MKCOL", "/new", ""))
+	require.Equal(t, http.StatusInsufficientStorage, request("COPY", "/a.txt", "/copy.txt"))
+
+	// Replacing files creates none.
+	require.Equal(t, http.StatusCreated, request(http.MethodPut, "/a.txt", ""))
+	require.Equal(t, http.StatusNoContent, request("COPY", "/file.txt", "/a.txt"))
+
+	// Deleting files makes room for others, as soon as they are deleted.
+	require.Equal(t, http.StatusNoContent, request(http.MethodDelete, "/a.txt", ""))
+	require.Equal(t, http.StatusCreated, request(http.MethodPut, "/c.txt", ""))
+
+	// Copies count all the copied files.
+	require.Equal(t, http.StatusNoContent, request(http.MethodDelete, "/c.txt", ""))
+	require.Equal(t, http.StatusInsufficientStorage, request("COPY", "/dir/", "/copy/"))
+	require.Equal(t, http.StatusNoContent, request(http.MethodDelete, "/file.txt", ""))
+	require.Equal(t, http.StatusCreated, request("COPY", "/dir/", "/copy/"))
+	require.FileExists(t, filepath.Join(cfg.Scope, "copy", "b.txt"))
+}
+
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+// blockingReader signals its first read, and then blocks until released.
+type blockingReader struct {
+	io.Reader
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	r.once.Do(func() {
+		close(r.started)
+		<-r.release
+	})
+	return r.Reader.Read(p)
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket limiting throughput to a certain amount of
+// bytes per second. The bucket holds at most one second worth of tokens.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// burst returns the maximum amount of bytes that can be taken at once.
+func (l *rateLimiter) burst() int {
+	return int(l.rate)
+}
+
+// wait blocks until n bytes can be transferred, or the context is done. The
+// value of n must not be larger than the burst.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	// Take the tokens right away, even if that puts the bucket in debt. This
+	// way, concurrent callers queue behind each other.
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type rateLimitedReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rateLimiter
+}
+
+func (r rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.burst() {
+		p = p[:r.limiter.burst()]
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := r.limiter.wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type rateLimitedWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limiter *rateLimiter
+}
+
+func (w rateLimitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > w.limiter.burst() {
+			chunk = chunk[:w.limiter.burst()]
+		}
+
+		if err := w.limiter.wait(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
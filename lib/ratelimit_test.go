@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Throttles", func(t *testing.T) {
+		t.Parallel()
+
+		l := newRateLimiter(100)
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			require.NoError(t, l.wait(context.Background(), 50))
+		}
+		// The first 100 bytes are in the bucket, the remaining 50 take 0.5s.
+		require.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	})
+
+	t.Run("Context Cancellation", func(t *testing.T) {
+		t.Parallel()
+
+		l := newRateLimiter(10)
+		require.NoError(t, l.wait(context.Background(), 10))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		start := time.Now()
+		require.ErrorIs(t, l.wait(ctx, 10), context.Canceled)
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		require.Nil(t, newRateLimiter(0))
+	})
+}
+
+func TestHandlerRateLimit(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Permissions: Permissions{Modify: true},
+		RateLimit:   4096,
+	}
+	h := newTestHandler(t, cfg)
+
+	content := bytes.Repeat([]byte("a"), 8192)
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "large.txt"), content, 0666))
+
+	start := time.Now()
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/large.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, content, w.Body.Bytes())
+	require.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+
+	start = time.Now()
+	w = doRequest(h, httptest.NewRequest(http.MethodPut, "/upload.txt", bytes.NewReader(content)))
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+}
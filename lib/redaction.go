@@ -0,0 +1,161 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	RedactionMask = "mask"
+	RedactionHash = "hash"
+)
+
+// redactedValue replaces the masked paths and the sensitive headers in the logs.
+const redactedValue = "[redacted]"
+
+// sensitiveHeaders are the headers whose values are never logged, as they carry
+// the credentials of the clients.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// redactor keeps the sensitive paths and headers out of the logs.
+type redactor struct {
+	patterns []string
+	hash     bool
+	headers  map[string]bool
+}
+
+func newRedactor(c Redaction) *redactor {
+	r := &redactor{patterns: c.Paths, hash: c.Mode == RedactionHash, headers: map[string]bool{}}
+	for _, name := range append(sensitiveHeaders, c.Headers...) {
+		r.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	return r
+}
+
+// path returns the path as it must be logged: masked or hashed if it matches
+// one of the patterns, or else as is. The hashes let the requests to the same
+// path be told apart without revealing it.
+func (r *redactor) path(p string) string {
+	if len(r.patterns) == 0 || p == "" || !matchesPath(r.patterns, p) {
+		return p
+	}
+	if r.hash {
+		sum := sha256.Sum256([]byte(p))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	}
+	return redactedValue
+}
+
+// uri returns the URI, or request URI, as it must be logged. If its path is
+// redacted, so are its query and fragment.
+func (r *redactor) uri(s string) string {
+	if len(r.patterns) == 0 {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || !matchesPath(r.patterns, u.Path) {
+		return s
+	}
+
+	prefix := ""
+	if u.Host != "" {
+		prefix = u.Scheme + "://" + u.Host
+	}
+	return prefix + r.path(u.Path)
+}
+
+// redactedFields are the fields that name paths within the scopes.
+var redactedFields = map[string]bool{"path": true, "destination": true}
+
+// uriHeaders are the headers whose values are URIs, whose paths are redacted.
+var uriHeaders = map[string]bool{"Destination": true, "Referer": true}
+
+func (r *redactor) fields(fields []zapcore.Field) []zapcore.Field {
+	var redacted []zapcore.Field
+	for i, f := range fields {
+		if f.Type != zapcore.StringType || !redactedFields[f.Key] {
+			continue
+		}
+		if p := r.path(f.String); p != f.String {
+			if redacted == nil {
+				redacted = append([]zapcore.Field(nil), fields...)
+			}
+			redacted[i].String = p
+		}
+	}
+	if redacted == nil {
+		return fields
+	}
+	return redacted
+}
+
+// wrap returns the logger with the paths of its entries redacted.
+func (r *redactor) wrap(logger *zap.Logger) *zap.Logger {
+	if len(r.patterns) == 0 {
+		return logger
+	}
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &redactingCore{Core: core, redactor: r}
+	}))
+}
+
+// httpHeaders returns a field with the headers, the sensitive ones redacted.
+func (r *redactor) httpHeaders(key string, header http.Header) zap.Field {
+	return zap.Object(key, loggedHeaders{header: header, redactor: r})
+}
+
+// redactingCore redacts the paths of the fields of the entries it writes.
+type redactingCore struct {
+	zapcore.Core
+	redactor *redactor
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(c.redactor.fields(fields)), redactor: c.redactor}
+}
+
+func (c *redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(entry, c.redactor.fields(fields))
+}
+
+// loggedHeaders logs the headers of a request, in order, with the values of the
+// sensitive ones redacted, as are the paths of the URIs that match.
+type loggedHeaders struct {
+	header   http.Header
+	redactor *redactor
+}
+
+func (h loggedHeaders) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	names := make([]string, 0, len(h.header))
+	for name := range h.header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		canonical := http.CanonicalHeaderKey(name)
+		value := strings.Join(h.header[name], ", ")
+		switch {
+		case h.redactor.headers[canonical]:
+			value = redactedValue
+		case uriHeaders[canonical]:
+			value = h.redactor.uri(value)
+		}
+		enc.AddString(name, value)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// newBufferLogger returns a logger that writes all the entries, as JSON, to the
+// returned buffer.
+func newBufferLogger() (*zap.Logger, *bytes.Buffer) {
+	var out bytes.Buffer
+	encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	return zap.New(zapcore.NewCore(encoder, zapcore.AddSync(&out), zapcore.DebugLevel)), &out
+}
+
+func TestRedaction(t *testing.T) {
+	t.Parallel()
+
+	logger, out := newBufferLogger()
+	h := newTestHandler(t, &Config{
+		Logger:        logger,
+		Auth:          true,
+		AccessLog:     AccessLog{Enabled: true, Format: AccessLogStructured},
+		Audit:         Audit{Enabled: true},
+		SlowThreshold: 1,
+		Redaction:     Redaction{Paths: []string{"/secret"}, Headers: []string{"X-Api-Key"}},
+		Users:         []User{{Username: "admin", Password: "password", Permissions: Permissions{Modify: true}}},
+	})
+
+	r := httptest.NewRequest("MKCOL", "/secret", nil)
+	r.SetBasicAuth("admin", "password")
+	r.Header.Set("X-Api-Key", "key")
+	r.Header.Set("User-Agent", "test-agent")
+	require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+
+	r = httptest.NewRequest(http.MethodPut, "/secret/file.txt", strings.NewReader("new"))
+	r.SetBasicAuth("admin", "password")
+	require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+
+	r = httptest.NewRequest("MOVE", "/file.txt", nil)
+	r.SetBasicAuth("admin", "password")
+	r.Header.Set("Destination", "/secret/moved.txt")
+	require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+
+	r = httptest.NewRequest(http.MethodGet, "/dir/", nil)
+	r.SetBasicAuth("admin", "password")
+	doRequest(h, r)
+
+	logs := out.String()
+	require.NotContains(t, logs, "/secret")
+	require.NotContains(t, logs, base64.StdEncoding.EncodeToString([]byte("admin:password")))
+	require.NotContains(t, logs, `"key"`)
+	require.Contains(t, logs, `"path":"[redacted]"`)
+	require.Contains(t, logs, `"destination":"[redacted]"`)
+	require.Contains(t, logs, `"Authorization":"[redacted]"`)
+	require.Contains(t, logs, `"User-Agent":"test-agent"`)
+	require.Contains(t, logs, `"path":"/dir/"`)
+	require.Contains(t, logs, `"path":"/file.txt"`)
+}
+
+func TestRedactionUnconfigured(t *testing.T) {
+	t.Parallel()
+
+	logger, out := newBufferLogger()
+	h := newTestHandler(t, &Config{
+		Logger: logger,
+		Auth:   true,
+		Users:  []User{{Username: "admin", Password: "password"}},
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	r.SetBasicAuth("admin", "password")
+	r.Header.Set("Cookie", "session=secret")
+	require.Equal(t, http.StatusOK, doRequest(h, r).Code)
+
+	// The paths are logged, but the credentials never are.
+	logs := out.String()
+	require.Contains(t, logs, `"path":"/file.txt"`)
+	require.NotContains(t, logs, base64.StdEncoding.EncodeToString([]byte("admin:password")))
+	require.NotContains(t, logs, "session=secret")
+}
+
+func TestRedactorPaths(t *testing.T) {
+	t.Parallel()
+
+	mask := newRedactor(Redaction{Paths: []string{"/private", "/*/secret.txt"}, Mode: RedactionMask})
+	for p, expected := range map[string]string{
+		"/":                  "/",
+		"/public/file.txt":   "/public/file.txt",
+		"/private":           redactedValue,
+		"/private/":          redactedValue,
+		"/private/a/b.txt":   redactedValue,
+		"/privateer":         "/privateer",
+		"/dir/secret.txt":    redactedValue,
+		"/dir/secret.txt/a":  redactedValue,
+		"/dir/other.txt":     "/dir/other.txt",
+		"/a/b/secret.txt":    "/a/b/secret.txt",
+		"private/relative":   redactedValue,
+		"":                   "",
+		"/public/../private": redactedValue,
+	} {
+		require.Equal(t, expected, mask.path(p), p)
+	}
+
+	// The hashes are the same for the same path, and differ across paths.
+	hash := newRedactor(Redaction{Paths: []string{"/private"}, Mode: RedactionHash})
+	require.Regexp(t, `^sha256:[0-9a-f]{16}$`, hash.path("/private/a"))
+	require.Equal(t, hash.path("/private/a"), hash.path("/private/a"))
+	require.NotEqual(t, hash.path("/private/a"), hash.path("/private/b"))
+	require.Equal(t, "/public", hash.path("/public"))
+
+	// The queries and fragments of the redacted URIs are dropped too.
+	require.Equal(t, redactedValue, mask.uri("/private/file.txt?token=secret"))
+	require.Equal(t, "https://example.com"+redactedValue, mask.uri("https://example.com/private/?a=b#c"))
+	require.Equal(t, "/public/?a=b", mask.uri("/public/?a=b"))
+}
+
+func TestAccessLogCombinedRedaction(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		AccessLog: AccessLog{Enabled: true, Format: AccessLogCombined},
+		Redaction: Redaction{Paths: []string{"/dir"}},
+	})
+
+	var out bytes.Buffer
+	h.(*Handler).handler.accessLog.out = &out
+
+	r := httptest.NewRequest(http.MethodGet, "/dir/?token=secret", nil)
+	r.Header.Set("Referer", "http://example.com/dir/")
+	doRequest(h, r)
+
+	require.Regexp(t, `^192\.0\.2\.1 - - \[.+\] "GET \[redacted\] HTTP/1\.1" 207 \d+ "http://example\.com\[redacted\]" "-"\n$`, out.String())
+}
+
+func TestConfigRedaction(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+redaction:
+  paths:
+    - /private
+  headers:
+    - X-Api-Key
+`, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, []string{"/private"}, cfg.Redaction.Paths)
+	require.Equal(t, RedactionMask, cfg.Redaction.Mode)
+	require.Equal(t, []string{"X-Api-Key"}, cfg.Redaction.Headers)
+
+	for _, redaction := range []Redaction{
+		{Mode: "encrypt"},
+		{Paths: []string{"private"}},
+		{Paths: []string{"/["}},
+		{Headers: []string{""}},
+	} {
+		cfg := &Config{Redaction: redaction}
+		require.ErrorContains(t, cfg.Validate(), "invalid config")
+	}
+}
@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerReload(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Permissions: Permissions{Modify: true},
+		Auth:        true,
+		Users: []User{
+			{Username: "admin", Password: "admin"},
+		},
+	}
+	h := newTestHandler(t, cfg).(*Handler)
+
+	request := func(method, target, username, password string) int {
+		r := httptest.NewRequest(method, target, nil)
+		r.SetBasicAuth(username, password)
+		return doRequest(h, r).Code
+	}
+
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/file.txt", "admin", "admin"))
+	require.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/file.txt", "other", "other"))
+
+	reloaded := *cfg
+	reloaded.Modify = false
+	reloaded.Users = []User{
+		{Username: "admin", Password: "changed", Permissions: Permissions{Scope: cfg.Scope}},
+		{Username: "other", Password: "other", Permissions: Permissions{Scope: cfg.Scope}},
+	}
+	require.NoError(t, reloaded.Validate())
+	require.NoError(t, h.Reload(&reloaded))
+
+	require.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/file.txt", "admin", "admin"))
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/file.txt", "admin", "changed"))
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/file.txt", "other", "other"))
+	require.Equal(t, http.StatusForbidden, request(http.MethodDelete, "/file.txt", "other", "other"))
+}
+
+func TestHandlerReloadInvalid(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Permissions: Permissions{Modify: true},
+	}
+	h := newTestHandler(t, cfg).(*Handler)
+
+	reloaded := *cfg
+	reloaded.UsersFile = "/does/not/exist"
+	require.Error(t, h.Reload(&reloaded))
+
+	// The previous configuration is still in use.
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHandlerReloadKeepsState(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Permissions: Permissions{Modify: true},
+		Backend:     BackendMemory,
+	}
+	h := newTestHandler(t, cfg).(*Handler)
+
+	w := doRequest(h, httptest.NewRequest(http.MethodPut, "/new.txt", strings.NewReader("new")))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	r := httptest.NewRequest("LOCK", "/new.txt", strings.NewReader(`<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`))
+	w = doRequest(h, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	reloaded := *cfg
+	require.NoError(t, h.Reload(&reloaded))
+
+	w = doRequest(h, httptest.NewRequest(http.MethodGet, "/new.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "new", w.Body.String())
+
+	w = doRequest(h, httptest.NewRequest(http.MethodPut, "/new.txt", strings.NewReader("changed")))
+	require.Equal(t, http.StatusLocked, w.Code)
+}
+
+func TestHandlerReloadConcurrent(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Permissions: Permissions{Modify: true},
+	}
+	h := newTestHandler(t, cfg).(*Handler)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+				assert.Equal(t, http.StatusOK, w.Code)
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		reloaded := *cfg
+		require.NoError(t, h.Reload(&reloaded))
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"go.uber.org/zap"
+)
+
+type requestIDKey struct{}
+
+type requestLoggerKey struct{}
+
+// requestIDRegexp matches the request IDs that are honored, when given by the
+// clients or the proxies in front of the server. The others are replaced, so
+// that they cannot garble the logs.
+var requestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._:+/=-]{1,128}$`)
+
+func newRequestID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// withRequestID gives the request its ID, taken from the header if valid, or
+// generated otherwise. The ID is echoed in the header of the response, and is
+// kept in the context of the request, along with a logger that includes it in
+// all the entries.
+func (h *handler) withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(h.requestIDHeader)
+	if !requestIDRegexp.MatchString(id) {
+		var err error
+		if id, err = newRequestID(); err != nil {
+			h.logger.Error("failed to generate request id", zap.Error(err))
+			return r
+		}
+	}
+
+	w.Header().Set(h.requestIDHeader, id)
+	ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+	ctx = context.WithValue(ctx, requestLoggerKey{}, h.logger.With(zap.String("request_id", id)))
+	return r.WithContext(ctx)
+}
+
+// requestID returns the ID of the request, if it was given one.
+func requestID(r *http.Request) (string, bool) {
+	id, ok := r.Context().Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// requestLogger returns the logger of the request, which includes its ID, or
+// the given logger if it was not given one.
+func requestLogger(r *http.Request, logger *zap.Logger) *zap.Logger {
+	if l, ok := r.Context().Value(requestLoggerKey{}).(*zap.Logger); ok {
+		return l
+	}
+	return logger
+}
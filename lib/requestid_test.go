@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T) (http.Handler, *observer.ObservedLogs) {
+		core, logs := observer.New(zapcore.DebugLevel)
+		h := newTestHandler(t, &Config{
+			Logger:      zap.New(core),
+			Permissions: Permissions{Modify: true},
+			RequestID:   RequestID{Enabled: true},
+			AccessLog:   AccessLog{Enabled: true, Format: AccessLogStructured},
+		})
+		return h, logs
+	}
+
+	requireLogged := func(t *testing.T, logs *observer.ObservedLogs, id string) {
+		t.Helper()
+		entries := logs.All()
+		require.NotEmpty(t, entries)
+		for _, entry := range entries {
+			require.Equal(t, id, entry.ContextMap()["request_id"], entry.Message)
+		}
+		require.Equal(t, 1, logs.FilterMessage("request").Len())
+	}
+
+	t.Run("Generated", func(t *testing.T) {
+		t.Parallel()
+
+		h, logs := newHandler(t)
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		id := w.Header().Get("X-Request-ID")
+		require.Len(t, id, 32)
+		requireLogged(t, logs, id)
+
+		// Every request gets its own.
+		w = doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+		require.NotEqual(t, id, w.Header().Get("X-Request-ID"))
+	})
+
+	t.Run("Inbound", func(t *testing.T) {
+		t.Parallel()
+
+		h, logs := newHandler(t)
+		r := httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("new"))
+		r.Header.Set("X-Request-ID", "trace-1234:abcd")
+		w := doRequest(h, r)
+		require.Equal(t, "trace-1234:abcd", w.Header().Get("X-Request-ID"))
+		requireLogged(t, logs, "trace-1234:abcd")
+	})
+
+	t.Run("Invalid Inbound", func(t *testing.T) {
+		t.Parallel()
+
+		h, logs := newHandler(t)
+		for _, id := range []string{"with spaces", "new\nline", strings.Repeat("a", 129)} {
+			r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+			r.Header.Set("X-Request-ID", id)
+			w := doRequest(h, r)
+			require.Len(t, w.Header().Get("X-Request-ID"), 32, id)
+		}
+		for _, entry := range logs.All() {
+			require.Len(t, entry.ContextMap()["request_id"], 32)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		core, logs := observer.New(zapcore.DebugLevel)
+		h := newTestHandler(t, &Config{Logger: zap.New(core)})
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.Header.Set("X-Request-ID", "trace-1234")
+		w := doRequest(h, r)
+		require.Empty(t, w.Header().Get("X-Request-ID"))
+		for _, entry := range logs.All() {
+			require.NotContains(t, entry.ContextMap(), "request_id")
+		}
+	})
+}
+
+func TestConfigRequestID(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+request_id:
+  enabled: true
+`, ".yml")
+	require.Equal(t, RequestID{Enabled: true, Header: DefaultRequestIDHeader}, cfg.RequestID)
+
+	cfg = &Config{RequestID: RequestID{Enabled: true, Header: "X Request"}}
+	require.ErrorContains(t, cfg.Validate(), "request id header")
+}
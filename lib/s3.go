@@ -0,0 +1,548 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// emptySHA256 is the hash of an empty payload.
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// unsignedPayload replaces the hash of the payloads sent over TLS, which
+	// already protects their integrity, so that they are not read twice.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+
+	s3TimeFormat = "20060102T150405Z"
+	s3DateFormat = "20060102"
+
+	// s3PartSize is the size of the parts of the multipart uploads, which are
+	// used for the objects larger than it, as a single PUT is limited to 5 GB.
+	s3PartSize = 64 << 20
+
+	// s3MaxParts is the maximum number of parts of a multipart upload. The
+	// parts are made larger for the objects that would need more.
+	s3MaxParts = 10000
+)
+
+// s3Client is a minimal client for the S3 API, which implements the few
+// operations needed by [s3FS], signing the requests with AWS Signature
+// Version 4.
+type s3Client struct {
+	endpoint        *url.URL
+	bucket          string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	pathStyle       bool
+	client          *http.Client
+	partSize        int64
+
+	// tempDir is the directory of the temporary files the uploads are
+	// buffered in, which is the default one of the system if empty.
+	tempDir string
+
+	// now returns the current time, used to sign the requests.
+	now func() time.Time
+}
+
+func newS3Client(c S3) *s3Client {
+	// The endpoint is validated beforehand.
+	endpoint, _ := url.Parse(c.Endpoint)
+
+	return &s3Client{
+		endpoint:        endpoint,
+		bucket:          c.Bucket,
+		region:          c.Region,
+		accessKeyID:     c.AccessKeyID,
+		secretAccessKey: c.SecretAccessKey,
+		pathStyle:       c.PathStyle,
+		client:          newS3HTTPClient(c),
+		partSize:        s3PartSize,
+		tempDir:         c.TempDir,
+		now:             time.Now,
+	}
+}
+
+// newS3HTTPClient returns the client for the endpoint, whose timeouts make sure
+// that a stalled endpoint does not hold the requests forever. The bodies are
+// not bounded, as they can be as large as the files.
+func newS3HTTPClient(c S3) *http.Client {
+	dialer := &net.Dialer{Timeout: c.DialTimeout, KeepAlive: 30 * time.Second}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   c.DialTimeout,
+			ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+			IdleConnTimeout:       c.IdleTimeout,
+			ExpectContinueTimeout: time.Second,
+			MaxIdleConnsPerHost:   16,
+			ForceAttemptHTTP2:     true,
+		},
+	}
+}
+
+// s3Object is an object, as returned by the HEAD requests and the listings.
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+}
+
+// s3Error is the error returned by the S3 API.
+type s3Error struct {
+	Status  int    `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: %s", http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// Unwrap maps the error to the errors of the standard library, so that the
+// WebDAV handler replies with the appropriate status.
+func (e *s3Error) Unwrap() error {
+	switch e.Status {
+	case http.StatusNotFound:
+		return os.ErrNotExist
+	case http.StatusForbidden:
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// url returns the URL of an object, or of the bucket if the key is empty.
+func (c *s3Client) url(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	p := "/" + key
+	if c.pathStyle {
+		p = "/" + c.bucket + p
+	} else {
+		u.Host = c.bucket + "." + u.Host
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3Query(query)
+	return &u
+}
+
+// s3HashedBody is implemented by the request bodies whose SHA-256 hash is
+// already known, so that they are not read once more to be signed.
+type s3HashedBody interface {
+	io.ReadSeeker
+	payloadHash() (string, error)
+}
+
+// do sends a signed request. The body must be seekable, since its hash is
+// part of the signature, unless the endpoint uses TLS. Responses with an error
+// status are turned into an [s3Error].
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.ReadSeeker) (*http.Response, error) {
+	payloadHash := emptySHA256
+	switch hashed, ok := body.(s3HashedBody); {
+	case body == nil:
+	case c.endpoint.Scheme == "https":
+		payloadHash = unsignedPayload
+	case ok:
+		var err error
+		if payloadHash, err = hashed.payloadHash(); err != nil {
+			return nil, err
+		}
+	default:
+		hash := sha256.New()
+		if _, err := io.Copy(hash, body); err != nil {
+			return nil, err
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		payloadHash = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = body
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.url(key, query).String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		size, err := body.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		req.ContentLength = size
+		if size == 0 {
+			// Otherwise, http.Client does not send a Content-Length.
+			req.Body = http.NoBody
+		}
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	c.sign(req, payloadHash, c.now())
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		e := &s3Error{Status: res.StatusCode}
+		if method != http.MethodHead {
+			data, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+			_ = xml.Unmarshal(data, e)
+		}
+		return nil, e
+	}
+
+	return res, nil
+}
+
+// sign signs the request with AWS Signature Version 4. Anonymous requests,
+// without credentials, are not signed.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (c *s3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	if c.accessKeyID == "" {
+		return
+	}
+
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Sign the host, and the headers that affect the operation.
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "range" || name == "content-type" || name == "content-md5" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	date := now.Format(s3DateFormat)
+	credentialScope := date + "/" + c.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format(s3TimeFormat),
+		credentialScope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.secretAccessKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.accessKeyID+"/"+credentialScope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hexSHA256(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape escapes the string as required by the signature: everything but
+// the unreserved characters is percent-encoded, including the slashes if
+// requested.
+func s3Escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Query encodes the query with the keys sorted, as required by the
+// signature.
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// headObject returns the metadata of an object.
+func (c *s3Client) headObject(ctx context.Context, key string) (s3Object, error) {
+	res, err := c.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return s3Object{}, err
+	}
+	res.Body.Close()
+
+	obj := s3Object{Key: key, Size: res.ContentLength, ETag: res.Header.Get("ETag")}
+	obj.LastModified, _ = http.ParseTime(res.Header.Get("Last-Modified"))
+	return obj, nil
+}
+
+// getObject returns the contents of an object, starting at the offset.
+func (c *s3Client) getObject(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+
+	res, err := c.do(ctx, http.MethodGet, key, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// putObject creates or replaces an object with the contents of the body. The
+// bodies larger than the part size are uploaded in parts, if they can be read
+// at arbitrary offsets.
+func (c *s3Client) putObject(ctx context.Context, key string, body io.ReadSeeker) error {
+	if body == nil {
+		body = bytes.NewReader(nil)
+	}
+
+	if r, ok := body.(io.ReaderAt); ok {
+		size, err := body.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if size > c.partSize {
+			return c.putMultipart(ctx, key, r, size)
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	res, err := c.do(ctx, http.MethodPut, key, nil, nil, body)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// s3Part is an uploaded part of a multipart upload.
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// s3CompletedUpload is the list of the parts of a multipart upload, sent to
+// complete it.
+type s3CompletedUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []s3Part `xml:"Part"`
+}
+
+// putMultipart uploads an object in parts. The upload is aborted if any of
+// them fails, so that S3 does not keep the parts already uploaded.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/userguide/mpuoverview.html
+func (c *s3Client) putMultipart(ctx context.Context, key string, body io.ReaderAt, size int64) (err error) {
+	res, err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	var upload struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(res.Body).Decode(&upload)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			// The upload is aborted even if the request was canceled.
+			res, abortErr := c.do(context.WithoutCancel(ctx), http.MethodDelete, key, url.Values{"uploadId": {upload.UploadID}}, nil, nil)
+			if abortErr == nil {
+				res.Body.Close()
+			}
+		}
+	}()
+
+	var completed s3CompletedUpload
+	partSize := max(c.partSize, (size+s3MaxParts-1)/s3MaxParts)
+	for number, offset := 1, int64(0); offset < size; number, offset = number+1, offset+partSize {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {upload.UploadID}}
+		part := io.NewSectionReader(body, offset, min(partSize, size-offset))
+		res, err := c.do(ctx, http.MethodPut, key, query, nil, part)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		completed.Parts = append(completed.Parts, s3Part{PartNumber: number, ETag: res.Header.Get("ETag")})
+	}
+
+	data, err := xml.Marshal(completed)
+	if err != nil {
+		return err
+	}
+	res, err = c.do(ctx, http.MethodPost, key, url.Values{"uploadId": {upload.UploadID}}, nil, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return s3BodyError(res)
+}
+
+// copyObject copies an object within the bucket.
+func (c *s3Client) copyObject(ctx context.Context, src, dst string) error {
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", s3Escape("/"+c.bucket+"/"+src, false))
+
+	res, err := c.do(ctx, http.MethodPut, dst, nil, header, nil)
+	if err != nil {
+		return err
+	}
+	return s3BodyError(res)
+}
+
+// s3BodyError closes the body of the response, and returns the error it holds.
+// Copies and multipart uploads can fail after the response has been started, in
+// which case the error is in the body.
+func s3BodyError(res *http.Response) error {
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	e := &s3Error{Status: http.StatusInternalServerError}
+	if xml.Unmarshal(data, e) == nil && e.Code != "" {
+		return e
+	}
+	return nil
+}
+
+// deleteObject deletes an object. Deleting an object that does not exist is
+// not an error.
+func (c *s3Client) deleteObject(ctx context.Context, key string) error {
+	res, err := c.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// s3Listing is a page of the listing of the objects of the bucket.
+type s3Listing struct {
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+	Contents              []s3Object `xml:"Contents"`
+	CommonPrefixes        []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+// listObjects lists the objects whose key starts with the prefix. With a
+// delimiter, the keys that contain it after the prefix are grouped into common
+// prefixes. With a limit greater than zero, at most that many results are
+// returned.
+func (c *s3Client) listObjects(ctx context.Context, prefix, delimiter string, limit int) (objects []s3Object, prefixes []string, err error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if limit > 0 {
+		query.Set("max-keys", strconv.Itoa(limit))
+	}
+
+	for {
+		res, err := c.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var listing s3Listing
+		err = xml.NewDecoder(res.Body).Decode(&listing)
+		res.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		objects = append(objects, listing.Contents...)
+		for _, p := range listing.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+
+		if !listing.IsTruncated || listing.NextContinuationToken == "" || (limit > 0 && len(objects)+len(prefixes) >= limit) {
+			return objects, prefixes, nil
+		}
+		query.Set("continuation-token", listing.NextContinuationToken)
+	}
+}
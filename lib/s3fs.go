@@ -0,0 +1,561 @@
+package lib
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// s3FS is a [webdav.FileSystem] that stores the files as the objects of an S3
+// bucket, under the key prefix of the scope. Since S3 has no directories, they
+// are emulated: a directory exists if any object has its key as a prefix, and
+// empty directories are kept as zero-sized marker objects whose key ends with
+// a slash, as most S3 tools do.
+type s3FS struct {
+	client *s3Client
+	prefix string
+}
+
+func newS3FS(client *s3Client, scope string) s3FS {
+	prefix := strings.Trim(path.Clean("/"+scope), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return s3FS{client: client, prefix: prefix}
+}
+
+// atomicUploads implements [atomicUploader], since the objects are only
+// replaced once the upload is complete.
+func (fs s3FS) atomicUploads() {}
+
+// key returns the key of the object of a file, which is empty for the root.
+func (fs s3FS) key(name string) string {
+	return fs.prefix + strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// dirKey returns the prefix of the keys of the contents of a directory, which
+// is also the key of its marker object.
+func (fs s3FS) dirKey(name string) string {
+	key := fs.key(name)
+	if key == "" || strings.HasSuffix(key, "/") {
+		return key
+	}
+	return key + "/"
+}
+
+func isRoot(name string) bool {
+	return path.Clean("/"+name) == "/"
+}
+
+func (fs s3FS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if _, err := fs.Stat(ctx, name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := fs.checkParent(ctx, "mkdir", name); err != nil {
+		return err
+	}
+
+	return fs.client.putObject(ctx, fs.dirKey(name), nil)
+}
+
+// checkParent makes sure that the parent directory of a file exists, like
+// a regular file system would.
+func (fs s3FS) checkParent(ctx context.Context, op, name string) error {
+	parent, err := fs.Stat(ctx, path.Dir(path.Clean("/"+name)))
+	if err != nil {
+		return err
+	}
+	if !parent.IsDir() {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+func (fs s3FS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	info, err := fs.Stat(ctx, name)
+	switch {
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case err == nil && info.IsDir():
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: errIsDirectory}
+		}
+		return &s3Dir{fs: fs, ctx: ctx, name: name, info: info}, nil
+	case errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE != 0:
+		if err := fs.checkParent(ctx, "open", name); err != nil {
+			return nil, err
+		}
+		info = s3FileInfo{name: path.Base(name), modTime: time.Now()}
+	case err != nil:
+		return nil, err
+	}
+
+	file := &s3File{fs: fs, ctx: ctx, key: fs.key(name), info: info.(s3FileInfo)}
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return file, nil
+	}
+
+	// Writes go to a temporary file, which is uploaded on close, since S3
+	// objects can only be replaced as a whole.
+	file.tmp, err = newS3Spool(fs.client.tempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&os.O_TRUNC == 0 && info.Size() > 0 {
+		if err := file.download(); err != nil {
+			file.discard()
+			return nil, err
+		}
+	}
+
+	if flag&os.O_APPEND != 0 {
+		if _, err := file.tmp.Seek(0, io.SeekEnd); err != nil {
+			file.discard()
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+func (fs s3FS) RemoveAll(ctx context.Context, name string) error {
+	if !isRoot(name) {
+		if err := fs.client.deleteObject(ctx, fs.key(name)); err != nil {
+			return err
+		}
+	}
+
+	objects, _, err := fs.client.listObjects(ctx, fs.dirKey(name), "", 0)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := fs.client.deleteObject(ctx, obj.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename copies the objects to their new keys, and then deletes the old ones,
+// since S3 cannot rename objects. Hence, renaming large directories is slow,
+// and not atomic.
+func (fs s3FS) Rename(ctx context.Context, oldName, newName string) error {
+	if isRoot(oldName) || isRoot(newName) {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrPermission}
+	}
+
+	info, err := fs.Stat(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	if err := fs.checkParent(ctx, "rename", newName); err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		if err := fs.client.copyObject(ctx, fs.key(oldName), fs.key(newName)); err != nil {
+			return err
+		}
+		return fs.client.deleteObject(ctx, fs.key(oldName))
+	}
+
+	oldPrefix, newPrefix := fs.dirKey(oldName), fs.dirKey(newName)
+	if strings.HasPrefix(newPrefix, oldPrefix) {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrInvalid}
+	}
+
+	objects, _, err := fs.client.listObjects(ctx, oldPrefix, "", 0)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := fs.client.copyObject(ctx, obj.Key, newPrefix+strings.TrimPrefix(obj.Key, oldPrefix)); err != nil {
+			return err
+		}
+	}
+
+	// Keep the directory, even if it is empty, or implicit.
+	if err := fs.client.putObject(ctx, newPrefix, nil); err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		if err := fs.client.deleteObject(ctx, obj.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs s3FS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if isRoot(name) {
+		return s3FileInfo{name: "/", dir: true}, nil
+	}
+
+	obj, err := fs.client.headObject(ctx, fs.key(name))
+	if err == nil {
+		return s3FileInfo{name: path.Base(name), size: obj.Size, modTime: obj.LastModified, etag: obj.ETag}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// There is no such object, but there might be a directory.
+	objects, prefixes, err := fs.client.listObjects(ctx, fs.dirKey(name), "/", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 && len(prefixes) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+
+	info := s3FileInfo{name: path.Base(name), dir: true}
+	if len(objects) > 0 && objects[0].Key == fs.dirKey(name) {
+		info.modTime = objects[0].LastModified
+	}
+	return info, nil
+}
+
+// s3FileInfo describes a file or a directory of an [s3FS]. It implements
+// [webdav.ETager], so that the ETags are the ones computed by S3.
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	etag    string
+	dir     bool
+}
+
+func (fi s3FileInfo) Name() string       { return fi.name }
+func (fi s3FileInfo) Size() int64        { return fi.size }
+func (fi s3FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi s3FileInfo) IsDir() bool        { return fi.dir }
+func (fi s3FileInfo) Sys() interface{}   { return nil }
+
+func (fi s3FileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (fi s3FileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.etag == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.etag, nil
+}
+
+// s3File is a file of an [s3FS]. Reads are streamed from the object, starting
+// at the current offset. Writes go to a temporary file, which replaces the
+// object on close, unless writing it failed.
+type s3File struct {
+	fs     s3FS
+	ctx    context.Context
+	key    string
+	info   s3FileInfo
+	offset int64
+	body   io.ReadCloser
+	tmp    *s3Spool
+	failed bool
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.tmp != nil {
+		return f.tmp.Read(p)
+	}
+
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		body, err := f.fs.client.getObject(f.ctx, f.key, f.offset)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	if f.tmp != nil {
+		return f.tmp.Seek(offset, whence)
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.key, Err: os.ErrInvalid}
+	}
+
+	// The object is requested again from the new offset, once read.
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3File) Write(p []byte) (int, error) {
+	if f.tmp == nil {
+		return 0, &os.PathError{Op: "write", Path: f.key, Err: os.ErrPermission}
+	}
+
+	n, err := f.tmp.Write(p)
+	if err != nil {
+		f.failed = true
+	}
+	return n, err
+}
+
+// ReadFrom is used by [io.Copy], which allows to notice when reading the
+// request body fails, in which case the object is not replaced.
+func (f *s3File) ReadFrom(r io.Reader) (int64, error) {
+	n, err := copyUpload(f.ctx, f, r)
+	if err != nil {
+		f.failed = true
+	}
+	return n, err
+}
+
+func (f *s3File) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.key, Err: errors.New("not a directory")}
+}
+
+func (f *s3File) Stat() (os.FileInfo, error) {
+	if f.tmp == nil {
+		return f.info, nil
+	}
+
+	// The ETag of the object will be the MD5 of the contents, so that the one
+	// of the uploaded file is known before it is stored.
+	if err := f.tmp.hash(); err != nil {
+		return nil, err
+	}
+	return s3FileInfo{
+		name:    f.info.name,
+		size:    f.tmp.size,
+		modTime: time.Now(),
+		etag:    `"` + hex.EncodeToString(f.tmp.md5.Sum(nil)) + `"`,
+	}, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	if f.tmp == nil {
+		return nil
+	}
+	defer f.discard()
+
+	if f.failed {
+		return errUploadAborted
+	}
+	if _, err := f.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return f.fs.client.putObject(f.ctx, f.key, f.tmp)
+}
+
+// download copies the current contents of the object to the temporary file,
+// so that they can be modified in place.
+func (f *s3File) download() error {
+	body, err := f.fs.client.getObject(f.ctx, f.key, 0)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if _, err := io.Copy(f.tmp, body); err != nil {
+		return err
+	}
+	_, err = f.tmp.Seek(0, io.SeekStart)
+	return err
+}
+
+// discard removes the temporary file.
+func (f *s3File) discard() {
+	if f.tmp != nil {
+		f.tmp.file.Close()
+		os.Remove(f.tmp.file.Name())
+		f.tmp = nil
+	}
+}
+
+// s3Spool is the temporary file the writes of an [s3File] go to. The MD5 and
+// SHA-256 hashes of the contents, for the ETag and the signature of the upload,
+// are computed as they are written, so that the file is only read again if it
+// is not written in order.
+type s3Spool struct {
+	file   *os.File
+	offset int64
+
+	// md5 and sha256 are the hashes of the first size bytes of the file. They
+	// are nil once a write does not extend these bytes.
+	md5, sha256 hash.Hash
+	size        int64
+}
+
+func newS3Spool(dir string) (*s3Spool, error) {
+	file, err := os.CreateTemp(dir, "webdav-s3-")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Spool{file: file, md5: md5.New(), sha256: sha256.New()}, nil
+}
+
+func (s *s3Spool) Read(p []byte) (int, error) {
+	n, err := s.file.Read(p)
+	s.offset += int64(n)
+	return n, err
+}
+
+// ReadAt allows [s3Client.putObject] to upload the file in parts.
+func (s *s3Spool) ReadAt(p []byte, offset int64) (int, error) {
+	return s.file.ReadAt(p, offset)
+}
+
+func (s *s3Spool) Seek(offset int64, whence int) (int64, error) {
+	offset, err := s.file.Seek(offset, whence)
+	if err == nil {
+		s.offset = offset
+	}
+	return offset, err
+}
+
+func (s *s3Spool) Write(p []byte) (int, error) {
+	if s.offset != s.size {
+		s.md5, s.sha256 = nil, nil
+	}
+
+	n, err := s.file.Write(p)
+	if s.md5 != nil {
+		s.md5.Write(p[:n])
+		s.sha256.Write(p[:n])
+		s.size += int64(n)
+	}
+	s.offset += int64(n)
+	return n, err
+}
+
+// hash makes sure that the hashes are the ones of the whole file, reading it
+// again if needed.
+func (s *s3Spool) hash() error {
+	if s.md5 != nil {
+		return nil
+	}
+
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), io.NewSectionReader(s.file, 0, math.MaxInt64))
+	if err != nil {
+		return err
+	}
+	s.md5, s.sha256, s.size = md5Hash, sha256Hash, size
+	return nil
+}
+
+// payloadHash implements [s3HashedBody].
+func (s *s3Spool) payloadHash() (string, error) {
+	if err := s.hash(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(s.sha256.Sum(nil)), nil
+}
+
+// s3Dir is a directory of an [s3FS].
+type s3Dir struct {
+	fs      s3FS
+	ctx     context.Context
+	name    string
+	info    os.FileInfo
+	entries []os.FileInfo
+	listed  bool
+}
+
+func (d *s3Dir) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: d.name, Err: errIsDirectory}
+}
+
+func (d *s3Dir) Seek(offset int64, whence int) (int64, error) {
+	return 0, &os.PathError{Op: "seek", Path: d.name, Err: errIsDirectory}
+}
+
+func (d *s3Dir) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.name, Err: errIsDirectory}
+}
+
+func (d *s3Dir) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *s3Dir) Close() error {
+	return nil
+}
+
+func (d *s3Dir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.listed {
+		prefix := d.fs.dirKey(d.name)
+		objects, prefixes, err := d.fs.client.listObjects(d.ctx, prefix, "/", 0)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range prefixes {
+			d.entries = append(d.entries, s3FileInfo{name: path.Base(p), dir: true})
+		}
+		for _, obj := range objects {
+			if obj.Key == prefix {
+				// The marker of the directory itself.
+				continue
+			}
+			d.entries = append(d.entries, s3FileInfo{
+				name:    path.Base(obj.Key),
+				size:    obj.Size,
+				modTime: obj.LastModified,
+				etag:    obj.ETag,
+			})
+		}
+		d.listed = true
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n := min(count, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startUpload starts a PUT request whose body is only complete once the
+// returned writer is closed, and returns once the request is being served.
+func startUpload(t *testing.T, h http.Handler) (*io.PipeWriter, <-chan int) {
+	pr, pw := io.Pipe()
+	codes := make(chan int, 1)
+	go func() {
+		codes <- doRequest(h, httptest.NewRequest(http.MethodPut, "/upload.txt", pr)).Code
+	}()
+
+	_, err := pw.Write([]byte("partial "))
+	require.NoError(t, err)
+	return pw, codes
+}
+
+func TestHandlerShutdown(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Drain", func(t *testing.T) {
+		t.Parallel()
+
+		h := newTestHandler(t, &Config{Permissions: Permissions{Modify: true}}).(*Handler)
+		pw, codes := startUpload(t, h)
+
+		shutdown := make(chan error, 1)
+		go func() {
+			shutdown <- h.Shutdown(context.Background())
+		}()
+
+		// New requests are rejected, while the upload is still in flight.
+		require.Eventually(t, func() bool {
+			return doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt", nil)).Code == http.StatusServiceUnavailable
+		}, time.Second, time.Millisecond)
+		require.Empty(t, shutdown)
+
+		_, err := pw.Write([]byte("content"))
+		require.NoError(t, err)
+		require.NoError(t, pw.Close())
+
+		require.Equal(t, http.StatusCreated, <-codes)
+		require.NoError(t, <-shutdown)
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		t.Parallel()
+
+		h := newTestHandler(t, &Config{Permissions: Permissions{Modify: true}}).(*Handler)
+		pw, codes := startUpload(t, h)
+		defer func() {
+			require.NoError(t, pw.Close())
+			<-codes
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, h.Shutdown(ctx), context.DeadlineExceeded)
+	})
+}
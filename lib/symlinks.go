@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// containedDir is a [webdav.Dir] that rejects the access to the paths that
+// resolve outside of the directory, once the symlinks are followed. Entries of
+// the directory listings that escape the directory are omitted. This does not
+// guard against the symlinks being changed concurrently.
+type containedDir struct {
+	webdav.Dir
+	root string
+}
+
+func newContainedDir(scope string) containedDir {
+	// The scope itself is allowed to be a symlink.
+	root, err := filepath.EvalSymlinks(scope)
+	if err != nil {
+		root = scope
+	}
+
+	return containedDir{Dir: webdav.Dir(scope), root: root}
+}
+
+// check returns an error if the path, or its nearest existing parent in case
+// it does not exist yet, resolves outside of the directory.
+func (d containedDir) check(name string) error {
+	p := filepath.Join(string(d.Dir), filepath.FromSlash(path.Clean("/"+name)))
+
+	for {
+		resolved, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			if !isWithin(d.root, resolved) {
+				return os.ErrPermission
+			}
+			return nil
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		// A dangling symlink could be used to create a file outside of the
+		// directory, since its target does not exist yet.
+		if _, err := os.Lstat(p); err == nil {
+			return os.ErrPermission
+		}
+
+		parent := filepath.Dir(p)
+		if parent == p {
+			return os.ErrPermission
+		}
+		p = parent
+	}
+}
+
+func isWithin(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (d containedDir) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := d.check(name); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return d.Dir.Mkdir(ctx, name, perm)
+}
+
+func (d containedDir) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if err := d.check(name); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	file, err := d.Dir.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return containedFile{File: file, dir: d, name: name}, nil
+}
+
+func (d containedDir) RemoveAll(ctx context.Context, name string) error {
+	if err := d.check(name); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return d.Dir.RemoveAll(ctx, name)
+}
+
+func (d containedDir) Rename(ctx context.Context, oldName, newName string) error {
+	for _, name := range []string{oldName, newName} {
+		if err := d.check(name); err != nil {
+			return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+		}
+	}
+	return d.Dir.Rename(ctx, oldName, newName)
+}
+
+func (d containedDir) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if err := d.check(name); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return d.Dir.Stat(ctx, name)
+}
+
+type containedFile struct {
+	webdav.File
+	dir  containedDir
+	name string
+}
+
+func (f containedFile) Readdir(count int) ([]os.FileInfo, error) {
+	fis, err := f.File.Readdir(count)
+	if err != nil {
+		return nil, err
+	}
+
+	contained := fis[:0]
+	for _, fi := range fis {
+		if fi.Mode()&os.ModeSymlink != 0 && f.dir.check(path.Join(f.name, fi.Name())) != nil {
+			continue
+		}
+		contained = append(contained, fi)
+	}
+	return contained, nil
+}
@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newSymlinksTestHandler creates a handler whose scope contains symlinks
+// pointing inside and outside of it.
+func newSymlinksTestHandler(t *testing.T, strict bool) (http.Handler, string) {
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0666))
+
+	cfg := &Config{
+		Permissions: Permissions{Modify: true},
+		StrictScope: strict,
+	}
+	h := newTestHandler(t, cfg)
+
+	for link, target := range map[string]string{
+		"outside":     outside,
+		"outside.txt": filepath.Join(outside, "secret.txt"),
+		"dangling":    filepath.Join(outside, "created.txt"),
+		"inside.txt":  filepath.Join(cfg.Scope, "file.txt"),
+		"inside":      filepath.Join(cfg.Scope, "dir"),
+	} {
+		require.NoError(t, os.Symlink(target, filepath.Join(cfg.Scope, link)))
+	}
+
+	return h, outside
+}
+
+func TestStrictScope(t *testing.T) {
+	t.Parallel()
+
+	h, outside := newSymlinksTestHandler(t, true)
+
+	t.Run("Inside", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/inside.txt", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "content", w.Body.String())
+
+		w = doRequest(h, httptest.NewRequest("PROPFIND", "/inside/", nil))
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+	})
+
+	t.Run("Outside", func(t *testing.T) {
+		t.Parallel()
+
+		for _, path := range []string{"/outside.txt", "/outside/secret.txt"} {
+			w := doRequest(h, httptest.NewRequest(http.MethodGet, path, nil))
+			require.Equal(t, http.StatusNotFound, w.Code, path)
+			require.NotContains(t, w.Body.String(), "secret")
+		}
+
+		for _, path := range []string{"/dir/../outside/secret.txt", "/../outside.txt"} {
+			w := doRequest(h, httptest.NewRequest(http.MethodGet, path, nil))
+			require.Equal(t, http.StatusBadRequest, w.Code, path)
+			require.NotContains(t, w.Body.String(), "secret")
+		}
+
+		w := doRequest(h, httptest.NewRequest("PROPFIND", "/outside/", nil))
+		require.NotEqual(t, http.StatusMultiStatus, w.Code)
+	})
+
+	t.Run("Write Outside", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest(http.MethodPut, "/outside/new.txt", strings.NewReader("new")))
+		require.GreaterOrEqual(t, w.Code, 400)
+		require.NoFileExists(t, filepath.Join(outside, "new.txt"))
+
+		w = doRequest(h, httptest.NewRequest(http.MethodPut, "/dangling", strings.NewReader("new")))
+		require.GreaterOrEqual(t, w.Code, 400)
+		require.NoFileExists(t, filepath.Join(outside, "created.txt"))
+
+		w = doRequest(h, httptest.NewRequest("MKCOL", "/outside/folder", nil))
+		require.GreaterOrEqual(t, w.Code, 400)
+		require.NoDirExists(t, filepath.Join(outside, "folder"))
+
+		r := httptest.NewRequest("COPY", "/file.txt", nil)
+		r.Header.Set("Destination", "/outside/copy.txt")
+		require.GreaterOrEqual(t, doRequest(h, r).Code, 400)
+		require.NoFileExists(t, filepath.Join(outside, "copy.txt"))
+
+		w = doRequest(h, httptest.NewRequest(http.MethodDelete, "/outside/secret.txt", nil))
+		require.GreaterOrEqual(t, w.Code, 400)
+		require.FileExists(t, filepath.Join(outside, "secret.txt"))
+	})
+
+	t.Run("Listing", func(t *testing.T) {
+		t.Parallel()
+
+		r := httptest.NewRequest("PROPFIND", "/", nil)
+		r.Header.Set("Depth", "1")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+
+		body := w.Body.String()
+		require.Contains(t, body, "/inside.txt")
+		require.NotContains(t, body, "/outside")
+		require.NotContains(t, body, "/dangling")
+	})
+}
+
+func TestStrictScopeDisabled(t *testing.T) {
+	t.Parallel()
+
+	h, _ := newSymlinksTestHandler(t, false)
+
+	w := doRequest(h, httptest.NewRequest(http.MethodGet, "/outside.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "secret", w.Body.String())
+}
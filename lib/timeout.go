@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var errRequestTimeout = errors.New("request timed out")
+
+// idleTimeout aborts a request once nothing has been read from, or written to,
+// the client for a certain time. Unlike a deadline for the whole request, it
+// does not abort large transfers, as long as they keep progressing.
+type idleTimeout struct {
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+// withIdleTimeout wraps the request and the response writer, so that the
+// request is aborted after the timeout of inactivity: its context is cancelled,
+// the pending reads and writes fail, and the client gets a 408 Request Timeout
+// if nothing was sent yet. The returned function must be called after the
+// request has been served.
+func withIdleTimeout(w http.ResponseWriter, r *http.Request, timeout time.Duration) (http.ResponseWriter, *http.Request, func()) {
+	ctx, cancel := context.WithCancel(r.Context())
+	rc := http.NewResponseController(w)
+
+	t := &idleTimeout{timeout: timeout}
+	t.timer = time.AfterFunc(timeout, func() {
+		t.expired.Store(true)
+		cancel()
+
+		// Unblock the reads and writes that are waiting for the client. This is
+		// not supported by all response writers, in which case only the context
+		// is cancelled.
+		now := time.Now()
+		_ = rc.SetReadDeadline(now)
+		_ = rc.SetWriteDeadline(now)
+	})
+
+	r = r.WithContext(ctx)
+	r.Body = idleBody{ReadCloser: r.Body, timeout: t}
+
+	return &idleResponseWriter{ResponseWriter: w, timeout: t}, r, func() {
+		t.timer.Stop()
+		cancel()
+	}
+}
+
+// reset postpones the timeout, since the request made progress.
+func (t *idleTimeout) reset() {
+	if !t.expired.Load() {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+type idleBody struct {
+	io.ReadCloser
+	timeout *idleTimeout
+}
+
+func (b idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timeout.reset()
+	}
+	if err != nil && err != io.EOF && b.timeout.expired.Load() {
+		err = errRequestTimeout
+	}
+	return n, err
+}
+
+// idleResponseWriter replaces the status written by the WebDAV handler if the
+// request timed out before the response was started, in the same way as
+// [limitedResponseWriter].
+type idleResponseWriter struct {
+	http.ResponseWriter
+	timeout     *idleTimeout
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (w *idleResponseWriter) WriteHeader(status int) {
+	if w.timeout.expired.Load() && !w.wroteHeader {
+		status = http.StatusRequestTimeout
+		w.timedOut = true
+		w.Header().Set("Connection", "close")
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *idleResponseWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.timedOut {
+		// Discard the status text written by the WebDAV handler.
+		return len(data), nil
+	}
+	if w.timeout.expired.Load() {
+		return 0, errRequestTimeout
+	}
+
+	n, err := w.ResponseWriter.Write(data)
+	if n > 0 {
+		w.timeout.reset()
+	}
+	return n, err
+}
+
+func (w *idleResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
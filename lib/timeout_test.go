@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// statusWriter records the status sent by the handler to the client.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestRequestTimeout(t *testing.T) {
+	t.Parallel()
+
+	newServer := func(t *testing.T) (*httptest.Server, string, chan int) {
+		cfg := &Config{
+			Permissions:    Permissions{Modify: true},
+			RequestTimeout: 200 * time.Millisecond,
+		}
+		h := newTestHandler(t, cfg)
+
+		statuses := make(chan int, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &statusWriter{ResponseWriter: w}
+			h.ServeHTTP(sw, r)
+			statuses <- sw.status
+		}))
+		t.Cleanup(server.Close)
+		return server, cfg.Scope, statuses
+	}
+
+	upload := func(server *httptest.Server, body io.Reader) {
+		r, err := http.NewRequest(http.MethodPut, server.URL+"/upload.txt", body)
+		if err != nil {
+			return
+		}
+		res, err := server.Client().Do(r)
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+
+	t.Run("Stalled Upload", func(t *testing.T) {
+		t.Parallel()
+
+		server, scope, statuses := newServer(t)
+
+		// The client sends a part of the body, and then stalls.
+		pr, pw := io.Pipe()
+		t.Cleanup(func() { pw.Close() })
+		go upload(server, pr)
+		_, err := pw.Write([]byte("partial"))
+		require.NoError(t, err)
+
+		select {
+		case status := <-statuses:
+			require.Equal(t, http.StatusRequestTimeout, status)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "the stalled request was not aborted")
+		}
+
+		require.NoFileExists(t, filepath.Join(scope, "upload.txt"))
+	})
+
+	t.Run("Slow Upload", func(t *testing.T) {
+		t.Parallel()
+
+		server, scope, statuses := newServer(t)
+
+		// The client is slow, but keeps sending the body, so that the whole
+		// upload takes longer than the timeout.
+		pr, pw := io.Pipe()
+		go func() {
+			for i := 0; i < 6; i++ {
+				if _, err := pw.Write([]byte("data")); err != nil {
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			pw.Close()
+		}()
+		upload(server, pr)
+
+		require.Equal(t, http.StatusCreated, <-statuses)
+		data, err := os.ReadFile(filepath.Join(scope, "upload.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "datadatadatadatadatadata", string(data))
+	})
+}
+
+func TestConfigRequestTimeout(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, "request_timeout: 30s", ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, 30*time.Second, cfg.RequestTimeout)
+
+	cfg = &Config{RequestTimeout: -time.Second}
+	require.Error(t, cfg.Validate())
+}
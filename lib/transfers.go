@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// Transfer is the amount of bytes transferred by a user, since the handler was
+// created. Reloading the configuration does not reset it.
+type Transfer struct {
+	BytesIn  int64
+	BytesOut int64
+}
+
+// Transfers returns the amount of bytes transferred by each user, keyed by
+// username. The default user, which also serves the anonymous requests, has
+// an empty username.
+func (h *Handler) Transfers() map[string]Transfer {
+	h.mu.RLock()
+	handler := h.handler
+	h.mu.RUnlock()
+
+	transfers := map[string]Transfer{"": handler.user.transfers.get()}
+	for username, user := range handler.users {
+		transfers[username] = user.transfers.get()
+	}
+	return transfers
+}
+
+// transferStats counts the bytes of the request bodies read, and the bytes of
+// the responses written, for a user.
+type transferStats struct {
+	in  atomic.Int64
+	out atomic.Int64
+}
+
+func (s *transferStats) get() Transfer {
+	return Transfer{BytesIn: s.in.Load(), BytesOut: s.out.Load()}
+}
+
+// transferReader adds the bytes read from the body to a total.
+type transferReader struct {
+	io.ReadCloser
+	total *atomic.Int64
+}
+
+func (r transferReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.total.Add(int64(n))
+	return n, err
+}
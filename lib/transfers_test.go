@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerTransfers(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Auth: true,
+		Users: []User{
+			{Username: "alice", Password: "alice", Permissions: Permissions{Modify: true}},
+			{Username: "bob", Password: "bob"},
+		},
+	}
+	h := newTestHandler(t, cfg).(*Handler)
+
+	request := func(method, path, username, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.SetBasicAuth(username, username)
+		return doRequest(h, r)
+	}
+
+	w := request(http.MethodGet, "/file.txt", "alice", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "content", w.Body.String())
+	sent := int64(w.Body.Len())
+
+	w = request(http.MethodPut, "/new.txt", "alice", "new content")
+	require.Equal(t, http.StatusCreated, w.Code)
+	sent += int64(w.Body.Len())
+
+	// HEAD requests send no body, so nothing is transferred.
+	w = request(http.MethodHead, "/file.txt", "bob", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Body.String())
+	require.Equal(t, "7", w.Header().Get("Content-Length"))
+
+	transfers := h.Transfers()
+	require.Equal(t, Transfer{BytesIn: int64(len("new content")), BytesOut: sent}, transfers["alice"])
+	require.Equal(t, Transfer{}, transfers["bob"])
+
+	// The totals are kept when the configuration is reloaded.
+	reloaded := *cfg
+	reloaded.Users = []User{
+		{Username: "alice", Password: "alice", Permissions: Permissions{Scope: cfg.Scope}},
+	}
+	require.NoError(t, reloaded.Validate())
+	require.NoError(t, h.Reload(&reloaded))
+	w = request(http.MethodGet, "/new.txt", "alice", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "new content", w.Body.String())
+	require.Equal(t, Transfer{BytesIn: int64(len("new content")), BytesOut: sent + int64(w.Body.Len())}, h.Transfers()["alice"])
+}
+
+func TestRecordingResponseWriterNoBody(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	w := &recordingResponseWriter{ResponseWriter: rec, noBody: true}
+
+	n, err := w.Write([]byte("content"))
+	require.NoError(t, err)
+	require.Equal(t, 7, n)
+	require.EqualValues(t, 7, w.bytes)
+	require.Equal(t, http.StatusOK, w.Status())
+	require.Empty(t, rec.Body.String())
+}
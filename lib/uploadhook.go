@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"golang.org/x/net/webdav"
+)
+
+// UploadHook checks the files uploaded by PUT requests before they are made
+// available, for example to scan them for viruses. The name is the path of the
+// file within the scope of the user. If an error is returned, the upload is
+// discarded, and the request fails with 422 Unprocessable Entity.
+type UploadHook interface {
+	CheckUpload(ctx context.Context, user *User, name string, contents io.Reader) error
+}
+
+var errUploadRejected = errors.New("upload rejected")
+
+type uploadCheckKey struct{}
+
+// uploadCheck carries the hook and the digests of the body through the context
+// of a PUT request, down to the file system, which runs it once the upload is
+// written. Either of them may be nil.
+type uploadCheck struct {
+	hook   UploadHook
+	digest *bodyDigest
+	user   *User
+	name   string
+	err    error
+}
+
+func withUploadCheck(r *http.Request, hook UploadHook, digest *bodyDigest, user *User, name string) (*http.Request, *uploadCheck) {
+	check := &uploadCheck{hook: hook, digest: digest, user: user, name: name}
+	return r.WithContext(context.WithValue(r.Context(), uploadCheckKey{}, check)), check
+}
+
+func uploadCheckFrom(ctx context.Context) *uploadCheck {
+	check, _ := ctx.Value(uploadCheckKey{}).(*uploadCheck)
+	return check
+}
+
+// run verifies the digests of the body, and runs the hook on the contents of the
+// given file, which is where the upload was written to.
+func (c *uploadCheck) run(ctx context.Context, fs webdav.FileSystem, file string) error {
+	if c.digest != nil {
+		if err := c.digest.verify(); err != nil {
+			c.err = err
+			return errUploadRejected
+		}
+	}
+	if c.hook == nil {
+		return nil
+	}
+
+	f, err := fs.OpenFile(ctx, file, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := c.hook.CheckUpload(ctx, c.user, c.name, f); err != nil {
+		c.err = err
+		return errUploadRejected
+	}
+	return nil
+}
+
+// status returns the status of the response to a rejected upload.
+func (c *uploadCheck) status() int {
+	if errors.Is(c.err, errDigestMismatch) {
+		return http.StatusBadRequest
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// checkedUpload runs the hook once the upload is complete, with the file
+// systems whose uploads are atomic on their own, which offer no temporary file
+// to check beforehand. If rejected, the file is removed.
+type checkedUpload struct {
+	webdav.File
+	ctx   context.Context
+	fs    webdav.FileSystem
+	name  string
+	check *uploadCheck
+}
+
+func (f *checkedUpload) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+
+	if err := f.check.run(f.ctx, f.fs, f.name); err != nil {
+		_ = f.fs.RemoveAll(context.Background(), f.name)
+		return err
+	}
+	return nil
+}
+
+// rejectedUploadResponseWriter replaces the status written by the WebDAV
+// handler if the upload was rejected by the hook, or because its digest did not
+// match. Otherwise, the WebDAV handler would reply with 405 Method Not Allowed.
+type rejectedUploadResponseWriter struct {
+	http.ResponseWriter
+	check       *uploadCheck
+	wroteHeader bool
+}
+
+func (w *rejectedUploadResponseWriter) WriteHeader(status int) {
+	if w.check.err != nil && !w.wroteHeader {
+		status = w.check.status()
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *rejectedUploadResponseWriter) Write(data []byte) (int, error) {
+	if w.check.err != nil {
+		if !w.wroteHeader {
+			w.WriteHeader(w.check.status())
+		}
+		// Discard the status text written by the WebDAV handler.
+		return len(data), nil
+	}
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(data)
+}
@@ -0,0 +1,91 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// scanHook rejects the uploads containing a signature, and records the uploads
+// it checked.
+type scanHook struct {
+	checked []string
+}
+
+func (h *scanHook) CheckUpload(ctx context.Context, user *User, name string, contents io.Reader) error {
+	data, err := io.ReadAll(contents)
+	if err != nil {
+		return err
+	}
+
+	h.checked = append(h.checked, user.Username+":"+name)
+	if strings.Contains(string(data), "EICAR") {
+		return errors.New("virus found")
+	}
+	return nil
+}
+
+func TestUploadHook(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T) (http.Handler, *scanHook, string) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0666))
+
+		hook := &scanHook{}
+		h := newTestHandler(t, &Config{
+			Auth:       true,
+			UploadHook: hook,
+			Users:      []User{{Username: "admin", Password: "admin", Permissions: Permissions{Scope: dir, Modify: true}}},
+		})
+		return h, hook, dir
+	}
+
+	put := func(h http.Handler, name, content string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, name, strings.NewReader(content))
+		r.SetBasicAuth("admin", "admin")
+		return doRequest(h, r)
+	}
+
+	t.Run("Accepted", func(t *testing.T) {
+		t.Parallel()
+
+		h, hook, dir := newHandler(t)
+		require.Equal(t, http.StatusCreated, put(h, "/new.txt", "clean").Code)
+		require.Equal(t, []string{"admin:/new.txt"}, hook.checked)
+
+		data, err := os.ReadFile(filepath.Join(dir, "new.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "clean", string(data))
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		t.Parallel()
+
+		h, hook, dir := newHandler(t)
+		w := put(h, "/new.txt", "EICAR")
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		require.Empty(t, w.Body.String())
+		require.NoFileExists(t, filepath.Join(dir, "new.txt"))
+
+		// The file being overwritten is left untouched.
+		require.Equal(t, http.StatusUnprocessableEntity, put(h, "/file.txt", "EICAR").Code)
+		data, err := os.ReadFile(filepath.Join(dir, "file.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "content", string(data))
+		require.Equal(t, []string{"admin:/new.txt", "admin:/file.txt"}, hook.checked)
+
+		// No temporary file is left behind.
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+	})
+}
@@ -4,33 +4,89 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	Permissions `mapstructure:",squash"`
-	Username    string
-	Password    string
+	Permissions   `mapstructure:",squash"`
+	Username      string
+	Password      string
+	RateLimit     int64 `mapstructure:"rate_limit"`
+	MaxUploadSize int64 `mapstructure:"max_upload_size"`
+	Quota         int64
+	MaxFiles      int64 `mapstructure:"max_files"`
+	Admin         bool
+	Owner         string
+
+	// owner is parsed from Owner, and owns the files created by the user if
+	// chown is enabled.
+	owner *owner
+
+	// home is set if the scope was expanded from a template, in which case the
+	// directory is created if missing.
+	home bool
+}
+
+// bcryptPrefixes are the identifiers at the start of a bcrypt hash, as produced
+// by the different bcrypt implementations (e.g. Go, PHP, htpasswd).
+var bcryptPrefixes = []string{"$2a$", "$2b$", "$2x$", "$2y$"}
+
+func isBcryptHash(password string) bool {
+	for _, prefix := range bcryptPrefixes {
+		if strings.HasPrefix(password, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasHashedPassword reports whether the password is stored as a hash, in which
+// case the plaintext password cannot be recovered.
+func (u User) hasHashedPassword() bool {
+	return strings.HasPrefix(u.Password, "{bcrypt}") || isHtpasswdHash(u.Password)
 }
 
 func (u User) checkPassword(input string) bool {
-	if strings.HasPrefix(u.Password, "{bcrypt}") {
-		savedPassword := strings.TrimPrefix(u.Password, "{bcrypt}")
+	// Passwords can either be explicitly marked as bcrypt with the "{bcrypt}"
+	// prefix, or be a raw bcrypt hash. Anything else is compared as plaintext.
+	savedPassword, explicit := strings.CutPrefix(u.Password, "{bcrypt}")
+	if explicit || isBcryptHash(savedPassword) {
 		return bcrypt.CompareHashAndPassword([]byte(savedPassword), []byte(input)) == nil
 	}
 
-	return u.Password == input
+	// Other hash formats that can be found in htpasswd files.
+	if isMD5CryptHash(savedPassword) {
+		return checkMD5Crypt(savedPassword, input)
+	}
+
+	if isSHA1Hash(savedPassword) {
+		return checkSHA1(savedPassword, input)
+	}
+
+	return savedPassword == input
 }
 
 func (u *User) Validate() error {
+	return u.validate(true)
+}
+
+func (u *User) validate(requirePassword bool) error {
 	if u.Username == "" {
 		return errors.New("invalid user: username must be set")
 	}
 
+	if err := u.expandScope(); err != nil {
+		return err
+	}
+
 	if u.Password == "" {
-		return fmt.Errorf("invalid user %q: password must be set", u.Username)
+		if requirePassword {
+			return fmt.Errorf("invalid user %q: password must be set", u.Username)
+		}
 	} else if strings.HasPrefix(u.Password, "{env}") {
 
 		env := strings.TrimPrefix(u.Password, "{env}")
@@ -44,9 +100,72 @@ func (u *User) Validate() error {
 		}
 	}
 
+	if u.RateLimit < 0 {
+		return fmt.Errorf("invalid user %q: rate limit cannot be negative", u.Username)
+	}
+
+	if u.MaxUploadSize < 0 {
+		return fmt.Errorf("invalid user %q: max upload size cannot be negative", u.Username)
+	}
+
+	if u.Quota < 0 {
+		return fmt.Errorf("invalid user %q: quota cannot be negative", u.Username)
+	}
+
+	if u.MaxFiles < 0 {
+		return fmt.Errorf("invalid user %q: max files cannot be negative", u.Username)
+	}
+
+	o, err := parseOwner(u.Owner)
+	if err != nil {
+		return fmt.Errorf("invalid user %q: %w", u.Username, err)
+	}
+	u.owner = o
+
+	u.Permissions.expandUser(u.Username)
 	if err := u.Permissions.Validate(); err != nil {
 		return fmt.Errorf("invalid user %q: %w", u.Username, err)
 	}
 
 	return nil
 }
+
+// scopeRoot returns the directory a scope template expands within, which is the
+// one before the user placeholder.
+func scopeRoot(scope string) string {
+	prefix, _, _ := strings.Cut(scope, userPlaceholder)
+	return filepath.Dir(prefix + "x")
+}
+
+// expandScope replaces the user placeholder in the scope by the username. The
+// scope must stay within the root of the template, so that the usernames cannot
+// point it anywhere else, or to the root itself.
+func (u *User) expandScope() error {
+	if !strings.Contains(u.Scope, userPlaceholder) {
+		return nil
+	}
+
+	root := scopeRoot(u.Scope)
+	scope := filepath.Clean(strings.ReplaceAll(u.Scope, userPlaceholder, u.Username))
+	rel, err := filepath.Rel(root, scope)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("invalid user %q: scope %q is not within %q", u.Username, scope, root)
+	}
+
+	u.Scope = scope
+	u.home = true
+	return nil
+}
+
+// createHome creates the directory of a user whose scope was expanded from a
+// template, if it is missing.
+func (u User) createHome(backend string) error {
+	if !u.home || backend != BackendDisk {
+		return nil
+	}
+
+	if err := os.MkdirAll(u.Scope, 0700); err != nil {
+		return fmt.Errorf("failed to create scope of user %q: %w", u.Username, err)
+	}
+	return nil
+}
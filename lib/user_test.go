@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUserCheckPassword(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	t.Run("Bcrypt", func(t *testing.T) {
+		t.Parallel()
+
+		u := User{Username: "admin", Password: string(hash)}
+		require.True(t, u.checkPassword("secret"))
+		require.False(t, u.checkPassword("wrong"))
+		require.False(t, u.checkPassword(string(hash)))
+	})
+
+	t.Run("Bcrypt Prefixed", func(t *testing.T) {
+		t.Parallel()
+
+		u := User{Username: "admin", Password: "{bcrypt}" + string(hash)}
+		require.True(t, u.checkPassword("secret"))
+		require.False(t, u.checkPassword("wrong"))
+	})
+
+	t.Run("Bcrypt Variants", func(t *testing.T) {
+		t.Parallel()
+
+		for _, prefix := range []string{"$2b$", "$2y$"} {
+			u := User{Username: "admin", Password: prefix + string(hash[4:])}
+			require.True(t, u.checkPassword("secret"), prefix)
+		}
+	})
+
+	t.Run("Plaintext", func(t *testing.T) {
+		t.Parallel()
+
+		u := User{Username: "admin", Password: "secret"}
+		require.True(t, u.checkPassword("secret"))
+		require.False(t, u.checkPassword("wrong"))
+		require.False(t, u.checkPassword(""))
+	})
+}
+
+func TestUserScopeTemplate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Homes", func(t *testing.T) {
+		t.Parallel()
+
+		root := t.TempDir()
+		template := filepath.Join(root, "homes", userPlaceholder)
+		h := newTestHandler(t, &Config{
+			Auth:        true,
+			Permissions: Permissions{Scope: template},
+			Users: []User{
+				{Username: "alice", Password: "alice", Permissions: Permissions{Scope: template, Modify: true}},
+				{Username: "bob", Password: "bob", Permissions: Permissions{Scope: template, Modify: true}},
+			},
+		})
+
+		// The homes are created along with the handler.
+		require.DirExists(t, filepath.Join(root, "homes", "alice"))
+		require.DirExists(t, filepath.Join(root, "homes", "bob"))
+
+		request := func(method, target, username string) int {
+			r := httptest.NewRequest(method, target, strings.NewReader("alice's"))
+			r.SetBasicAuth(username, username)
+			return doRequest(h, r).Code
+		}
+
+		require.Equal(t, http.StatusCreated, request(http.MethodPut, "/notes.txt", "alice"))
+		require.Equal(t, http.StatusOK, request(http.MethodGet, "/notes.txt", "alice"))
+		require.Equal(t, http.StatusNotFound, request(http.MethodGet, "/notes.txt", "bob"))
+		require.FileExists(t, filepath.Join(root, "homes", "alice", "notes.txt"))
+		require.NoFileExists(t, filepath.Join(root, "homes", "bob", "notes.txt"))
+	})
+
+	t.Run("Outside Root", func(t *testing.T) {
+		t.Parallel()
+
+		for _, username := range []string{"..", "../other", "."} {
+			u := User{Username: username, Password: "secret", Permissions: Permissions{Scope: "/data/" + userPlaceholder}}
+			require.ErrorContains(t, u.Validate(), "is not within", username)
+		}
+
+		u := User{Username: "alice", Password: "secret", Permissions: Permissions{Scope: "/data/home-" + userPlaceholder + "/files"}}
+		require.NoError(t, u.Validate())
+		require.Equal(t, "/data/home-alice/files", u.Scope)
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{Permissions: Permissions{Scope: "/data/" + userPlaceholder}}
+		require.ErrorContains(t, cfg.Validate(), "requires authentication")
+	})
+}
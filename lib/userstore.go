@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// UserStore looks up the users that are not configured, for example in a
+// database, so that they do not all have to be kept in memory. It returns false
+// if there is no such user.
+//
+// The users are complete, with their scope and permissions, and are validated
+// as the configured ones. They are cached by the handler. The lookups must be
+// safe for concurrent use.
+type UserStore interface {
+	Lookup(username string) (*User, bool)
+}
+
+// lookupUser returns the user with the given username. The configured users
+// take precedence over the ones of the store.
+func (h *handler) lookupUser(username string) (*handlerUser, bool) {
+	if user, ok := h.users[username]; ok {
+		return user, true
+	}
+	if h.userCache == nil {
+		return nil, false
+	}
+
+	user, err := h.userCache.user(time.Now(), username)
+	if err != nil {
+		h.logger.Error("invalid user in the user store", zap.String("username", username), zap.Error(err))
+		return nil, false
+	}
+	return user, user != nil
+}
+
+// userCache keeps the most recently used users of the store, so that their
+// locks, usage and transfers are shared by their requests, and the store is
+// only queried once in a while. The users evicted from the cache lose the locks
+// that are not kept in the locks file.
+type userCache struct {
+	store           UserStore
+	size            int
+	ttl             time.Duration
+	backend         string
+	requirePassword bool
+	newUser         func(User) *handlerUser
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type userCacheEntry struct {
+	user    *handlerUser
+	expires time.Time
+}
+
+func newUserCache(c *Config, newUser func(User) *handlerUser) *userCache {
+	return &userCache{
+		store:           c.UserStore,
+		size:            c.UserCache.Size,
+		ttl:             c.UserCache.TTL,
+		backend:         c.Backend,
+		requirePassword: c.requiresPasswords(),
+		newUser:         newUser,
+		entries:         map[string]*list.Element{},
+		lru:             list.New(),
+	}
+}
+
+// user returns the user with the given username, from the cache if it has not
+// expired, or else from the store. It returns nil if there is no such user.
+func (c *userCache) user(now time.Time, username string) (*handlerUser, error) {
+	c.mu.Lock()
+	var expired *handlerUser
+	if elem, ok := c.entries[username]; ok {
+		entry := elem.Value.(*userCacheEntry)
+		if now.Before(entry.expires) {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			return entry.user, nil
+		}
+		expired = entry.user
+		c.lru.Remove(elem)
+		delete(c.entries, username)
+	}
+	c.mu.Unlock()
+
+	// The store is not queried with the lock held, as it may be slow.
+	stored, ok := c.store.Lookup(username)
+	if !ok || stored == nil {
+		return nil, nil
+	}
+
+	u := *stored
+	u.Username = username
+	if err := u.validate(c.requirePassword); err != nil {
+		return nil, err
+	}
+	if err := u.createHome(c.backend); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another request may have looked the user up in the meantime.
+	if elem, ok := c.entries[username]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*userCacheEntry).user, nil
+	}
+
+	// The user looked up again keeps its locks, usage and transfers.
+	user := c.newUser(u)
+	if expired != nil {
+		user.transfers = expired.transfers
+		if expired.Scope == u.Scope {
+			user.LockSystem = expired.LockSystem
+			user.usage = expired.usage
+		}
+	}
+
+	c.entries[username] = c.lru.PushFront(&userCacheEntry{user: user, expires: now.Add(c.ttl)})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*userCacheEntry).user.Username)
+	}
+	return user, nil
+}
@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// mapStore is a user store that counts the lookups of each user.
+type mapStore struct {
+	users   map[string]User
+	mu      sync.Mutex
+	lookups map[string]int
+}
+
+func (s *mapStore) Lookup(username string) (*User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lookups[username]++
+	u, ok := s.users[username]
+	return &u, ok
+}
+
+func (s *mapStore) count(username string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookups[username]
+}
+
+func TestHandlerUserStore(t *testing.T) {
+	t.Parallel()
+
+	store := &mapStore{lookups: map[string]int{}}
+	cfg := &Config{
+		Auth:      true,
+		Users:     []User{{Username: "alice", Password: "alice", Permissions: Permissions{Scope: t.TempDir()}}},
+		UserStore: store,
+	}
+	h := newTestHandler(t, cfg)
+	store.users = map[string]User{
+		"bob":   {Password: "bob", Permissions: Permissions{Scope: cfg.Scope, Modify: true}},
+		"carol": {Password: "carol", Permissions: Permissions{Scope: cfg.Scope}},
+	}
+
+	do := func(method, username, password string) int {
+		r := httptest.NewRequest(method, "/file.txt", nil)
+		r.SetBasicAuth(username, password)
+		return doRequest(h, r).Code
+	}
+
+	// The users of the store get their own permissions.
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "bob", "bob"))
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "bob", "bob"))
+	require.Equal(t, http.StatusForbidden, do(http.MethodDelete, "carol", "carol"))
+	require.Equal(t, 1, store.count("bob"))
+
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "carol", "wrong"))
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "dave", "dave"))
+
+	// The configured users take precedence.
+	require.Equal(t, http.StatusNotFound, do(http.MethodGet, "alice", "alice"))
+	require.Zero(t, store.count("alice"))
+}
+
+func TestUserCache(t *testing.T) {
+	t.Parallel()
+
+	store := &mapStore{
+		users: map[string]User{
+			"alice": {Password: "alice", Permissions: Permissions{Scope: "/data"}},
+			"bob":   {Password: "bob", Permissions: Permissions{Scope: "/data"}},
+			"carol": {Password: "carol", Permissions: Permissions{Scope: "/data"}},
+			"dave":  {Permissions: Permissions{Scope: "/data"}},
+		},
+		lookups: map[string]int{},
+	}
+	users := 0
+	c := newUserCache(&Config{
+		UserStore:  store,
+		UserCache:  UserCache{Size: 2, TTL: time.Minute},
+		AuthMethod: AuthMethodBasic,
+	}, func(u User) *handlerUser {
+		users++
+		return &handlerUser{User: u, usage: &usageCache{}, transfers: &transferStats{}}
+	})
+
+	now := time.Now()
+	lookup := func(username string, now time.Time) *handlerUser {
+		user, err := c.user(now, username)
+		require.NoError(t, err)
+		return user
+	}
+
+	// The least recently used user is evicted first.
+	alice := lookup("alice", now)
+	require.Equal(t, "alice", alice.Username)
+	lookup("bob", now)
+	require.Same(t, alice, lookup("alice", now))
+	lookup("carol", now)
+	require.Same(t, alice, lookup("alice", now))
+	require.Equal(t, 1, store.count("alice"))
+
+	lookup("bob", now)
+	require.Equal(t, 2, store.count("bob"))
+
+	// The expired users are looked up again, and keep their state.
+	later := lookup("bob", now.Add(time.Minute))
+	require.Equal(t, 3, store.count("bob"))
+	require.Equal(t, 5, users)
+	require.Same(t, lookup("bob", now).usage, later.usage)
+
+	// The missing and invalid users are not found.
+	require.Nil(t, lookup("eve", now))
+	_, err := c.user(now, "dave")
+	require.ErrorContains(t, err, "password must be set")
+}
+
+func TestConfigUserCache(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, ``, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, DefaultUserCacheSize, cfg.UserCache.Size)
+	require.Equal(t, DefaultUserCacheTTL, cfg.UserCache.TTL)
+
+	cfg = &Config{UserCache: UserCache{Size: -1}}
+	require.ErrorContains(t, cfg.Validate(), "cannot be negative")
+}
@@ -0,0 +1,170 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// versionsDir is the directory the previous versions of the files are kept in,
+// next to the files themselves, with one subdirectory per file.
+const versionsDir = ".versions"
+
+// versionLayout is the layout of the names of the versions, which sort in the
+// order they were made.
+const versionLayout = "20060102T150405.000000000Z"
+
+// inVersions reports whether the name is within a versions directory, whose
+// files are not versioned themselves.
+func inVersions(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if segment == versionsDir {
+			return true
+		}
+	}
+	return false
+}
+
+// keepVersion copies the file with the given name, if it exists, to its
+// versions directory, before it is overwritten. The versions beyond the
+// retention are then removed.
+func keepVersion(ctx context.Context, fs webdav.FileSystem, c Versioning, name string, now time.Time) error {
+	version, err := copyToVersions(ctx, fs, name, now)
+	if err != nil || version == "" {
+		return err
+	}
+	return pruneVersions(ctx, fs, c, path.Dir(version), now)
+}
+
+// copyToVersions copies the file with the given name, if it exists, to its
+// versions directory, and returns the name of the version.
+func copyToVersions(ctx context.Context, fs webdav.FileSystem, name string, now time.Time) (string, error) {
+	info, err := fs.Stat(ctx, name)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", nil
+	}
+
+	dir := path.Join(path.Dir(name), versionsDir, path.Base(name))
+	for _, d := range []string{path.Dir(dir), dir} {
+		if err := fs.Mkdir(ctx, d, 0777); err != nil && !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+	}
+
+	version := path.Join(dir, now.UTC().Format(versionLayout))
+	if err := copyVersion(ctx, fs, name, version, info.Mode().Perm()); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// versionedUpload keeps the previous version of the file on close, with the
+// file systems whose uploads are atomic on their own, which replace the file
+// only then. The version is dropped if the upload fails.
+type versionedUpload struct {
+	webdav.File
+	ctx        context.Context
+	fs         webdav.FileSystem
+	name       string
+	versioning Versioning
+}
+
+// ReadFrom lets the wrapped file notice when reading the request body fails.
+func (f *versionedUpload) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := f.File.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{f.File}, r)
+}
+
+func (f *versionedUpload) Close() error {
+	now := time.Now()
+	version, err := copyToVersions(f.ctx, f.fs, f.name, now)
+	if err != nil {
+		// Better not replace the file than lose its previous version.
+		if d, ok := f.File.(uploadDiscarder); ok {
+			d.discard()
+		}
+		_ = f.File.Close()
+		return err
+	}
+
+	if err := f.File.Close(); err != nil {
+		if version != "" {
+			_ = f.fs.RemoveAll(context.Background(), version)
+		}
+		return err
+	}
+	if version == "" {
+		return nil
+	}
+	return pruneVersions(f.ctx, f.fs, f.versioning, path.Dir(version), now)
+}
+
+func copyVersion(ctx context.Context, fs webdav.FileSystem, src, dst string, perm os.FileMode) error {
+	in, err := fs.OpenFile(ctx, src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := fs.OpenFile(ctx, dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = fs.RemoveAll(ctx, dst)
+		return err
+	}
+	return out.Close()
+}
+
+// pruneVersions removes the versions older than the maximum age, and the
+// oldest ones beyond the maximum number of versions.
+func pruneVersions(ctx context.Context, fs webdav.FileSystem, c Versioning, dir string, now time.Time) error {
+	f, err := fs.OpenFile(ctx, dir, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	infos, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	var versions []string
+	for _, info := range infos {
+		if _, err := time.Parse(versionLayout, info.Name()); err == nil && !info.IsDir() {
+			versions = append(versions, info.Name())
+		}
+	}
+	sort.Strings(versions)
+
+	for i, version := range versions {
+		made, _ := time.Parse(versionLayout, version)
+		expired := c.MaxAge > 0 && now.Sub(made) > c.MaxAge
+		excess := c.MaxVersions > 0 && len(versions)-i > c.MaxVersions
+		if !expired && !excess {
+			continue
+		}
+
+		if err := fs.RemoveAll(ctx, path.Join(dir, version)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
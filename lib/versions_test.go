@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+func TestVersioning(t *testing.T) {
+	t.Parallel()
+
+	put := func(h http.Handler, name, content string) int {
+		return doRequest(h, httptest.NewRequest(http.MethodPut, name, strings.NewReader(content))).Code
+	}
+
+	versions := func(t *testing.T, dir string) []string {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+
+		var contents []string
+		for _, entry := range entries {
+			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			require.NoError(t, err)
+			contents = append(contents, string(data))
+		}
+		return contents
+	}
+
+	t.Run("Overwrite", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{Permissions: Permissions{Modify: true}, Versioning: Versioning{Enabled: true}}
+		h := newTestHandler(t, cfg)
+
+		require.Equal(t, http.StatusCreated, put(h, "/file.txt", "updated"))
+		require.Equal(t, []string{"content"}, versions(t, filepath.Join(cfg.Scope, ".versions", "file.txt")))
+
+		data, err := os.ReadFile(filepath.Join(cfg.Scope, "file.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "updated", string(data))
+
+		// New files, and the versions themselves, have no previous version.
+		require.Equal(t, http.StatusCreated, put(h, "/dir/new.txt", "new"))
+		require.NoDirExists(t, filepath.Join(cfg.Scope, "dir", ".versions"))
+		require.Equal(t, http.StatusCreated, put(h, "/.versions/file.txt/new.txt", "new"))
+		require.Equal(t, http.StatusCreated, put(h, "/.versions/file.txt/new.txt", "newer"))
+		require.NoDirExists(t, filepath.Join(cfg.Scope, ".versions", "file.txt", ".versions"))
+	})
+
+	t.Run("Max Versions", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{Permissions: Permissions{Modify: true}, Versioning: Versioning{Enabled: true, MaxVersions: 2}}
+		h := newTestHandler(t, cfg)
+
+		for _, content := range []string{"one", "two", "three", "four"} {
+			require.Equal(t, http.StatusCreated, put(h, "/file.txt", content))
+		}
+		require.Equal(t, []string{"two", "three"}, versions(t, filepath.Join(cfg.Scope, ".versions", "file.txt")))
+	})
+
+	t.Run("Failed Upload", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{Permissions: Permissions{Modify: true}, Versioning: Versioning{Enabled: true}, VerifyDigest: true}
+		h := newTestHandler(t, cfg)
+
+		// The uploads that fail or are rejected do not replace the file, so
+		// there is no previous version to keep.
+		r := httptest.NewRequest(http.MethodPut, "/file.txt", abortedReader{strings.NewReader("partial")})
+		require.NotEqual(t, http.StatusCreated, doRequest(h, r).Code)
+		r = httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("updated"))
+		r.Header.Set("Content-Md5", "AAAAAAAAAAAAAAAAAAAAAA==")
+		require.Equal(t, http.StatusBadRequest, doRequest(h, r).Code)
+		require.NoDirExists(t, filepath.Join(cfg.Scope, ".versions", "file.txt"))
+
+		require.Equal(t, http.StatusCreated, put(h, "/file.txt", "updated"))
+		require.Equal(t, []string{"content"}, versions(t, filepath.Join(cfg.Scope, ".versions", "file.txt")))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{Permissions: Permissions{Modify: true}}
+		h := newTestHandler(t, cfg)
+
+		require.Equal(t, http.StatusCreated, put(h, "/file.txt", "updated"))
+		require.NoDirExists(t, filepath.Join(cfg.Scope, ".versions"))
+	})
+}
+
+func TestPruneVersions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	fs := webdav.NewMemFS()
+	require.NoError(t, fs.Mkdir(ctx, "/.versions", 0777))
+	require.NoError(t, fs.Mkdir(ctx, "/.versions/file.txt", 0777))
+	for _, made := range []time.Time{now.Add(-72 * time.Hour), now.Add(-36 * time.Hour), now.Add(-time.Hour)} {
+		f, err := fs.OpenFile(ctx, "/.versions/file.txt/"+made.Format(versionLayout), os.O_WRONLY|os.O_CREATE, 0666)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	f, err := fs.OpenFile(ctx, "/file.txt", os.O_WRONLY|os.O_CREATE, 0666)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, keepVersion(ctx, fs, Versioning{Enabled: true, MaxAge: 48 * time.Hour}, "/file.txt", now))
+
+	dir, err := fs.OpenFile(ctx, "/.versions/file.txt", os.O_RDONLY, 0)
+	require.NoError(t, err)
+	infos, err := dir.Readdir(-1)
+	require.NoError(t, err)
+	require.NoError(t, dir.Close())
+
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	require.ElementsMatch(t, []string{
+		now.Add(-36 * time.Hour).Format(versionLayout),
+		now.Add(-time.Hour).Format(versionLayout),
+		now.Format(versionLayout),
+	}, names)
+}
+
+func TestConfigVersioning(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, "versioning:\n  enabled: true\n  max_age: 720h\n", ".yml")
+	require.True(t, cfg.Versioning.Enabled)
+	require.Equal(t, DefaultVersioningMaxVersions, cfg.Versioning.MaxVersions)
+	require.Equal(t, 720*time.Hour, cfg.Versioning.MaxAge)
+
+	require.ErrorContains(t, (&Config{Versioning: Versioning{Enabled: true, MaxVersions: -1}}).Validate(), "versioning")
+}
@@ -0,0 +1,206 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+var errNotDirectory = errors.New("not a directory")
+
+// virtualFile is the read-only file, kept in memory, that is served in every
+// collection without existing in the file systems.
+type virtualFile struct {
+	name    string
+	content []byte
+	modTime time.Time
+}
+
+func newVirtualFile(c VirtualFile) *virtualFile {
+	if c.Name == "" {
+		return nil
+	}
+	return &virtualFile{name: c.Name, content: []byte(c.Content), modTime: time.Now()}
+}
+
+// is reports whether the path is the virtual file of a collection.
+func (v *virtualFile) is(name string) bool {
+	name = path.Clean("/" + name)
+	return name != "/" && path.Base(name) == v.name
+}
+
+// modifiedBy reports whether the request would modify the virtual file, which
+// is forbidden. Copying it elsewhere only reads it.
+func (v *virtualFile) modifiedBy(r *http.Request, u *handlerUser) bool {
+	if v == nil || isReadMethod(r.Method) {
+		return false
+	}
+	if r.Method != "COPY" && v.is(u.name(r)) {
+		return true
+	}
+	dst, ok := u.destinationName(r)
+	return ok && v.is(dst)
+}
+
+type virtualFileInfo struct {
+	file *virtualFile
+}
+
+func (fi virtualFileInfo) Name() string       { return fi.file.name }
+func (fi virtualFileInfo) Size() int64        { return int64(len(fi.file.content)) }
+func (fi virtualFileInfo) Mode() os.FileMode  { return 0444 }
+func (fi virtualFileInfo) ModTime() time.Time { return fi.file.modTime }
+func (fi virtualFileInfo) IsDir() bool        { return false }
+func (fi virtualFileInfo) Sys() any           { return nil }
+
+func (fi virtualFileInfo) ContentType(ctx context.Context) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(fi.file.name)); contentType != "" {
+		return contentType, nil
+	}
+	return "", webdav.ErrNotImplemented
+}
+
+type virtualFileReader struct {
+	*bytes.Reader
+	file *virtualFile
+}
+
+func (f virtualFileReader) Close() error { return nil }
+
+func (f virtualFileReader) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.file.name, Err: errNotDirectory}
+}
+
+func (f virtualFileReader) Stat() (os.FileInfo, error) {
+	return virtualFileInfo{file: f.file}, nil
+}
+
+func (f virtualFileReader) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.file.name, Err: os.ErrPermission}
+}
+
+type unlistedVirtualFileKey struct{}
+
+// withoutVirtualFile leaves the virtual file out of the listings of the
+// collections while serving the request, so that the copies of the collections
+// do not try to write it.
+func withoutVirtualFile(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), unlistedVirtualFileKey{}, true))
+}
+
+// virtualFS is a [webdav.FileSystem] that adds the virtual file to every
+// collection of another file system, in place of any file with the same name.
+// The virtual file cannot be modified.
+type virtualFS struct {
+	webdav.FileSystem
+	file *virtualFile
+}
+
+// inCollection reports whether the virtual file with the given name is in a
+// collection that exists.
+func (fs virtualFS) inCollection(ctx context.Context, name string) bool {
+	info, err := fs.FileSystem.Stat(ctx, path.Dir(path.Clean("/"+name)))
+	return err == nil && info.IsDir()
+}
+
+func (fs virtualFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if fs.file.is(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrPermission}
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fs virtualFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if !fs.file.is(name) {
+		file, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		if unlisted, _ := ctx.Value(unlistedVirtualFileKey{}).(bool); unlisted {
+			return file, nil
+		}
+		return &virtualDir{File: file, file: fs.file}, nil
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	if !fs.inCollection(ctx, name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return virtualFileReader{Reader: bytes.NewReader(fs.file.content), file: fs.file}, nil
+}
+
+func (fs virtualFS) RemoveAll(ctx context.Context, name string) error {
+	if fs.file.is(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	return fs.FileSystem.RemoveAll(ctx, name)
+}
+
+func (fs virtualFS) Rename(ctx context.Context, oldName, newName string) error {
+	if fs.file.is(oldName) || fs.file.is(newName) {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrPermission}
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+func (fs virtualFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if !fs.file.is(name) {
+		return fs.FileSystem.Stat(ctx, name)
+	}
+	if !fs.inCollection(ctx, name) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return virtualFileInfo{file: fs.file}, nil
+}
+
+// virtualDir is a file of a [virtualFS]. If it is a collection, the virtual
+// file is listed after its members.
+type virtualDir struct {
+	webdav.File
+	file   *virtualFile
+	listed bool
+}
+
+// ReadFrom lets the wrapped file notice when reading the request body fails,
+// see [uploadFile].
+func (d *virtualDir) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := d.File.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{d.File}, r)
+}
+
+func (d *virtualDir) DeadProps() (map[xml.Name]webdav.Property, error) {
+	return wrappedDeadProps(d.File)
+}
+
+func (d *virtualDir) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return wrappedPatch(d.File, patches)
+}
+
+func (d *virtualDir) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := d.File.Readdir(count)
+	if d.listed || (err != nil && err != io.EOF) {
+		return infos, err
+	}
+	d.listed = true
+
+	visible := infos[:0]
+	for _, info := range infos {
+		if info.Name() != d.file.name {
+			visible = append(visible, info)
+		}
+	}
+	return append(visible, virtualFileInfo{file: d.file}), nil
+}
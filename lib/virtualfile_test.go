@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualFile(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T) (http.Handler, string) {
+		h := newTestHandler(t, &Config{
+			Permissions:      Permissions{Modify: true},
+			DirectoryListing: true,
+			VirtualFile:      VirtualFile{Name: "README.txt", Content: "Usage notes"},
+		})
+		return h, h.(*Handler).handler.user.Scope
+	}
+
+	t.Run("Listing", func(t *testing.T) {
+		t.Parallel()
+
+		h, _ := newHandler(t)
+		for _, dir := range []string{"/", "/dir/"} {
+			r := httptest.NewRequest("PROPFIND", dir, nil)
+			r.Header.Set("Depth", "1")
+			w := doRequest(h, r)
+			require.Equal(t, http.StatusMultiStatus, w.Code)
+			require.Contains(t, w.Body.String(), "<D:href>"+dir+"README.txt</D:href>")
+			require.Equal(t, 1, strings.Count(w.Body.String(), "README.txt</D:href>"))
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/dir/", nil)
+		r.Header.Set("Accept", "text/html")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), "README.txt")
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/dir/README.txt", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "Usage notes", w.Body.String())
+		require.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+
+		// It is not on disk, nor in the collections that do not exist.
+		require.NoFileExists(t, filepath.Join(scope, "dir", "README.txt"))
+		require.Equal(t, http.StatusNotFound, doRequest(h, httptest.NewRequest(http.MethodGet, "/missing/README.txt", nil)).Code)
+		require.Equal(t, http.StatusNotFound, doRequest(h, httptest.NewRequest(http.MethodGet, "/file.txt/README.txt", nil)).Code)
+	})
+
+	t.Run("Read-Only", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		require.Equal(t, http.StatusForbidden, doRequest(h, httptest.NewRequest(http.MethodPut, "/README.txt", strings.NewReader("new"))).Code)
+		require.Equal(t, http.StatusForbidden, doRequest(h, httptest.NewRequest(http.MethodDelete, "/dir/README.txt", nil)).Code)
+		require.Equal(t, http.StatusForbidden, doRequest(h, httptest.NewRequest("MKCOL", "/README.txt", nil)).Code)
+		require.Equal(t, http.StatusForbidden, doRequest(h, httptest.NewRequest("PROPPATCH", "/README.txt", nil)).Code)
+
+		r := httptest.NewRequest("MOVE", "/file.txt", nil)
+		r.Header.Set("Destination", "/dir/README.txt")
+		require.Equal(t, http.StatusForbidden, doRequest(h, r).Code)
+		require.FileExists(t, filepath.Join(scope, "file.txt"))
+
+		r = httptest.NewRequest("MOVE", "/README.txt", nil)
+		r.Header.Set("Destination", "/moved.txt")
+		require.Equal(t, http.StatusForbidden, doRequest(h, r).Code)
+
+		w := doRequest(h, httptest.NewRequest(http.MethodGet, "/README.txt", nil))
+		require.Equal(t, "Usage notes", w.Body.String())
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+
+		// Copying the virtual file makes a real file of it.
+		r := httptest.NewRequest("COPY", "/README.txt", nil)
+		r.Header.Set("Destination", "/notes.txt")
+		require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+		data, err := os.ReadFile(filepath.Join(scope, "notes.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "Usage notes", string(data))
+
+		// The copies of the collections do not include it.
+		r = httptest.NewRequest("COPY", "/dir/", nil)
+		r.Header.Set("Destination", "/copy/")
+		require.Equal(t, http.StatusCreated, doRequest(h, r).Code)
+		require.DirExists(t, filepath.Join(scope, "copy"))
+		require.NoFileExists(t, filepath.Join(scope, "copy", "README.txt"))
+	})
+}
+
+func TestConfigVirtualFile(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+virtual_file:
+  name: README.txt
+  content: Usage notes
+`, ".yml")
+	require.Equal(t, VirtualFile{Name: "README.txt", Content: "Usage notes"}, cfg.VirtualFile)
+
+	for _, name := range []string{".", "..", "dir/README.txt"} {
+		cfg := &Config{VirtualFile: VirtualFile{Name: name}}
+		require.ErrorContains(t, cfg.Validate(), "must be a file name", name)
+	}
+}
@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// violatesWriteOnce reports whether the request would modify or remove a file
+// that exists at a write-once path, which only lets files be created. The
+// paths are those of the requests, matched against the patterns.
+func (u *handlerUser) violatesWriteOnce(r *http.Request, patterns []string) bool {
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodPut, http.MethodPatch:
+		if !matchesPath(patterns, r.URL.Path) {
+			return false
+		}
+		_, err := u.FileSystem.Stat(ctx, u.name(r))
+		return err == nil
+	case http.MethodDelete, "MOVE":
+		if u.holdsWriteOnce(ctx, patterns, r.URL.Path) {
+			return true
+		}
+	}
+
+	// Copies and moves replace their destination, along with its members.
+	if dst, ok := destinationPath(r); ok && r.Header.Get("Overwrite") != "F" {
+		return u.holdsWriteOnce(ctx, patterns, dst)
+	}
+	return false
+}
+
+// holdsWriteOnce reports whether the path exists and is a write-once path, or
+// is a collection with members that are.
+func (u *handlerUser) holdsWriteOnce(ctx context.Context, patterns []string, p string) bool {
+	info, err := u.FileSystem.Stat(ctx, strings.TrimPrefix(p, u.Prefix))
+	if err != nil {
+		return false
+	}
+	if matchesPath(patterns, p) {
+		return true
+	}
+	if !info.IsDir() {
+		return false
+	}
+
+	f, err := u.FileSystem.OpenFile(ctx, strings.TrimPrefix(p, u.Prefix), os.O_RDONLY, 0)
+	if err != nil {
+		// Better forbid the request than lose the files.
+		return true
+	}
+	infos, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return true
+	}
+
+	for _, info := range infos {
+		if u.holdsWriteOnce(ctx, patterns, path.Join(p, info.Name())) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteOnce(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(t *testing.T) (http.Handler, string) {
+		cfg := &Config{
+			Permissions: Permissions{Modify: true},
+			WriteOnce:   []string{"/archive", "/dir/*.log"},
+		}
+		h := newTestHandler(t, cfg)
+		require.NoError(t, os.MkdirAll(filepath.Join(cfg.Scope, "archive", "2024"), 0777))
+		require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "archive", "2024", "report.txt"), []byte("report"), 0666))
+		require.NoError(t, os.WriteFile(filepath.Join(cfg.Scope, "dir", "app.log"), []byte("log"), 0666))
+		return h, cfg.Scope
+	}
+
+	move := func(method, src, dst string) *http.Request {
+		r := httptest.NewRequest(method, src, nil)
+		r.Header.Set("Destination", dst)
+		return r
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		require.Equal(t, http.StatusCreated, doRequest(h, httptest.NewRequest(http.MethodPut, "/archive/2024/new.txt", strings.NewReader("new"))).Code)
+		require.Equal(t, http.StatusCreated, doRequest(h, httptest.NewRequest(http.MethodPut, "/dir/new.log", strings.NewReader("new"))).Code)
+		require.Equal(t, http.StatusCreated, doRequest(h, httptest.NewRequest("MKCOL", "/archive/2025", nil)).Code)
+		require.Equal(t, http.StatusCreated, doRequest(h, move("COPY", "/file.txt", "/archive/file.txt")).Code)
+		require.FileExists(t, filepath.Join(scope, "archive", "file.txt"))
+
+		// The other paths are not affected.
+		require.Equal(t, http.StatusCreated, doRequest(h, httptest.NewRequest(http.MethodPut, "/file.txt", strings.NewReader("new"))).Code)
+		require.Equal(t, http.StatusNoContent, doRequest(h, httptest.NewRequest(http.MethodDelete, "/file.txt", nil)).Code)
+	})
+
+	t.Run("Modify", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		require.Equal(t, http.StatusForbidden, doRequest(h, httptest.NewRequest(http.MethodPut, "/archive/2024/report.txt", strings.NewReader("new"))).Code)
+		require.Equal(t, http.StatusForbidden, doRequest(h, httptest.NewRequest(http.MethodPut, "/dir/app.log", strings.NewReader("new"))).Code)
+		require.Equal(t, http.StatusForbidden, doRequest(h, move("COPY", "/file.txt", "/archive/2024/report.txt")).Code)
+		require.Equal(t, http.StatusForbidden, doRequest(h, move("MOVE", "/file.txt", "/dir/app.log")).Code)
+
+		data, err := os.ReadFile(filepath.Join(scope, "archive", "2024", "report.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "report", string(data))
+		require.FileExists(t, filepath.Join(scope, "file.txt"))
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		t.Parallel()
+
+		h, scope := newHandler(t)
+		require.Equal(t, http.StatusForbidden, doRequest(h, httptest.NewRequest(http.MethodDelete, "/archive/2024/report.txt", nil)).Code)
+		require.Equal(t, http.StatusForbidden, doRequest(h, httptest.NewRequest(http.MethodDelete, "/dir/app.log", nil)).Code)
+		require.Equal(t, http.StatusForbidden, doRequest(h, move("MOVE", "/archive/2024/report.txt", "/report.txt")).Code)
+
+		// Nor can the directories holding them be removed or replaced.
+		require.Equal(t, http.StatusForbidden, doRequest(h, httptest.NewRequest(http.MethodDelete, "/dir/", nil)).Code)
+		require.Equal(t, http.StatusForbidden, doRequest(h, move("MOVE", "/dir/", "/moved/")).Code)
+		require.Equal(t, http.StatusForbidden, doRequest(h, move("COPY", "/archive/", "/dir/")).Code)
+		require.FileExists(t, filepath.Join(scope, "archive", "2024", "report.txt"))
+		require.FileExists(t, filepath.Join(scope, "dir", "app.log"))
+
+		// They can be copied elsewhere.
+		require.Equal(t, http.StatusCreated, doRequest(h, move("COPY", "/archive/2024/report.txt", "/report.txt")).Code)
+	})
+}
+
+func TestConfigWriteOnce(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, `
+write_once:
+  - /archive
+  - /logs/*.log
+`, ".yml")
+	require.Equal(t, []string{"/archive", "/logs/*.log"}, cfg.WriteOnce)
+
+	for _, pattern := range []string{"archive", "/[invalid"} {
+		cfg := &Config{WriteOnce: []string{pattern}}
+		require.ErrorContains(t, cfg.Validate(), "write-once pattern", pattern)
+	}
+}
@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// The policies for the concurrent writes to the same file.
+const (
+	ConcurrentWritesAllow  = "allow"
+	ConcurrentWritesWait   = "wait"
+	ConcurrentWritesReject = "reject"
+)
+
+var errWriteConflict = errors.New("file is being written")
+
+// writeGuard serializes the writes to the same file, so that the clients that
+// do not lock files cannot interleave their uploads. The files are identified
+// by their resolved paths, so that the writes of users sharing a scope are
+// also serialized.
+type writeGuard struct {
+	mu     sync.Mutex
+	writes map[string]*pathWrite
+}
+
+// pathWrite is the write in progress to a file. It is removed from the guard
+// once nobody holds it or waits for it, so that the map does not grow
+// indefinitely.
+type pathWrite struct {
+	held chan struct{}
+	refs int
+}
+
+func newWriteGuard() *writeGuard {
+	return &writeGuard{writes: map[string]*pathWrite{}}
+}
+
+// acquire takes the write of the file. If the file is already being written,
+// it either waits until the write is done, or the context is, or it fails
+// with errWriteConflict right away. The returned function releases the write.
+func (g *writeGuard) acquire(ctx context.Context, key string, wait bool) (func(), error) {
+	g.mu.Lock()
+	write, ok := g.writes[key]
+	if !ok {
+		write = &pathWrite{held: make(chan struct{}, 1)}
+		g.writes[key] = write
+	}
+	write.refs++
+	g.mu.Unlock()
+
+	if wait {
+		select {
+		case write.held <- struct{}{}:
+		case <-ctx.Done():
+			g.drop(key, write)
+			return nil, ctx.Err()
+		}
+	} else {
+		select {
+		case write.held <- struct{}{}:
+		default:
+			g.drop(key, write)
+			return nil, errWriteConflict
+		}
+	}
+
+	return func() {
+		<-write.held
+		g.drop(key, write)
+	}, nil
+}
+
+func (g *writeGuard) drop(key string, write *pathWrite) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	write.refs--
+	if write.refs == 0 {
+		delete(g.writes, key)
+	}
+}
+
+// resolvedPath returns the path of the file in the backend, going through the
+// mounts, so that it is the same for every user.
+func (f *fileSystems) resolvedPath(scope, name string) string {
+	name = path.Clean("/" + name)
+
+	// The longest mount path matches, as mounts can be nested.
+	longest := -1
+	for i, mount := range f.mounts {
+		if name != mount.Path && !strings.HasPrefix(name, mount.Path+"/") {
+			continue
+		}
+		if longest < 0 || len(mount.Path) > len(f.mounts[longest].Path) {
+			longest = i
+		}
+	}
+	if longest >= 0 {
+		scope = f.mounts[longest].Scope
+		name = "/" + strings.TrimPrefix(name, f.mounts[longest].Path)
+	}
+
+	if f.backend == BackendDisk {
+		return filepath.Join(f.physical(scope), filepath.FromSlash(name))
+	}
+	return f.backend + ":" + path.Join(scope, name)
+}
+
+// guardWrite takes the write of the file for the duration of the request,
+// according to the policy. If it cannot, the response is written and false is
+// returned. Otherwise, the returned function must be called once the request
+// is done.
+func (h *handler) guardWrite(w http.ResponseWriter, r *http.Request, user *handlerUser, name string) (func(), bool) {
+	key := h.fileSystems.resolvedPath(user.Scope, name)
+
+	release, err := h.writes.acquire(r.Context(), key, h.concurrentWrites == ConcurrentWritesWait)
+	switch {
+	case errors.Is(err, errWriteConflict):
+		requestLogger(r, h.logger).Info("conflicting concurrent write", zap.String("username", user.Username), zap.String("path", r.URL.Path))
+		http.Error(w, "Conflict", http.StatusConflict)
+		return nil, false
+	case err != nil:
+		// The client went away, or the request timed out, while waiting.
+		requestLogger(r, h.logger).Debug("gave up waiting for concurrent write", zap.String("path", r.URL.Path), zap.Error(err))
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return nil, false
+	}
+
+	return release, true
+}
@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writesInProgress returns the amount of files with writes in progress, or
+// waiting for them.
+func writesInProgress(h http.Handler) int {
+	g := h.(*Handler).handler.writes
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.writes)
+}
+
+func TestHandlerConcurrentWrites(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []string{ConcurrentWritesWait, ConcurrentWritesReject} {
+		t.Run(policy, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			h := newTestHandler(t, &Config{
+				Permissions:      Permissions{Scope: dir, Modify: true},
+				ConcurrentWrites: policy,
+			})
+
+			// The first upload stalls until its body is written.
+			body, pw := io.Pipe()
+			first := make(chan int)
+			go func() {
+				first <- doRequest(h, httptest.NewRequest(http.MethodPut, "/new.txt", body)).Code
+			}()
+			require.Eventually(t, func() bool { return writesInProgress(h) == 1 }, time.Second, time.Millisecond)
+
+			second := make(chan int)
+			go func() {
+				second <- doRequest(h, httptest.NewRequest(http.MethodPut, "/new.txt", strings.NewReader("second"))).Code
+			}()
+
+			// Uploads to other files are not held.
+			require.Equal(t, http.StatusCreated, doRequest(h, httptest.NewRequest(http.MethodPut, "/other.txt", strings.NewReader("other"))).Code)
+
+			if policy == ConcurrentWritesReject {
+				require.Equal(t, http.StatusConflict, <-second)
+			} else {
+				select {
+				case <-second:
+					t.Fatal("second upload did not wait")
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+
+			_, err := pw.Write([]byte("first"))
+			require.NoError(t, err)
+			require.NoError(t, pw.Close())
+			require.Equal(t, http.StatusCreated, <-first)
+
+			data, err := os.ReadFile(filepath.Join(dir, "new.txt"))
+			require.NoError(t, err)
+			if policy == ConcurrentWritesReject {
+				require.Equal(t, "first", string(data))
+			} else {
+				require.Equal(t, http.StatusCreated, <-second)
+				data, err = os.ReadFile(filepath.Join(dir, "new.txt"))
+				require.NoError(t, err)
+				require.Equal(t, "second", string(data))
+			}
+
+			// Nothing is left behind.
+			require.Zero(t, writesInProgress(h))
+		})
+	}
+}
+
+func TestWriteGuard(t *testing.T) {
+	t.Parallel()
+
+	g := newWriteGuard()
+	release, err := g.acquire(context.Background(), "a", false)
+	require.NoError(t, err)
+
+	_, err = g.acquire(context.Background(), "a", false)
+	require.ErrorIs(t, err, errWriteConflict)
+
+	// Waiting gives up with the context.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = g.acquire(ctx, "a", true)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+	require.Empty(t, g.writes)
+
+	release, err = g.acquire(context.Background(), "a", false)
+	require.NoError(t, err)
+	release()
+	require.Empty(t, g.writes)
+}
+
+func TestFileSystemsResolvedPath(t *testing.T) {
+	t.Parallel()
+
+	f := &fileSystems{
+		backend: BackendMemory,
+		mounts:  []Mount{{Path: "/shared", Scope: "/data"}, {Path: "/shared/deep", Scope: "/deep"}},
+	}
+	require.Equal(t, "memory:/home/a.txt", f.resolvedPath("/home", "//a.txt"))
+	require.Equal(t, "memory:/data/a.txt", f.resolvedPath("/home", "/shared/a.txt"))
+	require.Equal(t, "memory:/deep/a.txt", f.resolvedPath("/home", "/shared/deep/a.txt"))
+	require.Equal(t, "memory:/home/sharedx/a.txt", f.resolvedPath("/home", "/sharedx/a.txt"))
+}
+
+func TestConfigConcurrentWrites(t *testing.T) {
+	t.Parallel()
+
+	cfg := writeAndParseConfig(t, ``, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, ConcurrentWritesAllow, cfg.ConcurrentWrites)
+
+	cfg = writeAndParseConfig(t, `concurrent_writes: wait`, ".yml")
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, ConcurrentWritesWait, cfg.ConcurrentWrites)
+
+	cfg = &Config{ConcurrentWrites: "queue"}
+	require.Error(t, cfg.Validate())
+}
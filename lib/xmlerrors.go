@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+)
+
+// davMethods are the methods that WebDAV adds to HTTP, which only the WebDAV
+// clients send.
+var davMethods = map[string]bool{
+	"PROPFIND":   true,
+	"PROPPATCH":  true,
+	"MKCOL":      true,
+	"MKCALENDAR": true,
+	"COPY":       true,
+	"MOVE":       true,
+	"LOCK":       true,
+	"UNLOCK":     true,
+}
+
+// wantsXMLError reports whether the client is a WebDAV client, or prefers XML
+// responses, in which case the errors are replied as XML.
+func wantsXMLError(r *http.Request) bool {
+	return davMethods[r.Method] || acceptsXML(r.Header.Get("Accept"))
+}
+
+// serveError replies to the request with the status. The clients that want XML
+// get the error body of RFC 4918, section 8.7, with the condition that failed,
+// if any. The others get the message, as with [http.Error].
+func serveError(w http.ResponseWriter, r *http.Request, status int, message, condition string) {
+	if !wantsXMLError(r) {
+		http.Error(w, message, status)
+		return
+	}
+	writeXMLError(w, status, condition)
+}
+
+// writeXMLError writes the error body of RFC 4918, section 8.7, with the
+// condition, which is an XML element of the DAV: namespace, or nothing.
+func writeXMLError(w http.ResponseWriter, status int, condition string) {
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>` +
+		`<D:error xmlns:D="DAV:">` + condition + `</D:error>`))
+}
+
+// needPrivileges returns the precondition of RFC 3744, section 7.1.1, for the
+// privilege that the user lacks on the resource.
+func needPrivileges(href, privilege string) string {
+	var b strings.Builder
+	b.WriteString("<D:need-privileges><D:resource><D:href>")
+	_ = xml.EscapeText(&b, []byte(href))
+	b.WriteString("</D:href><D:privilege><D:" + privilege + "/></D:privilege></D:resource></D:need-privileges>")
+	return b.String()
+}
+
+// requiredPrivilege returns the privilege of RFC 3744, section 3, that the
+// method requires.
+func requiredPrivilege(method string) string {
+	switch {
+	case isReadMethod(method) || method == "PROPFIND":
+		return "read"
+	case method == "PROPPATCH":
+		return "write-properties"
+	case method == "UNLOCK":
+		return "unlock"
+	default:
+		return "write"
+	}
+}
+
+// serveForbidden replies to a request that the permissions of the user do not
+// allow, naming the resource it lacks the privileges on: the destination of a
+// move or a copy if the source is allowed, or the path of the request.
+func serveForbidden(w http.ResponseWriter, r *http.Request, allowed bool) {
+	href, privilege := r.URL.EscapedPath(), requiredPrivilege(r.Method)
+	if allowed && (r.Method == "MOVE" || r.Method == "COPY") {
+		href, privilege = r.Header.Get("Destination"), "write"
+	}
+	serveError(w, r, http.StatusForbidden, "Forbidden", needPrivileges(href, privilege))
+}
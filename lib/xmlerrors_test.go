@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// davError is the error body of RFC 4918, section 8.7, with the precondition
+// of RFC 3744, section 7.1.1.
+type davError struct {
+	XMLName        xml.Name `xml:"DAV: error"`
+	NeedPrivileges *struct {
+		Resources []struct {
+			Href      string `xml:"DAV: href"`
+			Privilege struct {
+				Privileges []struct {
+					XMLName xml.Name
+				} `xml:",any"`
+			} `xml:"DAV: privilege"`
+		} `xml:"DAV: resource"`
+	} `xml:"DAV: need-privileges"`
+}
+
+func parseDAVError(t *testing.T, w *httptest.ResponseRecorder) davError {
+	require.Equal(t, "application/xml; charset=utf-8", w.Header().Get("Content-Type"))
+
+	var e davError
+	require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &e))
+	return e
+}
+
+func TestHandlerXMLErrors(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(t, &Config{
+		Permissions: Permissions{
+			Modify: true,
+			Rules:  []*Rule{{Path: "/dir/", Allow: true, Modify: false}},
+		},
+		DisabledMethods: []string{"PROPPATCH"},
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		t.Parallel()
+
+		r := httptest.NewRequest(http.MethodPut, "/dir/a%20b.txt", nil)
+		r.Header.Set("Accept", "application/xml")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		e := parseDAVError(t, w)
+		require.NotNil(t, e.NeedPrivileges)
+		require.Len(t, e.NeedPrivileges.Resources, 1)
+		require.Equal(t, "/dir/a%20b.txt", e.NeedPrivileges.Resources[0].Href)
+		require.Len(t, e.NeedPrivileges.Resources[0].Privilege.Privileges, 1)
+		require.Equal(t, xml.Name{Space: "DAV:", Local: "write"}, e.NeedPrivileges.Resources[0].Privilege.Privileges[0].XMLName)
+	})
+
+	t.Run("Forbidden Destination", func(t *testing.T) {
+		t.Parallel()
+
+		// WebDAV methods get XML errors, whatever they accept.
+		r := httptest.NewRequest("COPY", "/file.txt", nil)
+		r.Header.Set("Destination", "http://example.com/dir/copy.txt")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		e := parseDAVError(t, w)
+		require.NotNil(t, e.NeedPrivileges)
+		require.Equal(t, "/dir/copy.txt", e.NeedPrivileges.Resources[0].Href)
+	})
+
+	t.Run("Method Not Allowed", func(t *testing.T) {
+		t.Parallel()
+
+		w := doRequest(h, httptest.NewRequest("PROPPATCH", "/file.txt", nil))
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		require.Nil(t, parseDAVError(t, w).NeedPrivileges)
+	})
+
+	t.Run("Plain Text", func(t *testing.T) {
+		t.Parallel()
+
+		r := httptest.NewRequest(http.MethodPut, "/dir/new.txt", nil)
+		r.Header.Set("Accept", "text/html, application/xml;q=0.9")
+		w := doRequest(h, r)
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+		require.Equal(t, "Forbidden\n", w.Body.String())
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		t.Parallel()
+
+		h := newTestHandler(t, &Config{Auth: true, Users: []User{{Username: "admin", Password: "admin"}}})
+		w := doRequest(h, httptest.NewRequest("PROPFIND", "/", nil))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+		require.Nil(t, parseDAVError(t, w).NeedPrivileges)
+	})
+}
+
+func TestAcceptsXML(t *testing.T) {
+	t.Parallel()
+
+	for header, expected := range map[string]bool{
+		"":                                     false,
+		"*/*":                                  false,
+		"application/xml":                      true,
+		"text/xml, text/html;q=0.5":            true,
+		"text/html, application/xml":           false,
+		"text/html;q=0.1, application/xml;q=0": false,
+	} {
+		require.Equal(t, expected, acceptsXML(header), header)
+	}
+}